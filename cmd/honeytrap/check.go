@@ -0,0 +1,101 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package honeytrap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/honeytrap/honeytrap/server"
+	cli "gopkg.in/urfave/cli.v1"
+
+	logging "github.com/op/go-logging"
+)
+
+var configCommand = cli.Command{
+	Name:  "config",
+	Usage: "Configuration commands",
+	Subcommands: []cli.Command{
+		{
+			Name:   "check",
+			Usage:  "Check the configuration without starting honeytrap",
+			Action: checkConfig,
+		},
+	},
+}
+
+// checkConfig configures every director, service and listener without
+// binding sockets, and reports the problems found. Storage is opened in a
+// temporary data directory, so the storage of a running honeytrap will be
+// left alone.
+func checkConfig(c *cli.Context) error {
+	options := []server.OptionFn{}
+
+	fn, err := tryConfig(c.GlobalString("config"))
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Failed to read config file %s: %s", c.GlobalString("config"), err.Error()), 1)
+	}
+
+	options = append(options, fn)
+
+	dataDir, err := ioutil.TempDir("", "honeytrap-check")
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	defer os.RemoveAll(dataDir)
+
+	if fn, err := server.WithDataDir(dataDir); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	} else {
+		options = append(options, fn)
+	}
+
+	srvr, err := server.New(options...)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("%s: %s", c.GlobalString("config"), err.Error()), 1)
+	}
+
+	// the problems are reported below
+	logging.SetBackend(logging.NewLogBackend(ioutil.Discard, "", 0))
+
+	result := srvr.Check()
+
+	fmt.Println("ports")
+	fmt.Println("=======")
+	for _, port := range result.Ports {
+		fmt.Printf("* %s => %s (%s)\n", port.Port, strings.Join(port.Services, ", "), strings.Join(port.Listeners, ", "))
+	}
+
+	if len(result.Problems) == 0 {
+		fmt.Println(color.GreenString("Configuration ok."))
+		return nil
+	}
+
+	fmt.Println()
+	fmt.Println("problems")
+	fmt.Println("=======")
+	for _, p := range result.Problems {
+		if p.Warning {
+			fmt.Println(color.YellowString(p.String()))
+		} else {
+			fmt.Println(color.RedString(p.String()))
+		}
+	}
+
+	return cli.NewExitError(fmt.Sprintf("Found %d problem(s) in configuration.", len(result.Problems)), 1)
+}
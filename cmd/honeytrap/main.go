@@ -222,7 +222,10 @@ Commit-ID: %s
 	app.Flags = globalFlags
 	app.Description = `honeytrap: The honeypot server.`
 	app.CustomAppHelpTemplate = helpTemplate
-	app.Commands = []cli.Command{}
+	app.Commands = []cli.Command{
+		configCommand,
+		tryCommand,
+	}
 	app.Before = func(c *cli.Context) error {
 		return nil
 	}
@@ -0,0 +1,230 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package honeytrap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/server"
+	"github.com/honeytrap/honeytrap/services/transcript"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+var tryCommand = cli.Command{
+	Name:      "try",
+	Usage:     "Run a single service locally and print its events",
+	ArgsUsage: "<service>",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "listen, l",
+			Usage: "Listen on `ADDR` (eg. 127.0.0.1:2222) instead of using a pipe",
+		},
+		cli.IntFlag{
+			Name:  "port, p",
+			Usage: "Local `PORT` the service sees for pipe connections",
+		},
+		cli.StringFlag{
+			Name:  "transcript, t",
+			Usage: "Replay the client transcript in `FILE` instead of reading stdin",
+		},
+		cli.DurationFlag{
+			Name:  "timeout",
+			Value: time.Second * 5,
+			Usage: "Time to wait for expected data of the transcript",
+		},
+	},
+	Action: try,
+}
+
+// eventPrinter prints the events of the service as they are sent.
+type eventPrinter struct {
+	w io.Writer
+	m sync.Mutex
+}
+
+func (ep *eventPrinter) Send(e event.Event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		data = []byte(err.Error())
+	}
+
+	ep.m.Lock()
+	defer ep.m.Unlock()
+
+	fmt.Fprintln(ep.w, color.CyanString("event: %s", data))
+}
+
+// pipeConn is a pipe with tcp addresses, as services expect.
+type pipeConn struct {
+	net.Conn
+
+	laddr net.Addr
+	raddr net.Addr
+}
+
+func (pc *pipeConn) LocalAddr() net.Addr {
+	return pc.laddr
+}
+
+func (pc *pipeConn) RemoteAddr() net.Addr {
+	return pc.raddr
+}
+
+func handle(sm *server.ServiceMap, conn net.Conn) {
+	defer conn.Close()
+
+	if err := sm.Service.Handle(context.Background(), conn); err != nil {
+		fmt.Fprintln(os.Stderr, color.RedString("Error handling service: %s: %s", sm.Name, err.Error()))
+	}
+}
+
+// try runs the service on its own, on a loopback port or pipe, with events
+// printed to stderr.
+func try(c *cli.Context) error {
+	name := c.Args().First()
+	if name == "" {
+		return cli.NewExitError("Service name required.", 1)
+	}
+
+	options := []server.OptionFn{}
+
+	fn, err := tryConfig(c.GlobalString("config"))
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Failed to read config file %s: %s", c.GlobalString("config"), err.Error()), 1)
+	}
+
+	options = append(options, fn)
+
+	if d := c.GlobalString("data"); d == "" {
+	} else if fn, err := server.WithDataDir(d); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	} else {
+		options = append(options, fn)
+	}
+
+	srvr, err := server.New(options...)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	sm, err := srvr.Service(name, &eventPrinter{w: os.Stderr})
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	var t transcript.Transcript
+
+	if path := c.String("transcript"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		t, err = transcript.Parse(f)
+		f.Close()
+
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("%s: %s", path, err.Error()), 1)
+		}
+	}
+
+	var client net.Conn
+
+	done := make(chan struct{})
+
+	if addr := c.String("listen"); addr != "" {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		defer l.Close()
+
+		fmt.Fprintln(os.Stderr, color.YellowString("Service %s (%s) listening on %s", sm.Name, sm.Type, l.Addr()))
+
+		if t == nil {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+
+				go handle(sm, conn)
+			}
+		}
+
+		go func() {
+			defer close(done)
+
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			handle(sm, conn)
+		}()
+
+		if client, err = net.Dial("tcp", l.Addr().String()); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	} else {
+		s, p := net.Pipe()
+
+		conn := &pipeConn{
+			Conn:  s,
+			laddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: c.Int("port")},
+			raddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000 + os.Getpid()%20000},
+		}
+
+		go func() {
+			defer close(done)
+
+			handle(sm, conn)
+		}()
+
+		client = p
+	}
+
+	defer client.Close()
+
+	if t == nil {
+		go io.Copy(client, os.Stdin)
+
+		io.Copy(os.Stdout, client)
+		return nil
+	}
+
+	if err := t.Replay(client, c.Duration("timeout"), os.Stdout); err != nil {
+		return cli.NewExitError(color.RedString("Transcript failed: %s", err.Error()), 1)
+	}
+
+	client.Close()
+
+	select {
+	case <-done:
+	case <-time.After(c.Duration("timeout")):
+	}
+
+	fmt.Fprintln(os.Stderr, color.GreenString("Transcript replayed."))
+	return nil
+}
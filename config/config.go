@@ -17,6 +17,7 @@ import (
 	"regexp"
 
 	"io"
+	"io/ioutil"
 	"os"
 
 	"github.com/BurntSushi/toml"
@@ -36,10 +37,17 @@ var format = logging.MustStringFormatter(
 type Config struct {
 	toml.MetaData
 
+	// File is the name of the configuration file, used in positions.
+	File string `toml:"-"`
+
+	positions map[string]int
+
 	Listener toml.Primitive `toml:"listener"`
 
 	Web toml.Primitive `toml:"web"`
 
+	Metrics toml.Primitive `toml:"metrics"`
+
 	Services  map[string]toml.Primitive `toml:"service"`
 	Ports     []toml.Primitive          `toml:"port"`
 	Directors map[string]toml.Primitive `toml:"director"`
@@ -58,11 +66,17 @@ var Default = Config{}
 
 // Load attempts to load the giving toml configuration file.
 func (c *Config) Load(r io.Reader) error {
-	md, err := toml.DecodeReader(r, c)
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	md, err := toml.Decode(string(data), c)
 	if err != nil {
 		return err
 	}
 	c.MetaData = md
+	c.positions = indexPositions(data)
 
 	if len(c.Logging) == 0 {
 		fmt.Println("Warning: no logging backends configured. Add one to view log messages.")
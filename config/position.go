@@ -0,0 +1,156 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Position defines the location of a key in the configuration file.
+type Position struct {
+	File string
+	Line int
+}
+
+func (p Position) String() string {
+	file := p.File
+	if file == "" {
+		file = "config"
+	}
+
+	if p.Line == 0 {
+		return file
+	}
+
+	return fmt.Sprintf("%s:%d", file, p.Line)
+}
+
+// Position returns the position of the key, elements of arrays of tables
+// are addressed by their index, eg. Position("port", "0", "services").
+func (c *Config) Position(key ...string) Position {
+	for i := len(key); i > 0; i-- {
+		if line, ok := c.positions[strings.Join(key[:i], ".")]; ok {
+			return Position{File: c.File, Line: line}
+		}
+	}
+
+	return Position{File: c.File}
+}
+
+func unquoteKey(s string) string {
+	s = strings.TrimSpace(s)
+
+	if v, err := strconv.Unquote(s); err == nil {
+		return v
+	}
+
+	return strings.Trim(s, "'")
+}
+
+func splitKey(s string) []string {
+	parts := []string{}
+	for _, part := range strings.Split(s, ".") {
+		parts = append(parts, unquoteKey(part))
+	}
+
+	return parts
+}
+
+// indexPositions returns the lines of the tables and keys of the toml
+// document, keys of arrays of tables are indexed with and without
+// the index of the element.
+func indexPositions(data []byte) map[string]int {
+	positions := map[string]int{}
+
+	counts := map[string]int{}
+
+	table := []string{}
+	element := []string{}
+
+	store := func(line int, key ...string) {
+		for _, k := range [][]string{element, table} {
+			path := strings.Join(append(append([]string{}, k...), key...), ".")
+			if _, ok := positions[path]; !ok {
+				positions[path] = line
+			}
+		}
+	}
+
+	multiline := ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if multiline != "" {
+			if strings.Count(line, multiline)%2 == 1 {
+				multiline = ""
+			}
+
+			continue
+		}
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[[") {
+			end := strings.Index(line, "]]")
+			if end == -1 {
+				continue
+			}
+
+			table = splitKey(line[2:end])
+
+			name := strings.Join(table, ".")
+			element = append(append([]string{}, table...), strconv.Itoa(counts[name]))
+			counts[name]++
+
+			store(n)
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			end := strings.Index(line, "]")
+			if end == -1 {
+				continue
+			}
+
+			table = splitKey(line[1:end])
+			element = table
+
+			store(n)
+			continue
+		}
+
+		i := strings.Index(line, "=")
+		if i == -1 {
+			continue
+		}
+
+		store(n, splitKey(line[:i])...)
+
+		for _, quote := range []string{`"""`, `'''`} {
+			if strings.Count(line[i:], quote)%2 == 1 {
+				multiline = quote
+			}
+		}
+	}
+
+	return positions
+}
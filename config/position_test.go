@@ -0,0 +1,66 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPosition(t *testing.T) {
+	c := &Config{File: "config.toml"}
+	if err := c.Load(strings.NewReader(`# honeytrap
+[listener]
+type = "socket"
+
+[service.ssh]
+type = "ssh-simulator"
+banner = """
+type = "not a key"
+"""
+credentials = ["root:root"]
+
+[[port]]
+port = "tcp/22"
+
+[[port]]
+port = "tcp/23"
+services = ["telnet"]
+
+[channel."console"]
+type = "console"
+`)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		key      []string
+		expected string
+	}{
+		{[]string{"listener"}, "config.toml:2"},
+		{[]string{"listener", "type"}, "config.toml:3"},
+		{[]string{"service", "ssh", "type"}, "config.toml:6"},
+		{[]string{"service", "ssh", "credentials"}, "config.toml:10"},
+		{[]string{"port", "1"}, "config.toml:15"},
+		{[]string{"port", "1", "port"}, "config.toml:16"},
+		{[]string{"port", "services"}, "config.toml:17"},
+		{[]string{"channel", "console", "type"}, "config.toml:20"},
+		{[]string{"service", "ssh", "unknown"}, "config.toml:5"},
+		{[]string{"filter"}, "config.toml"},
+	} {
+		if p := c.Position(test.key...); p.String() != test.expected {
+			t.Errorf("Expected %s for %v, got %s", test.expected, test.key, p)
+		}
+	}
+}
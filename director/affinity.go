@@ -0,0 +1,354 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package director
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/storage"
+)
+
+// Identities sources can be recognized by.
+const (
+	// IdentityIP identifies sources by their ip address.
+	IdentityIP = "ip"
+
+	// IdentitySubnet identifies sources by their /24 (IPv4) or
+	// /64 (IPv6) subnet.
+	IdentitySubnet = "subnet"
+
+	// IdentitySSHKey identifies sources by the ssh public key offered,
+	// as observed by the ssh services.
+	IdentitySSHKey = "ssh-key"
+
+	// IdentityCredentials identifies sources by the credentials used,
+	// as observed by the services.
+	IdentityCredentials = "credentials"
+)
+
+const (
+	defaultAffinityTTL = 24 * time.Hour
+
+	// affinityPurgeEvery is the interval expired records are purged.
+	affinityPurgeEvery = time.Hour
+)
+
+// AffinityConfig defines how sources are assigned to the same container,
+// across services and restarts. Affinity is kept in the storage of the
+// sensor, sensors don't share the containers assigned to sources.
+type AffinityConfig struct {
+	Identity string       `toml:"identity"`
+	TTL      config.Delay `toml:"ttl"`
+}
+
+// Validate returns an error for unknown identities.
+func (c AffinityConfig) Validate() error {
+	switch c.Identity {
+	case "", IdentityIP, IdentitySubnet, IdentitySSHKey, IdentityCredentials:
+		return nil
+	default:
+		return fmt.Errorf("Unknown affinity identity %s", c.Identity)
+	}
+}
+
+type affinityRecord struct {
+	Value   string    `json:"value"`
+	Expires time.Time `json:"expires"`
+}
+
+// Affinity persists the containers assigned to sources. Records expire
+// after the ttl, storage doesn't expire keys itself so expiry is checked
+// when reading.
+type Affinity struct {
+	AffinityConfig
+
+	s storage.Storage
+}
+
+var (
+	affinityOnce  sync.Once
+	affinityStore storage.Storage
+)
+
+// affinityStorage returns the storage shared by the pools and services,
+// affinity is kept in memory when storage hasn't been initialized.
+func affinityStorage() storage.Storage {
+	affinityOnce.Do(func() {
+		s, err := storage.Namespace("affinity")
+		if err != nil {
+			log.Errorf("Error initializing affinity storage, affinity won't be persisted: %s", err.Error())
+			affinityStore = newMemoryStorage()
+			return
+		}
+
+		affinityStore = s
+	})
+
+	return affinityStore
+}
+
+// NewAffinity returns the affinity for the configuration, using the
+// storage.
+func NewAffinity(c AffinityConfig, s storage.Storage) (*Affinity, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	if c.Identity == "" {
+		c.Identity = IdentityIP
+	}
+
+	if c.TTL == 0 {
+		c.TTL = config.Delay(defaultAffinityTTL)
+	}
+
+	return &Affinity{
+		AffinityConfig: c,
+		s:              s,
+	}, nil
+}
+
+func getAffinity(s storage.Storage, key string) (string, bool) {
+	data, err := s.Get(key)
+	if err != nil {
+		return "", false
+	}
+
+	r := affinityRecord{}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return "", false
+	}
+
+	if time.Now().After(r.Expires) {
+		return "", false
+	}
+
+	return r.Value, true
+}
+
+func setAffinity(s storage.Storage, key, value string, ttl time.Duration) {
+	data, err := json.Marshal(affinityRecord{
+		Value:   value,
+		Expires: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return
+	}
+
+	if err := s.Set(key, data); err != nil {
+		log.Errorf("Error storing affinity %s: %s", key, err.Error())
+	}
+}
+
+func addrHost(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	return host
+}
+
+// Observe records the identity of the kind, eg. the ssh key or credentials,
+// used by the source. Pools identifying sources by the kind will assign
+// the source to the same container in all services.
+func Observe(addr net.Addr, kind, value string) {
+	observe(affinityStorage(), addr, kind, value)
+}
+
+func observe(s storage.Storage, addr net.Addr, kind, value string) {
+	h := sha256.Sum256([]byte(value))
+
+	identity := fmt.Sprintf("%s:%s", kind, hex.EncodeToString(h[:8]))
+
+	setAffinity(s, fmt.Sprintf("alias/%s/%s", kind, addrHost(addr)), identity, defaultAffinityTTL)
+}
+
+// Identify returns the identity of the source address, sources without
+// an observed ssh key or credentials are identified by their ip address.
+func (a *Affinity) Identify(addr net.Addr) string {
+	host := addrHost(addr)
+
+	switch a.Identity {
+	case IdentitySubnet:
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return host
+		}
+
+		mask := net.CIDRMask(64, 128)
+		if ip.To4() != nil {
+			ip, mask = ip.To4(), net.CIDRMask(24, 32)
+		}
+
+		ipnet := net.IPNet{IP: ip.Mask(mask), Mask: mask}
+		return fmt.Sprintf("subnet:%s", ipnet.String())
+	case IdentitySSHKey, IdentityCredentials:
+		if identity, ok := getAffinity(a.s, fmt.Sprintf("alias/%s/%s", a.Identity, host)); ok {
+			return identity
+		}
+	}
+
+	return host
+}
+
+// Backend returns the name of the container assigned to the identity.
+func (a *Affinity) Backend(identity string) (string, bool) {
+	return getAffinity(a.s, "backend/"+identity)
+}
+
+// Assign assigns the container to the identity, assigning again
+// extends the ttl.
+func (a *Affinity) Assign(identity, name string) {
+	setAffinity(a.s, "backend/"+identity, name, a.TTL.Duration())
+	setAffinity(a.s, "name/"+name, identity, a.TTL.Duration())
+}
+
+// Alias assigns the container of the source identified by from to the
+// identity, unless a container has been assigned to the identity already.
+func (a *Affinity) Alias(from, identity string) (string, bool) {
+	if _, ok := a.Backend(identity); ok {
+		return "", false
+	}
+
+	name, ok := a.Backend(from)
+	if !ok {
+		return "", false
+	}
+
+	a.Forget(from, name)
+	a.Assign(identity, name)
+	return name, true
+}
+
+// Forget removes the assignment of the container to the identity.
+func (a *Affinity) Forget(identity, name string) {
+	for _, key := range []string{"backend/" + identity, "name/" + name} {
+		if err := a.s.Delete(key); err != nil {
+			log.Errorf("Error removing affinity %s: %s", key, err.Error())
+		}
+	}
+}
+
+// Purge removes the expired records, when the storage can iterate
+// over its keys.
+func (a *Affinity) Purge() {
+	r, ok := a.s.(storage.Ranger)
+	if !ok {
+		return
+	}
+
+	expired := []string{}
+
+	if err := r.Range("", func(key string, data []byte) bool {
+		rec := affinityRecord{}
+		if err := json.Unmarshal(data, &rec); err != nil || time.Now().After(rec.Expires) {
+			expired = append(expired, key)
+		}
+
+		return true
+	}); err != nil {
+		log.Errorf("Error purging affinity: %s", err.Error())
+		return
+	}
+
+	for _, key := range expired {
+		if err := a.s.Delete(key); err != nil {
+			log.Errorf("Error removing affinity %s: %s", key, err.Error())
+		}
+	}
+
+	log.Debugf("Purged %d expired affinity records", len(expired))
+}
+
+// InUse returns whether the container is still assigned to an identity.
+func (a *Affinity) InUse(name string) bool {
+	identity, ok := getAffinity(a.s, "name/"+name)
+	if !ok {
+		return false
+	}
+
+	backend, ok := a.Backend(identity)
+	return ok && backend == name
+}
+
+var errKeyNotFound = errors.New("key not found")
+
+type memoryStorage struct {
+	m      sync.Mutex
+	values map[string][]byte
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{
+		values: map[string][]byte{},
+	}
+}
+
+func (ms *memoryStorage) Get(key string) ([]byte, error) {
+	ms.m.Lock()
+	defer ms.m.Unlock()
+
+	if v, ok := ms.values[key]; ok {
+		return v, nil
+	}
+
+	return nil, errKeyNotFound
+}
+
+func (ms *memoryStorage) Set(key string, data []byte) error {
+	ms.m.Lock()
+	defer ms.m.Unlock()
+
+	ms.values[key] = data
+	return nil
+}
+
+func (ms *memoryStorage) Delete(key string) error {
+	ms.m.Lock()
+	defer ms.m.Unlock()
+
+	delete(ms.values, key)
+	return nil
+}
+
+func (ms *memoryStorage) Range(prefix string, fn func(key string, data []byte) bool) error {
+	ms.m.Lock()
+
+	values := map[string][]byte{}
+	for k, v := range ms.values {
+		if strings.HasPrefix(k, prefix) {
+			values[k] = v
+		}
+	}
+
+	ms.m.Unlock()
+
+	for k, v := range values {
+		if !fn(k, v) {
+			return nil
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,310 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package director
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+func TestAffinityIdentify(t *testing.T) {
+	s := newMemoryStorage()
+
+	observe(s, &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}, IdentityCredentials, "root:toor")
+
+	tests := []struct {
+		identity string
+		addr     string
+		expected string
+	}{
+		{"", "10.0.0.1", "10.0.0.1"},
+		{IdentityIP, "10.0.0.1", "10.0.0.1"},
+		{IdentitySubnet, "10.0.0.1", "subnet:10.0.0.0/24"},
+		{IdentitySubnet, "2001:db8::1", "subnet:2001:db8::/64"},
+		{IdentitySSHKey, "10.0.0.1", "10.0.0.1"},
+		{IdentityCredentials, "10.0.0.2", "10.0.0.2"},
+	}
+
+	for _, test := range tests {
+		a, err := NewAffinity(AffinityConfig{Identity: test.identity}, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := a.Identify(&net.TCPAddr{IP: net.ParseIP(test.addr), Port: 80}); got != test.expected {
+			t.Errorf("Identity %q of %s: got %s, expected %s", test.identity, test.addr, got, test.expected)
+		}
+	}
+
+	a, _ := NewAffinity(AffinityConfig{Identity: IdentityCredentials}, s)
+
+	// credentials used from another address identify the same source
+	observe(s, &net.TCPAddr{IP: net.ParseIP("10.1.0.1"), Port: 22}, IdentityCredentials, "root:toor")
+
+	first := a.Identify(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 80})
+	second := a.Identify(&net.TCPAddr{IP: net.ParseIP("10.1.0.1"), Port: 8080})
+
+	if first != second {
+		t.Errorf("Expected same identity for the same credentials, got %s and %s", first, second)
+	}
+
+	if _, err := NewAffinity(AffinityConfig{Identity: "unknown"}, s); err == nil {
+		t.Error("Expected error for unknown identity")
+	}
+}
+
+func TestAffinityExpires(t *testing.T) {
+	a, err := NewAffinity(AffinityConfig{TTL: config.Delay(time.Millisecond * 10)}, newMemoryStorage())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.Assign("10.0.0.1", "honeytrap-pool-1")
+
+	if name, ok := a.Backend("10.0.0.1"); !ok || name != "honeytrap-pool-1" {
+		t.Errorf("Expected backend honeytrap-pool-1, got %s", name)
+	}
+
+	if !a.InUse("honeytrap-pool-1") {
+		t.Error("Expected container to be in use")
+	}
+
+	time.Sleep(time.Millisecond * 20)
+
+	if _, ok := a.Backend("10.0.0.1"); ok {
+		t.Error("Expected affinity to be expired")
+	}
+
+	if a.InUse("honeytrap-pool-1") {
+		t.Error("Expected expired container not to be in use")
+	}
+}
+
+func TestAffinityForgetPurge(t *testing.T) {
+	s := newMemoryStorage()
+
+	a, err := NewAffinity(AffinityConfig{TTL: config.Delay(time.Millisecond * 10)}, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.Assign("10.0.0.1", "honeytrap-pool-1")
+	a.Forget("10.0.0.1", "honeytrap-pool-1")
+
+	if n := len(s.values); n != 0 {
+		t.Errorf("Expected forgotten records to be removed, got %d records", n)
+	}
+
+	a.Assign("10.0.0.2", "honeytrap-pool-2")
+
+	time.Sleep(time.Millisecond * 20)
+
+	b, _ := NewAffinity(AffinityConfig{}, s)
+	b.Assign("10.0.0.3", "honeytrap-pool-3")
+
+	a.Purge()
+
+	if n := len(s.values); n != 2 {
+		t.Errorf("Expected expired records to be purged, got %d records", n)
+	}
+
+	if name, ok := b.Backend("10.0.0.3"); !ok || name != "honeytrap-pool-3" {
+		t.Errorf("Expected backend honeytrap-pool-3 to be kept, got %s", name)
+	}
+}
+
+func TestAffinityAlias(t *testing.T) {
+	a, err := NewAffinity(AffinityConfig{Identity: IdentityCredentials}, newMemoryStorage())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := a.Alias("10.0.0.1", "credentials:1"); ok {
+		t.Error("Expected no alias for unassigned source")
+	}
+
+	a.Assign("10.0.0.1", "honeytrap-pool-1")
+
+	if name, ok := a.Alias("10.0.0.1", "credentials:1"); !ok || name != "honeytrap-pool-1" {
+		t.Errorf("Expected alias to honeytrap-pool-1, got %s", name)
+	}
+
+	if _, ok := a.Backend("10.0.0.1"); ok {
+		t.Error("Expected ip address not to be assigned after alias")
+	}
+
+	if !a.InUse("honeytrap-pool-1") {
+		t.Error("Expected container to stay in use")
+	}
+
+	// identities keep the container assigned first
+	a.Assign("10.0.0.2", "honeytrap-pool-2")
+
+	if _, ok := a.Alias("10.0.0.2", "credentials:1"); ok {
+		t.Error("Expected no alias for assigned identity")
+	}
+
+	if name, _ := a.Backend("credentials:1"); name != "honeytrap-pool-1" {
+		t.Errorf("Expected identity to keep honeytrap-pool-1, got %s", name)
+	}
+}
+
+func TestPoolIdentify(t *testing.T) {
+	p := testPool(t, PoolConfig{Size: 1, Affinity: AffinityConfig{Identity: IdentityCredentials}}, fakeCreate, pushers.MustDummy())
+
+	p.fill()
+
+	addr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+
+	key := p.Identify(addr)
+	if key != "10.0.0.1" {
+		t.Fatalf("Expected source to be identified by ip address, got %s", key)
+	}
+
+	c, release, err := p.Acquire(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release()
+
+	// the credentials are observed during the first session
+	observe(p.affinity.s, addr, IdentityCredentials, "root:toor")
+
+	key = p.Identify(addr)
+	if key == "10.0.0.1" {
+		t.Fatal("Expected source to be identified by credentials")
+	}
+
+	c2, release, err := p.Acquire(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release()
+
+	if c2 != c {
+		t.Errorf("Expected source to keep container %s, got %s", c.Name(), c2.Name())
+	}
+
+	p.m.Lock()
+	_, ok := p.assigned["10.0.0.1"]
+	p.m.Unlock()
+
+	if ok {
+		t.Error("Expected ip address not to be assigned")
+	}
+
+	// the same credentials used from another address
+	other := &net.TCPAddr{IP: net.ParseIP("10.1.0.1"), Port: 22}
+	observe(p.affinity.s, other, IdentityCredentials, "root:toor")
+
+	c3, release, err := p.Acquire(p.Identify(other))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release()
+
+	if c3 != c {
+		t.Errorf("Expected same credentials to get container %s, got %s", c.Name(), c3.Name())
+	}
+}
+
+func TestPoolAffinitySensors(t *testing.T) {
+	p := testPool(t, PoolConfig{Size: 1}, fakeCreate, pushers.MustDummy())
+	p.fill()
+
+	c, release, err := p.Acquire("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release()
+
+	// other sensors keep affinity in their own storage, and don't know
+	// the containers assigned by this sensor
+	other := testPool(t, PoolConfig{Size: 1}, fakeCreate, pushers.MustDummy())
+	other.fill()
+
+	c2, release, err := other.Acquire("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release()
+
+	if !c2.(*fakeContainer).warm {
+		t.Errorf("Expected other sensor to assign a ready container, got %s assigned before as %s", c2.Name(), c.Name())
+	}
+}
+
+func TestPoolAffinity(t *testing.T) {
+	s := newMemoryStorage()
+
+	p := testPool(t, PoolConfig{Size: 1}, fakeCreate, pushers.MustDummy())
+	p.affinity.s = s
+
+	p.fill()
+
+	c, release, err := p.Acquire("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release()
+
+	if !p.InUse(c.Name()) {
+		t.Errorf("Expected container %s to be in use", c.Name())
+	}
+
+	// a restarted sensor, or another service of the sensor
+	p = testPool(t, PoolConfig{Size: 1}, fakeCreate, pushers.MustDummy())
+	p.affinity.s = s
+
+	p.fill()
+
+	c2, release, err := p.Acquire("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c2.Name() != c.Name() {
+		t.Errorf("Expected returning source to get container %s, got %s", c.Name(), c2.Name())
+	}
+
+	if c2.(*fakeContainer).warm {
+		t.Error("Expected container to be reopened, not taken from the pool")
+	}
+
+	p.m.Lock()
+	ready := len(p.ready)
+	p.m.Unlock()
+
+	if ready != 1 {
+		t.Errorf("Expected warm container to stay ready, got %d ready", ready)
+	}
+
+	release()
+
+	p.destroy("10.0.0.1", c2)
+
+	if p.InUse(c.Name()) {
+		t.Errorf("Expected destroyed container %s not to be in use", c.Name())
+	}
+}
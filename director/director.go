@@ -54,6 +54,13 @@ type Director interface {
 	// Run(ctx context.Context)
 }
 
+// Starter is implemented by directors managing containers. Start cleans up
+// the containers of a previous run and starts housekeeping, directors are
+// usable without being started, eg. when trying a service.
+type Starter interface {
+	Start() error
+}
+
 type SetChanneler interface {
 	SetChannel(pushers.Channel)
 }
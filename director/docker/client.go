@@ -0,0 +1,344 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// apiError is an error returned by the Docker Engine API.
+type apiError struct {
+	StatusCode int
+	Message    string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("docker: %s (%d)", e.Message, e.StatusCode)
+}
+
+func isStatus(err error, code int) bool {
+	ae, ok := err.(*apiError)
+	return ok && ae.StatusCode == code
+}
+
+// client is a minimal client of the Docker Engine API.
+type client struct {
+	http *http.Client
+	base string
+}
+
+// newClient returns a client for the daemon at host, eg.
+// unix:///var/run/docker.sock or tcp://127.0.0.1:2375.
+func newClient(host string) (*client, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "unix":
+		path := u.Path
+
+		return &client{
+			http: &http.Client{
+				Transport: &http.Transport{
+					DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+						return (&net.Dialer{}).DialContext(ctx, "unix", path)
+					},
+				},
+			},
+			base: "http://docker",
+		}, nil
+	case "tcp", "http":
+		return &client{
+			http: &http.Client{},
+			base: "http://" + u.Host,
+		}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported docker host %s", host)
+	}
+}
+
+// request executes the request, the returned body needs to be closed.
+func (c *client) request(method, path string, query url.Values, body interface{}) (io.ReadCloser, error) {
+	var r io.Reader
+
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+
+		r = bytes.NewReader(data)
+	}
+
+	u := c.base + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, u, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp.Body, nil
+	}
+
+	defer resp.Body.Close()
+
+	ae := &apiError{StatusCode: resp.StatusCode}
+
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err := json.Unmarshal(data, ae); err != nil || ae.Message == "" {
+		ae.Message = strings.TrimSpace(string(data))
+	}
+
+	return nil, ae
+}
+
+// do executes the request and decodes the response into v.
+func (c *client) do(method, path string, query url.Values, body interface{}, v interface{}) error {
+	rc, err := c.request(method, path, query, body)
+	if err != nil {
+		return err
+	}
+
+	defer rc.Close()
+
+	if v == nil {
+		_, err := io.Copy(ioutil.Discard, rc)
+		return err
+	}
+
+	return json.NewDecoder(rc).Decode(v)
+}
+
+type networkConfig struct {
+	Name     string            `json:"Name"`
+	Driver   string            `json:"Driver"`
+	Internal bool              `json:"Internal"`
+	Labels   map[string]string `json:"Labels,omitempty"`
+}
+
+// EnsureNetwork creates the network, when not existing already.
+func (c *client) EnsureNetwork(config networkConfig) error {
+	if err := c.do("GET", "/networks/"+url.PathEscape(config.Name), nil, nil, nil); err == nil {
+		return nil
+	} else if !isStatus(err, http.StatusNotFound) {
+		return err
+	}
+
+	return c.do("POST", "/networks/create", nil, config, nil)
+}
+
+type hostConfig struct {
+	NanoCPUs    int64  `json:"NanoCpus,omitempty"`
+	Memory      int64  `json:"Memory,omitempty"`
+	PidsLimit   int64  `json:"PidsLimit,omitempty"`
+	NetworkMode string `json:"NetworkMode,omitempty"`
+}
+
+type containerConfig struct {
+	Image      string            `json:"Image"`
+	Cmd        []string          `json:"Cmd,omitempty"`
+	Env        []string          `json:"Env,omitempty"`
+	Hostname   string            `json:"Hostname,omitempty"`
+	Labels     map[string]string `json:"Labels,omitempty"`
+	HostConfig hostConfig        `json:"HostConfig"`
+}
+
+// CreateContainer creates the container and returns its id, the image
+// will be pulled when not available.
+func (c *client) CreateContainer(name string, config containerConfig) (string, error) {
+	query := url.Values{"name": []string{name}}
+
+	resp := struct {
+		ID string `json:"Id"`
+	}{}
+
+	err := c.do("POST", "/containers/create", query, config, &resp)
+	if isStatus(err, http.StatusNotFound) {
+		if err := c.PullImage(config.Image); err != nil {
+			return "", err
+		}
+
+		err = c.do("POST", "/containers/create", query, config, &resp)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return resp.ID, nil
+}
+
+// PullImage pulls the image, the progress is being discarded.
+func (c *client) PullImage(image string) error {
+	rc, err := c.request("POST", "/images/create", url.Values{"fromImage": []string{image}}, nil)
+	if err != nil {
+		return err
+	}
+
+	defer rc.Close()
+
+	dec := json.NewDecoder(rc)
+	for {
+		msg := struct {
+			Error string `json:"error"`
+		}{}
+
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		} else if msg.Error != "" {
+			return fmt.Errorf("docker: error pulling %s: %s", image, msg.Error)
+		}
+	}
+}
+
+type containerState struct {
+	ID    string `json:"Id"`
+	Name  string `json:"Name"`
+	State struct {
+		Running bool `json:"Running"`
+		Paused  bool `json:"Paused"`
+	} `json:"State"`
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress string `json:"IPAddress"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+func (c *client) InspectContainer(id string) (*containerState, error) {
+	state := &containerState{}
+	if err := c.do("GET", "/containers/"+url.PathEscape(id)+"/json", nil, nil, state); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+func (c *client) StartContainer(id string) error {
+	err := c.do("POST", "/containers/"+url.PathEscape(id)+"/start", nil, nil, nil)
+	if isStatus(err, http.StatusNotModified) {
+		return nil
+	}
+
+	return err
+}
+
+func (c *client) StopContainer(id string, timeout time.Duration) error {
+	query := url.Values{"t": []string{fmt.Sprintf("%d", int(timeout.Seconds()))}}
+
+	err := c.do("POST", "/containers/"+url.PathEscape(id)+"/stop", query, nil, nil)
+	if isStatus(err, http.StatusNotModified) {
+		return nil
+	}
+
+	return err
+}
+
+func (c *client) PauseContainer(id string) error {
+	return c.do("POST", "/containers/"+url.PathEscape(id)+"/pause", nil, nil, nil)
+}
+
+func (c *client) UnpauseContainer(id string) error {
+	return c.do("POST", "/containers/"+url.PathEscape(id)+"/unpause", nil, nil, nil)
+}
+
+// RemoveContainer removes the container, killing it when running.
+func (c *client) RemoveContainer(id string) error {
+	query := url.Values{"force": []string{"1"}, "v": []string{"1"}}
+
+	err := c.do("DELETE", "/containers/"+url.PathEscape(id), query, nil, nil)
+	if isStatus(err, http.StatusNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+// ListContainers returns the names of the containers matching the name.
+func (c *client) ListContainers(name string) ([]string, error) {
+	filters, err := json.Marshal(map[string][]string{"name": []string{name}})
+	if err != nil {
+		return nil, err
+	}
+
+	containers := []struct {
+		Names []string `json:"Names"`
+	}{}
+
+	if err := c.do("GET", "/containers/json", url.Values{"all": []string{"1"}, "filters": []string{string(filters)}}, nil, &containers); err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, c := range containers {
+		for _, n := range c.Names {
+			names = append(names, strings.TrimPrefix(n, "/"))
+		}
+	}
+
+	return names, nil
+}
+
+// change is a change of the filesystem of a container, compared to its image.
+type change struct {
+	Path string `json:"Path"`
+	Kind int    `json:"Kind"`
+}
+
+const (
+	changeModified = 0
+	changeAdded    = 1
+	changeDeleted  = 2
+)
+
+func (c *client) ContainerChanges(id string) ([]change, error) {
+	changes := []change{}
+	if err := c.do("GET", "/containers/"+url.PathEscape(id)+"/changes", nil, nil, &changes); err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// ExportContainer returns the filesystem of the container as tar stream.
+func (c *client) ExportContainer(id string) (io.ReadCloser, error) {
+	return c.request("GET", "/containers/"+url.PathEscape(id)+"/export", nil, nil)
+}
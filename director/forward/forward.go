@@ -19,6 +19,8 @@ import (
 	"net"
 
 	"github.com/honeytrap/honeytrap/director"
+	"github.com/honeytrap/honeytrap/director/record"
+	"github.com/honeytrap/honeytrap/event"
 	"github.com/honeytrap/honeytrap/pushers"
 )
 
@@ -26,15 +28,30 @@ var (
 	_ = director.Register("forward", New)
 )
 
+var ErrNotAllowed = errors.New("forward target not allowed")
+
 func New(options ...func(director.Director) error) (director.Director, error) {
 	d := &forwardDirector{
-		eb: pushers.MustDummy(),
+		eb:     pushers.MustDummy(),
+		Record: record.DefaultConfig(),
 	}
 
 	for _, optionFn := range options {
-		optionFn(d)
+		if err := optionFn(d); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := d.Record.Validate(); err != nil {
+		return nil, err
 	}
 
+	rules, err := parseRules(d.Allow)
+	if err != nil {
+		return nil, err
+	}
+
+	d.rules = rules
 	return d, nil
 }
 
@@ -42,6 +59,14 @@ type forwardDirector struct {
 	eb pushers.Channel
 
 	Host string `toml:"host"`
+
+	// Allow restricts the targets being forwarded to, eg. "10.0.0.0/8",
+	// "192.168.1.10:22" or "*:80". Everything is allowed when empty.
+	Allow []string `toml:"allow"`
+
+	Record record.Config `toml:"record"`
+
+	rules []rule
 }
 
 func (d *forwardDirector) SetChannel(eb pushers.Channel) {
@@ -69,5 +94,53 @@ func (d *forwardDirector) Dial(conn net.Conn) (net.Conn, error) {
 		port = v
 	}
 
-	return net.Dial(protocol, net.JoinHostPort(host, port))
+	addr, err := d.resolve(host, port)
+	if err != nil {
+		d.eb.Send(event.New(
+			event.ProxySensor,
+			event.ProxyDenied,
+			event.Category("forward"),
+			event.SourceAddr(conn.RemoteAddr()),
+			event.DestinationAddr(conn.LocalAddr()),
+			event.Custom("proxy.target", net.JoinHostPort(host, port)),
+			event.Error(err),
+		))
+
+		return nil, err
+	}
+
+	c, err := net.Dial(protocol, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return record.Wrap(c, conn, d.eb, "forward", d.Record), nil
+}
+
+// resolve returns the address to dial, the host is being resolved to
+// check the allowlist and to prevent it resolving differently when dialing.
+func (d *forwardDirector) resolve(host, port string) (string, error) {
+	if len(d.rules) == 0 {
+		return net.JoinHostPort(host, port), nil
+	}
+
+	ips := []net.IP{}
+
+	if ip := net.ParseIP(host); ip != nil {
+		ips = append(ips, ip)
+	} else if addrs, err := net.LookupIP(host); err != nil {
+		return "", err
+	} else {
+		ips = addrs
+	}
+
+	for _, ip := range ips {
+		for _, r := range d.rules {
+			if r.Match(host, ip, port) {
+				return net.JoinHostPort(ip.String(), port), nil
+			}
+		}
+	}
+
+	return "", ErrNotAllowed
 }
@@ -0,0 +1,132 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package forward
+
+import (
+	"net"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/director"
+	"github.com/honeytrap/honeytrap/director/record"
+)
+
+type addrConn struct {
+	net.Conn
+
+	laddr net.Addr
+}
+
+func (c addrConn) LocalAddr() net.Addr {
+	return c.laddr
+}
+
+func (c addrConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4321}
+}
+
+func TestRules(t *testing.T) {
+	rules, err := parseRules([]string{"10.0.0.0/8", "192.168.1.10:22", "*:80", "backend.local", "[fd00::/8]:443"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allowed := func(host, ip, port string) bool {
+		for _, r := range rules {
+			if r.Match(host, net.ParseIP(ip), port) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for _, tc := range []struct {
+		host, ip, port string
+		expected       bool
+	}{
+		{"10.1.2.3", "10.1.2.3", "3306", true},
+		{"192.168.1.10", "192.168.1.10", "22", true},
+		{"192.168.1.10", "192.168.1.10", "23", false},
+		{"8.8.8.8", "8.8.8.8", "80", true},
+		{"8.8.8.8", "8.8.8.8", "53", false},
+		{"Backend.local", "172.16.0.1", "25", true},
+		{"fd00::1", "fd00::1", "443", true},
+		{"fd00::1", "fd00::1", "22", false},
+	} {
+		if allowed(tc.host, tc.ip, tc.port) != tc.expected {
+			t.Errorf("Expected %s (%s) port %s allowed to be %t", tc.host, tc.ip, tc.port, tc.expected)
+		}
+	}
+
+	for _, s := range []string{"10.0.0.0/33", "192.168.1.10:ssh", ":22"} {
+		if _, err := parseRules([]string{s}); err == nil {
+			t.Errorf("Expected error parsing %s", s)
+		}
+	}
+}
+
+func TestDial(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			conn.Close()
+		}
+	}()
+
+	for _, tc := range []struct {
+		allow    []string
+		expected error
+	}{
+		{nil, nil},
+		{[]string{"127.0.0.0/8"}, nil},
+		{[]string{"10.0.0.0/8", "127.0.0.1:1"}, ErrNotAllowed},
+	} {
+		d, err := New(func(d director.Director) error {
+			d.(*forwardDirector).Host = "127.0.0.1"
+			d.(*forwardDirector).Allow = tc.allow
+			d.(*forwardDirector).Record.Enabled = true
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn, err := d.Dial(addrConn{laddr: l.Addr()})
+		if err != tc.expected {
+			t.Errorf("Expected %v for %v, got %v", tc.expected, tc.allow, err)
+			continue
+		}
+
+		if err != nil {
+			continue
+		}
+
+		if _, ok := conn.(*record.Conn); !ok {
+			t.Errorf("Expected connection to be recorded")
+		}
+
+		conn.Close()
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package lxc
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChangesDir(t *testing.T) {
+	for rootfs, expected := range map[string]string{
+		"aufs:/var/lib/lxc/honeytrap/rootfs:/var/lib/lxc/honeytrap-1/delta0":      "/var/lib/lxc/honeytrap-1/delta0",
+		"overlayfs:/var/lib/lxc/honeytrap/rootfs:/var/lib/lxc/honeytrap-1/delta0": "/var/lib/lxc/honeytrap-1/delta0",
+		"dir:/var/lib/lxc/honeytrap-1/rootfs":                                     "/var/lib/lxc/honeytrap-1/rootfs",
+		"/var/lib/lxc/honeytrap-1/rootfs":                                         "/var/lib/lxc/honeytrap-1/rootfs",
+	} {
+		if dir := changesDir(rootfs); dir != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, rootfs, dir)
+		}
+	}
+}
+
+func TestArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "honeytrap-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "delta0")
+	if err := os.MkdirAll(filepath.Join(src, "root"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(src, "root", ".bash_history"), []byte("wget http://example.com/x\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(dir, "snapshots", "honeytrap-1.tar.gz")
+	if err := archive(src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+
+		names = append(names, hdr.Name)
+	}
+
+	if len(names) != 2 || names[0] != "root" || names[1] != "root/.bash_history" {
+		t.Errorf("Unexpected archive contents: %v", names)
+	}
+}
@@ -17,16 +17,17 @@
 package lxc
 
 import (
-	"encoding/hex"
 	"errors"
 	"fmt"
-	"hash/fnv"
 	"net"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/honeytrap/honeytrap/director"
 	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/storage"
 	lxc "gopkg.in/lxc/go-lxc.v2"
 )
 
@@ -37,70 +38,136 @@ var (
 func New(options ...func(director.Director) error) (director.Director, error) {
 	d := &lxcDirector{
 		eb:       pushers.MustDummy(),
-		template: "honeytrap",
+		Template: "honeytrap",
+		Delays: Delays{
+			FreezeDelay:      Delay(15 * time.Minute),
+			StopDelay:        Delay(30 * time.Minute),
+			HousekeeperDelay: Delay(1 * time.Minute),
+		},
 	}
 
 	for _, optionFn := range options {
-		optionFn(d)
+		if err := optionFn(d); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.Pool.SnapshotDir == "" {
+		d.Pool.SnapshotDir = filepath.Join(storage.DataDir(), "snapshots")
 	}
 
-	d.cache = &sync.Map{} // map[string]*lxcContainer{}
+	pool, err := director.NewPool("lxc", d.Pool, d.create, d.eb)
+	if err != nil {
+		return nil, err
+	}
+
+	d.pool = pool
+
 	return d, nil
 }
 
-type lxcDirector struct {
-	template string
-	eb       pushers.Channel
-	cache    *sync.Map // map[string]*lxcContainer
-}
+// Start destroys the unassigned containers of a previous run and
+// starts filling the pool.
+func (d *lxcDirector) Start() error {
+	// pre-cloned containers of a previous run haven't been assigned,
+	// unless assigned to a source that could return
+	for _, name := range lxc.DefinedContainerNames() {
+		if !strings.HasPrefix(name, "honeytrap-pool-") {
+			continue
+		}
 
-func (d *lxcDirector) SetChannel(eb pushers.Channel) {
-	d.eb = eb
+		if d.pool.InUse(name) {
+			continue
+		}
+
+		c := lxcContainer{name: name, d: d}
+		if err := c.open(); err != nil {
+			continue
+		}
+
+		if err := c.Destroy(); err != nil {
+			log.Errorf("Error destroying container %s: %s", name, err.Error())
+		}
+	}
+
+	go d.pool.Run()
+
+	return nil
 }
 
-func (d *lxcDirector) Dial(conn net.Conn) (net.Conn, error) {
-	h := fnv.New32()
+type lxcDirector struct {
+	Template string `toml:"template"`
 
-	remoteAddr, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
-	h.Write([]byte(remoteAddr))
-	hash := h.Sum(nil)
+	Delays
 
-	name := fmt.Sprintf("honeytrap-%s", hex.EncodeToString(hash))
+	Pool director.PoolConfig `toml:"pool"`
 
-	c, ok := d.cache.Load(name)
-	// c := d.cache[name]
-	if !ok {
-		var err error
+	eb   pushers.Channel
+	pool *director.Pool
+}
 
-		c, err = d.newContainer(name, d.template)
-		if err != nil {
-			log.Errorf("Error creating container: %s", err.Error())
-			return nil, err
-		}
+func (d *lxcDirector) SetChannel(eb pushers.Channel) {
+	d.eb = eb
+}
 
-		// m.Lock()
-		// d.cache[name] = c
-		//m.Unlock()
-		d.cache.Store(name, c)
+func (d *lxcDirector) Dial(conn net.Conn) (net.Conn, error) {
+	container, release, err := d.pool.Acquire(d.pool.Identify(conn.RemoteAddr()))
+	if err != nil {
+		log.Errorf("Error creating container: %s", err.Error())
+		return nil, err
 	}
 
-	if err := c.(*lxcContainer).ensureStarted(); err != nil {
+	c := container.(*lxcContainer)
+
+	if err := c.ensureStarted(); err != nil {
 		log.Errorf("Error creating container: %s", err.Error())
+		release()
 		return nil, err
 	}
 
 	// Housekeeper only runs in Running containers, so start it always
-	go c.(*lxcContainer).housekeeper()
+	c.startHousekeeper()
+
+	var connection net.Conn
 
 	if ta, ok := conn.LocalAddr().(*net.TCPAddr); ok {
-		connection, err := c.(*lxcContainer).Dial("tcp", ta.Port)
-		return lxcContainerConn{Conn: connection, container: c.(*lxcContainer)}, err
+		connection, err = c.Dial("tcp", ta.Port)
 	} else if ta, ok := conn.LocalAddr().(*net.UDPAddr); ok {
-		connection, err := c.(*lxcContainer).Dial("udp", ta.Port)
-		return lxcContainerConn{Conn: connection, container: c.(*lxcContainer)}, err
+		connection, err = c.Dial("udp", ta.Port)
 	} else {
-		return nil, errors.New("Unsupported protocol")
+		err = errors.New("Unsupported protocol")
+	}
+
+	if err != nil {
+		release()
+		return nil, err
 	}
+
+	return lxcContainerConn{Conn: connection, container: c, release: release}, nil
+}
+
+// create creates the container for the pool, warm containers are started
+// and frozen to be assigned later.
+func (d *lxcDirector) create(name string, warm bool) (director.Container, error) {
+	c, err := d.newContainer(name, d.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	if !warm {
+		return c, nil
+	}
+
+	if err := c.start(); err != nil {
+		return nil, err
+	}
+
+	if err := c.c.Freeze(); err != nil {
+		return nil, err
+	}
+
+	d.eb.Send(director.ContainerFrozenEvent(c.name))
+	return c, nil
 }
 
 type lxcContainer struct {
@@ -115,6 +182,10 @@ type lxcContainer struct {
 	idevice  string
 	template string
 	Delays   Delays
+
+	m            sync.Mutex
+	housekeeping bool
+	destroyed    bool
 }
 
 // NewContainer returns a new LxcContainer from the provider.
@@ -124,16 +195,11 @@ func (d *lxcDirector) newContainer(name string, template string) (*lxcContainer,
 		template: template,
 		eb:       d.eb,
 		d:        d,
-		Delays: Delays{
-			FreezeDelay:      Delay(15 * time.Minute),
-			StopDelay:        Delay(30 * time.Minute),
-			HousekeeperDelay: Delay(1 * time.Minute),
-		},
+		Delays:   d.Delays,
 	}
 
-	if c2, err := lxc.NewContainer(c.name); err == nil {
+	if err := c.open(); err == nil {
 		// TODO(nl5887): beautify
-		c.c = c2
 		return &c, nil
 	}
 
@@ -143,6 +209,40 @@ func (d *lxcDirector) newContainer(name string, template string) (*lxcContainer,
 	return &c, nil
 }
 
+// open opens an existing container.
+func (c *lxcContainer) open() error {
+	c2, err := lxc.NewContainer(c.name)
+	if err != nil {
+		return err
+	}
+
+	if !c2.Defined() {
+		lxc.Release(c2)
+		return fmt.Errorf("lxccontainer not defined %s", c.name)
+	}
+
+	c.c = c2
+	return nil
+}
+
+// Name returns the name of the container.
+func (c *lxcContainer) Name() string {
+	return c.name
+}
+
+// startHousekeeper starts the housekeeper, when not running already.
+func (c *lxcContainer) startHousekeeper() {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.housekeeping || c.destroyed {
+		return
+	}
+
+	c.housekeeping = true
+	go c.housekeeper()
+}
+
 // housekeeper handles the needed process of handling internal logic
 // in maintaining the provided lxc.Container.
 func (c *lxcContainer) housekeeper() {
@@ -150,27 +250,118 @@ func (c *lxcContainer) housekeeper() {
 	log.Infof("Housekeeper (%s) started.", c.name)
 	defer log.Infof("Housekeeper (%s) stopped.", c.name)
 
+	defer func() {
+		c.m.Lock()
+		c.housekeeping = false
+		c.m.Unlock()
+	}()
+
 	for {
 		time.Sleep(time.Duration(c.Delays.HousekeeperDelay))
 
-		if c.isStopped() {
-			continue
+		if !c.housekeep() {
+			return
 		}
+	}
+}
 
-		if time.Since(c.idle) > time.Duration(c.Delays.StopDelay) && c.isFrozen() {
-			log.Debugf("LxcContainer %s: idle for %s, stopping container", c.name, time.Now().Sub(c.idle).String())
-			c.c.Stop()
-			return
-		} else if time.Since(c.idle) > time.Duration(c.Delays.FreezeDelay) && c.isRunning() {
-			log.Debugf("LxcContainer %s: idle for %s, freezing container", c.name, time.Now().Sub(c.idle).String())
-			c.c.Freeze()
+// housekeep freezes or stops the container when idle, it returns false
+// when the container has been stopped or destroyed.
+func (c *lxcContainer) housekeep() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.destroyed {
+		return false
+	}
+
+	if c.isStopped() {
+		return true
+	}
+
+	if time.Since(c.idle) > time.Duration(c.Delays.StopDelay) && c.isFrozen() {
+		log.Debugf("LxcContainer %s: idle for %s, stopping container", c.name, time.Now().Sub(c.idle).String())
+		c.c.Stop()
+		c.d.eb.Send(director.ContainerStoppedEvent(c.name))
+		return false
+	} else if time.Since(c.idle) > time.Duration(c.Delays.FreezeDelay) && c.isRunning() {
+		log.Debugf("LxcContainer %s: idle for %s, freezing container", c.name, time.Now().Sub(c.idle).String())
+		c.c.Freeze()
+		c.d.eb.Send(director.ContainerFrozenEvent(c.name))
+	}
+
+	return true
+}
+
+// Destroy stops and destroys the container.
+func (c *lxcContainer) Destroy() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.destroyed {
+		return nil
+	}
+
+	if c.isFrozen() {
+		if err := c.c.Unfreeze(); err != nil {
+			return err
 		}
 	}
+
+	if !c.isStopped() {
+		if err := c.c.Stop(); err != nil {
+			return err
+		}
+	}
+
+	if err := c.c.Destroy(); err != nil {
+		return err
+	}
+
+	c.destroyed = true
+
+	lxc.Release(c.c)
+	return nil
+}
+
+// Snapshot stops the container and archives the changes made to the
+// root filesystem of the container.
+func (c *lxcContainer) Snapshot(dir string) (string, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.isFrozen() {
+		if err := c.c.Unfreeze(); err != nil {
+			return "", err
+		}
+	}
+
+	if !c.isStopped() {
+		if err := c.c.Stop(); err != nil {
+			return "", err
+		}
+	}
+
+	rootfs := c.c.ConfigItem("lxc.rootfs")
+	if len(rootfs) == 0 || rootfs[0] == "" {
+		rootfs = c.c.ConfigItem("lxc.rootfs.path")
+	}
+
+	if len(rootfs) == 0 || rootfs[0] == "" {
+		return "", fmt.Errorf("could not determine root filesystem of %s", c.name)
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.tar.gz", c.name, time.Now().UTC().Format("20060102T150405Z")))
+	if err := archive(changesDir(rootfs[0]), path); err != nil {
+		return "", err
+	}
+
+	return path, nil
 }
 
 // clone attempts to clone the underline lxc.Container.
 func (c *lxcContainer) clone() error {
-	log.Debugf("Creating new container %s from template %s", c.name, c.d.template)
+	log.Debugf("Creating new container %s from template %s", c.name, c.template)
 
 	c1, err := lxc.NewContainer(c.template)
 	if err != nil {
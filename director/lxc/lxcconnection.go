@@ -27,6 +27,9 @@ import (
 type lxcContainerConn struct {
 	net.Conn
 	container *lxcContainer
+
+	// release ends the session of the container in the pool
+	release func()
 }
 
 // Read reads the giving set of data from the container connection to the
@@ -42,6 +45,12 @@ func (c lxcContainerConn) Write(b []byte) (n int, err error) {
 	return c.Conn.Write(b)
 }
 
+// Close closes the container connection and releases the container.
+func (c lxcContainerConn) Close() error {
+	c.release()
+	return c.Conn.Close()
+}
+
 // stillActive returns an error if the containerr is not still active
 func (c *lxcContainer) stillActive() error {
 	if c.isStopped() {
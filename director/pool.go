@@ -0,0 +1,507 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package director
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/rs/xid"
+
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("honeytrap:director")
+
+var (
+	ErrPoolExhausted = errors.New("container pool exhausted")
+	ErrSessionLimit  = errors.New("session limit of source reached")
+)
+
+// Container is a container, or virtual machine, managed by a pool.
+type Container interface {
+	Name() string
+
+	// Snapshot archives the changes of the container into the
+	// directory, it returns the path of the archive.
+	Snapshot(dir string) (string, error)
+
+	// Destroy stops and removes the container.
+	Destroy() error
+}
+
+// CreateFunc creates the container with the name. Warm containers will be kept
+// ready in the pool, and should be started and frozen.
+type CreateFunc func(name string, warm bool) (Container, error)
+
+// PoolConfig defines the pool and lifecycle policies of containers.
+type PoolConfig struct {
+	// Size is the number of pre-cloned, frozen containers kept ready.
+	Size int `toml:"size"`
+
+	// MaxContainers limits the number of containers, ready
+	// containers included.
+	MaxContainers int `toml:"max_containers"`
+
+	// MaxSessionsPerIP limits the concurrent sessions of a source.
+	MaxSessionsPerIP int `toml:"max_sessions_per_ip"`
+
+	// ResetAfterSession destroys the container of a source
+	// when its last session has ended.
+	ResetAfterSession bool `toml:"reset_after_session"`
+
+	// DestroyAfter destroys containers idle for the duration.
+	DestroyAfter config.Delay `toml:"destroy_after"`
+
+	// SnapshotBeforeDestroy archives the changes of containers
+	// into SnapshotDir before they are destroyed.
+	SnapshotBeforeDestroy bool   `toml:"snapshot_before_destroy"`
+	SnapshotDir           string `toml:"snapshot_dir"`
+
+	// ReportEvery is the interval of pool utilisation events.
+	ReportEvery config.Delay `toml:"report_every"`
+
+	// Affinity assigns returning sources to the same container.
+	Affinity AffinityConfig `toml:"affinity"`
+}
+
+type assignment struct {
+	// key is the identity of the source, it changes when the ssh key or
+	// credentials of the source are observed
+	key string
+
+	container Container
+	err       error
+
+	// closed when the container has been created
+	done chan struct{}
+
+	// set while the container is being destroyed, closed when destroyed
+	destroying chan struct{}
+
+	sessions int
+	idle     time.Time
+}
+
+// Pool assigns containers to sources, from pre-cloned containers
+// when available.
+type Pool struct {
+	PoolConfig
+
+	name     string
+	create   CreateFunc
+	eb       pushers.Channel
+	affinity *Affinity
+
+	m        sync.Mutex
+	ready    []Container
+	assigned map[string]*assignment
+	creating int
+}
+
+// NewPool returns a pool for the director, containers are named
+// after the prefix.
+func NewPool(name string, c PoolConfig, create CreateFunc, eb pushers.Channel) (*Pool, error) {
+	if c.ReportEvery == 0 {
+		c.ReportEvery = config.Delay(time.Minute)
+	}
+
+	affinity, err := NewAffinity(c.Affinity, affinityStorage())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Pool{
+		PoolConfig: c,
+		name:       name,
+		create:     create,
+		eb:         eb,
+		affinity:   affinity,
+		assigned:   map[string]*assignment{},
+	}, nil
+}
+
+// ContainerName returns the name of the container of the source, for
+// containers created on demand.
+func ContainerName(key string) string {
+	h := fnv.New32()
+	h.Write([]byte(key))
+
+	return fmt.Sprintf("honeytrap-%s", hex.EncodeToString(h.Sum(nil)))
+}
+
+// Identify returns the key of the source address, according
+// to the configured affinity. Sources are identified by their ip address
+// until their ssh key or credentials have been observed, the container
+// assigned to the ip address is assigned to the identity when it is
+// first seen.
+func (p *Pool) Identify(addr net.Addr) string {
+	key := p.affinity.Identify(addr)
+
+	switch p.affinity.Identity {
+	case IdentitySSHKey, IdentityCredentials:
+	default:
+		return key
+	}
+
+	host := addrHost(addr)
+	if key == host {
+		return key
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if _, ok := p.assigned[key]; ok {
+		return key
+	}
+
+	if a, ok := p.assigned[host]; ok {
+		if a.destroying != nil {
+			return key
+		}
+
+		delete(p.assigned, host)
+
+		a.key = key
+		p.assigned[key] = a
+	}
+
+	if name, ok := p.affinity.Alias(host, key); ok {
+		log.Debugf("Assigned container %s of %s to %s", name, host, key)
+	}
+
+	return key
+}
+
+// InUse returns whether the container is assigned to a source, possibly
+// by a previous run.
+func (p *Pool) InUse(name string) bool {
+	return p.affinity.InUse(name)
+}
+
+func (p *Pool) total() int {
+	return len(p.ready) + len(p.assigned) + p.creating
+}
+
+// Acquire returns the container assigned to the source, the returned
+// function should be called when the session has ended.
+func (p *Pool) Acquire(key string) (Container, func(), error) {
+	p.m.Lock()
+
+	a, ok := p.assigned[key]
+	for ok && a.destroying != nil {
+		// wait for the container of the source to be destroyed, as
+		// it would be reopened otherwise
+		destroying := a.destroying
+		p.m.Unlock()
+
+		<-destroying
+
+		p.m.Lock()
+		a, ok = p.assigned[key]
+	}
+
+	if ok {
+		if p.MaxSessionsPerIP > 0 && a.sessions >= p.MaxSessionsPerIP {
+			p.m.Unlock()
+			return nil, nil, ErrSessionLimit
+		}
+
+		a.sessions++
+		p.m.Unlock()
+
+		<-a.done
+
+		release := p.releaseFunc(a)
+
+		if a.err != nil {
+			release()
+			return nil, nil, a.err
+		}
+
+		p.affinity.Assign(key, a.container.Name())
+		return a.container, release, nil
+	}
+
+	a = &assignment{
+		key:      key,
+		done:     make(chan struct{}),
+		sessions: 1,
+	}
+
+	// returning sources get the container assigned before, by
+	// a previous run or another service
+	name, returning := p.affinity.Backend(key)
+	if !returning {
+		name = ContainerName(key)
+	}
+
+	if len(p.ready) > 0 && !returning {
+		a.container = p.ready[0]
+		p.ready = p.ready[1:]
+
+		close(a.done)
+
+		p.assigned[key] = a
+		p.m.Unlock()
+
+		go p.fill()
+
+		p.affinity.Assign(key, a.container.Name())
+		return a.container, p.releaseFunc(a), nil
+	}
+
+	if p.MaxContainers > 0 && p.total() >= p.MaxContainers && !p.evict() {
+		p.m.Unlock()
+		return nil, nil, ErrPoolExhausted
+	}
+
+	p.assigned[key] = a
+	p.m.Unlock()
+
+	c, err := p.create(name, false)
+
+	p.m.Lock()
+	a.container, a.err = c, err
+
+	if err != nil {
+		delete(p.assigned, key)
+	}
+
+	close(a.done)
+	p.m.Unlock()
+
+	if err != nil {
+		p.eb.Send(ContainerErrorEvent(name, err))
+		return nil, nil, err
+	}
+
+	if returning {
+		log.Debugf("Assigned container %s to returning source %s", name, key)
+	}
+
+	p.affinity.Assign(key, c.Name())
+	return c, p.releaseFunc(a), nil
+}
+
+func (p *Pool) releaseFunc(a *assignment) func() {
+	once := sync.Once{}
+
+	return func() {
+		once.Do(func() {
+			p.m.Lock()
+			defer p.m.Unlock()
+
+			a.sessions--
+			a.idle = time.Now()
+
+			if a.sessions > 0 || a.err != nil || !p.ResetAfterSession {
+				return
+			}
+
+			if p.assigned[a.key] != a || a.destroying != nil {
+				return
+			}
+
+			p.remove(a.key, a)
+		})
+	}
+}
+
+// evict destroys the container idle the longest, to make room
+// for a new container.
+func (p *Pool) evict() bool {
+	var (
+		key string
+		a   *assignment
+	)
+
+	for k, v := range p.assigned {
+		if v.sessions > 0 || v.container == nil || v.destroying != nil {
+			continue
+		}
+
+		if a == nil || v.idle.Before(a.idle) {
+			key, a = k, v
+		}
+	}
+
+	if a == nil {
+		return false
+	}
+
+	p.remove(key, a)
+	return true
+}
+
+// reclaim destroys the containers idle longer than allowed.
+func (p *Pool) reclaim() {
+	if p.DestroyAfter == 0 {
+		return
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	for key, a := range p.assigned {
+		if a.sessions > 0 || a.container == nil || a.destroying != nil {
+			continue
+		}
+
+		if time.Since(a.idle) < p.DestroyAfter.Duration() {
+			continue
+		}
+
+		p.remove(key, a)
+	}
+}
+
+// remove destroys the container of the source, the source stays assigned
+// until the container has been destroyed. Should be called with the lock held.
+func (p *Pool) remove(key string, a *assignment) {
+	a.destroying = make(chan struct{})
+
+	go func() {
+		p.destroy(key, a.container)
+
+		p.m.Lock()
+		if p.assigned[key] == a {
+			delete(p.assigned, key)
+		}
+
+		close(a.destroying)
+		p.m.Unlock()
+
+		p.fill()
+	}()
+}
+
+func (p *Pool) destroy(key string, c Container) {
+	p.affinity.Forget(key, c.Name())
+
+	if p.SnapshotBeforeDestroy {
+		if path, err := c.Snapshot(p.SnapshotDir); err != nil {
+			log.Errorf("Error creating snapshot of container %s: %s", c.Name(), err.Error())
+			p.eb.Send(ContainerErrorEvent(c.Name(), err))
+		} else {
+			p.eb.Send(ContainerSnapshotEvent(c.Name(), path))
+		}
+	}
+
+	if err := c.Destroy(); err != nil {
+		log.Errorf("Error destroying container %s: %s", c.Name(), err.Error())
+		p.eb.Send(ContainerErrorEvent(c.Name(), err))
+	} else {
+		p.eb.Send(ContainerDestroyedEvent(c.Name()))
+	}
+}
+
+// fill creates warm containers until the pool has been filled.
+func (p *Pool) fill() {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	for len(p.ready)+p.creating < p.Size {
+		if p.MaxContainers > 0 && p.total() >= p.MaxContainers {
+			return
+		}
+
+		name := fmt.Sprintf("honeytrap-pool-%s", xid.New().String())
+
+		p.creating++
+		p.m.Unlock()
+
+		c, err := p.create(name, true)
+
+		p.m.Lock()
+		p.creating--
+
+		if err != nil {
+			log.Errorf("Error creating container %s for pool: %s", name, err.Error())
+			p.eb.Send(ContainerErrorEvent(name, err))
+			return
+		}
+
+		p.ready = append(p.ready, c)
+	}
+}
+
+// Stats returns the utilisation of the pool.
+func (p *Pool) Stats() PoolStats {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	stats := PoolStats{
+		Ready:    len(p.ready),
+		Assigned: len(p.assigned),
+		Creating: p.creating,
+		Size:     p.Size,
+		Max:      p.MaxContainers,
+	}
+
+	for _, a := range p.assigned {
+		stats.Sessions += a.sessions
+	}
+
+	return stats
+}
+
+// Containers returns the names of the assigned containers by source.
+func (p *Pool) Containers() map[string]string {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	containers := map[string]string{}
+	for key, a := range p.assigned {
+		if a.container != nil && a.destroying == nil {
+			containers[key] = a.container.Name()
+		}
+	}
+
+	return containers
+}
+
+// Run fills the pool, reclaims idle containers and reports the
+// utilisation of the pool periodically. Expired affinity records are
+// purged every hour.
+func (p *Pool) Run() {
+	p.affinity.Purge()
+	p.fill()
+
+	ticker := time.NewTicker(p.ReportEvery.Duration())
+	defer ticker.Stop()
+
+	purge := time.NewTicker(affinityPurgeEvery)
+	defer purge.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.reclaim()
+			p.fill()
+
+			p.eb.Send(ContainerPoolEvent(p.name, p.Stats()))
+		case <-purge.C:
+			p.affinity.Purge()
+		}
+	}
+}
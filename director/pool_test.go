@@ -0,0 +1,325 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package director
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+type fakeContainer struct {
+	name string
+	warm bool
+
+	m         sync.Mutex
+	destroyed bool
+}
+
+func (c *fakeContainer) Name() string {
+	return c.name
+}
+
+func (c *fakeContainer) Snapshot(dir string) (string, error) {
+	return dir + "/" + c.name + ".tar.gz", nil
+}
+
+func (c *fakeContainer) Destroy() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.destroyed = true
+	return nil
+}
+
+func (c *fakeContainer) isDestroyed() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.destroyed
+}
+
+func fakeCreate(name string, warm bool) (Container, error) {
+	return &fakeContainer{name: name, warm: warm}, nil
+}
+
+type eventChannel chan event.Event
+
+func (ec eventChannel) Send(e event.Event) {
+	ec <- e
+}
+
+// testPool returns a pool keeping its affinity in memory, so tests
+// don't share assignments.
+func testPool(t *testing.T, c PoolConfig, create CreateFunc, eb pushers.Channel) *Pool {
+	p, err := NewPool("test", c, create, eb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.affinity.s = newMemoryStorage()
+	return p
+}
+
+func waitFor(t *testing.T, fn func() bool) {
+	for i := 0; i < 100; i++ {
+		if fn() {
+			return
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	t.Fatal("Timeout waiting for condition")
+}
+
+func TestPoolAcquireWarm(t *testing.T) {
+	p := testPool(t, PoolConfig{
+		Size: 2,
+	}, fakeCreate, pushers.MustDummy())
+
+	p.fill()
+
+	if stats := p.Stats(); stats.Ready != 2 {
+		t.Fatalf("Expected 2 ready containers, got %d", stats.Ready)
+	}
+
+	c, release, err := p.Acquire("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !c.(*fakeContainer).warm {
+		t.Errorf("Expected warm container to be assigned")
+	}
+
+	// same source gets the same container
+	c2, release2, err := p.Acquire("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c2 != c {
+		t.Errorf("Expected same container for same source")
+	}
+
+	release()
+	release()
+	release2()
+
+	// pool is being filled again
+	waitFor(t, func() bool {
+		return p.Stats().Ready == 2
+	})
+
+	stats := p.Stats()
+	if stats.Assigned != 1 || stats.Sessions != 0 {
+		t.Errorf("Expected 1 assigned container without sessions, got %+v", stats)
+	}
+}
+
+func TestPoolAcquireOnDemand(t *testing.T) {
+	p := testPool(t, PoolConfig{}, fakeCreate, pushers.MustDummy())
+
+	c, release, err := p.Acquire("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer release()
+
+	if c.Name() != ContainerName("10.0.0.1") {
+		t.Errorf("Expected container name %s, got %s", ContainerName("10.0.0.1"), c.Name())
+	}
+}
+
+func TestPoolCreateError(t *testing.T) {
+	p := testPool(t, PoolConfig{}, func(name string, warm bool) (Container, error) {
+		return nil, errors.New("create failed")
+	}, pushers.MustDummy())
+
+	if _, _, err := p.Acquire("10.0.0.1"); err == nil {
+		t.Fatal("Expected error")
+	}
+
+	if stats := p.Stats(); stats.Assigned != 0 {
+		t.Errorf("Expected no assigned containers, got %d", stats.Assigned)
+	}
+}
+
+func TestPoolMaxSessionsPerIP(t *testing.T) {
+	p := testPool(t, PoolConfig{
+		MaxSessionsPerIP: 1,
+	}, fakeCreate, pushers.MustDummy())
+
+	_, release, err := p.Acquire("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := p.Acquire("10.0.0.1"); err != ErrSessionLimit {
+		t.Errorf("Expected ErrSessionLimit, got %v", err)
+	}
+
+	release()
+
+	if _, _, err := p.Acquire("10.0.0.1"); err != nil {
+		t.Errorf("Expected session after release, got %v", err)
+	}
+}
+
+func TestPoolMaxContainers(t *testing.T) {
+	p := testPool(t, PoolConfig{
+		MaxContainers: 1,
+	}, fakeCreate, pushers.MustDummy())
+
+	c, release, err := p.Acquire("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := p.Acquire("10.0.0.2"); err != ErrPoolExhausted {
+		t.Errorf("Expected ErrPoolExhausted, got %v", err)
+	}
+
+	release()
+
+	// idle container will be evicted
+	if _, _, err := p.Acquire("10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, c.(*fakeContainer).isDestroyed)
+}
+
+func TestPoolResetAfterSession(t *testing.T) {
+	ch := make(eventChannel, 10)
+
+	p := testPool(t, PoolConfig{
+		ResetAfterSession:     true,
+		SnapshotBeforeDestroy: true,
+		SnapshotDir:           "snapshots",
+	}, fakeCreate, ch)
+
+	c, release, err := p.Acquire("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release()
+
+	waitFor(t, c.(*fakeContainer).isDestroyed)
+
+	for _, expected := range []event.Event{
+		ContainerSnapshotEvent(c.Name(), "snapshots/"+c.Name()+".tar.gz"),
+		ContainerDestroyedEvent(c.Name()),
+	} {
+		e := <-ch
+
+		if e.Get("type") != expected.Get("type") || e.Get("container-snapshot") != expected.Get("container-snapshot") {
+			t.Errorf("Expected event %v, got %v", expected, e)
+		}
+	}
+
+	waitFor(t, func() bool {
+		return p.Stats().Assigned == 0
+	})
+}
+
+// slowContainer blocks destroying until unblocked.
+type slowContainer struct {
+	*fakeContainer
+
+	unblock chan struct{}
+}
+
+func (c *slowContainer) Destroy() error {
+	<-c.unblock
+	return c.fakeContainer.Destroy()
+}
+
+func TestPoolReacquireWhileDestroying(t *testing.T) {
+	unblock := make(chan struct{})
+
+	p := testPool(t, PoolConfig{
+		ResetAfterSession: true,
+	}, func(name string, warm bool) (Container, error) {
+		return &slowContainer{&fakeContainer{name: name}, unblock}, nil
+	}, pushers.MustDummy())
+
+	c, release, err := p.Acquire("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release()
+
+	acquired := make(chan Container)
+
+	go func() {
+		c2, _, err := p.Acquire("10.0.0.1")
+		if err != nil {
+			t.Error(err)
+		}
+
+		acquired <- c2
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("Expected source to wait for its container to be destroyed")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	close(unblock)
+
+	c2 := <-acquired
+
+	if c2 == c {
+		t.Error("Expected a new container")
+	}
+
+	if !c.(*slowContainer).isDestroyed() {
+		t.Error("Expected container to be destroyed before the source got a new one")
+	}
+}
+
+func TestPoolReclaim(t *testing.T) {
+	p := testPool(t, PoolConfig{
+		DestroyAfter: config.Delay(time.Millisecond),
+	}, fakeCreate, pushers.MustDummy())
+
+	c, release, err := p.Acquire("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.reclaim()
+
+	if c.(*fakeContainer).isDestroyed() {
+		t.Fatal("Expected container with active session not to be destroyed")
+	}
+
+	release()
+
+	time.Sleep(time.Millisecond * 5)
+	p.reclaim()
+
+	waitFor(t, c.(*fakeContainer).isDestroyed)
+}
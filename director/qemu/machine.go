@@ -0,0 +1,588 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package qemu
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/director"
+)
+
+var (
+	// slirp assigns this address to the guest with user networking
+	userNetworkIP = net.IPv4(10, 0, 2, 15)
+
+	errMachineDestroyed = errors.New("machine has been destroyed")
+)
+
+// machine is a virtual machine booted from a copy-on-write overlay
+// of the base image.
+type machine struct {
+	d *qemuDirector
+
+	name    string
+	overlay string
+	socket  string
+	mac     string
+
+	m        sync.Mutex
+	cmd      *exec.Cmd
+	exited   chan struct{}
+	qmp      *qmp
+	ip       net.IP
+	forwards map[string]int
+	frozen   bool
+	idle     time.Time
+
+	housekeeping bool
+	destroyed    bool
+}
+
+// macAddress returns a stable, locally administered mac address for the
+// machine, used to find the address of the machine with tap networking.
+func macAddress(name string) string {
+	h := fnv.New32()
+	h.Write([]byte(name))
+
+	b := h.Sum(nil)
+	return fmt.Sprintf("52:54:00:%02x:%02x:%02x", b[1], b[2], b[3])
+}
+
+// Name returns the name of the machine.
+func (m *machine) Name() string {
+	return m.name
+}
+
+// createOverlay creates the copy-on-write overlay of the base image.
+func (m *machine) createOverlay() error {
+	image, err := filepath.Abs(m.d.Image)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Creating new machine %s from image %s", m.name, image)
+
+	if _, err := m.d.img("create", "-f", "qcow2", "-F", m.d.ImageFormat, "-b", image, m.overlay); err != nil {
+		return err
+	}
+
+	m.d.eb.Send(director.ContainerClonedEvent(m.name, m.d.Image))
+	return nil
+}
+
+func (m *machine) running() bool {
+	if m.cmd == nil {
+		return false
+	}
+
+	select {
+	case <-m.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// boot starts qemu and connects to its machine protocol socket.
+func (m *machine) boot() error {
+	log.Infof("Starting machine %s", m.name)
+
+	os.Remove(m.socket)
+
+	stderr := &bytes.Buffer{}
+
+	cmd := exec.Command(m.d.Binary, m.d.args(m)...)
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan struct{})
+
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			log.Errorf("Machine %s exited: %s (%s)", m.name, err.Error(), strings.TrimSpace(stderr.String()))
+		}
+
+		close(exited)
+	}()
+
+	q, err := dialQMP(m.socket, time.Second*30)
+	if err != nil {
+		cmd.Process.Kill()
+		<-exited
+		return err
+	}
+
+	m.cmd, m.exited, m.qmp = cmd, exited, q
+	m.forwards = map[string]int{}
+	m.frozen = false
+	m.idle = time.Now()
+
+	if m.d.Network == "user" {
+		m.ip = userNetworkIP
+	} else {
+		m.ip = nil
+	}
+
+	m.d.eb.Send(director.ContainerStartedEvent(m.name))
+	return nil
+}
+
+// pause pauses the virtual cpus of the machine.
+func (m *machine) pause() error {
+	log.Infof("Freezing machine: %s", m.name)
+
+	if _, err := m.qmp.Execute("stop", nil); err != nil {
+		return err
+	}
+
+	m.frozen = true
+
+	m.d.eb.Send(director.ContainerFrozenEvent(m.name))
+	return nil
+}
+
+// resume resumes the virtual cpus of the machine.
+func (m *machine) resume() error {
+	log.Infof("Unfreezing machine: %s", m.name)
+
+	if _, err := m.qmp.Execute("cont", nil); err != nil {
+		return err
+	}
+
+	m.frozen = false
+
+	m.d.eb.Send(director.ContainerUnfrozenEvent(m.name, m.ip))
+	return nil
+}
+
+// shutdown stops qemu, the overlay is kept.
+func (m *machine) shutdown() {
+	if !m.running() {
+		return
+	}
+
+	log.Infof("Stopping machine: %s", m.name)
+
+	m.qmp.Execute("quit", nil)
+
+	select {
+	case <-m.exited:
+	case <-time.After(time.Second * 10):
+		m.cmd.Process.Kill()
+		<-m.exited
+	}
+
+	m.qmp.Close()
+	m.qmp = nil
+
+	m.d.eb.Send(director.ContainerStoppedEvent(m.name))
+}
+
+// freeze pauses the machine, used for machines waiting in the pool.
+func (m *machine) freeze() error {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	return m.pause()
+}
+
+func (m *machine) ensureStarted() error {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	if m.destroyed {
+		return errMachineDestroyed
+	}
+
+	if !m.running() {
+		if err := m.boot(); err != nil {
+			return err
+		}
+	} else if m.frozen {
+		if err := m.resume(); err != nil {
+			return err
+		}
+	}
+
+	m.idle = time.Now()
+	return nil
+}
+
+// stillActive resumes the machine when it has been frozen meanwhile.
+func (m *machine) stillActive() error {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	if !m.running() {
+		return fmt.Errorf("machine not running %s", m.name)
+	}
+
+	if m.frozen {
+		if err := m.resume(); err != nil {
+			return err
+		}
+	}
+
+	m.idle = time.Now()
+	return nil
+}
+
+// address returns the address to reach the port of the machine. With user
+// networking the port will be forwarded from the loopback interface.
+func (m *machine) address(network string, port int) (string, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	if !m.running() {
+		return "", fmt.Errorf("machine not running %s", m.name)
+	}
+
+	if m.d.Network == "tap" {
+		if m.ip == nil {
+			ip, err := arpLookup(m.mac)
+			if err != nil {
+				return "", err
+			}
+
+			m.ip = ip
+		}
+
+		return net.JoinHostPort(m.ip.String(), fmt.Sprintf("%d", port)), nil
+	}
+
+	key := fmt.Sprintf("%s/%d", network, port)
+
+	if hostPort, ok := m.forwards[key]; ok {
+		return fmt.Sprintf("127.0.0.1:%d", hostPort), nil
+	}
+
+	hostPort, err := freePort(network)
+	if err != nil {
+		return "", err
+	}
+
+	// human monitor commands report errors in their output
+	output, err := m.qmp.HumanMonitorCommand(fmt.Sprintf("hostfwd_add net0 %s:127.0.0.1:%d-:%d", network, hostPort, port))
+	if err != nil {
+		return "", err
+	} else if output = strings.TrimSpace(output); output != "" {
+		return "", fmt.Errorf("could not forward port %s: %s", key, output)
+	}
+
+	m.forwards[key] = hostPort
+
+	return fmt.Sprintf("127.0.0.1:%d", hostPort), nil
+}
+
+// Dial connects to the port of the machine, waiting for the machine
+// to boot until the boot timeout.
+func (m *machine) Dial(network string, port int) (net.Conn, error) {
+	start := time.Now()
+
+	for {
+		addr, err := m.address(network, port)
+		if err == nil {
+			conn, err := net.DialTimeout(network, addr, time.Second*5)
+			if err == nil {
+				return conn, nil
+			}
+		}
+
+		if time.Since(start) < m.d.BootTimeout.Duration() {
+			log.Debugf("Waiting for machine to be fully started %s", m.name)
+			time.Sleep(time.Millisecond * 500)
+			continue
+		}
+
+		return nil, fmt.Errorf("could not connect to machine %s", m.name)
+	}
+}
+
+// startHousekeeper starts the housekeeper, when not running already.
+func (m *machine) startHousekeeper() {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	if m.housekeeping || m.destroyed {
+		return
+	}
+
+	m.housekeeping = true
+	go m.housekeeper()
+}
+
+// housekeeper freezes and stops the machine when idle.
+func (m *machine) housekeeper() {
+	log.Infof("Housekeeper (%s) started.", m.name)
+	defer log.Infof("Housekeeper (%s) stopped.", m.name)
+
+	defer func() {
+		m.m.Lock()
+		m.housekeeping = false
+		m.m.Unlock()
+	}()
+
+	for {
+		time.Sleep(m.d.HousekeeperDelay.Duration())
+
+		if !m.housekeep() {
+			return
+		}
+	}
+}
+
+// housekeep returns false when the machine has been stopped or destroyed.
+func (m *machine) housekeep() bool {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	if m.destroyed || !m.running() {
+		return false
+	}
+
+	if time.Since(m.idle) > m.d.StopDelay.Duration() && m.frozen {
+		log.Debugf("Machine %s: idle for %s, stopping machine", m.name, time.Since(m.idle).String())
+		m.shutdown()
+		return false
+	} else if time.Since(m.idle) > m.d.FreezeDelay.Duration() && !m.frozen {
+		log.Debugf("Machine %s: idle for %s, freezing machine", m.name, time.Since(m.idle).String())
+
+		if err := m.pause(); err != nil {
+			log.Errorf("Error freezing machine %s: %s", m.name, err.Error())
+		}
+	}
+
+	return true
+}
+
+// Destroy stops the machine and removes its overlay.
+func (m *machine) Destroy() error {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	if m.destroyed {
+		return nil
+	}
+
+	m.shutdown()
+	m.d.cleanup(m)
+
+	m.destroyed = true
+	return nil
+}
+
+// Snapshot stops the machine and copies its overlay, containing all changes
+// to the base image, to the directory. The extents of the disk written by
+// the machine are stored next to it.
+func (m *machine) Snapshot(dir string) (string, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.shutdown()
+
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", err
+	}
+
+	base := filepath.Join(dir, fmt.Sprintf("%s-%s", m.name, time.Now().UTC().Format("20060102T150405Z")))
+
+	if err := copyFile(m.overlay, base+".qcow2"); err != nil {
+		return "", err
+	}
+
+	output, err := m.d.img("map", "--output=json", m.overlay)
+	if err != nil {
+		return "", err
+	}
+
+	extents, err := changedExtents(output)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.MarshalIndent(extents, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	if err := ioutil.WriteFile(base+".json", data, 0640); err != nil {
+		return "", err
+	}
+
+	return base + ".qcow2", nil
+}
+
+// extent is a region of the disk, as reported by qemu-img map.
+type extent struct {
+	Start  int64 `json:"start"`
+	Length int64 `json:"length"`
+	Depth  int   `json:"depth"`
+	Zero   bool  `json:"zero"`
+	Data   bool  `json:"data"`
+}
+
+// changedExtents returns the extents allocated in the overlay itself,
+// being the regions of the disk written by the machine.
+func changedExtents(data []byte) ([]extent, error) {
+	extents := []extent{}
+	if err := json.Unmarshal(data, &extents); err != nil {
+		return nil, err
+	}
+
+	changed := []extent{}
+	for _, e := range extents {
+		if e.Depth != 0 {
+			continue
+		}
+
+		if !e.Data && !e.Zero {
+			continue
+		}
+
+		changed = append(changed, e)
+	}
+
+	return changed, nil
+}
+
+func copyFile(src, dst string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	w, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+// freePort returns a free port on the loopback interface.
+func freePort(network string) (int, error) {
+	if network == "udp" {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			return 0, err
+		}
+
+		defer pc.Close()
+		return pc.LocalAddr().(*net.UDPAddr).Port, nil
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+var arpTable = "/proc/net/arp"
+
+// arpLookup returns the address of the machine with the mac address, from
+// the arp table of the host.
+func arpLookup(mac string) (net.IP, error) {
+	f, err := os.Open(arpTable)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return parseARP(f, mac)
+}
+
+func parseARP(r io.Reader, mac string) (net.IP, error) {
+	scanner := bufio.NewScanner(r)
+
+	// skip header
+	scanner.Scan()
+
+	for scanner.Scan() {
+		// IP address, HW type, Flags, HW address, Mask, Device
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+
+		if !strings.EqualFold(fields[3], mac) {
+			continue
+		}
+
+		// incomplete entry
+		if fields[2] == "0x0" {
+			continue
+		}
+
+		return net.ParseIP(fields[0]), nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, fmt.Errorf("no address found for %s", mac)
+}
+
+// machineConn is a connection to the machine, keeping the machine active
+// while in use.
+type machineConn struct {
+	net.Conn
+
+	machine *machine
+	release func()
+}
+
+func (c *machineConn) Read(b []byte) (int, error) {
+	c.machine.stillActive()
+	return c.Conn.Read(b)
+}
+
+func (c *machineConn) Write(b []byte) (int, error) {
+	c.machine.stillActive()
+	return c.Conn.Write(b)
+}
+
+// Close closes the connection and releases the machine.
+func (c *machineConn) Close() error {
+	c.release()
+	return c.Conn.Close()
+}
@@ -15,12 +15,24 @@ package qemu
 
 import (
 	"errors"
+	"fmt"
 	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/honeytrap/honeytrap/config"
 	"github.com/honeytrap/honeytrap/director"
 	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/storage"
+
+	logging "github.com/op/go-logging"
 )
 
+var log = logging.MustGetLogger("director/qemu")
+
 var (
 	_ = director.Register("qemu", New)
 )
@@ -28,17 +40,127 @@ var (
 func New(options ...func(director.Director) error) (director.Director, error) {
 	d := &qemuDirector{
 		eb: pushers.MustDummy(),
+
+		Binary:         "qemu-system-x86_64",
+		ImgBinary:      "qemu-img",
+		ImageFormat:    "qcow2",
+		Memory:         512,
+		CPUs:           1,
+		DriveInterface: "virtio",
+		NIC:            "virtio-net-pci",
+		Network:        "user",
+		Helper:         "/usr/lib/qemu/qemu-bridge-helper",
+
+		BootTimeout:      config.Delay(2 * time.Minute),
+		FreezeDelay:      config.Delay(15 * time.Minute),
+		StopDelay:        config.Delay(30 * time.Minute),
+		HousekeeperDelay: config.Delay(1 * time.Minute),
 	}
 
 	for _, optionFn := range options {
-		optionFn(d)
+		if err := optionFn(d); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.Image == "" {
+		return nil, errors.New("Qemu director needs a base image")
 	}
 
+	switch d.Network {
+	case "user":
+	case "tap":
+		if d.Bridge == "" {
+			return nil, errors.New("Qemu director needs a bridge for tap networking")
+		}
+
+		if d.AllowEgress {
+			log.Warningf("Egress of tap networking depends on bridge %s, allow_egress is ignored", d.Bridge)
+		}
+	default:
+		return nil, fmt.Errorf("Qemu director doesn't support network %s", d.Network)
+	}
+
+	if d.OverlayDir == "" {
+		d.OverlayDir = filepath.Join(storage.DataDir(), "qemu")
+	}
+
+	if d.Pool.SnapshotDir == "" {
+		d.Pool.SnapshotDir = filepath.Join(storage.DataDir(), "snapshots")
+	}
+
+	pool, err := director.NewPool("qemu", d.Pool, d.create, d.eb)
+	if err != nil {
+		return nil, err
+	}
+
+	d.pool = pool
+
 	return d, nil
 }
 
+// Start removes the overlays of unassigned machines of a previous run and
+// starts filling the pool.
+func (d *qemuDirector) Start() error {
+	if err := os.MkdirAll(d.OverlayDir, 0750); err != nil {
+		return err
+	}
+
+	// overlays of pre-booted machines of a previous run haven't been assigned,
+	// unless assigned to a source that could return
+	if matches, err := filepath.Glob(filepath.Join(d.OverlayDir, "honeytrap-pool-*")); err == nil {
+		for _, match := range matches {
+			name := strings.TrimSuffix(filepath.Base(match), filepath.Ext(match))
+			if d.pool.InUse(name) {
+				continue
+			}
+
+			os.Remove(match)
+		}
+	}
+
+	go d.pool.Run()
+
+	return nil
+}
+
 type qemuDirector struct {
 	eb pushers.Channel
+
+	// Image is the base image, machines boot from a copy-on-write
+	// overlay of the image.
+	Image       string `toml:"image"`
+	ImageFormat string `toml:"image_format"`
+
+	Binary    string `toml:"binary"`
+	ImgBinary string `toml:"img_binary"`
+
+	Memory         int      `toml:"memory"`
+	CPUs           int      `toml:"cpus"`
+	KVM            bool     `toml:"kvm"`
+	DriveInterface string   `toml:"drive_interface"`
+	NIC            string   `toml:"nic"`
+	Args           []string `toml:"args"`
+
+	// Network is either "user", forwarding the attacked ports
+	// to the machine, or "tap" attaching the machine to Bridge.
+	// Without egress user networking is restricted, the machine can't
+	// connect to the host or the outside.
+	Network     string `toml:"network"`
+	Bridge      string `toml:"bridge"`
+	Helper      string `toml:"helper"`
+	AllowEgress bool   `toml:"allow_egress"`
+
+	OverlayDir string `toml:"overlay_dir"`
+
+	BootTimeout      config.Delay `toml:"boot_timeout"`
+	FreezeDelay      config.Delay `toml:"freeze_every"`
+	StopDelay        config.Delay `toml:"stop_every"`
+	HousekeeperDelay config.Delay `toml:"housekeeper_every"`
+
+	Pool director.PoolConfig `toml:"pool"`
+
+	pool *director.Pool
 }
 
 func (d *qemuDirector) SetChannel(eb pushers.Channel) {
@@ -46,5 +168,132 @@ func (d *qemuDirector) SetChannel(eb pushers.Channel) {
 }
 
 func (d *qemuDirector) Dial(conn net.Conn) (net.Conn, error) {
-	return nil, errors.New("Qemu director not implemented yet")
+	network, port := "", 0
+
+	if ta, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		network, port = "tcp", ta.Port
+	} else if ta, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		network, port = "udp", ta.Port
+	} else {
+		return nil, errors.New("Unsupported protocol")
+	}
+
+	c, release, err := d.pool.Acquire(d.pool.Identify(conn.RemoteAddr()))
+	if err != nil {
+		log.Errorf("Error creating machine: %s", err.Error())
+		return nil, err
+	}
+
+	m := c.(*machine)
+
+	if err := m.ensureStarted(); err != nil {
+		log.Errorf("Error starting machine %s: %s", m.name, err.Error())
+		d.eb.Send(director.ContainerErrorEvent(m.name, err))
+		release()
+		return nil, err
+	}
+
+	m.startHousekeeper()
+
+	connection, err := m.Dial(network, port)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	return &machineConn{Conn: connection, machine: m, release: release}, nil
+}
+
+// create creates the machine for the pool, warm machines are booted and
+// paused to be assigned later.
+func (d *qemuDirector) create(name string, warm bool) (director.Container, error) {
+	m := &machine{
+		d:       d,
+		name:    name,
+		overlay: filepath.Join(d.OverlayDir, name+".qcow2"),
+		socket:  filepath.Join(d.OverlayDir, name+".qmp"),
+		mac:     macAddress(name),
+	}
+
+	if err := os.MkdirAll(d.OverlayDir, 0750); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(m.overlay); os.IsNotExist(err) {
+		if err := m.createOverlay(); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !warm {
+		return m, nil
+	}
+
+	if err := m.ensureStarted(); err != nil {
+		m.Destroy()
+		return nil, err
+	}
+
+	if err := m.freeze(); err != nil {
+		m.Destroy()
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// img runs qemu-img with the arguments.
+func (d *qemuDirector) img(args ...string) ([]byte, error) {
+	cmd := exec.Command(d.ImgBinary, args...)
+	cmd.Stderr = &strings.Builder{}
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %s (%s)", d.ImgBinary, args[0], err.Error(), strings.TrimSpace(cmd.Stderr.(*strings.Builder).String()))
+	}
+
+	return output, nil
+}
+
+// args returns the arguments to boot the machine.
+func (d *qemuDirector) args(m *machine) []string {
+	args := []string{
+		"-name", m.name,
+		"-m", fmt.Sprintf("%d", d.Memory),
+		"-smp", fmt.Sprintf("%d", d.CPUs),
+		"-drive", fmt.Sprintf("file=%s,format=qcow2,if=%s", m.overlay, d.DriveInterface),
+		"-qmp", fmt.Sprintf("unix:%s,server,nowait", m.socket),
+		"-display", "none",
+		"-serial", "null",
+	}
+
+	if d.KVM {
+		args = append(args, "-enable-kvm")
+	}
+
+	switch d.Network {
+	case "tap":
+		args = append(args, "-netdev", fmt.Sprintf("tap,id=net0,br=%s,helper=%s", d.Bridge, d.Helper))
+	case "user":
+		if d.AllowEgress {
+			args = append(args, "-netdev", "user,id=net0")
+		} else {
+			args = append(args, "-netdev", "user,id=net0,restrict=on")
+		}
+	}
+
+	args = append(args, "-device", fmt.Sprintf("%s,netdev=net0,mac=%s", d.NIC, m.mac))
+
+	return append(args, d.Args...)
+}
+
+// cleanup removes the files of the machine.
+func (d *qemuDirector) cleanup(m *machine) {
+	for _, name := range []string{m.overlay, m.socket} {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			log.Errorf("Error removing %s: %s", name, err.Error())
+		}
+	}
 }
@@ -0,0 +1,213 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package qemu
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+// fakeQMP serves the machine protocol on the connection, answering
+// commands with the responses.
+func fakeQMP(t *testing.T, conn net.Conn, responses map[string]string) {
+	defer conn.Close()
+
+	conn.Write([]byte(`{"QMP": {"version": {"qemu": {"micro": 0, "minor": 12, "major": 2}}, "capabilities": []}}` + "\n"))
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		req := struct {
+			Execute   string                 `json:"execute"`
+			Arguments map[string]interface{} `json:"arguments"`
+		}{}
+
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if req.Execute == "qmp_capabilities" {
+			conn.Write([]byte(`{"return": {}}` + "\n"))
+			continue
+		}
+
+		// events may be received before the response
+		conn.Write([]byte(`{"event": "RESUME", "timestamp": {"seconds": 1, "microseconds": 0}}` + "\n"))
+
+		resp, ok := responses[req.Execute]
+		if !ok {
+			resp = `{"error": {"class": "CommandNotFound", "desc": "The command ` + req.Execute + ` has not been found"}}`
+		}
+
+		conn.Write([]byte(resp + "\n"))
+	}
+}
+
+func TestQMP(t *testing.T) {
+	client, server := net.Pipe()
+
+	go fakeQMP(t, server, map[string]string{
+		"query-status":          `{"return": {"status": "paused", "singlestep": false, "running": false}}`,
+		"human-monitor-command": `{"return": ""}`,
+	})
+
+	q, err := newQMP(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer q.Close()
+
+	if status, err := q.Status(); err != nil {
+		t.Fatal(err)
+	} else if status != "paused" {
+		t.Errorf("Expected status paused, got %s", status)
+	}
+
+	if output, err := q.HumanMonitorCommand("hostfwd_add net0 tcp:127.0.0.1:2222-:22"); err != nil {
+		t.Fatal(err)
+	} else if output != "" {
+		t.Errorf("Expected empty output, got %s", output)
+	}
+
+	if _, err := q.Execute("unknown", nil); err == nil {
+		t.Error("Expected error for unknown command")
+	} else if qe, ok := err.(*qmpError); !ok || qe.Class != "CommandNotFound" {
+		t.Errorf("Expected CommandNotFound error, got %v", err)
+	}
+}
+
+func TestArgs(t *testing.T) {
+	d := &qemuDirector{
+		eb:             pushers.MustDummy(),
+		Memory:         1024,
+		CPUs:           2,
+		KVM:            true,
+		DriveInterface: "ide",
+		NIC:            "e1000",
+		Network:        "tap",
+		Bridge:         "br0",
+		Helper:         "/usr/lib/qemu/qemu-bridge-helper",
+		Args:           []string{"-rtc", "base=localtime"},
+	}
+
+	m := &machine{
+		name:    "honeytrap-1",
+		overlay: "/var/lib/honeytrap/qemu/honeytrap-1.qcow2",
+		socket:  "/var/lib/honeytrap/qemu/honeytrap-1.qmp",
+		mac:     macAddress("honeytrap-1"),
+	}
+
+	args := strings.Join(d.args(m), " ")
+
+	for _, expected := range []string{
+		"-m 1024 -smp 2",
+		"-drive file=/var/lib/honeytrap/qemu/honeytrap-1.qcow2,format=qcow2,if=ide",
+		"-qmp unix:/var/lib/honeytrap/qemu/honeytrap-1.qmp,server,nowait",
+		"-enable-kvm",
+		"-netdev tap,id=net0,br=br0,helper=/usr/lib/qemu/qemu-bridge-helper",
+		"-device e1000,netdev=net0,mac=" + m.mac,
+		"-rtc base=localtime",
+	} {
+		if !strings.Contains(args, expected) {
+			t.Errorf("Expected arguments to contain %q, got %s", expected, args)
+		}
+	}
+}
+
+func TestArgsUserNetwork(t *testing.T) {
+	m := &machine{
+		name: "honeytrap-1",
+		mac:  macAddress("honeytrap-1"),
+	}
+
+	d := &qemuDirector{
+		Network: "user",
+		NIC:     "e1000",
+	}
+
+	if args := strings.Join(d.args(m), " "); !strings.Contains(args, "-netdev user,id=net0,restrict=on ") {
+		t.Errorf("Expected restricted user networking, got %s", args)
+	}
+
+	d.AllowEgress = true
+
+	if args := strings.Join(d.args(m), " "); !strings.Contains(args, "-netdev user,id=net0 ") {
+		t.Errorf("Expected unrestricted user networking, got %s", args)
+	}
+}
+
+func TestMacAddress(t *testing.T) {
+	mac := macAddress("honeytrap-1")
+
+	if _, err := net.ParseMAC(mac); err != nil {
+		t.Fatal(err)
+	}
+
+	if mac != macAddress("honeytrap-1") {
+		t.Error("Expected mac address to be stable")
+	}
+
+	if mac == macAddress("honeytrap-2") {
+		t.Error("Expected different mac address for different machine")
+	}
+}
+
+func TestParseARP(t *testing.T) {
+	table := `IP address       HW type     Flags       HW address            Mask     Device
+192.168.122.10   0x1         0x0         52:54:00:aa:bb:cc     *        virbr0
+192.168.122.11   0x1         0x2         52:54:00:aa:bb:cc     *        virbr0
+192.168.122.12   0x1         0x2         52:54:00:dd:ee:ff     *        virbr0
+`
+
+	ip, err := parseARP(strings.NewReader(table), "52:54:00:AA:BB:CC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ip.Equal(net.ParseIP("192.168.122.11")) {
+		t.Errorf("Expected 192.168.122.11, got %s", ip)
+	}
+
+	if _, err := parseARP(strings.NewReader(table), "52:54:00:00:00:00"); err == nil {
+		t.Error("Expected error for unknown mac address")
+	}
+}
+
+func TestChangedExtents(t *testing.T) {
+	output := `[{ "start": 0, "length": 65536, "depth": 0, "zero": false, "data": true, "offset": 327680},
+{ "start": 65536, "length": 1048576, "depth": 1, "zero": false, "data": true, "offset": 393216},
+{ "start": 1114112, "length": 65536, "depth": 0, "zero": true, "data": false},
+{ "start": 1179648, "length": 2097152, "depth": 1, "zero": true, "data": false}]`
+
+	extents, err := changedExtents([]byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(extents) != 2 || extents[0].Start != 0 || extents[1].Start != 1114112 {
+		t.Errorf("Unexpected changed extents: %+v", extents)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(); err == nil {
+		t.Error("Expected error without base image")
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package qemu
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const qmpTimeout = time.Second * 30
+
+// qmpError is an error returned by the QEMU Machine Protocol.
+type qmpError struct {
+	Class string `json:"class"`
+	Desc  string `json:"desc"`
+}
+
+func (e *qmpError) Error() string {
+	return fmt.Sprintf("qmp: %s: %s", e.Class, e.Desc)
+}
+
+type qmpResponse struct {
+	QMP    json.RawMessage `json:"QMP"`
+	Return json.RawMessage `json:"return"`
+	Error  *qmpError       `json:"error"`
+	Event  string          `json:"event"`
+}
+
+// qmp is a client of the QEMU Machine Protocol, used to control
+// the virtual machine.
+type qmp struct {
+	conn net.Conn
+	dec  *json.Decoder
+
+	m sync.Mutex
+}
+
+// dialQMP connects to the QMP socket, waiting for the socket to become
+// available until the timeout.
+func dialQMP(path string, timeout time.Duration) (*qmp, error) {
+	start := time.Now()
+
+	for {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			return newQMP(conn)
+		}
+
+		if time.Since(start) > timeout {
+			return nil, err
+		}
+
+		time.Sleep(time.Millisecond * 100)
+	}
+}
+
+func newQMP(conn net.Conn) (*qmp, error) {
+	q := &qmp{
+		conn: conn,
+		dec:  json.NewDecoder(conn),
+	}
+
+	conn.SetReadDeadline(time.Now().Add(qmpTimeout))
+
+	greeting := qmpResponse{}
+	if err := q.dec.Decode(&greeting); err != nil {
+		conn.Close()
+		return nil, err
+	} else if greeting.QMP == nil {
+		conn.Close()
+		return nil, fmt.Errorf("qmp: unexpected greeting")
+	}
+
+	if _, err := q.Execute("qmp_capabilities", nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// Execute executes the command and returns its result, asynchronous
+// events received in the meantime are ignored.
+func (q *qmp) Execute(command string, arguments interface{}) (json.RawMessage, error) {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.conn.SetDeadline(time.Now().Add(qmpTimeout))
+
+	req := map[string]interface{}{
+		"execute": command,
+	}
+
+	if arguments != nil {
+		req["arguments"] = arguments
+	}
+
+	if err := json.NewEncoder(q.conn).Encode(req); err != nil {
+		return nil, err
+	}
+
+	for {
+		resp := qmpResponse{}
+		if err := q.dec.Decode(&resp); err != nil {
+			return nil, err
+		}
+
+		if resp.Event != "" {
+			continue
+		}
+
+		if resp.Error != nil {
+			return nil, resp.Error
+		}
+
+		return resp.Return, nil
+	}
+}
+
+// HumanMonitorCommand executes a command of the human monitor, eg. hostfwd_add.
+func (q *qmp) HumanMonitorCommand(command string) (string, error) {
+	data, err := q.Execute("human-monitor-command", map[string]interface{}{
+		"command-line": command,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	output := ""
+	if err := json.Unmarshal(data, &output); err != nil {
+		return "", err
+	}
+
+	return output, nil
+}
+
+// Status returns the run state of the virtual machine.
+func (q *qmp) Status() (string, error) {
+	data, err := q.Execute("query-status", nil)
+	if err != nil {
+		return "", err
+	}
+
+	status := struct {
+		Status string `json:"status"`
+	}{}
+
+	if err := json.Unmarshal(data, &status); err != nil {
+		return "", err
+	}
+
+	return status.Status, nil
+}
+
+func (q *qmp) Close() error {
+	return q.conn.Close()
+}
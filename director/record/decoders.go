@@ -0,0 +1,385 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// maxLine is the maximum length of lines and headers being buffered
+// by the decoders.
+const maxLine = 64 * 1024
+
+// Decoder decodes the protocol of a proxied session, into events with
+// structured fields.
+type Decoder interface {
+	// Decode receives the data of the direction, and emits the fields of
+	// each message decoded.
+	Decode(dir Direction, data []byte, emit func(...event.Option))
+}
+
+var decoders = map[string]func() Decoder{}
+
+// RegisterDecoder registers the decoder for the protocol.
+func RegisterDecoder(name string, fn func() Decoder) func() Decoder {
+	decoders[name] = fn
+	return fn
+}
+
+var (
+	_ = RegisterDecoder("http", func() Decoder {
+		return &httpDecoder{
+			buffers: map[Direction]*bytes.Buffer{Client: {}, Server: {}},
+			skip:    map[Direction]int64{},
+		}
+	})
+	_ = RegisterDecoder("ssh", func() Decoder {
+		return &sshDecoder{
+			lines: map[Direction]*lineBuffer{Client: {}, Server: {}},
+			done:  map[Direction]bool{},
+		}
+	})
+	_ = RegisterDecoder("smtp", func() Decoder {
+		return &smtpDecoder{
+			lines: map[Direction]*lineBuffer{Client: {}, Server: {}},
+		}
+	})
+)
+
+// lineBuffer splits data into lines, lines exceeding maxLine are dropped.
+type lineBuffer struct {
+	bytes.Buffer
+}
+
+func (lb *lineBuffer) Lines(data []byte) []string {
+	lb.Write(data)
+
+	lines := []string{}
+
+	for {
+		i := bytes.IndexByte(lb.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+
+		line := string(lb.Next(i + 1))
+		lines = append(lines, strings.TrimRight(line, "\r\n"))
+	}
+
+	if lb.Len() > maxLine {
+		lb.Reset()
+	}
+
+	return lines
+}
+
+// hasPrefix returns whether data starts with the prefix, or could do so
+// when more data arrives.
+func hasPrefix(data []byte, prefix string) bool {
+	if len(data) < len(prefix) {
+		return strings.HasPrefix(prefix, string(data))
+	}
+
+	return bytes.HasPrefix(data, []byte(prefix))
+}
+
+var httpMethods = []string{"GET", "POST", "HEAD", "PUT", "DELETE", "OPTIONS", "PATCH", "CONNECT", "TRACE", "PROPFIND"}
+
+type httpDecoder struct {
+	buffers map[Direction]*bytes.Buffer
+
+	// body bytes to skip per direction
+	skip map[Direction]int64
+}
+
+func (d *httpDecoder) isHTTP(dir Direction, data []byte) bool {
+	if dir == Server {
+		return hasPrefix(data, "HTTP/")
+	}
+
+	for _, method := range httpMethods {
+		if hasPrefix(data, method+" ") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (d *httpDecoder) Decode(dir Direction, data []byte, emit func(...event.Option)) {
+	if skip := d.skip[dir]; skip > 0 {
+		if int64(len(data)) <= skip {
+			d.skip[dir] -= int64(len(data))
+			return
+		}
+
+		data = data[skip:]
+		d.skip[dir] = 0
+	}
+
+	b := d.buffers[dir]
+	b.Write(data)
+
+	for b.Len() > 0 {
+		if !d.isHTTP(dir, b.Bytes()) {
+			// body of unknown length, or not http at all
+			b.Reset()
+			return
+		}
+
+		i := bytes.Index(b.Bytes(), []byte("\r\n\r\n"))
+		if i < 0 {
+			if b.Len() > maxLine {
+				b.Reset()
+			}
+
+			return
+		}
+
+		r := bufio.NewReader(bytes.NewReader(b.Next(i + 4)))
+
+		var length int64
+
+		if dir == Client {
+			req, err := http.ReadRequest(r)
+			if err != nil {
+				continue
+			}
+
+			emit(
+				event.Custom("http.method", req.Method),
+				event.Custom("http.url", req.URL.String()),
+				event.Custom("http.proto", req.Proto),
+				event.Custom("http.host", req.Host),
+				event.Custom("http.user-agent", req.UserAgent()),
+			)
+
+			length = req.ContentLength
+		} else {
+			resp, err := http.ReadResponse(r, nil)
+			if err != nil {
+				continue
+			}
+
+			emit(
+				event.Custom("http.status", resp.StatusCode),
+				event.Custom("http.proto", resp.Proto),
+				event.Custom("http.server", resp.Header.Get("Server")),
+				event.Custom("http.content-type", resp.Header.Get("Content-Type")),
+			)
+
+			length = resp.ContentLength
+		}
+
+		if length <= 0 {
+			continue
+		}
+
+		// skip the body
+		if int64(b.Len()) <= length {
+			d.skip[dir] = length - int64(b.Len())
+			b.Reset()
+			return
+		}
+
+		b.Next(int(length))
+	}
+}
+
+type sshDecoder struct {
+	lines map[Direction]*lineBuffer
+	done  map[Direction]bool
+}
+
+func (d *sshDecoder) Decode(dir Direction, data []byte, emit func(...event.Option)) {
+	if d.done[dir] {
+		return
+	}
+
+	lb := d.lines[dir]
+
+	if !hasPrefix(append(lb.Bytes(), data...), "SSH-") {
+		d.done[dir] = true
+		return
+	}
+
+	lines := lb.Lines(data)
+	if len(lines) == 0 {
+		return
+	}
+
+	d.done[dir] = true
+	lb.Reset()
+
+	// SSH-protoversion-softwareversion SP comments
+	banner := lines[0]
+
+	parts := strings.SplitN(banner, "-", 3)
+	if len(parts) != 3 {
+		return
+	}
+
+	software, comments := parts[2], ""
+	if i := strings.IndexByte(software, ' '); i >= 0 {
+		software, comments = software[:i], software[i+1:]
+	}
+
+	emit(
+		event.Custom("ssh.banner", banner),
+		event.Custom("ssh.proto-version", parts[1]),
+		event.Custom("ssh.software", software),
+		event.Custom("ssh.comments", comments),
+	)
+}
+
+var smtpCommands = map[string]bool{
+	"HELO": true, "EHLO": true, "MAIL": true, "RCPT": true, "DATA": true, "AUTH": true, "STARTTLS": true,
+	"QUIT": true, "RSET": true, "NOOP": true, "VRFY": true, "EXPN": true, "HELP": true,
+}
+
+type smtpDecoder struct {
+	lines map[Direction]*lineBuffer
+
+	active   bool
+	disabled bool
+
+	// the client is sending the message, after DATA has been accepted
+	data bool
+
+	// the client is sending credentials, after AUTH has been challenged
+	auth bool
+}
+
+func (d *smtpDecoder) Decode(dir Direction, data []byte, emit func(...event.Option)) {
+	if d.disabled {
+		return
+	}
+
+	for _, line := range d.lines[dir].Lines(data) {
+		if dir == Server {
+			d.reply(line, emit)
+		} else {
+			d.command(line, emit)
+		}
+
+		if d.disabled {
+			return
+		}
+	}
+}
+
+func (d *smtpDecoder) reply(line string, emit func(...event.Option)) {
+	if len(line) < 3 {
+		d.disabled = !d.active
+		return
+	}
+
+	code, err := strconv.Atoi(line[:3])
+	if err != nil {
+		d.disabled = !d.active
+		return
+	}
+
+	if !d.active && code != 220 {
+		d.disabled = true
+		return
+	}
+
+	d.active = true
+
+	// multiline replies are being continued with a dash
+	if len(line) > 3 && line[3] == '-' {
+		return
+	}
+
+	emit(
+		event.Custom("smtp.reply-code", code),
+		event.Custom("smtp.reply", strings.TrimSpace(line[3:])),
+	)
+
+	switch code {
+	case 354:
+		d.data = true
+	case 334:
+		d.auth = true
+	default:
+		d.auth = false
+	}
+}
+
+func (d *smtpDecoder) command(line string, emit func(...event.Option)) {
+	if d.data {
+		if line == "." {
+			d.data = false
+		}
+
+		return
+	}
+
+	if d.auth {
+		d.auth = false
+		return
+	}
+
+	parts := strings.SplitN(line, " ", 2)
+
+	command := strings.ToUpper(parts[0])
+	if !smtpCommands[command] {
+		d.disabled = !d.active
+		return
+	}
+
+	if !d.active && command != "HELO" && command != "EHLO" {
+		d.disabled = true
+		return
+	}
+
+	d.active = true
+
+	argument := ""
+	if len(parts) == 2 {
+		argument = parts[1]
+	}
+
+	options := []event.Option{
+		event.Custom("smtp.command", command),
+		event.Custom("smtp.argument", argument),
+	}
+
+	// AUTH PLAIN with initial response contains the credentials
+	if fields := strings.Fields(argument); command == "AUTH" && len(fields) == 2 && strings.EqualFold(fields[0], "PLAIN") {
+		if data, err := base64.StdEncoding.DecodeString(fields[1]); err == nil {
+			if creds := strings.Split(string(data), "\x00"); len(creds) == 3 {
+				options = append(options,
+					event.Custom("smtp.username", creds[1]),
+					event.Custom("smtp.password", creds[2]),
+				)
+			}
+		}
+	}
+
+	emit(options...)
+
+	if command == "STARTTLS" {
+		// the session continues encrypted
+		d.disabled = true
+	}
+}
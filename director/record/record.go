@@ -0,0 +1,240 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package record
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("director/record")
+
+// Direction is the direction of the data of a proxied session.
+type Direction string
+
+const (
+	// Client is the data sent by the attacker to the target.
+	Client Direction = "client"
+	// Server is the data sent by the target to the attacker.
+	Server Direction = "server"
+)
+
+// Config defines the recording of proxied sessions.
+type Config struct {
+	Enabled bool `toml:"enabled"`
+
+	// ChunkSize is the maximum size of the payload of chunk events.
+	ChunkSize int `toml:"chunk_size"`
+
+	// MaxBytes is the number of bytes per direction being recorded and
+	// decoded, the counters keep counting.
+	MaxBytes int64 `toml:"max_bytes"`
+
+	Decoders []string `toml:"decoders"`
+}
+
+// DefaultConfig returns the default recording configuration. Recording is
+// disabled by default, as it sends an event per chunk of proxied data.
+func DefaultConfig() Config {
+	return Config{
+		Enabled:   false,
+		ChunkSize: 4096,
+		MaxBytes:  1024 * 1024,
+		Decoders:  []string{"http", "ssh", "smtp"},
+	}
+}
+
+// Validate returns an error for unknown decoders.
+func (c Config) Validate() error {
+	for _, name := range c.Decoders {
+		if _, ok := decoders[name]; !ok {
+			return fmt.Errorf("Unknown decoder %s", name)
+		}
+	}
+
+	return nil
+}
+
+type recorder struct {
+	ch       pushers.Channel
+	config   Config
+	category string
+
+	source      net.Addr
+	destination net.Addr
+	target      string
+
+	m        sync.Mutex
+	start    time.Time
+	bytes    map[Direction]int64
+	chunks   int
+	decoders map[string]Decoder
+}
+
+func (r *recorder) newEvent(dir Direction, options ...event.Option) event.Event {
+	return event.New(append([]event.Option{
+		event.ProxySensor,
+		event.Category(r.category),
+		event.SourceAddr(r.source),
+		event.DestinationAddr(r.destination),
+		event.Custom("proxy.target", r.target),
+		event.Custom("direction", string(dir)),
+	}, options...)...)
+}
+
+// record sends chunk events for the data and feeds the decoders.
+func (r *recorder) record(dir Direction, data []byte) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	offset := r.bytes[dir]
+	r.bytes[dir] += int64(len(data))
+
+	if r.config.MaxBytes > 0 {
+		if offset >= r.config.MaxBytes {
+			return
+		} else if offset+int64(len(data)) > r.config.MaxBytes {
+			data = data[:r.config.MaxBytes-offset]
+		}
+	}
+
+	for i := 0; i < len(data); i += r.config.ChunkSize {
+		end := i + r.config.ChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		r.chunks++
+
+		r.ch.Send(r.newEvent(dir,
+			event.ProxyChunk,
+			event.Custom("chunk.offset", offset+int64(i)),
+			event.Payload(data[i:end]),
+		))
+	}
+
+	for name, decoder := range r.decoders {
+		decoder.Decode(dir, data, func(options ...event.Option) {
+			r.ch.Send(r.newEvent(dir, append([]event.Option{
+				event.ProxyDecoded,
+				event.Custom("decoder", name),
+			}, options...)...))
+		})
+	}
+}
+
+// finish sends the session event with the counters.
+func (r *recorder) finish() {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.ch.Send(event.New(
+		event.ProxySensor,
+		event.ProxySession,
+		event.Category(r.category),
+		event.SourceAddr(r.source),
+		event.DestinationAddr(r.destination),
+		event.Custom("proxy.target", r.target),
+		event.Custom("bytes.client", r.bytes[Client]),
+		event.Custom("bytes.server", r.bytes[Server]),
+		event.Custom("chunks", r.chunks),
+		event.Custom("duration", time.Since(r.start).Seconds()),
+	))
+}
+
+// Conn is a connection to the target of a proxied session, the data
+// read and written will be recorded.
+type Conn struct {
+	net.Conn
+
+	r    *recorder
+	once sync.Once
+}
+
+// Wrap returns the target connection recording the session of the attacker
+// connection, sending the events to the channel.
+func Wrap(target net.Conn, attacker net.Conn, ch pushers.Channel, category string, c Config) net.Conn {
+	if !c.Enabled {
+		return target
+	}
+
+	// recorded already, eg. by the director
+	if _, ok := target.(*Conn); ok {
+		return target
+	}
+
+	if c.ChunkSize <= 0 {
+		c.ChunkSize = DefaultConfig().ChunkSize
+	}
+
+	r := &recorder{
+		ch:          ch,
+		config:      c,
+		category:    category,
+		source:      attacker.RemoteAddr(),
+		destination: attacker.LocalAddr(),
+		target:      target.RemoteAddr().String(),
+		start:       time.Now(),
+		bytes:       map[Direction]int64{},
+		decoders:    map[string]Decoder{},
+	}
+
+	for _, name := range c.Decoders {
+		fn, ok := decoders[name]
+		if !ok {
+			log.Errorf("Unknown decoder %s", name)
+			continue
+		}
+
+		r.decoders[name] = fn()
+	}
+
+	return &Conn{
+		Conn: target,
+		r:    r,
+	}
+}
+
+// Read reads the data sent by the target.
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.r.record(Server, b[:n])
+	}
+
+	return n, err
+}
+
+// Write writes the data sent by the attacker.
+func (c *Conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.r.record(Client, b[:n])
+	}
+
+	return n, err
+}
+
+// Close closes the connection and sends the session event.
+func (c *Conn) Close() error {
+	c.once.Do(c.r.finish)
+	return c.Conn.Close()
+}
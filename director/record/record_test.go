@@ -0,0 +1,267 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package record
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+type eventRecorder struct {
+	m      sync.Mutex
+	events []event.Event
+}
+
+func (er *eventRecorder) Send(e event.Event) {
+	er.m.Lock()
+	defer er.m.Unlock()
+
+	er.events = append(er.events, e)
+}
+
+func (er *eventRecorder) ofType(t string) []map[string]interface{} {
+	er.m.Lock()
+	defer er.m.Unlock()
+
+	events := []map[string]interface{}{}
+	for _, e := range er.events {
+		if e.Get("type") == t {
+			events = append(events, event.ToMap(e))
+		}
+	}
+
+	return events
+}
+
+type addrConn struct {
+	net.Conn
+}
+
+func (addrConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 80}
+}
+
+func (addrConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4321}
+}
+
+func decode(name string, data map[Direction][]string) []map[string]interface{} {
+	d := decoders[name]()
+
+	fields := []map[string]interface{}{}
+
+	emit := func(options ...event.Option) {
+		fields = append(fields, event.ToMap(event.New(options...)))
+	}
+
+	// alternate the directions, as a session would
+	for i := 0; i < len(data[Client]) || i < len(data[Server]); i++ {
+		for _, dir := range []Direction{Server, Client} {
+			if i < len(data[dir]) {
+				d.Decode(dir, []byte(data[dir][i]), emit)
+			}
+		}
+	}
+
+	return fields
+}
+
+func TestWrap(t *testing.T) {
+	target, backend := net.Pipe()
+
+	go func() {
+		buf := make([]byte, 1024)
+		backend.Read(buf)
+		backend.Write([]byte("HTTP/1.1 200 OK\r\nServer: nginx\r\nContent-Length: 5\r\n\r\nhello"))
+		backend.Close()
+	}()
+
+	er := &eventRecorder{}
+
+	c := DefaultConfig()
+	c.Enabled = true
+	c.ChunkSize = 16
+
+	conn := Wrap(target, addrConn{}, er, "forward", c)
+
+	if _, err := conn.Write([]byte("GET /index.html HTTP/1.1\r\nHost: example.com\r\nUser-Agent: curl\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Close()
+	conn.Close()
+
+	if len(data) != 58 {
+		t.Errorf("Expected response of 58 bytes, got %d", len(data))
+	}
+
+	chunks := er.ofType("PROXY:CHUNK")
+	if len(chunks) != 9 {
+		t.Errorf("Expected 9 chunks, got %d", len(chunks))
+	}
+
+	if chunks[0]["direction"] != "client" || chunks[0]["payload"] != "GET /index.html " || chunks[0]["source-ip"] != "10.0.0.1" {
+		t.Errorf("Unexpected first chunk %v", chunks[0])
+	}
+
+	decoded := er.ofType("PROXY:DECODED")
+	if len(decoded) != 2 {
+		t.Fatalf("Expected 2 decoded messages, got %d", len(decoded))
+	}
+
+	if decoded[0]["http.method"] != "GET" || decoded[0]["http.url"] != "/index.html" || decoded[0]["http.host"] != "example.com" {
+		t.Errorf("Unexpected decoded request %v", decoded[0])
+	}
+
+	if decoded[1]["http.status"] != 200 || decoded[1]["http.server"] != "nginx" {
+		t.Errorf("Unexpected decoded response %v", decoded[1])
+	}
+
+	sessions := er.ofType("PROXY:SESSION")
+	if len(sessions) != 1 {
+		t.Fatalf("Expected 1 session event, got %d", len(sessions))
+	}
+
+	if sessions[0]["bytes.client"] != int64(65) || sessions[0]["bytes.server"] != int64(58) {
+		t.Errorf("Unexpected counters %v", sessions[0])
+	}
+}
+
+func TestWrapMaxBytes(t *testing.T) {
+	target, backend := net.Pipe()
+
+	go io.Copy(ioutil.Discard, backend)
+
+	er := &eventRecorder{}
+
+	conn := Wrap(target, addrConn{}, er, "copy", Config{
+		Enabled:  true,
+		MaxBytes: 10,
+	})
+
+	conn.Write([]byte("0123456789abcdef"))
+	conn.Write([]byte("ghijkl"))
+	conn.Close()
+
+	chunks := er.ofType("PROXY:CHUNK")
+	if len(chunks) != 1 || chunks[0]["payload"] != "0123456789" {
+		t.Errorf("Expected recording to be limited, got %v", chunks)
+	}
+
+	if sessions := er.ofType("PROXY:SESSION"); sessions[0]["bytes.client"] != int64(22) {
+		t.Errorf("Expected counters to continue, got %v", sessions[0]["bytes.client"])
+	}
+
+	// recorded connections aren't being recorded twice
+	if Wrap(conn, addrConn{}, er, "copy", Config{Enabled: true}) != conn {
+		t.Error("Expected recorded connection to be returned")
+	}
+}
+
+func TestWrapDisabled(t *testing.T) {
+	target, backend := net.Pipe()
+	defer backend.Close()
+
+	// recording is opt-in, existing proxies don't send chunk events
+	if Wrap(target, addrConn{}, &eventRecorder{}, "copy", DefaultConfig()) != target {
+		t.Error("Expected connection not to be recorded by default")
+	}
+}
+
+func TestDecodeHTTPBody(t *testing.T) {
+	fields := decode("http", map[Direction][]string{
+		Client: {
+			"POST /login HTTP/1.1\r\nHost: example.com\r\nContent-Length: 10\r\n\r\nuser",
+			"=admin",
+			"GET / HTTP/1.1\r\n",
+			"Host: example.com\r\n\r\n",
+		},
+	})
+
+	if len(fields) != 2 || fields[0]["http.method"] != "POST" || fields[1]["http.method"] != "GET" {
+		t.Errorf("Unexpected fields %v", fields)
+	}
+}
+
+func TestDecodeSSH(t *testing.T) {
+	fields := decode("ssh", map[Direction][]string{
+		Server: {"SSH-2.0-OpenSSH_7.4p1 Debian-10+deb9u7\r\n", "\x00\x00\x01"},
+		Client: {"SSH-2.0-libssh", "_0.8.1\r\n\x00\x00"},
+	})
+
+	if len(fields) != 2 {
+		t.Fatalf("Expected 2 banners, got %v", fields)
+	}
+
+	if fields[0]["ssh.software"] != "OpenSSH_7.4p1" || fields[0]["ssh.comments"] != "Debian-10+deb9u7" {
+		t.Errorf("Unexpected server banner %v", fields[0])
+	}
+
+	if fields[1]["ssh.banner"] != "SSH-2.0-libssh_0.8.1" || fields[1]["ssh.proto-version"] != "2.0" {
+		t.Errorf("Unexpected client banner %v", fields[1])
+	}
+
+	if fields := decode("ssh", map[Direction][]string{Client: {"GET / HTTP/1.1\r\n"}}); len(fields) != 0 {
+		t.Errorf("Expected no fields for http, got %v", fields)
+	}
+}
+
+func TestDecodeSMTP(t *testing.T) {
+	fields := decode("smtp", map[Direction][]string{
+		Server: {
+			"220 mail.example.com ESMTP\r\n",
+			"250-mail.example.com\r\n250 AUTH PLAIN\r\n",
+			"235 Authentication successful\r\n",
+			"354 End data with <CR><LF>.<CR><LF>\r\n",
+			"250 Ok\r\n",
+		},
+		Client: {
+			"EHLO attacker\r\n",
+			"AUTH PLAIN AGFkbWluAHNlY3JldA==\r\n",
+			"DATA\r\n",
+			"MAIL FROM:<x@example.com>\r\n.\r\n",
+		},
+	})
+
+	commands := []string{}
+	for _, f := range fields {
+		if v, ok := f["smtp.command"]; ok {
+			commands = append(commands, v.(string))
+		}
+	}
+
+	if len(commands) != 3 || commands[0] != "EHLO" || commands[1] != "AUTH" || commands[2] != "DATA" {
+		t.Errorf("Unexpected commands %v", commands)
+	}
+
+	for _, f := range fields {
+		if f["smtp.command"] == "AUTH" && (f["smtp.username"] != "admin" || f["smtp.password"] != "secret") {
+			t.Errorf("Expected credentials to be decoded, got %v", f)
+		}
+	}
+
+	if fields := decode("smtp", map[Direction][]string{Client: {"GET / HTTP/1.1\r\n", "EHLO x\r\n"}}); len(fields) != 0 {
+		t.Errorf("Expected no fields for http, got %v", fields)
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ecs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/miekg/dns"
+)
+
+// Version is the version of the Elastic Common Schema the events are mapped onto.
+const Version = "1.12.0"
+
+// Prefix is prepended to the keys that have no ECS equivalent.
+const Prefix = "honeytrap."
+
+// Field maps an event key onto one or more ECS fields.
+type Field struct {
+	Key string
+	ECS string
+
+	// Convert converts the value, the field will be skipped when it returns false.
+	Convert func(interface{}) (interface{}, bool)
+}
+
+func same(v interface{}) (interface{}, bool) {
+	return v, true
+}
+
+func httpVersion(v interface{}) (interface{}, bool) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, false
+	}
+
+	return strings.TrimPrefix(s, "HTTP/"), true
+}
+
+func dnsQuestionName(v interface{}) (interface{}, bool) {
+	questions, ok := v.([]dns.Question)
+	if !ok || len(questions) == 0 {
+		return nil, false
+	}
+
+	return strings.TrimSuffix(questions[0].Name, "."), true
+}
+
+func dnsQuestionType(v interface{}) (interface{}, bool) {
+	questions, ok := v.([]dns.Question)
+	if !ok || len(questions) == 0 {
+		return nil, false
+	}
+
+	return dns.TypeToString[questions[0].Qtype], true
+}
+
+func fileHash(v interface{}) (interface{}, bool) {
+	data, ok := v.([]byte)
+	if !ok {
+		return nil, false
+	}
+
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), true
+}
+
+func fileSize(v interface{}) (interface{}, bool) {
+	data, ok := v.([]byte)
+	if !ok {
+		return nil, false
+	}
+
+	return len(data), true
+}
+
+func errorMessage(v interface{}) (interface{}, bool) {
+	switch v := v.(type) {
+	case error:
+		return v.Error(), true
+	case string:
+		return v, true
+	default:
+		return fmt.Sprintf("%v", v), true
+	}
+}
+
+// Common contains the fields shared by all services.
+var Common = []Field{
+	{Key: "date", ECS: "@timestamp"},
+	{Key: "source-ip", ECS: "source.ip"},
+	{Key: "source-port", ECS: "source.port"},
+	{Key: "source-mac", ECS: "source.mac"},
+	{Key: "destination-ip", ECS: "destination.ip"},
+	{Key: "destination-port", ECS: "destination.port"},
+	{Key: "destination-mac", ECS: "destination.mac"},
+	{Key: "protocol", ECS: "network.transport"},
+	{Key: "message", ECS: "message"},
+	{Key: "type", ECS: "event.action"},
+	{Key: "error", ECS: "error.message", Convert: errorMessage},
+}
+
+// Services contains the fields of the services, by category.
+var Services = map[string][]Field{
+	"ssh": {
+		{Key: "ssh.username", ECS: "user.name"},
+		{Key: "ssh.command", ECS: "process.command_line"},
+		{Key: "ssh.exec", ECS: "process.command_line"},
+	},
+	"telnet": {
+		{Key: "telnet.username", ECS: "user.name"},
+		{Key: "telnet.command", ECS: "process.command_line"},
+	},
+	"telnet-lua": {
+		{Key: "telnet.username", ECS: "user.name"},
+		{Key: "telnet.command", ECS: "process.command_line"},
+	},
+	"http": {
+		{Key: "http.method", ECS: "http.request.method"},
+		{Key: "http.url", ECS: "url.original"},
+		{Key: "http.host", ECS: "url.domain"},
+		{Key: "http.proto", ECS: "http.version", Convert: httpVersion},
+		{Key: "http.user-agent", ECS: "user_agent.original"},
+		{Key: "http.body", ECS: "http.request.body.content"},
+		// http proxy
+		{Key: "method", ECS: "http.request.method"},
+		{Key: "url", ECS: "url.original"},
+		{Key: "referer", ECS: "http.request.referrer"},
+		{Key: "user-agent", ECS: "user_agent.original"},
+	},
+	"https": {
+		{Key: "http.method", ECS: "http.request.method"},
+		{Key: "http.url", ECS: "url.original"},
+		{Key: "http.host", ECS: "url.domain"},
+		{Key: "http.proto", ECS: "http.version", Convert: httpVersion},
+		{Key: "http.user-agent", ECS: "user_agent.original"},
+		{Key: "http.body", ECS: "http.request.body.content"},
+		{Key: "https.ja3-digest", ECS: "tls.client.ja3"},
+		{Key: "https.server-name", ECS: "tls.client.server_name"},
+	},
+	"dns": {
+		{Key: "dns.id", ECS: "dns.id"},
+		{Key: "dns.opcode", ECS: "dns.op_code"},
+		{Key: "dns.questions", ECS: "dns.question.name", Convert: dnsQuestionName},
+		{Key: "dns.questions", ECS: "dns.question.type", Convert: dnsQuestionType},
+	},
+	"tftp": {
+		{Key: "tftp.filename", ECS: "file.name"},
+		{Key: "tftp.file", ECS: "file.hash.sha256", Convert: fileHash},
+		{Key: "tftp.file", ECS: "file.size", Convert: fileSize},
+	},
+	"ipp": {
+		{Key: "ipp.user", ECS: "user.name"},
+		{Key: "ipp.uri", ECS: "url.original"},
+	},
+	"cwmp": {
+		{Key: "http.method", ECS: "http.request.method"},
+		{Key: "http.url", ECS: "url.original"},
+		{Key: "http.host", ECS: "url.domain"},
+	},
+}
+
+// Category returns the ECS event categories of the event.
+func Category(values map[string]interface{}) []string {
+	typ, _ := values["type"].(string)
+	category, _ := values["category"].(string)
+
+	switch {
+	case strings.HasSuffix(typ, "-authentication"):
+		return []string{"authentication"}
+	case typ == "exec" || typ == "shell" || typ == "command":
+		return []string{"process"}
+	case strings.HasPrefix(typ, "tftp-write"), strings.HasPrefix(typ, "tftp-read"):
+		return []string{"file"}
+	case category == "http" || category == "https":
+		return []string{"network", "web"}
+	default:
+		return []string{"network"}
+	}
+}
+
+// Outcome returns the ECS event outcome of the event.
+func Outcome(values map[string]interface{}) string {
+	typ, _ := values["type"].(string)
+
+	if _, ok := values["error"]; ok {
+		return "failure"
+	}
+
+	if strings.Contains(strings.ToLower(typ), "fail") || strings.Contains(typ, "ERROR") {
+		return "failure"
+	}
+
+	return "unknown"
+}
+
+// Normalize returns the event with the known fields mapped onto ECS, the
+// other fields are kept under the honeytrap prefix.
+func Normalize(e event.Event) event.Event {
+	values := event.ToMap(e)
+
+	category, _ := values["category"].(string)
+
+	n := event.New()
+	n.Delete("date")
+
+	mapped := map[string]bool{}
+
+	for _, field := range append(append([]Field{}, Common...), Services[category]...) {
+		v, ok := values[field.Key]
+		if !ok {
+			continue
+		}
+
+		convert := field.Convert
+		if convert == nil {
+			convert = same
+		}
+
+		if v, ok := convert(v); ok {
+			n.Store(field.ECS, v)
+		}
+
+		mapped[field.Key] = true
+	}
+
+	for k, v := range values {
+		if mapped[k] {
+			continue
+		}
+
+		n.Store(Prefix+k, v)
+	}
+
+	if _, ok := values["date"]; !ok {
+		n.Store("@timestamp", time.Now())
+	}
+
+	n.Store("ecs.version", Version)
+	n.Store("event.kind", "event")
+	n.Store("event.module", "honeytrap")
+	n.Store("event.category", Category(values))
+	n.Store("event.outcome", Outcome(values))
+	n.Store("observer.type", "honeypot")
+
+	if category != "" {
+		n.Store("event.dataset", "honeytrap."+category)
+	}
+
+	return n
+}
@@ -0,0 +1,232 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ecs
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/miekg/dns"
+)
+
+var (
+	sourceAddr      = &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}
+	destinationAddr = &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 22}
+)
+
+type normalizeTest struct {
+	Name     string
+	Event    event.Event
+	Expected map[string]interface{}
+}
+
+var normalizeTests = []normalizeTest{
+	{
+		Name: "common",
+		Event: event.New(
+			event.Category("echo"),
+			event.SourceAddr(sourceAddr),
+			event.DestinationAddr(destinationAddr),
+			event.Protocol("tcp"),
+			event.Error(errors.New("connection reset")),
+		),
+		Expected: map[string]interface{}{
+			"source.ip":         "10.0.0.1",
+			"source.port":       1234,
+			"destination.ip":    "10.0.0.2",
+			"destination.port":  22,
+			"network.transport": "tcp",
+			"error.message":     "connection reset",
+			"event.category":    []string{"network"},
+			"event.outcome":     "failure",
+			"event.dataset":     "honeytrap.echo",
+		},
+	},
+	{
+		Name: "ssh",
+		Event: event.New(
+			event.Category("ssh"),
+			event.Type("password-authentication"),
+			event.Custom("ssh.sessionid", "abc"),
+			event.Custom("ssh.username", "root"),
+			event.Custom("ssh.password", "admin"),
+		),
+		Expected: map[string]interface{}{
+			"user.name":               "root",
+			"event.action":            "password-authentication",
+			"event.category":          []string{"authentication"},
+			"event.outcome":           "unknown",
+			"honeytrap.ssh.password":  "admin",
+			"honeytrap.ssh.sessionid": "abc",
+			"honeytrap.category":      "ssh",
+		},
+	},
+	{
+		Name: "ssh-exec",
+		Event: event.New(
+			event.Category("ssh"),
+			event.Type("exec"),
+			event.Custom("ssh.exec", "uname -a"),
+		),
+		Expected: map[string]interface{}{
+			"process.command_line": "uname -a",
+			"event.category":       []string{"process"},
+		},
+	},
+	{
+		Name: "telnet",
+		Event: event.New(
+			event.Category("telnet"),
+			event.Type("password-authentication"),
+			event.Custom("telnet.username", "admin"),
+			event.Custom("telnet.password", "1234"),
+		),
+		Expected: map[string]interface{}{
+			"user.name":                 "admin",
+			"honeytrap.telnet.password": "1234",
+			"event.category":            []string{"authentication"},
+		},
+	},
+	{
+		Name: "http",
+		Event: event.New(
+			event.Category("http"),
+			event.Type("request"),
+			event.Custom("http.method", "GET"),
+			event.Custom("http.url", "/index.html"),
+			event.Custom("http.host", "example.org"),
+			event.Custom("http.proto", "HTTP/1.1"),
+			event.Custom("http.user-agent", "curl/7.0"),
+		),
+		Expected: map[string]interface{}{
+			"http.request.method": "GET",
+			"url.original":        "/index.html",
+			"url.domain":          "example.org",
+			"http.version":        "1.1",
+			"user_agent.original": "curl/7.0",
+			"event.category":      []string{"network", "web"},
+		},
+	},
+	{
+		Name: "http-proxy",
+		Event: event.New(
+			event.Category("http"),
+			event.Custom("method", "CONNECT"),
+			event.Custom("url", "example.org:443"),
+			event.Custom("referer", "http://example.com/"),
+			event.Custom("user-agent", "curl/7.0"),
+		),
+		Expected: map[string]interface{}{
+			"http.request.method":   "CONNECT",
+			"url.original":          "example.org:443",
+			"http.request.referrer": "http://example.com/",
+			"user_agent.original":   "curl/7.0",
+		},
+	},
+	{
+		Name: "https",
+		Event: event.New(
+			event.Category("https"),
+			event.Custom("https.ja3-digest", "e7d705a3286e19ea42f587b344ee6865"),
+			event.Custom("https.server-name", "example.org"),
+			event.Custom("http.method", "POST"),
+		),
+		Expected: map[string]interface{}{
+			"tls.client.ja3":         "e7d705a3286e19ea42f587b344ee6865",
+			"tls.client.server_name": "example.org",
+			"http.request.method":    "POST",
+		},
+	},
+	{
+		Name: "dns",
+		Event: event.New(
+			event.Category("dns"),
+			event.Type("dns"),
+			event.Custom("dns.id", uint16(42)),
+			event.Custom("dns.opcode", 0),
+			event.Custom("dns.questions", []dns.Question{
+				{Name: "example.org.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
+			}),
+		),
+		Expected: map[string]interface{}{
+			"dns.id":            uint16(42),
+			"dns.op_code":       0,
+			"dns.question.name": "example.org",
+			"dns.question.type": "A",
+		},
+	},
+	{
+		Name: "tftp",
+		Event: event.New(
+			event.Category("tftp"),
+			event.Type("tftp-write-file"),
+			event.Custom("tftp.filename", "x.sh"),
+			event.Custom("tftp.file", []byte("test")),
+		),
+		Expected: map[string]interface{}{
+			"file.name":        "x.sh",
+			"file.hash.sha256": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+			"file.size":        4,
+			"event.category":   []string{"file"},
+		},
+	},
+	{
+		Name: "ipp",
+		Event: event.New(
+			event.Category("ipp"),
+			event.Custom("ipp.user", "printer"),
+			event.Custom("ipp.uri", "ipp://10.0.0.2/printers/p1"),
+		),
+		Expected: map[string]interface{}{
+			"user.name":    "printer",
+			"url.original": "ipp://10.0.0.2/printers/p1",
+		},
+	},
+}
+
+func TestNormalize(t *testing.T) {
+	for _, tt := range normalizeTests {
+		values := event.ToMap(Normalize(tt.Event))
+
+		for k, expected := range tt.Expected {
+			if v, ok := values[k]; !ok {
+				t.Errorf("%s: expected field %s to be set", tt.Name, k)
+			} else if !reflect.DeepEqual(v, expected) {
+				t.Errorf("%s: expected field %s to be %#v, got %#v", tt.Name, k, expected, v)
+			}
+		}
+
+		if _, ok := values["@timestamp"]; !ok {
+			t.Errorf("%s: expected @timestamp to be set", tt.Name)
+		}
+
+		if _, ok := values["date"]; ok {
+			t.Errorf("%s: expected date to be mapped onto @timestamp", tt.Name)
+		}
+	}
+}
+
+func TestNormalizeMappedKeysRemoved(t *testing.T) {
+	values := event.ToMap(Normalize(event.New(
+		event.Category("ssh"),
+		event.Custom("ssh.username", "root"),
+	)))
+
+	if _, ok := values["honeytrap.ssh.username"]; ok {
+		t.Error("Expected mapped key not to be kept under the honeytrap prefix")
+	}
+}
@@ -31,6 +31,7 @@ var (
 	DataRead        = Type("DATA:READ")
 	DataWrite       = Type("DATA:WRITE")
 	ServiceEnded    = Type("SERVICE:ENDED")
+	ServiceFallback = Type("SERVICE:FALLBACK")
 
 	SeverityFatal = Type("fatal")
 	SeverityError = Type("error")
@@ -55,6 +56,13 @@ var (
 	ContainerTarred      = Type("CONTAINER:TARRED")
 	ContainerCheckpoint  = Type("CONTAINER:CHECKPOINT")
 	ContainerPcaped      = Type("CONTAINER:PCAPED")
+	ContainerDestroyed   = Type("CONTAINER:DESTROYED")
+	ContainerSnapshot    = Type("CONTAINER:SNAPSHOT")
+	ContainerPool        = Type("CONTAINER:POOL")
+	ProxyChunk           = Type("PROXY:CHUNK")
+	ProxyDecoded         = Type("PROXY:DECODED")
+	ProxySession         = Type("PROXY:SESSION")
+	ProxyDenied          = Type("PROXY:DENIED")
 )
 
 //====================================================================================
@@ -71,6 +79,7 @@ var (
 	ErrorsSensorName          = "ERRORS"
 	DataErrorSensorName       = "DATA:ERROR"
 	ConnectionErrorSensorName = "CONNECTION:ERROR"
+	ProxySensorName           = "PROXY"
 
 	ContainersSensor      = Sensor("CONTAINER")
 	ConnectionSensor      = Sensor("CONNECTION")
@@ -82,6 +91,7 @@ var (
 	ErrorsSensor          = Sensor("ERRORS")
 	DataErrorSensor       = Sensor("DATA:ERROR")
 	ConnectionErrorSensor = Sensor("CONNECTION:ERROR")
+	ProxySensor           = Sensor("PROXY")
 )
 
 //====================================================================================
@@ -67,6 +67,11 @@ func (e Event) Store(s string, v interface{}) {
 	e.sm.Store(s, v)
 }
 
+// Delete removes the key from the event.
+func (e Event) Delete(s string) {
+	e.sm.Delete(s)
+}
+
 // Has returns true/false if the giving key exists.
 func (e Event) Has(s string) bool {
 	_, ok := e.sm.Load(s)
@@ -16,16 +16,22 @@ package agent
 import (
 	"context"
 	"encoding"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"runtime"
+	"strings"
+	"sync"
+	"time"
 
 	bus "github.com/dutchcoders/gobus"
 	"github.com/fatih/color"
 	"github.com/honeytrap/honeytrap/event"
 	"github.com/honeytrap/honeytrap/listener"
 	"github.com/honeytrap/honeytrap/messages"
+	"github.com/honeytrap/honeytrap/pushers"
 	"github.com/mimoo/disco/libdisco"
 
 	logging "github.com/op/go-logging"
@@ -38,17 +44,101 @@ var (
 	_ = listener.Register("agent", New)
 )
 
+var (
+	SensorAgent = event.Sensor("agent")
+
+	EventCategoryAgent = event.Category("agent")
+
+	EventAgentConnected    = event.Type("AGENT:CONNECTED")
+	EventAgentDisconnected = event.Type("AGENT:DISCONNECTED")
+	EventAgentDenied       = event.Type("AGENT:DENIED")
+)
+
+var (
+	ErrNotStarted       = errors.New("agent listener hasn't been started")
+	ErrInvalidPublicKey = errors.New("invalid agent public key")
+)
+
+const handshakeTimeout = time.Second * 30
+
 type agentListener struct {
 	agentConfig
 
 	ch        chan net.Conn
 	Addresses []net.Addr
 
+	events pushers.Channel
+
+	keyPair  *libdisco.KeyPair
+	registry *agentRegistry
+
+	// ports configured per agent, by public key
+	ports map[string][]net.Addr
+
+	// active agent sessions by public key, m serializes replacing
+	// and removing sessions
+	m        sync.Mutex
+	sessions sync.Map
+
 	net.Listener
 }
 
 type agentConfig struct {
 	Listen string `toml:"listen"`
+
+	// Agents contains the agents that are allowed to connect
+	Agents []agentAuthorization `toml:"agents"`
+
+	// Denied and Revoked contain public keys of agents that are not
+	// allowed to connect, revocation is permanent.
+	Denied  []string `toml:"denied"`
+	Revoked []string `toml:"revoked"`
+}
+
+type agentAuthorization struct {
+	Name      string `toml:"name"`
+	PublicKey string `toml:"public_key"`
+	Token     string `toml:"token"`
+
+	// Ports the agent should listen on, defaults to all configured ports
+	Ports []string `toml:"ports"`
+}
+
+// parsePort parses ports in the "protocol/(host:)port" format.
+func parsePort(s string) (net.Addr, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Port %s has wrong format (needs to be \"protocol/(host:)port\")", s)
+	}
+
+	host, port, err := net.SplitHostPort(parts[1])
+	if err != nil {
+		port = parts[1]
+	}
+
+	switch parts[0] {
+	case "tcp":
+		return net.ResolveTCPAddr("tcp", net.JoinHostPort(host, port))
+	case "udp":
+		return net.ResolveUDPAddr("udp", net.JoinHostPort(host, port))
+	default:
+		return nil, fmt.Errorf("Unknown protocol %s for port %s", parts[0], s)
+	}
+}
+
+func port(addr net.Addr) int {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.Port
+	case *net.UDPAddr:
+		return a.Port
+	default:
+		return 0
+	}
+}
+
+func samePort(a net.Addr, b net.Addr) bool {
+	return a.Network() == b.Network() && port(a) == port(b)
 }
 
 // AddAddress will add the addresses to listen to
@@ -63,6 +153,7 @@ func New(options ...func(listener.Listener) error) (listener.Listener, error) {
 	l := agentListener{
 		agentConfig: agentConfig{},
 		ch:          ch,
+		events:      pushers.MustDummy(),
 	}
 
 	for _, option := range options {
@@ -72,7 +163,148 @@ func New(options ...func(listener.Listener) error) (listener.Listener, error) {
 	return &l, nil
 }
 
-func (al *agentListener) serv(c *conn2) {
+func (al *agentListener) SetChannel(c pushers.Channel) {
+	al.events = c
+}
+
+// Registrations returns the registrations of all known agents.
+func (al *agentListener) Registrations() []AgentRecord {
+	if al.registry == nil {
+		return []AgentRecord{}
+	}
+
+	return al.registry.List()
+}
+
+func (al *agentListener) checkPublicKey(publicKey string) error {
+	if al.registry == nil {
+		return ErrNotStarted
+	}
+
+	if key, err := hex.DecodeString(publicKey); err != nil || len(key) != 32 {
+		return ErrInvalidPublicKey
+	}
+
+	return nil
+}
+
+// Revoke revokes the agent permanently and disconnects it when connected.
+func (al *agentListener) Revoke(publicKey string) error {
+	if err := al.checkPublicKey(publicKey); err != nil {
+		return err
+	}
+
+	if err := al.registry.Revoke(publicKey); err != nil {
+		return err
+	}
+
+	al.disconnect(publicKey)
+	return nil
+}
+
+// Deny denies the agent and disconnects it when connected.
+func (al *agentListener) Deny(publicKey string) error {
+	if err := al.checkPublicKey(publicKey); err != nil {
+		return err
+	}
+
+	if err := al.registry.Deny(publicKey); err != nil {
+		return err
+	}
+
+	al.disconnect(publicKey)
+	return nil
+}
+
+// register registers the session of the agent, the previous session of the
+// agent will be closed. After a network failure the previous connection
+// can stay open until it times out, the agent would be locked out otherwise.
+// The returned function removes the session.
+func (al *agentListener) register(publicKey, name string, conn net.Conn) func() {
+	al.m.Lock()
+	defer al.m.Unlock()
+
+	if v, ok := al.sessions.Load(publicKey); ok {
+		log.Warningf("Agent %s (%s) reconnected, closing previous session", name, publicKey)
+		v.(net.Conn).Close()
+	}
+
+	al.sessions.Store(publicKey, conn)
+
+	return func() {
+		al.m.Lock()
+		defer al.m.Unlock()
+
+		if v, ok := al.sessions.Load(publicKey); ok && v == conn {
+			al.sessions.Delete(publicKey)
+		}
+	}
+}
+
+func (al *agentListener) disconnect(publicKey string) {
+	if v, ok := al.sessions.Load(publicKey); ok {
+		log.Infof("Disconnecting agent %s", publicKey)
+		v.(net.Conn).Close()
+	}
+}
+
+// handshake performs the Noise_XK handshake, which authenticates both the
+// server and the agent, and returns the static public key of the agent.
+func (al *agentListener) handshake(c net.Conn) (*libdisco.Conn, string, error) {
+	var remoteKey []byte
+
+	config := libdisco.Config{
+		HandshakePattern: libdisco.Noise_XK,
+		KeyPair:          al.keyPair,
+		PublicKeyVerifier: func(publicKey, proof []byte) bool {
+			remoteKey = make([]byte, len(publicKey))
+			copy(remoteKey, publicKey)
+			return true
+		},
+	}
+
+	dc := libdisco.Server(c, &config)
+
+	dc.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	if err := dc.Handshake(); err != nil {
+		return nil, "", err
+	}
+
+	dc.SetDeadline(time.Time{})
+
+	if len(remoteKey) == 0 {
+		return nil, "", fmt.Errorf("agent didn't send a public key")
+	}
+
+	return dc, hex.EncodeToString(remoteKey), nil
+}
+
+func agentOptions(record AgentRecord, h *Handshake, remoteAddr net.Addr) event.Option {
+	addresses := make([]string, len(h.Addresses))
+	for i, addr := range h.Addresses {
+		addresses[i] = fmt.Sprintf("%s/%s", addr.Network(), addr.String())
+	}
+
+	return event.NewWith(
+		event.Custom("agent.name", record.Name),
+		event.Custom("agent.public-key", record.PublicKey),
+		event.Custom("agent.version", h.Version),
+		event.Custom("agent.remote-addr", remoteAddr.String()),
+		event.Custom("agent.addresses", addresses),
+	)
+}
+
+// addresses returns the addresses the agent should listen on.
+func (al *agentListener) addresses(publicKey string) []net.Addr {
+	if addresses, ok := al.ports[publicKey]; ok {
+		return addresses
+	}
+
+	return al.Addresses
+}
+
+func (al *agentListener) serv(rc net.Conn) {
 	defer func() {
 		if err := recover(); err != nil {
 			trace := make([]byte, 1024)
@@ -83,7 +315,16 @@ func (al *agentListener) serv(c *conn2) {
 		}
 	}()
 
-	log.Debugf("Agent connecting from remote address: %s", c.RemoteAddr())
+	log.Debugf("Agent connecting from remote address: %s", rc.RemoteAddr())
+
+	dc, publicKey, err := al.handshake(rc)
+	if err != nil {
+		log.Errorf("Error during agent handshake (%s): %s", rc.RemoteAddr(), err.Error())
+		rc.Close()
+		return
+	}
+
+	c := Conn2(dc)
 
 	p, err := c.receive()
 	if err == io.EOF {
@@ -103,31 +344,77 @@ func (al *agentListener) serv(c *conn2) {
 	shortCommitID := h.ShortCommitID
 	token := h.Token
 
-	log.Infof(color.YellowString("Agent connected (version=%s, commitid=%s, token=%s)...", version, shortCommitID, token))
-	defer log.Infof(color.YellowString("Agent disconnected"))
+	record, err := al.registry.Authorize(publicKey, token)
+	if err != nil {
+		log.Errorf(color.RedString("Agent denied (publickey=%s, version=%s, remote=%s): %s", publicKey, version, c.RemoteAddr(), err.Error()))
+
+		al.events.Send(event.New(
+			SensorAgent,
+			EventCategoryAgent,
+			EventAgentDenied,
+			agentOptions(record, h, c.RemoteAddr()),
+			event.Custom("agent.status", string(record.Status)),
+			event.Error(err),
+		))
+
+		c.Close()
+		return
+	}
+
+	defer al.register(publicKey, record.Name, c.Conn)()
+
+	if err := al.registry.Seen(publicKey, version, c.RemoteAddr().String()); err != nil {
+		log.Errorf("Error updating agent registry: %s", err.Error())
+	}
+
+	agent := &messages.Agent{
+		Name:          record.Name,
+		PublicKey:     publicKey,
+		Version:       version,
+		ShortCommitID: shortCommitID,
+		Token:         token,
+		RemoteAddr:    c.RemoteAddr().String(),
+	}
+
+	log.Infof(color.YellowString("Agent connected (name=%s, version=%s, commitid=%s)...", record.Name, version, shortCommitID))
+	defer log.Infof(color.YellowString("Agent disconnected (name=%s)", record.Name))
+
+	connected := time.Now()
+
+	al.events.Send(event.New(
+		SensorAgent,
+		EventCategoryAgent,
+		EventAgentConnected,
+		agentOptions(record, h, c.RemoteAddr()),
+	))
+
+	defer func() {
+		al.events.Send(event.New(
+			SensorAgent,
+			EventCategoryAgent,
+			EventAgentDisconnected,
+			agentOptions(record, h, c.RemoteAddr()),
+			event.Custom("agent.duration", time.Since(connected).String()),
+		))
+	}()
 
 	bus.Emit("agent-connect", &messages.AgentConnect{
-		Agent: &messages.Agent{
-			Version:       version,
-			ShortCommitID: shortCommitID,
-			Token:         token,
-			RemoteAddr:    c.RemoteAddr().String(),
-		},
+		Agent: agent,
 	})
 
 	defer bus.Emit("agent-disconnect", &messages.AgentDisconnect{
-		Agent: &messages.Agent{
-			Version:       version,
-			ShortCommitID: shortCommitID,
-			Token:         token,
-			RemoteAddr:    c.RemoteAddr().String(),
-		},
+		Agent: agent,
 	})
 
 	c.send(HandshakeResponse{
-		al.Addresses,
+		al.addresses(publicKey),
 	})
 
+	options := event.NewWith(
+		event.Custom("agent", record.Name),
+		agentOptions(record, h, c.RemoteAddr()),
+	)
+
 	out := make(chan interface{})
 
 	conns := Connections{}
@@ -188,7 +475,7 @@ func (al *agentListener) serv(c *conn2) {
 
 			conns.Add(ac)
 
-			conn := event.WithConn(ac, event.Custom("agent", token))
+			conn := event.WithConn(ac, options)
 			al.ch <- conn
 		case *ReadWriteTCP:
 			conn := conns.Get(v.Laddr, v.Raddr)
@@ -198,7 +485,7 @@ func (al *agentListener) serv(c *conn2) {
 
 			conn.receive(v.Payload)
 		case *ReadWriteUDP:
-			al.ch <- &listener.DummyUDPConn{
+			al.ch <- event.WithConn(&listener.DummyUDPConn{
 				Buffer: v.Payload,
 				Laddr:  v.Laddr.(*net.UDPAddr),
 				Raddr:  v.Raddr.(*net.UDPAddr),
@@ -215,7 +502,7 @@ func (al *agentListener) serv(c *conn2) {
 					out <- p
 					return len(b), nil
 				},
-			}
+			}, options)
 		case *EOF:
 			conn := conns.Get(v.Laddr, v.Raddr)
 			if conn == nil {
@@ -228,13 +515,14 @@ func (al *agentListener) serv(c *conn2) {
 		case *Ping:
 			log.Debugf("Received ping from agent: %s", c.RemoteAddr())
 
+			if err := al.registry.Seen(publicKey, version, c.RemoteAddr().String()); err != nil {
+				log.Errorf("Error updating agent registry: %s", err.Error())
+			}
+
+			agent.LastPing = time.Now()
+
 			bus.Emit("agent-ping", messages.AgentPing{
-				Agent: &messages.Agent{
-					Version:       version,
-					ShortCommitID: shortCommitID,
-					Token:         token,
-					RemoteAddr:    c.RemoteAddr().String(),
-				},
+				Agent: agent,
 			})
 		}
 	}
@@ -254,17 +542,77 @@ func (al *agentListener) Start(ctx context.Context) error {
 
 	fmt.Println(color.YellowString("Honeytrap Agent Server public key: %s", keyPair.ExportPublicKey()))
 
-	serverConfig := libdisco.Config{
-		HandshakePattern: libdisco.Noise_NK,
-		KeyPair:          keyPair,
+	al.keyPair = keyPair
+
+	registry, err := storage.Registry()
+	if err != nil {
+		return err
+	}
+
+	al.ports = map[string][]net.Addr{}
+
+	allowed := []string{}
+	for _, a := range al.Agents {
+		allowed = append(allowed, a.PublicKey)
+	}
+
+	if err := registry.Configure(allowed); err != nil {
+		return err
 	}
 
+	for _, a := range al.Agents {
+		if err := registry.Allow(a.PublicKey, a.Name, a.Token); err != nil {
+			log.Errorf("Could not allow agent %s (%s): %s", a.Name, a.PublicKey, err.Error())
+		}
+
+		if len(a.Ports) == 0 {
+			continue
+		}
+
+		addresses := []net.Addr{}
+
+		for _, p := range a.Ports {
+			addr, err := parsePort(p)
+			if err != nil {
+				log.Errorf("Could not parse port for agent %s: %s", a.Name, err.Error())
+				continue
+			}
+
+			found := false
+			for _, address := range al.Addresses {
+				found = found || samePort(address, addr)
+			}
+
+			if !found {
+				log.Warningf("Port %s for agent %s has no services configured", p, a.Name)
+			}
+
+			addresses = append(addresses, addr)
+		}
+
+		al.ports[a.PublicKey] = addresses
+	}
+
+	for _, publicKey := range al.Denied {
+		if err := registry.Deny(publicKey); err != nil {
+			log.Errorf("Could not deny agent %s: %s", publicKey, err.Error())
+		}
+	}
+
+	for _, publicKey := range al.Revoked {
+		if err := registry.Revoke(publicKey); err != nil {
+			log.Errorf("Could not revoke agent %s: %s", publicKey, err.Error())
+		}
+	}
+
+	al.registry = registry
+
 	listen := ":1339"
 	if al.Listen != "" {
 		listen = al.Listen
 	}
 
-	listener, err := libdisco.Listen("tcp", listen, &serverConfig)
+	listener, err := net.Listen("tcp", listen)
 	if err != nil {
 		fmt.Println(color.RedString("Error starting listener: %s", err.Error()))
 		return err
@@ -280,7 +628,7 @@ func (al *agentListener) Start(ctx context.Context) error {
 				continue
 			}
 
-			go al.serv(Conn2(c))
+			go al.serv(c)
 		}
 	}()
 
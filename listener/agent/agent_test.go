@@ -0,0 +1,51 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agent
+
+import (
+	"net"
+	"testing"
+)
+
+func TestAgentListenerRegister(t *testing.T) {
+	al := &agentListener{}
+
+	c1, p1 := net.Pipe()
+	defer p1.Close()
+
+	remove1 := al.register("sensor1", "sensor-1", c1)
+
+	c2, p2 := net.Pipe()
+	defer p2.Close()
+
+	remove2 := al.register("sensor1", "sensor-1", c2)
+
+	// the previous session is closed when the agent reconnects
+	if _, err := c1.Write([]byte{0}); err == nil {
+		t.Errorf("Expected previous session to be closed")
+	}
+
+	// the previous session ending doesn't remove the new session
+	remove1()
+
+	if v, ok := al.sessions.Load("sensor1"); !ok || v != c2 {
+		t.Errorf("Expected new session to be registered, got %v", v)
+	}
+
+	remove2()
+
+	if _, ok := al.sessions.Load("sensor1"); ok {
+		t.Errorf("Expected session to be removed")
+	}
+}
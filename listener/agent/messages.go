@@ -38,6 +38,9 @@ type Handshake struct {
 	Version string
 
 	Token string
+
+	// Addresses contains the public facing addresses of the agent
+	Addresses []net.Addr
 }
 
 func (hs *Handshake) UnmarshalBinary(data []byte) error {
@@ -47,6 +50,16 @@ func (hs *Handshake) UnmarshalBinary(data []byte) error {
 	hs.ShortCommitID = d.ReadString()
 	hs.CommitID = d.ReadString()
 	hs.Token = d.ReadString()
+
+	// addresses are optional, older agents don't send them
+	n := d.ReadUint8()
+
+	for i := 0; i < n; i++ {
+		if addr := d.ReadAddr(); addr != nil {
+			hs.Addresses = append(hs.Addresses, addr)
+		}
+	}
+
 	return nil
 }
 
@@ -62,6 +75,14 @@ func (hs Handshake) MarshalBinary() ([]byte, error) {
 
 	e.WriteString(hs.Token)
 
+	e.WriteUint8(len(hs.Addresses))
+
+	for _, address := range hs.Addresses {
+		e.WriteAddr(address)
+	}
+
+	e.Flush()
+
 	return buff.Bytes(), nil
 }
 
@@ -0,0 +1,76 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agent
+
+import (
+	"net"
+	"testing"
+)
+
+func TestHandshakeAddresses(t *testing.T) {
+	hs := Handshake{
+		ProtocolVersion: 1,
+		Version:         "1.0",
+		Token:           "token",
+		Addresses: []net.Addr{
+			&net.TCPAddr{IP: net.ParseIP("1.2.3.4").To4(), Port: 22},
+			&net.UDPAddr{IP: net.ParseIP("1.2.3.4").To4(), Port: 53},
+		},
+	}
+
+	data, err := hs.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hs2 := Handshake{}
+	if err := hs2.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if hs2.Token != "token" {
+		t.Errorf("Expected token, got %s", hs2.Token)
+	}
+
+	if len(hs2.Addresses) != 2 {
+		t.Fatalf("Expected 2 addresses, got %d", len(hs2.Addresses))
+	}
+
+	if hs2.Addresses[1].String() != "1.2.3.4:53" || hs2.Addresses[1].Network() != "udp" {
+		t.Errorf("Expected udp/1.2.3.4:53, got %s/%s", hs2.Addresses[1].Network(), hs2.Addresses[1])
+	}
+}
+
+func TestHandshakeWithoutAddresses(t *testing.T) {
+	hs := Handshake{
+		Token: "token",
+	}
+
+	data, err := hs.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// older agents don't send the address count
+	data = data[:len(data)-1]
+
+	hs2 := Handshake{}
+	if err := hs2.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if hs2.Token != "token" || len(hs2.Addresses) != 0 {
+		t.Errorf("Unexpected handshake: %+v", hs2)
+	}
+}
@@ -14,7 +14,13 @@
 package agent
 
 import (
+	"crypto/subtle"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/mimoo/disco/libdisco"
 
@@ -61,3 +67,330 @@ func (s *agentListenerStorage) KeyPair() (*libdisco.KeyPair, error) {
 
 	return keyPair, nil
 }
+
+// AgentStatus defines the authorization status of an agent.
+type AgentStatus string
+
+const (
+	// AgentAllowed agents are allowed to connect
+	AgentAllowed AgentStatus = "allowed"
+	// AgentPending agents have tried to connect, but haven't been allowed yet
+	AgentPending AgentStatus = "pending"
+	// AgentDenied agents are not allowed to connect
+	AgentDenied AgentStatus = "denied"
+	// AgentRevoked agents have been revoked, they can't be allowed anymore
+	AgentRevoked AgentStatus = "revoked"
+)
+
+// seenPersistEvery is the interval the last seen time of agents is
+// persisted at, pings only update the registry in memory in between.
+const seenPersistEvery = time.Minute * 5
+
+// maxPendingAgents limits the unknown agents kept, unknown agents are not
+// persisted as anyone completing the handshake would be registered.
+const maxPendingAgents = 256
+
+var (
+	ErrAgentUnknown      = errors.New("agent unknown")
+	ErrAgentNotAllowed   = errors.New("agent not allowed")
+	ErrAgentRevoked      = errors.New("agent has been revoked")
+	ErrAgentInvalidToken = errors.New("agent token invalid")
+)
+
+// AgentRecord contains the registration of an agent, agents are identified
+// by their static public key.
+type AgentRecord struct {
+	Name      string      `json:"name"`
+	PublicKey string      `json:"public_key"`
+	Token     string      `json:"token,omitempty"`
+	Status    AgentStatus `json:"status"`
+
+	Version    string `json:"version,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+
+	FirstSeen time.Time `json:"first_seen,omitempty"`
+	LastSeen  time.Time `json:"last_seen,omitempty"`
+}
+
+func (s *agentListenerStorage) Registry() (*agentRegistry, error) {
+	return newAgentRegistry(s.Storage)
+}
+
+// agentRegistry keeps track of all known agents and their authorization, the
+// registry is persisted in storage. Pending agents are only kept in memory.
+type agentRegistry struct {
+	s storage.Storage
+
+	m       sync.Mutex
+	agents  map[string]*AgentRecord
+	pending map[string]*AgentRecord
+
+	persisted time.Time
+}
+
+func newAgentRegistry(s storage.Storage) (*agentRegistry, error) {
+	r := &agentRegistry{
+		s:       s,
+		agents:  map[string]*AgentRecord{},
+		pending: map[string]*AgentRecord{},
+	}
+
+	data, err := s.Get("agents")
+	if err != nil {
+		// no agents registered yet
+		return r, nil
+	}
+
+	records := []*AgentRecord{}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+
+	for _, record := range records {
+		if record.Status == AgentPending {
+			// registries of previous versions persisted pending agents
+			continue
+		}
+
+		r.agents[record.PublicKey] = record
+	}
+
+	return r, nil
+}
+
+// Configure makes the configuration authoritative for allowed agents, agents
+// allowed before but not configured anymore will be pending again.
+func (r *agentRegistry) Configure(allowed []string) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	configured := map[string]bool{}
+	for _, publicKey := range allowed {
+		configured[publicKey] = true
+	}
+
+	for publicKey, record := range r.agents {
+		if record.Status != AgentAllowed || configured[publicKey] {
+			continue
+		}
+
+		log.Warningf("Agent %s (%s) isn't allowed by configuration anymore", record.Name, publicKey)
+
+		delete(r.agents, publicKey)
+
+		record.Status = AgentPending
+		record.Token = ""
+		r.addPending(record)
+	}
+
+	return r.persist()
+}
+
+// addPending keeps the pending agent, the agent seen the longest ago
+// makes room when too many agents are pending.
+func (r *agentRegistry) addPending(record *AgentRecord) {
+	if len(r.pending) >= maxPendingAgents {
+		var oldest *AgentRecord
+		for _, v := range r.pending {
+			if oldest == nil || v.FirstSeen.Before(oldest.FirstSeen) {
+				oldest = v
+			}
+		}
+
+		delete(r.pending, oldest.PublicKey)
+	}
+
+	r.pending[record.PublicKey] = record
+}
+
+func (r *agentRegistry) persist() error {
+	records := []*AgentRecord{}
+	for _, record := range r.agents {
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].PublicKey < records[j].PublicKey
+	})
+
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	if err := r.s.Set("agents", data); err != nil {
+		return err
+	}
+
+	r.persisted = time.Now()
+	return nil
+}
+
+// record returns the record of the agent to be persisted, pending
+// agents will be moved from memory.
+func (r *agentRegistry) record(publicKey string) *AgentRecord {
+	record, ok := r.agents[publicKey]
+	if ok {
+		return record
+	}
+
+	record, ok = r.pending[publicKey]
+	if ok {
+		delete(r.pending, publicKey)
+	} else {
+		record = &AgentRecord{
+			PublicKey: publicKey,
+			Status:    AgentPending,
+		}
+	}
+
+	r.agents[publicKey] = record
+	return record
+}
+
+// Get returns the registration of the agent.
+func (r *agentRegistry) Get(publicKey string) (AgentRecord, bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if record, ok := r.agents[publicKey]; ok {
+		return *record, true
+	}
+
+	if record, ok := r.pending[publicKey]; ok {
+		return *record, true
+	}
+
+	return AgentRecord{}, false
+}
+
+// List returns the registrations of all known agents.
+func (r *agentRegistry) List() []AgentRecord {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	records := []AgentRecord{}
+	for _, record := range r.agents {
+		records = append(records, *record)
+	}
+
+	for _, record := range r.pending {
+		records = append(records, *record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].PublicKey < records[j].PublicKey
+	})
+
+	return records
+}
+
+// Allow allows the agent to connect, when token is not empty the agent needs
+// to present the same token during the handshake.
+func (r *agentRegistry) Allow(publicKey, name, token string) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	record := r.record(publicKey)
+	if record.Status == AgentRevoked {
+		return ErrAgentRevoked
+	}
+
+	record.Status = AgentAllowed
+	record.Name = name
+	record.Token = token
+
+	return r.persist()
+}
+
+// Deny denies the agent from connecting.
+func (r *agentRegistry) Deny(publicKey string) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	record := r.record(publicKey)
+	if record.Status == AgentRevoked {
+		return nil
+	}
+
+	record.Status = AgentDenied
+	return r.persist()
+}
+
+// Revoke revokes the agent permanently.
+func (r *agentRegistry) Revoke(publicKey string) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	record := r.record(publicKey)
+	record.Status = AgentRevoked
+	return r.persist()
+}
+
+// Authorize checks if the agent is allowed to connect with the given token.
+// Unknown agents will be kept as pending.
+func (r *agentRegistry) Authorize(publicKey, token string) (AgentRecord, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	record, ok := r.agents[publicKey]
+	if !ok {
+		record, ok = r.pending[publicKey]
+		if !ok {
+			record = &AgentRecord{
+				PublicKey: publicKey,
+				Status:    AgentPending,
+				FirstSeen: time.Now(),
+			}
+
+			r.addPending(record)
+		}
+
+		record.LastSeen = time.Now()
+		return *record, ErrAgentUnknown
+	}
+
+	switch record.Status {
+	case AgentAllowed:
+	case AgentRevoked:
+		return *record, ErrAgentRevoked
+	default:
+		return *record, ErrAgentNotAllowed
+	}
+
+	if record.Token != "" && subtle.ConstantTimeCompare([]byte(record.Token), []byte(token)) != 1 {
+		return *record, ErrAgentInvalidToken
+	}
+
+	return *record, nil
+}
+
+// Seen updates the last seen time of the agent. The registry is only
+// persisted when the agent changed or seenPersistEvery has passed.
+func (r *agentRegistry) Seen(publicKey, version, remoteAddr string) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	record, ok := r.agents[publicKey]
+	if !ok {
+		return ErrAgentUnknown
+	}
+
+	now := time.Now()
+
+	changed := record.FirstSeen.IsZero() || record.Version != version || record.RemoteAddr != remoteAddr
+
+	if record.FirstSeen.IsZero() {
+		record.FirstSeen = now
+	}
+
+	record.LastSeen = now
+	record.Version = version
+	record.RemoteAddr = remoteAddr
+
+	if !changed && now.Sub(r.persisted) < seenPersistEvery {
+		return nil
+	}
+
+	return r.persist()
+}
@@ -0,0 +1,221 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type memoryStorage map[string][]byte
+
+func (ms memoryStorage) Get(key string) ([]byte, error) {
+	if v, ok := ms[key]; ok {
+		return v, nil
+	}
+
+	return nil, errors.New("key not found")
+}
+
+func (ms memoryStorage) Set(key string, data []byte) error {
+	ms[key] = data
+	return nil
+}
+
+func (ms memoryStorage) Delete(key string) error {
+	delete(ms, key)
+	return nil
+}
+
+func TestAgentRegistry(t *testing.T) {
+	s := memoryStorage{}
+
+	r, err := newAgentRegistry(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Authorize("unknown", ""); err != ErrAgentUnknown {
+		t.Errorf("Expected ErrAgentUnknown, got %v", err)
+	}
+
+	if record, ok := r.Get("unknown"); !ok || record.Status != AgentPending {
+		t.Errorf("Expected unknown agent to be registered as pending, got %+v", record)
+	}
+
+	if err := r.Allow("sensor1", "sensor-1", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Authorize("sensor1", "wrong"); err != ErrAgentInvalidToken {
+		t.Errorf("Expected ErrAgentInvalidToken, got %v", err)
+	}
+
+	if record, err := r.Authorize("sensor1", "secret"); err != nil {
+		t.Errorf("Expected agent to be authorized, got %v", err)
+	} else if record.Name != "sensor-1" {
+		t.Errorf("Expected name sensor-1, got %s", record.Name)
+	}
+
+	if err := r.Revoke("sensor1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Authorize("sensor1", "secret"); err != ErrAgentRevoked {
+		t.Errorf("Expected ErrAgentRevoked, got %v", err)
+	}
+
+	if err := r.Allow("sensor1", "sensor-1", "secret"); err != ErrAgentRevoked {
+		t.Errorf("Expected revoked agent not to be allowed again, got %v", err)
+	}
+
+	// registry should be persisted
+	r, err = newAgentRegistry(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pending agents aren't persisted
+	if len(r.List()) != 1 {
+		t.Errorf("Expected 1 agent, got %d", len(r.List()))
+	}
+
+	if record, _ := r.Get("sensor1"); record.Status != AgentRevoked {
+		t.Errorf("Expected persisted status revoked, got %s", record.Status)
+	}
+}
+
+func TestAgentRegistryPending(t *testing.T) {
+	s := memoryStorage{}
+
+	r, err := newAgentRegistry(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < maxPendingAgents+10; i++ {
+		if _, err := r.Authorize(fmt.Sprintf("unknown%d", i), ""); err != ErrAgentUnknown {
+			t.Errorf("Expected ErrAgentUnknown, got %v", err)
+		}
+	}
+
+	if len(r.List()) != maxPendingAgents {
+		t.Errorf("Expected %d pending agents, got %d", maxPendingAgents, len(r.List()))
+	}
+
+	if _, ok := s["agents"]; ok {
+		t.Error("Expected pending agents not to be persisted")
+	}
+
+	if err := r.Deny("unknown300"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = newAgentRegistry(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if record, ok := r.Get("unknown300"); !ok || record.Status != AgentDenied {
+		t.Errorf("Expected denied agent to be persisted, got %+v", record)
+	}
+}
+
+func TestAgentRegistryConfigure(t *testing.T) {
+	s := memoryStorage{}
+
+	r, err := newAgentRegistry(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Allow("sensor1", "sensor-1", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Allow("sensor2", "sensor-2", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	// sensor2 has been removed from the configuration
+	r, err = newAgentRegistry(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Configure([]string{"sensor1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Authorize("sensor1", "secret"); err != nil {
+		t.Errorf("Expected configured agent to be authorized, got %v", err)
+	}
+
+	if _, err := r.Authorize("sensor2", "secret"); err != ErrAgentUnknown {
+		t.Errorf("Expected ErrAgentUnknown for removed agent, got %v", err)
+	}
+}
+
+func TestAgentRegistrySeen(t *testing.T) {
+	s := memoryStorage{}
+
+	r, err := newAgentRegistry(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Allow("sensor1", "sensor-1", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Seen("sensor1", "1.0", "127.0.0.1:1337"); err != nil {
+		t.Fatal(err)
+	}
+
+	// pings of an unchanged agent only update the registry in memory
+	delete(s, "agents")
+
+	if err := r.Seen("sensor1", "1.0", "127.0.0.1:1337"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s["agents"]; ok {
+		t.Errorf("Expected unchanged agent not to be persisted")
+	}
+
+	if record, _ := r.Get("sensor1"); record.LastSeen.IsZero() {
+		t.Errorf("Expected last seen to be updated, got %+v", record)
+	}
+
+	if err := r.Seen("sensor1", "1.1", "127.0.0.1:1337"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s["agents"]; !ok {
+		t.Errorf("Expected changed agent to be persisted")
+	}
+
+	delete(s, "agents")
+
+	r.persisted = r.persisted.Add(-seenPersistEvery)
+
+	if err := r.Seen("sensor1", "1.1", "127.0.0.1:1337"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s["agents"]; !ok {
+		t.Errorf("Expected agent to be persisted after %s", seenPersistEvery)
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"time"
 
 	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/metrics"
 )
 
 var (
@@ -169,6 +170,15 @@ func (c *Canary) knockDetector(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case sk := <-c.knockChan:
+			switch sk.(type) {
+			case KnockTCPPort:
+				metrics.CanaryKnocks.WithLabelValues("tcp").Inc()
+			case KnockUDPPort:
+				metrics.CanaryKnocks.WithLabelValues("udp").Inc()
+			case KnockICMP:
+				metrics.CanaryKnocks.WithLabelValues("icmp").Inc()
+			}
+
 			grouper := sk.(KnockGrouper)
 			knock := knocks.Add(grouper.NewGroup()).(*KnockGroup)
 
@@ -16,8 +16,12 @@ package listener
 import (
 	"context"
 	"net"
+
+	logging "github.com/op/go-logging"
 )
 
+var log = logging.MustGetLogger("listener")
+
 var (
 	listeners = map[string]func(...func(Listener) error) (Listener, error){}
 )
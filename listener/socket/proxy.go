@@ -0,0 +1,312 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+)
+
+var (
+	proxySignatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	ErrNoProxyHeader      = errors.New("no proxy protocol header")
+	ErrInvalidProxyHeader = errors.New("invalid proxy protocol header")
+)
+
+const (
+	proxyHeaderV1MaxLength = 107
+
+	defaultProxyTimeout = time.Second * 5
+)
+
+type proxyConfig struct {
+	Ports   []string     `toml:"ports"`
+	Trusted []string     `toml:"trusted"`
+	Timeout config.Delay `toml:"timeout"`
+
+	trusted []*net.IPNet
+}
+
+func (pc *proxyConfig) init() error {
+	pc.trusted = []*net.IPNet{}
+
+	for _, s := range pc.Trusted {
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip == nil {
+			} else if ip.To4() != nil {
+				s = s + "/32"
+			} else {
+				s = s + "/128"
+			}
+		}
+
+		_, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return fmt.Errorf("Error parsing trusted proxy %s: %s", s, err.Error())
+		}
+
+		pc.trusted = append(pc.trusted, ipnet)
+	}
+
+	for _, s := range pc.Ports {
+		if _, _, err := parseProxyPort(s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (pc *proxyConfig) timeout() time.Duration {
+	if pc.Timeout == 0 {
+		return defaultProxyTimeout
+	}
+
+	return pc.Timeout.Duration()
+}
+
+// parseProxyPort parses ports in the "tcp/(host:)port" format.
+func parseProxyPort(s string) (net.IP, int, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 || parts[0] != "tcp" {
+		return nil, 0, fmt.Errorf("Proxy protocol port %s has wrong format (needs to be \"tcp/(host:)port\")", s)
+	}
+
+	host, port, err := net.SplitHostPort(parts[1])
+	if err != nil {
+		port = parts[1]
+	}
+
+	v, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Error parsing proxy protocol port %s: %s", s, err.Error())
+	}
+
+	return net.ParseIP(host), int(v), nil
+}
+
+// Enabled returns whether the proxy protocol has been enabled for the address.
+func (pc *proxyConfig) Enabled(addr net.Addr) bool {
+	ta, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	for _, s := range pc.Ports {
+		ip, port, err := parseProxyPort(s)
+		if err != nil {
+			continue
+		}
+
+		if port != ta.Port {
+			continue
+		}
+
+		if ip == nil || ta.IP == nil || ip.Equal(ta.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsTrusted returns whether the remote address is allowed to send proxy headers.
+func (pc *proxyConfig) IsTrusted(addr net.Addr) bool {
+	ta, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	for _, ipnet := range pc.trusted {
+		if ipnet.Contains(ta.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// proxyConn is a connection received through a proxy, the remote address
+// returned is the address of the original client.
+type proxyConn struct {
+	net.Conn
+
+	r *bufio.Reader
+
+	Version   int
+	ProxyAddr net.Addr
+	Raddr     net.Addr
+}
+
+func (pc *proxyConn) Read(b []byte) (int, error) {
+	return pc.r.Read(b)
+}
+
+func (pc *proxyConn) RemoteAddr() net.Addr {
+	return pc.Raddr
+}
+
+// readProxyHeader reads a PROXY protocol v1 or v2 header from the connection.
+func readProxyHeader(conn net.Conn) (*proxyConn, error) {
+	r := bufio.NewReader(conn)
+
+	pc := &proxyConn{
+		Conn:      conn,
+		r:         r,
+		ProxyAddr: conn.RemoteAddr(),
+		Raddr:     conn.RemoteAddr(),
+	}
+
+	if sig, err := r.Peek(len(proxySignatureV2)); err == nil && bytes.Equal(sig, proxySignatureV2) {
+		pc.Version = 2
+		return pc, readProxyHeaderV2(r, pc)
+	}
+
+	if sig, err := r.Peek(6); err != nil {
+		return nil, err
+	} else if string(sig) != "PROXY " {
+		return nil, ErrNoProxyHeader
+	}
+
+	pc.Version = 1
+	return pc, readProxyHeaderV1(r, pc)
+}
+
+func readProxyHeaderV1(r *bufio.Reader, pc *proxyConn) error {
+	line := []byte{}
+
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		line = append(line, b)
+
+		if b == '\n' {
+			break
+		}
+
+		if len(line) >= proxyHeaderV1MaxLength {
+			return ErrInvalidProxyHeader
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return ErrInvalidProxyHeader
+	}
+
+	parts := strings.Split(string(line[:len(line)-2]), " ")
+	if len(parts) < 2 {
+		return ErrInvalidProxyHeader
+	}
+
+	switch parts[1] {
+	case "UNKNOWN":
+		// the proxy doesn't know the source, keep the proxy address
+		return nil
+	case "TCP4", "TCP6":
+	default:
+		return ErrInvalidProxyHeader
+	}
+
+	if len(parts) != 6 {
+		return ErrInvalidProxyHeader
+	}
+
+	ip := net.ParseIP(parts[2])
+	if ip == nil {
+		return ErrInvalidProxyHeader
+	}
+
+	port, err := strconv.ParseUint(parts[4], 10, 16)
+	if err != nil {
+		return ErrInvalidProxyHeader
+	}
+
+	pc.Raddr = &net.TCPAddr{
+		IP:   ip,
+		Port: int(port),
+	}
+
+	return nil
+}
+
+func readProxyHeaderV2(r *bufio.Reader, pc *proxyConn) error {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+
+	if header[12]>>4 != 0x2 {
+		return ErrInvalidProxyHeader
+	}
+
+	command := header[12] & 0x0f
+	family := header[13]
+
+	size := binary.BigEndian.Uint16(header[14:16])
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+
+	switch command {
+	case 0x0:
+		// LOCAL, connection has been established by the proxy itself
+		return nil
+	case 0x1:
+	default:
+		return ErrInvalidProxyHeader
+	}
+
+	switch family {
+	case 0x11:
+		// TCP over IPv4
+		if len(data) < 12 {
+			return ErrInvalidProxyHeader
+		}
+
+		pc.Raddr = &net.TCPAddr{
+			IP:   net.IP(data[0:4]),
+			Port: int(binary.BigEndian.Uint16(data[8:10])),
+		}
+	case 0x21:
+		// TCP over IPv6
+		if len(data) < 36 {
+			return ErrInvalidProxyHeader
+		}
+
+		pc.Raddr = &net.TCPAddr{
+			IP:   net.IP(data[0:16]),
+			Port: int(binary.BigEndian.Uint16(data[32:34])),
+		}
+	default:
+		// unsupported families (UDP, unix sockets), keep the proxy address
+	}
+
+	return nil
+}
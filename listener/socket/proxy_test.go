@@ -0,0 +1,143 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func proxyHeader(header []byte, payload string) (*proxyConn, error) {
+	server, client := net.Pipe()
+
+	go func() {
+		client.Write(header)
+		client.Write([]byte(payload))
+		client.Close()
+	}()
+
+	return readProxyHeader(server)
+}
+
+func TestProxyHeaderV1(t *testing.T) {
+	pc, err := proxyHeader([]byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"), "GET / HTTP/1.1\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pc.Version != 1 {
+		t.Errorf("Expected version 1, got %d", pc.Version)
+	}
+
+	if pc.RemoteAddr().String() != "192.168.0.1:56324" {
+		t.Errorf("Expected remote address 192.168.0.1:56324, got %s", pc.RemoteAddr())
+	}
+
+	data, err := ioutil.ReadAll(pc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "GET / HTTP/1.1\r\n" {
+		t.Errorf("Expected payload to be preserved, got %q", data)
+	}
+}
+
+func TestProxyHeaderV1Unknown(t *testing.T) {
+	pc, err := proxyHeader([]byte("PROXY UNKNOWN\r\n"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pc.RemoteAddr() != pc.ProxyAddr {
+		t.Errorf("Expected remote address to be the proxy address, got %s", pc.RemoteAddr())
+	}
+}
+
+func TestProxyHeaderV2(t *testing.T) {
+	buff := bytes.Buffer{}
+	buff.Write(proxySignatureV2)
+	buff.Write([]byte{0x21, 0x11})
+	binary.Write(&buff, binary.BigEndian, uint16(12))
+	buff.Write(net.ParseIP("10.1.2.3").To4())
+	buff.Write(net.ParseIP("10.3.2.1").To4())
+	binary.Write(&buff, binary.BigEndian, uint16(4321))
+	binary.Write(&buff, binary.BigEndian, uint16(22))
+
+	pc, err := proxyHeader(buff.Bytes(), "SSH-2.0-OpenSSH\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pc.Version != 2 {
+		t.Errorf("Expected version 2, got %d", pc.Version)
+	}
+
+	if pc.RemoteAddr().String() != "10.1.2.3:4321" {
+		t.Errorf("Expected remote address 10.1.2.3:4321, got %s", pc.RemoteAddr())
+	}
+
+	data, err := ioutil.ReadAll(pc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "SSH-2.0-OpenSSH\r\n" {
+		t.Errorf("Expected payload to be preserved, got %q", data)
+	}
+}
+
+func TestProxyHeaderMissing(t *testing.T) {
+	if _, err := proxyHeader([]byte("GET / HTTP/1.1\r\n"), ""); err != ErrNoProxyHeader {
+		t.Errorf("Expected ErrNoProxyHeader, got %v", err)
+	}
+}
+
+func TestProxyConfig(t *testing.T) {
+	pc := proxyConfig{
+		Ports:   []string{"tcp/8080", "tcp/127.0.0.1:8443"},
+		Trusted: []string{"10.0.0.0/8", "192.168.1.1"},
+	}
+
+	if err := pc.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !pc.Enabled(&net.TCPAddr{Port: 8080}) {
+		t.Error("Expected proxy protocol to be enabled for tcp/8080")
+	}
+
+	if pc.Enabled(&net.TCPAddr{IP: net.ParseIP("127.0.0.2"), Port: 8443}) {
+		t.Error("Expected proxy protocol to be disabled for 127.0.0.2:8443")
+	}
+
+	if pc.Enabled(&net.UDPAddr{Port: 8080}) {
+		t.Error("Expected proxy protocol to be disabled for udp")
+	}
+
+	if !pc.IsTrusted(&net.TCPAddr{IP: net.ParseIP("10.20.30.40")}) {
+		t.Error("Expected 10.20.30.40 to be trusted")
+	}
+
+	if !pc.IsTrusted(&net.TCPAddr{IP: net.ParseIP("192.168.1.1")}) {
+		t.Error("Expected 192.168.1.1 to be trusted")
+	}
+
+	if pc.IsTrusted(&net.TCPAddr{IP: net.ParseIP("192.168.1.2")}) {
+		t.Error("Expected 192.168.1.2 not to be trusted")
+	}
+}
@@ -17,9 +17,13 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"time"
 
 	"github.com/fatih/color"
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
 	"github.com/honeytrap/honeytrap/listener"
+	"github.com/honeytrap/honeytrap/pushers"
 	logging "github.com/op/go-logging"
 )
 
@@ -34,11 +38,19 @@ type socketListener struct {
 
 	ch chan net.Conn
 
+	events pushers.Channel
+
 	net.Listener
 }
 
 type socketConfig struct {
 	Addresses []net.Addr
+
+	UDPIdleTimeout config.Delay `toml:"udp_idle_timeout"`
+	UDPMaxFlows    int          `toml:"udp_max_flows"`
+	UDPMaxQueue    int          `toml:"udp_max_queue"`
+
+	ProxyProtocol proxyConfig `toml:"proxy_protocol"`
 }
 
 func (sc *socketConfig) AddAddress(a net.Addr) {
@@ -51,6 +63,7 @@ func New(options ...func(listener.Listener) error) (listener.Listener, error) {
 	l := socketListener{
 		socketConfig: socketConfig{},
 		ch:           ch,
+		events:       pushers.MustDummy(),
 	}
 
 	for _, option := range options {
@@ -60,7 +73,48 @@ func New(options ...func(listener.Listener) error) (listener.Listener, error) {
 	return &l, nil
 }
 
+func (sl *socketListener) SetChannel(c pushers.Channel) {
+	sl.events = c
+}
+
+func (sl *socketListener) acceptProxy(c net.Conn) {
+	if !sl.ProxyProtocol.IsTrusted(c.RemoteAddr()) {
+		sl.ch <- c
+		return
+	}
+
+	c.SetReadDeadline(time.Now().Add(sl.ProxyProtocol.timeout()))
+
+	pc, err := readProxyHeader(c)
+	if err != nil {
+		log.Errorf("Error reading proxy protocol header from %s: %s", c.RemoteAddr(), err.Error())
+		c.Close()
+		return
+	}
+
+	c.SetReadDeadline(time.Time{})
+
+	log.Debugf("Proxied connection (v%d) for %s through %s", pc.Version, pc.RemoteAddr(), pc.ProxyAddr)
+
+	sl.ch <- event.WithConn(pc,
+		event.Custom("proxy.addr", pc.ProxyAddr.String()),
+		event.Custom("proxy.version", pc.Version),
+	)
+}
+
 func (sl *socketListener) Start(ctx context.Context) error {
+	if err := sl.ProxyProtocol.init(); err != nil {
+		return err
+	}
+
+	flows := listener.NewUDPFlows(listener.UDPFlowConfig{
+		IdleTimeout: sl.UDPIdleTimeout.Duration(),
+		MaxFlows:    sl.UDPMaxFlows,
+		MaxQueue:    sl.UDPMaxQueue,
+	}, sl.events)
+
+	go flows.Run(ctx)
+
 	for _, address := range sl.Addresses {
 		if _, ok := address.(*net.TCPAddr); ok {
 			l, err := net.Listen(address.Network(), address.String())
@@ -69,7 +123,12 @@ func (sl *socketListener) Start(ctx context.Context) error {
 				continue
 			}
 
-			log.Infof("Listener started: tcp/%s", address)
+			proxied := sl.ProxyProtocol.Enabled(address)
+			if proxied {
+				log.Infof("Listener started: tcp/%s (proxy protocol)", address)
+			} else {
+				log.Infof("Listener started: tcp/%s", address)
+			}
 
 			go func() {
 				for {
@@ -79,6 +138,11 @@ func (sl *socketListener) Start(ctx context.Context) error {
 						continue
 					}
 
+					if proxied {
+						go sl.acceptProxy(c)
+						continue
+					}
+
 					sl.ch <- c
 				}
 			}()
@@ -92,20 +156,20 @@ func (sl *socketListener) Start(ctx context.Context) error {
 			log.Infof("Listener started: udp/%s", address)
 
 			go func() {
-				for {
-					var buf [65535]byte
+				var buf [65535]byte
 
+				for {
 					n, raddr, err := l.ReadFromUDP(buf[:])
 					if err != nil {
 						log.Error("Error reading udp:", err.Error())
 						continue
 					}
 
-					sl.ch <- &listener.DummyUDPConn{
-						Buffer: buf[:n],
-						Laddr:  l.LocalAddr(),
-						Raddr:  raddr,
-						Fn:     l.WriteToUDP,
+					payload := make([]byte, n)
+					copy(payload, buf[:n])
+
+					if fc := flows.Deliver(l.LocalAddr(), raddr, payload, l.WriteToUDP); fc != nil {
+						sl.ch <- fc
 					}
 				}
 			}()
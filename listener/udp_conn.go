@@ -64,3 +64,10 @@ func (dc *DummyUDPConn) SetReadDeadline(t time.Time) error {
 func (dc *DummyUDPConn) SetWriteDeadline(t time.Time) error {
 	return nil
 }
+
+// IsUDPConn returns whether the connection carries udp datagrams, the
+// local address is checked as services receive wrapped connections.
+func IsUDPConn(conn net.Conn) bool {
+	_, ok := conn.LocalAddr().(*net.UDPAddr)
+	return ok
+}
@@ -0,0 +1,389 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package listener
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+var (
+	SensorListener = event.Sensor("listener")
+
+	EventCategoryUDPFlow = event.Category("udp-flow")
+)
+
+const (
+	DefaultUDPFlowIdleTimeout = time.Second * 60
+	DefaultUDPFlowMaxFlows    = 4096
+	DefaultUDPFlowMaxQueue    = 64
+)
+
+var (
+	ErrUDPFlowClosed = errors.New("udp flow closed")
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// UDPFlowConfig contains the bounds of an UDPFlows table.
+type UDPFlowConfig struct {
+	// IdleTimeout is the time after which a flow without datagrams expires.
+	IdleTimeout time.Duration
+
+	// MaxFlows is the maximum number of concurrent flows, when reached the
+	// least recently seen flow will be expired.
+	MaxFlows int
+
+	// MaxQueue is the number of datagrams queued per flow before new
+	// datagrams are dropped.
+	MaxQueue int
+}
+
+// UDPFlows keeps track of udp pseudo sessions, keyed by their 5-tuple, so
+// subsequent datagrams of the same flow will be delivered to the same
+// connection.
+type UDPFlows struct {
+	UDPFlowConfig
+
+	events pushers.Channel
+
+	m     sync.Mutex
+	flows map[string]*UDPFlowConn
+}
+
+func NewUDPFlows(config UDPFlowConfig, events pushers.Channel) *UDPFlows {
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = DefaultUDPFlowIdleTimeout
+	}
+
+	if config.MaxFlows <= 0 {
+		config.MaxFlows = DefaultUDPFlowMaxFlows
+	}
+
+	if config.MaxQueue <= 0 {
+		config.MaxQueue = DefaultUDPFlowMaxQueue
+	}
+
+	if events == nil {
+		events = pushers.MustDummy()
+	}
+
+	return &UDPFlows{
+		UDPFlowConfig: config,
+		events:        events,
+		flows:         map[string]*UDPFlowConn{},
+	}
+}
+
+func flowKey(laddr net.Addr, raddr net.Addr) string {
+	return "udp|" + laddr.String() + "|" + raddr.String()
+}
+
+// Deliver queues the payload on the flow for laddr and raddr. When there is no
+// flow yet, a new flow will be created and returned, the caller is responsible
+// for handing it to a service. For existing flows nil will be returned.
+func (t *UDPFlows) Deliver(laddr net.Addr, raddr *net.UDPAddr, payload []byte, fn func([]byte, *net.UDPAddr) (int, error)) *UDPFlowConn {
+	key := flowKey(laddr, raddr)
+
+	t.m.Lock()
+
+	if fc, ok := t.flows[key]; ok {
+		t.m.Unlock()
+
+		fc.deliver(payload)
+		return nil
+	}
+
+	var expired *UDPFlowConn
+	if len(t.flows) >= t.MaxFlows {
+		for _, fc := range t.flows {
+			if expired == nil || fc.LastSeen().Before(expired.LastSeen()) {
+				expired = fc
+			}
+		}
+
+		delete(t.flows, expired.key)
+	}
+
+	now := time.Now()
+
+	fc := &UDPFlowConn{
+		Laddr: laddr,
+		Raddr: raddr,
+		Fn:    fn,
+
+		table:    t,
+		key:      key,
+		in:       make(chan []byte, t.MaxQueue),
+		closed:   make(chan struct{}),
+		started:  now,
+		lastSeen: now,
+	}
+
+	t.flows[key] = fc
+
+	t.m.Unlock()
+
+	if expired != nil {
+		log.Debugf("Maximum udp flows reached, expiring flow %s => %s", expired.Raddr, expired.Laddr)
+		expired.end("evicted")
+	}
+
+	t.events.Send(event.New(
+		SensorListener,
+		EventCategoryUDPFlow,
+		event.ConnectionOpened,
+		event.Protocol("udp"),
+		event.SourceAddr(raddr),
+		event.DestinationAddr(laddr),
+	))
+
+	fc.deliver(payload)
+	return fc
+}
+
+// Len returns the number of active flows.
+func (t *UDPFlows) Len() int {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	return len(t.flows)
+}
+
+func (t *UDPFlows) remove(fc *UDPFlowConn) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	if current, ok := t.flows[fc.key]; ok && current == fc {
+		delete(t.flows, fc.key)
+	}
+}
+
+// Expire ends all flows that have been idle for longer than the idle timeout.
+func (t *UDPFlows) Expire() {
+	deadline := time.Now().Add(-t.IdleTimeout)
+
+	expired := []*UDPFlowConn{}
+
+	t.m.Lock()
+	for key, fc := range t.flows {
+		if fc.LastSeen().After(deadline) {
+			continue
+		}
+
+		delete(t.flows, key)
+		expired = append(expired, fc)
+	}
+	t.m.Unlock()
+
+	for _, fc := range expired {
+		fc.end("idle")
+	}
+}
+
+// Run expires idle flows until the context is done.
+func (t *UDPFlows) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.IdleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.Expire()
+		}
+	}
+}
+
+// UDPFlowConn is a connection for a single udp flow, every Read will return
+// (part of) the next datagram received from the remote address.
+type UDPFlowConn struct {
+	Laddr net.Addr
+	Raddr *net.UDPAddr
+
+	Fn func(b []byte, addr *net.UDPAddr) (int, error)
+
+	table *UDPFlows
+	key   string
+
+	in     chan []byte
+	buffer []byte
+
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	m            sync.Mutex
+	readDeadline time.Time
+	started      time.Time
+	lastSeen     time.Time
+	packetsIn    int
+	bytesIn      int
+	packetsOut   int
+	bytesOut     int
+}
+
+func (fc *UDPFlowConn) deliver(payload []byte) {
+	fc.m.Lock()
+	fc.lastSeen = time.Now()
+	fc.packetsIn++
+	fc.bytesIn += len(payload)
+	fc.m.Unlock()
+
+	select {
+	case <-fc.closed:
+	case fc.in <- payload:
+	default:
+		log.Debugf("Dropping datagram for udp flow %s => %s, queue full", fc.Raddr, fc.Laddr)
+	}
+}
+
+// LastSeen returns the time the last datagram was received.
+func (fc *UDPFlowConn) LastSeen() time.Time {
+	fc.m.Lock()
+	defer fc.m.Unlock()
+
+	return fc.lastSeen
+}
+
+func (fc *UDPFlowConn) Read(b []byte) (int, error) {
+	if len(fc.buffer) > 0 {
+		n := copy(b, fc.buffer)
+		fc.buffer = fc.buffer[n:]
+		return n, nil
+	}
+
+	select {
+	case <-fc.closed:
+		return 0, io.EOF
+	default:
+	}
+
+	fc.m.Lock()
+	deadline := fc.readDeadline
+	fc.m.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return 0, timeoutError{}
+		}
+
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+
+		timeout = timer.C
+	}
+
+	select {
+	case payload := <-fc.in:
+		n := copy(b, payload)
+		fc.buffer = payload[n:]
+		return n, nil
+	case <-fc.closed:
+		return 0, io.EOF
+	case <-timeout:
+		return 0, timeoutError{}
+	}
+}
+
+func (fc *UDPFlowConn) Write(b []byte) (int, error) {
+	select {
+	case <-fc.closed:
+		return 0, ErrUDPFlowClosed
+	default:
+	}
+
+	fc.m.Lock()
+	fc.packetsOut++
+	fc.bytesOut += len(b)
+	fc.m.Unlock()
+
+	if fc.Fn == nil {
+		return len(b), nil
+	}
+
+	return fc.Fn(b[:], fc.Raddr)
+}
+
+func (fc *UDPFlowConn) end(reason string) {
+	fc.closeOnce.Do(func() {
+		close(fc.closed)
+
+		fc.m.Lock()
+		duration := time.Since(fc.started)
+		packetsIn, bytesIn := fc.packetsIn, fc.bytesIn
+		packetsOut, bytesOut := fc.packetsOut, fc.bytesOut
+		fc.m.Unlock()
+
+		fc.table.events.Send(event.New(
+			SensorListener,
+			EventCategoryUDPFlow,
+			event.ConnectionClosed,
+			event.Protocol("udp"),
+			event.SourceAddr(fc.Raddr),
+			event.DestinationAddr(fc.Laddr),
+			event.Custom("udp-flow.reason", reason),
+			event.Custom("udp-flow.duration", duration),
+			event.Custom("udp-flow.packets-in", packetsIn),
+			event.Custom("udp-flow.bytes-in", bytesIn),
+			event.Custom("udp-flow.packets-out", packetsOut),
+			event.Custom("udp-flow.bytes-out", bytesOut),
+		))
+	})
+}
+
+// Close removes the flow from the table, a new datagram from the same remote
+// address will start a new flow.
+func (fc *UDPFlowConn) Close() error {
+	fc.table.remove(fc)
+	fc.end("closed")
+	return nil
+}
+
+func (fc *UDPFlowConn) LocalAddr() net.Addr {
+	return fc.Laddr
+}
+
+func (fc *UDPFlowConn) RemoteAddr() net.Addr {
+	return fc.Raddr
+}
+
+func (fc *UDPFlowConn) SetDeadline(t time.Time) error {
+	return fc.SetReadDeadline(t)
+}
+
+func (fc *UDPFlowConn) SetReadDeadline(t time.Time) error {
+	fc.m.Lock()
+	defer fc.m.Unlock()
+
+	fc.readDeadline = t
+	return nil
+}
+
+func (fc *UDPFlowConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package listener
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+var (
+	laddr = &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 69}
+)
+
+func TestUDPFlowsSameFlow(t *testing.T) {
+	flows := NewUDPFlows(UDPFlowConfig{}, nil)
+
+	raddr := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}
+
+	fc := flows.Deliver(laddr, raddr, []byte("first"), nil)
+	if fc == nil {
+		t.Fatal("Expected new flow for first datagram")
+	}
+
+	if c := flows.Deliver(laddr, raddr, []byte("second"), nil); c != nil {
+		t.Fatal("Expected second datagram to be delivered to existing flow")
+	}
+
+	buffer := make([]byte, 1024)
+	for _, expected := range []string{"first", "second"} {
+		n, err := fc.Read(buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(buffer[:n]) != expected {
+			t.Errorf("Test failed: got %q, expected %q", buffer[:n], expected)
+		}
+	}
+
+	fc.Close()
+
+	if flows.Len() != 0 {
+		t.Errorf("Expected flow to be removed after close, got %d flows", flows.Len())
+	}
+
+	if c := flows.Deliver(laddr, raddr, []byte("third"), nil); c == nil {
+		t.Error("Expected new flow after close")
+	}
+}
+
+func TestUDPFlowsReadDeadline(t *testing.T) {
+	flows := NewUDPFlows(UDPFlowConfig{}, nil)
+
+	raddr := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}
+
+	fc := flows.Deliver(laddr, raddr, []byte("first"), nil)
+
+	buffer := make([]byte, 1024)
+	if _, err := fc.Read(buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	fc.SetReadDeadline(time.Now().Add(time.Millisecond * 10))
+
+	_, err := fc.Read(buffer)
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("Expected timeout error, got %v", err)
+	}
+}
+
+func TestUDPFlowsExpire(t *testing.T) {
+	flows := NewUDPFlows(UDPFlowConfig{
+		IdleTimeout: time.Millisecond,
+	}, nil)
+
+	raddr := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}
+
+	fc := flows.Deliver(laddr, raddr, []byte("first"), nil)
+
+	time.Sleep(time.Millisecond * 5)
+
+	flows.Expire()
+
+	if flows.Len() != 0 {
+		t.Errorf("Expected idle flow to be expired, got %d flows", flows.Len())
+	}
+
+	buffer := make([]byte, 1024)
+	if _, err := fc.Read(buffer); err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+}
+
+func TestUDPFlowsMaxFlows(t *testing.T) {
+	flows := NewUDPFlows(UDPFlowConfig{
+		MaxFlows: 2,
+	}, nil)
+
+	for i := 0; i < 5; i++ {
+		raddr := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1000 + i}
+		flows.Deliver(laddr, raddr, []byte("payload"), nil)
+	}
+
+	if flows.Len() != 2 {
+		t.Errorf("Expected 2 flows, got %d", flows.Len())
+	}
+}
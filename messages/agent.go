@@ -16,6 +16,8 @@ package messages
 import "time"
 
 type Agent struct {
+	Name          string `json:"name"`
+	PublicKey     string `json:"public_key"`
 	Version       string `json:"version"`
 	ShortCommitID string `json:"shortcommit_id"`
 	Token         string `json:"token"`
@@ -0,0 +1,178 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metrics
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	logging "github.com/op/go-logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var log = logging.MustGetLogger("honeytrap/metrics")
+
+const namespace = "honeytrap"
+
+// Config contains the configuration of the metrics endpoint.
+type Config struct {
+	Enabled bool   `toml:"enabled"`
+	Listen  string `toml:"listen"`
+	Path    string `toml:"path"`
+}
+
+func (c *Config) defaults() {
+	if c.Listen == "" {
+		c.Listen = "127.0.0.1:9108"
+	}
+
+	if c.Path == "" {
+		c.Path = "/metrics"
+	}
+}
+
+var (
+	// Registry contains all honeytrap metrics and the Go runtime metrics.
+	Registry = prometheus.NewRegistry()
+
+	// ConnectionsAccepted counts the connections handled per port and service.
+	ConnectionsAccepted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "connections_accepted_total",
+		Help:      "Number of accepted connections per port and service.",
+	}, []string{"port", "service"})
+
+	// ServiceMisses counts the connections no service could be found for.
+	ServiceMisses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "service_misses_total",
+		Help:      "Number of connections without a suitable service per port.",
+	}, []string{"port"})
+
+	// SessionDuration observes the time services spent handling connections.
+	SessionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "session_duration_seconds",
+		Help:      "Duration of sessions handled per service.",
+		Buckets:   []float64{.1, .5, 1, 5, 10, 30, 60, 300, 900, 3600},
+	}, []string{"service"})
+
+	// AuthAttempts counts the authentication events per category and type.
+	AuthAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_attempts_total",
+		Help:      "Number of authentication attempts per category and type.",
+	}, []string{"category", "type"})
+
+	// Events counts the events sent to the bus per category.
+	Events = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_total",
+		Help:      "Number of events per category.",
+	}, []string{"category"})
+
+	// PusherEvents counts the events sent to each channel.
+	PusherEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pusher_events_total",
+		Help:      "Number of events sent to a channel.",
+	}, []string{"channel"})
+
+	// CanaryKnocks counts the knocks seen by the canary per protocol.
+	CanaryKnocks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "canary_knocks_total",
+		Help:      "Number of port knocks seen by the canary per protocol.",
+	}, []string{"protocol"})
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		ConnectionsAccepted,
+		ServiceMisses,
+		SessionDuration,
+		AuthAttempts,
+		Events,
+		PusherEvents,
+		CanaryKnocks,
+		spoolCollector{},
+	)
+}
+
+// ListenAndServe serves the metrics endpoint, it returns when the endpoint
+// hasn't been enabled.
+func ListenAndServe(c Config) error {
+	if !c.Enabled {
+		return nil
+	}
+
+	c.defaults()
+
+	mux := http.NewServeMux()
+	mux.Handle(c.Path, Handler())
+
+	log.Infof("Metrics endpoint started: http://%s%s", c.Listen, c.Path)
+	return http.ListenAndServe(c.Listen, mux)
+}
+
+// Handler returns the http handler exposing the metrics.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// EventChannel returns a channel counting the events per category and the
+// authentication attempts, it should be subscribed to the event bus.
+func EventChannel() pushers.Channel {
+	return &eventChannel{}
+}
+
+type eventChannel struct {
+}
+
+func (c *eventChannel) Send(e event.Event) {
+	category := e.Get("category")
+	if category == "" {
+		category = "unknown"
+	}
+
+	Events.WithLabelValues(category).Inc()
+
+	if t := e.Get("type"); strings.HasSuffix(t, "-authentication") {
+		AuthAttempts.WithLabelValues(category, t).Inc()
+	}
+}
+
+// PusherChannel returns a channel counting the events sent to ch.
+func PusherChannel(name string, ch pushers.Channel) pushers.Channel {
+	return &pusherChannel{
+		Channel: ch,
+		counter: PusherEvents.WithLabelValues(name),
+	}
+}
+
+type pusherChannel struct {
+	pushers.Channel
+
+	counter prometheus.Counter
+}
+
+func (c *pusherChannel) Send(e event.Event) {
+	c.counter.Inc()
+	c.Channel.Send(e)
+}
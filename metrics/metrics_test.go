@@ -0,0 +1,159 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/pushers/spool"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type nopChannel struct {
+	count int
+}
+
+func (c *nopChannel) Send(e event.Event) {
+	c.count++
+}
+
+type countingChannel struct {
+	pushers.Counting
+}
+
+func (c *countingChannel) Send(e event.Event) {
+	c.CountDropped()
+}
+
+type queuedChannel struct {
+	ch chan event.Event
+}
+
+func (c *queuedChannel) Send(e event.Event) {
+	c.ch <- e
+}
+
+func (c *queuedChannel) QueueLen() int {
+	return len(c.ch)
+}
+
+func TestEventChannel(t *testing.T) {
+	ch := EventChannel()
+
+	ch.Send(event.New(
+		event.Category("ssh"),
+		event.Type("password-authentication"),
+	))
+	ch.Send(event.New(
+		event.Category("ssh"),
+		event.Type("session"),
+	))
+	ch.Send(event.New())
+
+	if v := testutil.ToFloat64(Events.WithLabelValues("ssh")); v != 2 {
+		t.Errorf("Expected 2 ssh events, got %v", v)
+	}
+
+	if v := testutil.ToFloat64(Events.WithLabelValues("unknown")); v != 1 {
+		t.Errorf("Expected 1 unknown event, got %v", v)
+	}
+
+	if v := testutil.ToFloat64(AuthAttempts.WithLabelValues("ssh", "password-authentication")); v != 1 {
+		t.Errorf("Expected 1 auth attempt, got %v", v)
+	}
+}
+
+func TestPusherChannel(t *testing.T) {
+	nc := &nopChannel{}
+
+	ch := PusherChannel("test", nc)
+	ch.Send(event.New())
+	ch.Send(event.New())
+
+	if nc.count != 2 {
+		t.Errorf("Expected events to be passed to the channel, got %d", nc.count)
+	}
+
+	if v := testutil.ToFloat64(PusherEvents.WithLabelValues("test")); v != 2 {
+		t.Errorf("Expected 2 pusher events, got %v", v)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	s, err := spool.Open("elasticsearch", dir, spool.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+
+	if err := s.Append([]byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the failed deliveries of the spool and the channel are merged
+	es := &countingChannel{}
+	pushers.WithCounters("elasticsearch")(es)
+	es.CountFailed()
+
+	sl := &countingChannel{}
+	pushers.WithCounters("syslog")(sl)
+	sl.Send(event.New())
+
+	// the buffer of the channel is added to the depth of the spool
+	es2 := &queuedChannel{ch: make(chan event.Event, 10)}
+	pushers.WithCounters("elasticsearch")(es2)
+	es2.Send(event.New())
+
+	sk := &queuedChannel{ch: make(chan event.Event, 10)}
+	pushers.WithCounters("splunk")(sk)
+	sk.Send(event.New())
+	sk.Send(event.New())
+
+	ServiceMisses.WithLabelValues("tcp/23").Inc()
+	SessionDuration.WithLabelValues("ssh").Observe(1.5)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := rec.Body.String()
+
+	for _, expected := range []string{
+		`honeytrap_pusher_queue_depth{channel="elasticsearch"} 2`,
+		`honeytrap_pusher_queue_depth{channel="splunk"} 2`,
+		`honeytrap_pusher_errors_total{channel="elasticsearch"} 1`,
+		`honeytrap_pusher_dropped_total{channel="syslog",reason="channel-full"} 1`,
+		`honeytrap_pusher_errors_total{channel="syslog"} 0`,
+		`honeytrap_service_misses_total{port="tcp/23"} 1`,
+		`honeytrap_session_duration_seconds_count{service="ssh"} 1`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected metrics to contain %s, got %s", expected, body)
+		}
+	}
+}
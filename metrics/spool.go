@@ -0,0 +1,100 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metrics
+
+import (
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/pushers/spool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	spoolDepth = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "pusher", "queue_depth"),
+		"Number of buffered and spooled events waiting to be delivered.",
+		[]string{"channel"}, nil,
+	)
+	spoolSize = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "pusher", "spool_bytes"),
+		"Size of the spool segments in bytes.",
+		[]string{"channel"}, nil,
+	)
+	spoolDelivered = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "pusher", "delivered_total"),
+		"Number of spooled events delivered.",
+		[]string{"channel"}, nil,
+	)
+	spoolDropped = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "pusher", "dropped_total"),
+		"Number of events dropped because the channel or its spool was full, or the events expired.",
+		[]string{"channel", "reason"}, nil,
+	)
+	spoolErrors = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "pusher", "errors_total"),
+		"Number of failed deliveries of events.",
+		[]string{"channel"}, nil,
+	)
+)
+
+// spoolCollector collects the stats of the open spools and the counters of
+// the channels.
+type spoolCollector struct {
+}
+
+func (sc spoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- spoolDepth
+	ch <- spoolSize
+	ch <- spoolDelivered
+	ch <- spoolDropped
+	ch <- spoolErrors
+}
+
+func (sc spoolCollector) Collect(ch chan<- prometheus.Metric) {
+	// failed deliveries are counted by the spool and by the channel, they
+	// are reported once per channel
+	failed := map[string]uint64{}
+
+	// events wait in the spool and in the buffer of the channel
+	depth := map[string]int64{}
+
+	spool.Range(func(name string, s *spool.Spool) {
+		stats := s.Stats()
+
+		ch <- prometheus.MustNewConstMetric(spoolSize, prometheus.GaugeValue, float64(stats.Size), name)
+		ch <- prometheus.MustNewConstMetric(spoolDelivered, prometheus.CounterValue, float64(stats.Delivered), name)
+		ch <- prometheus.MustNewConstMetric(spoolDropped, prometheus.CounterValue, float64(stats.Dropped), name, "full")
+		ch <- prometheus.MustNewConstMetric(spoolDropped, prometheus.CounterValue, float64(stats.Expired), name, "expired")
+
+		depth[name] += stats.Depth
+		failed[name] += stats.Failed
+	})
+
+	pushers.RangeCounters(func(name string, c *pushers.Counters) {
+		ch <- prometheus.MustNewConstMetric(spoolDropped, prometheus.CounterValue, float64(c.Dropped()), name, "channel-full")
+
+		if n, ok := c.QueueLen(); ok {
+			depth[name] += int64(n)
+		}
+
+		failed[name] += c.Failed()
+	})
+
+	for name, n := range depth {
+		ch <- prometheus.MustNewConstMetric(spoolDepth, prometheus.GaugeValue, float64(n), name)
+	}
+
+	for name, count := range failed {
+		ch <- prometheus.MustNewConstMetric(spoolErrors, prometheus.CounterValue, float64(count), name)
+	}
+}
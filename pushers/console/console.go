@@ -118,3 +118,8 @@ func (b *Console) Send(e event.Event) {
 
 	b.ch <- mp
 }
+
+// QueueLen returns the number of events waiting in the channel buffer.
+func (b *Console) QueueLen() int {
+	return len(b.ch)
+}
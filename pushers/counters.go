@@ -0,0 +1,150 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Counters counts the events a channel dropped because it was full, and
+// its failed deliveries.
+type Counters struct {
+	dropped uint64
+	failed  uint64
+
+	queued Queued
+}
+
+// Dropped returns the number of dropped events.
+func (c *Counters) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
+
+// Failed returns the number of failed deliveries.
+func (c *Counters) Failed() uint64 {
+	return atomic.LoadUint64(&c.failed)
+}
+
+// QueueLen returns the number of events waiting in the buffer of the
+// channel, ok is false when the channel doesn't report it.
+func (c *Counters) QueueLen() (n int, ok bool) {
+	countersMutex.Lock()
+	q := c.queued
+	countersMutex.Unlock()
+
+	if q == nil {
+		return 0, false
+	}
+
+	return q.QueueLen(), true
+}
+
+var (
+	countersMutex sync.Mutex
+	counters      = map[string]*Counters{}
+)
+
+// CountersOf returns the counters of the named channel.
+func CountersOf(name string) *Counters {
+	countersMutex.Lock()
+	defer countersMutex.Unlock()
+
+	c, ok := counters[name]
+	if !ok {
+		c = &Counters{}
+		counters[name] = c
+	}
+
+	return c
+}
+
+// RangeCounters calls fn for the counters of all channels, ordered by name.
+func RangeCounters(fn func(string, *Counters)) {
+	countersMutex.Lock()
+
+	names := make([]string, 0, len(counters))
+	for name := range counters {
+		names = append(names, name)
+	}
+
+	countersMutex.Unlock()
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		fn(name, CountersOf(name))
+	}
+}
+
+// Counted is implemented by channels counting their dropped events and
+// failed deliveries.
+type Counted interface {
+	SetCounters(*Counters)
+}
+
+// Queued is implemented by channels buffering events in memory.
+type Queued interface {
+	QueueLen() int
+}
+
+// WithCounters counts the dropped events and failed deliveries of the
+// channel as the named channel, and reports the length of its buffer.
+func WithCounters(name string) func(Channel) error {
+	return func(d Channel) error {
+		counters := CountersOf(name)
+
+		if c, ok := d.(Counted); ok {
+			c.SetCounters(counters)
+		}
+
+		if q, ok := d.(Queued); ok {
+			countersMutex.Lock()
+			counters.queued = q
+			countersMutex.Unlock()
+		}
+
+		return nil
+	}
+}
+
+// Counting is embedded by channels to count their dropped events and
+// failed deliveries, events are only counted when counters have been set.
+type Counting struct {
+	counters *Counters
+}
+
+// SetCounters sets the counters of the channel.
+func (c *Counting) SetCounters(counters *Counters) {
+	c.counters = counters
+}
+
+// CountDropped counts an event dropped because the channel was full.
+func (c *Counting) CountDropped() {
+	if c.counters == nil {
+		return
+	}
+
+	atomic.AddUint64(&c.counters.dropped, 1)
+}
+
+// CountFailed counts a failed delivery.
+func (c *Counting) CountFailed() {
+	if c.counters == nil {
+		return
+	}
+
+	atomic.AddUint64(&c.counters.failed, 1)
+}
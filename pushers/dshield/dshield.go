@@ -24,6 +24,7 @@ import (
 	"net/http/httputil"
 	"os"
 	"runtime"
+	"strings"
 
 	"time"
 
@@ -59,9 +60,12 @@ api_key="{api_key}"
 // push messages to an elasticsearch api.
 type Backend struct {
 	Config
+	pushers.Counting
 
 	MyIP string
 
+	client *http.Client
+
 	ch chan json.Marshaler
 }
 
@@ -98,6 +102,18 @@ func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
 		log.Warning("DShield apikey not set.")
 	}
 
+	tlsClientConfig := &tls.Config{}
+
+	if c.Insecure {
+		tlsClientConfig = Insecure(tlsClientConfig)
+	}
+
+	c.client = &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: tlsClientConfig,
+		},
+	}
+
 	go c.run()
 
 	return &c, nil
@@ -155,19 +171,6 @@ func (hc Backend) MakeAuthHeader() (string, error) {
 }
 
 func (hc Backend) run() {
-
-	tlsClientConfig := &tls.Config{}
-
-	if hc.Insecure {
-		tlsClientConfig = Insecure(tlsClientConfig)
-	}
-
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: tlsClientConfig,
-		},
-	}
-
 	docs := make([]json.Marshaler, 0)
 
 	send := func(docs []json.Marshaler) {
@@ -175,63 +178,10 @@ func (hc Backend) run() {
 			return
 		}
 
-		authHeader := ""
-		if val, err := hc.MakeAuthHeader(); err == nil {
-			authHeader = val
-		} else {
-			log.Errorf("Error creating DShield authentication header: %s", err.Error())
-		}
-
-		l := Submit{
-			AuthHeader: authHeader,
-			Type:       "multiple",
-			Logs:       docs,
-		}
-
-		pr, pw := io.Pipe()
-
-		hash := sha1.New()
-
-		r := io.TeeReader(pr, hash)
-		r = io.TeeReader(r, os.Stdout)
-
-		go func(l Submit) {
-			var err error
-
-			defer pw.CloseWithError(err)
-
-			if err := json.NewEncoder(pw).Encode(l); err != nil {
-				log.Errorf("Error json encoding: %s", err.Error())
-			}
-		}(l)
-
-		req, err := http.NewRequest(http.MethodPost, "https://www.dshield.org/submitapi/", r)
-		if err != nil {
-			log.Errorf("Could create new request: %s", err.Error())
-			return
-		}
-
-		req.Header.Set("User-Agent", fmt.Sprintf("Honeytrap/%s (%s; %s) %s", cmd.Version, runtime.GOOS, runtime.GOARCH, cmd.ShortCommitID))
-		req.Header.Set("Content-Type", "application/json")
-
-		resp, err := client.Do(req)
-		if err != nil {
+		if err := hc.submit(docs); err != nil {
 			log.Errorf("Could not submit event to DShield: %s", err.Error())
-			return
-		}
-
-		if resp.StatusCode != http.StatusOK {
-			log.Errorf("Could not submit event to DShield: %d", resp.StatusCode)
-			return
-		}
-
-		if !hc.Debug {
-		} else if val, err := httputil.DumpResponse(resp, true); err == nil {
-			log.Debug(string(val))
+			hc.CountFailed()
 		}
-
-		// verify hash!
-		fmt.Printf("%x\n", hash.Sum(nil))
 	}
 
 	for {
@@ -254,81 +204,159 @@ func (hc Backend) run() {
 	}
 }
 
+// submit submits the logs to DShield.
+func (hc Backend) submit(docs []json.Marshaler) error {
+	authHeader := ""
+	if val, err := hc.MakeAuthHeader(); err == nil {
+		authHeader = val
+	} else {
+		log.Errorf("Error creating DShield authentication header: %s", err.Error())
+	}
+
+	l := Submit{
+		AuthHeader: authHeader,
+		Type:       "multiple",
+		Logs:       docs,
+	}
+
+	pr, pw := io.Pipe()
+	defer pr.Close()
+
+	hash := sha1.New()
+
+	r := io.TeeReader(pr, hash)
+	r = io.TeeReader(r, os.Stdout)
+
+	go func(l Submit) {
+		var err error
+
+		defer pw.CloseWithError(err)
+
+		if err := json.NewEncoder(pw).Encode(l); err != nil {
+			log.Errorf("Error json encoding: %s", err.Error())
+		}
+	}(l)
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(hc.URL, "/")+"/submitapi/", r)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("User-Agent", fmt.Sprintf("Honeytrap/%s (%s; %s) %s", cmd.Version, runtime.GOOS, runtime.GOARCH, cmd.ShortCommitID))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := hc.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected status code %d", resp.StatusCode)
+	}
+
+	if !hc.Debug {
+	} else if val, err := httputil.DumpResponse(resp, true); err == nil {
+		log.Debug(string(val))
+	}
+
+	// verify hash!
+	fmt.Printf("%x\n", hash.Sum(nil))
+	return nil
+}
+
 func (hc Backend) send(msg json.Marshaler) {
 	select {
 	case hc.ch <- msg:
 	default:
 		log.Errorf("Could not send more messages, channel full")
+		hc.CountDropped()
 	}
 }
 
-// Send delivers the giving push messages into dshield endpoint.
-func (hc Backend) Send(message event.Event) {
-	defer func() {
-		if r := recover(); r != nil {
-			log.Error("Error sending event: %+v", r)
+func toTime(v interface{}) time.Time {
+	switch v := v.(type) {
+	case time.Time:
+		return v
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t
 		}
-	}()
-
-	switch message.Get("category") {
-	case "telnet":
-		if message.Get("type") != "password-authentication" {
-		} else {
-			evt := &SSHEvent{}
-
-			message.Range(func(key, val interface{}) bool {
-				switch key.(string) {
-				case "date":
-					evt.Date = val.(time.Time)
-				case "source-ip":
-					evt.SourceIP = val.(string)
-				case "destination-ip":
-					evt.DestinationIP = val.(string)
-				case "source-port":
-					evt.SourcePort = val.(int)
-				case "destination-port":
-					evt.DestinationPort = val.(int)
-				case "telnet.username":
-					evt.Username = val.(string)
-				case "telnet.password":
-					evt.Password = val.(string)
-				}
-
-				return true
-			})
-
-			hc.send(evt)
+	}
+
+	return time.Time{}
+}
+
+func toInt(v interface{}) int {
+	switch v := v.(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+
+	return 0
+}
+
+// convert returns the DShield log of the event, nil when the event isn't
+// supported. Spooled events contain the decoded JSON values, eg. the date
+// as string and ports as float64.
+func convert(message event.Event) json.Marshaler {
+	values := event.ToMap(message)
+
+	category, _ := values["category"].(string)
+
+	switch category {
+	case "telnet", "ssh":
+		if values["type"] != "password-authentication" {
+			return nil
 		}
-	case "ssh":
-		if message.Get("type") != "password-authentication" {
-		} else {
-			evt := &SSHEvent{}
-
-			message.Range(func(key, val interface{}) bool {
-				switch key.(string) {
-				case "date":
-					evt.Date = val.(time.Time)
-				case "source-ip":
-					evt.SourceIP = val.(string)
-				case "destination-ip":
-					evt.DestinationIP = val.(string)
-				case "source-port":
-					evt.SourcePort = val.(int)
-				case "destination-port":
-					evt.DestinationPort = val.(int)
-				case "ssh.username":
-					evt.Username = val.(string)
-				case "ssh.password":
-					evt.Password = val.(string)
-				}
-
-				return true
-			})
-
-			hc.send(evt)
+
+		evt := &SSHEvent{
+			Date:            toTime(values["date"]),
+			SourcePort:      toInt(values["source-port"]),
+			DestinationPort: toInt(values["destination-port"]),
 		}
+
+		evt.SourceIP, _ = values["source-ip"].(string)
+		evt.DestinationIP, _ = values["destination-ip"].(string)
+		evt.Username, _ = values[category+".username"].(string)
+		evt.Password, _ = values[category+".password"].(string)
+
+		return evt
 	case "http":
 		// not yet supported
-	default:
 	}
+
+	return nil
+}
+
+// Deliver submits the events synchronously, it is used when the channel is spooled.
+func (hc Backend) Deliver(events []event.Event) error {
+	docs := []json.Marshaler{}
+
+	for _, e := range events {
+		if doc := convert(e); doc != nil {
+			docs = append(docs, doc)
+		}
+	}
+
+	if len(docs) == 0 {
+		return nil
+	}
+
+	return hc.submit(docs)
+}
+
+// Send delivers the giving push messages into dshield endpoint.
+func (hc Backend) Send(message event.Event) {
+	if doc := convert(message); doc != nil {
+		hc.send(doc)
+	}
+}
+
+// QueueLen returns the number of events waiting in the channel buffer.
+func (hc Backend) QueueLen() int {
+	return len(hc.ch)
 }
@@ -11,4 +11,68 @@
 // WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
 // See the License for the specific language governing permissions and
 // limitations under the License.
-package dshield_test
+package dshield
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+func TestDeliver(t *testing.T) {
+	logs := make(chan []map[string]interface{}, 1)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := struct {
+			Logs []map[string]interface{} `json:"logs"`
+		}{}
+
+		if r.URL.Path != "/submitapi/" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		} else if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			t.Error(err)
+		}
+
+		logs <- v.Logs
+	}))
+	defer s.Close()
+
+	b := Backend{
+		Config: Config{
+			URL: s.URL,
+		},
+		client: http.DefaultClient,
+	}
+
+	// spooled events contain the decoded JSON values
+	data, _ := json.Marshal(event.New(
+		event.Category("ssh"),
+		event.Type("password-authentication"),
+		event.Custom("date", time.Unix(1546434245, 0)),
+		event.Custom("source-port", 1234),
+		event.Custom("ssh.username", "root"),
+	))
+
+	values := map[string]interface{}{}
+	json.Unmarshal(data, &values)
+
+	if err := b.Deliver([]event.Event{
+		event.New(event.CopyFrom(values)),
+		event.New(event.Category("http")),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	v := <-logs
+	if len(v) != 1 {
+		t.Fatalf("Expected 1 log, got %v", v)
+	}
+
+	if v[0]["time"] != float64(1546434245) || v[0]["sport"] != float64(1234) || v[0]["username"] != "root" {
+		t.Errorf("Unexpected log %v", v[0])
+	}
+}
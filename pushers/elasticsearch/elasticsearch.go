@@ -15,6 +15,11 @@ package elasticsearch
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
 
 	"time"
 
@@ -38,6 +43,7 @@ var log = logging.MustGetLogger("channels/elasticsearch")
 // push messages to an elasticsearch api.
 type Backend struct {
 	Config
+	pushers.Counting
 
 	es *elastic.Client
 	ch chan map[string]interface{}
@@ -96,12 +102,14 @@ func (hc Backend) run() {
 		if bulk.NumberOfActions() == 0 {
 		} else if response, err := bulk.Do(context.Background()); err != nil {
 			log.Errorf("Error indexing: %s", err.Error())
+			hc.CountFailed()
 		} else {
 			indexed := response.Indexed()
 			count += len(indexed)
 
 			for _, item := range response.Failed() {
 				log.Errorf("Error indexing item: %s with error: %+v", item.Id, *item.Error)
+				hc.CountFailed()
 			}
 
 			log.Debugf("Bulk indexing: %d total %d", len(indexed), count)
@@ -109,6 +117,59 @@ func (hc Backend) run() {
 	}
 }
 
+// Deliver indexes the events synchronously, it is used when the channel is spooled.
+// An error is returned when items failed temporarily, so the events will be
+// redelivered. Items rejected by elasticsearch are dropped, they would fail
+// again. Documents are identified by their content, so redelivering events
+// that already have been indexed won't duplicate them.
+func (hc Backend) Deliver(events []event.Event) error {
+	bulk := hc.es.Bulk()
+
+	for _, e := range events {
+		doc := event.ToMap(e)
+
+		bulk = bulk.Add(elastic.NewBulkIndexRequest().
+			Index(hc.index).
+			Type("event").
+			Id(documentID(doc)).
+			Doc(doc),
+		)
+	}
+
+	response, err := bulk.Do(context.Background())
+	if err != nil {
+		return err
+	}
+
+	retry := 0
+
+	for _, item := range response.Failed() {
+		if item.Status == http.StatusTooManyRequests || item.Status >= 500 {
+			retry++
+			continue
+		}
+
+		log.Errorf("Error indexing item, dropping: %s with error: %+v", item.Id, item.Error)
+	}
+
+	if retry > 0 {
+		return fmt.Errorf("%d of %d items failed to index", retry, len(events))
+	}
+
+	return nil
+}
+
+// documentID returns the id of the document, derived from its content.
+func documentID(doc map[string]interface{}) string {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return uuid.NewV4().String()
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
 // Send delivers the giving push messages into the internal elastic search endpoint.
 func (hc Backend) Send(message event.Event) {
 	mp := make(map[string]interface{})
@@ -122,3 +183,8 @@ func (hc Backend) Send(message event.Event) {
 
 	hc.ch <- mp
 }
+
+// QueueLen returns the number of events waiting in the channel buffer.
+func (hc Backend) QueueLen() int {
+	return len(hc.ch)
+}
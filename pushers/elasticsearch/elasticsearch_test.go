@@ -12,3 +12,118 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 package elasticsearch_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/pushers/elasticsearch"
+	"github.com/honeytrap/honeytrap/pushers/spool"
+)
+
+// newServer returns an elasticsearch server answering bulk requests, failing
+// every item with status.
+func newServer(status int) (*httptest.Server, chan []string) {
+	ids := make(chan []string, 10)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" {
+			fmt.Fprint(w, `{}`)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+
+		items := []string{}
+		received := []string{}
+
+		for _, line := range strings.Split(string(body), "\n") {
+			i := strings.Index(line, `"_id":"`)
+			if i == -1 {
+				continue
+			}
+
+			id := line[i+7:]
+			id = id[:strings.Index(id, `"`)]
+
+			received = append(received, id)
+			items = append(items, fmt.Sprintf(`{"index":{"_index":"honeytrap","_type":"event","_id":"%s","status":%d,"error":{"type":"error","reason":"failed"}}}`, id, status))
+		}
+
+		ids <- received
+
+		fmt.Fprintf(w, `{"took":1,"errors":true,"items":[%s]}`, strings.Join(items, ","))
+	}))
+
+	return ts, ids
+}
+
+func newDeliverer(t *testing.T, url string) spool.Deliverer {
+	s := struct {
+		P toml.Primitive
+	}{}
+
+	md, err := toml.Decode(fmt.Sprintf("[p]\nurl=\"%s/honeytrap\"\n", url), &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := elasticsearch.New(
+		pushers.WithConfig(s.P, &md),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c.(spool.Deliverer)
+}
+
+func TestDeliverFailed(t *testing.T) {
+	ts, ids := newServer(http.StatusServiceUnavailable)
+	defer ts.Close()
+
+	d := newDeliverer(t, ts.URL)
+
+	events := []event.Event{
+		event.New(event.Custom("test", "first")),
+		event.New(event.Custom("test", "second")),
+	}
+
+	if err := d.Deliver(events); err == nil {
+		t.Error("Expected error when items failed to index")
+	}
+
+	first := <-ids
+
+	if err := d.Deliver(events); err == nil {
+		t.Error("Expected error when items failed to index")
+	}
+
+	second := <-ids
+
+	if len(first) != 2 || first[0] == first[1] {
+		t.Fatalf("Expected 2 distinct document ids, got %v", first)
+	}
+
+	if strings.Join(first, ",") != strings.Join(second, ",") {
+		t.Errorf("Expected redelivered documents to keep their ids, got %v and %v", first, second)
+	}
+}
+
+func TestDeliverRejected(t *testing.T) {
+	ts, _ := newServer(http.StatusBadRequest)
+	defer ts.Close()
+
+	d := newDeliverer(t, ts.URL)
+
+	if err := d.Deliver([]event.Event{event.New(event.Custom("test", "first"))}); err != nil {
+		t.Errorf("Expected rejected items to be dropped, got %s", err.Error())
+	}
+}
@@ -87,6 +87,7 @@ type FileConfig struct {
 // also the old file will be renamed with the current timestamp and a new file created.
 type FileBackend struct {
 	FileConfig
+	pushers.Counting
 
 	request chan map[string]interface{}
 }
@@ -142,10 +143,12 @@ func (f *FileBackend) writeLoop() {
 
 		if _, err := io.Copy(dest, &buf); err != nil {
 			log.Errorf("Failed to copy data to File : %+q", err)
+			f.CountFailed()
 		}
 
 		if err := dest.Sync(); err != nil {
 			log.Errorf("Failed to sync Write to File : %+q", err)
+			f.CountFailed()
 		}
 
 		// Reset the buffer for reuse.
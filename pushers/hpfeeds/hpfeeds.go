@@ -0,0 +1,258 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package hpfeeds
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+
+	logging "github.com/op/go-logging"
+)
+
+var (
+	_ = pushers.Register("hpfeeds", New)
+)
+
+var log = logging.MustGetLogger("channels/hpfeeds")
+
+var (
+	ErrAddressNotSet = errors.New("Address has not been set")
+	ErrIdentNotSet   = errors.New("Ident has not been set")
+)
+
+const (
+	defaultReconnectDelay    = time.Second
+	defaultMaxReconnectDelay = time.Minute
+)
+
+type Config struct {
+	Address string `toml:"address"`
+
+	Ident  string `toml:"ident"`
+	Secret string `toml:"secret"`
+
+	// Channel events will be published to, when the category
+	// of the event has not been configured in Channels.
+	Channel string `toml:"channel"`
+
+	// Channels maps event categories to hpfeeds channels.
+	Channels map[string]string `toml:"channels"`
+
+	ReconnectDelay    config.Delay `toml:"reconnect_delay"`
+	MaxReconnectDelay config.Delay `toml:"max_reconnect_delay"`
+}
+
+// Backend publishes events to a hpfeeds broker.
+type Backend struct {
+	Config
+	pushers.Counting
+
+	ch chan event.Event
+}
+
+func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
+	c := Backend{
+		Config: Config{
+			Channel:           "honeytrap.events",
+			ReconnectDelay:    config.Delay(defaultReconnectDelay),
+			MaxReconnectDelay: config.Delay(defaultMaxReconnectDelay),
+		},
+		ch: make(chan event.Event, 100),
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(&c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Address == "" {
+		return nil, ErrAddressNotSet
+	} else if c.Ident == "" {
+		return nil, ErrIdentNotSet
+	}
+
+	go c.run()
+
+	return &c, nil
+}
+
+// channel returns the hpfeeds channel the event will be published to.
+func (b *Backend) channel(e event.Event) string {
+	if channel, ok := b.Channels[e.Get("category")]; ok {
+		return channel
+	}
+
+	return b.Channel
+}
+
+// connect connects and authenticates to the broker.
+func (b *Backend) connect() (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", b.Address, time.Second*10)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(time.Second * 10))
+
+	m, err := ReadMessage(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if m.Opcode == OpError {
+		conn.Close()
+		return nil, fmt.Errorf("Broker returned error: %s", string(m.Payload))
+	} else if m.Opcode != OpInfo {
+		conn.Close()
+		return nil, fmt.Errorf("Expected info message, got opcode %d", m.Opcode)
+	}
+
+	info, err := ParseInfo(m.Payload)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	auth, err := AuthMessage(info.Nonce, b.Ident, b.Secret)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := WriteMessage(conn, auth); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	log.Debugf("Connected to hpfeeds broker %s (%s)", b.Address, info.Name)
+
+	return conn, nil
+}
+
+func (b *Backend) run() {
+	delay := b.ReconnectDelay.Duration()
+
+	// message that couldn't be written, will be retried after reconnecting
+	var pending *Message
+
+	for {
+		func() {
+			conn, err := b.connect()
+			if err != nil {
+				log.Errorf("Error connecting to hpfeeds broker %s: %s", b.Address, err.Error())
+				b.CountFailed()
+				return
+			}
+
+			defer conn.Close()
+
+			// the broker doesn't acknowledge authentication, errors
+			// (like an invalid secret) are sent before closing the connection.
+			go func() {
+				defer conn.Close()
+
+				for {
+					m, err := ReadMessage(conn)
+					if err != nil {
+						return
+					}
+
+					if m.Opcode == OpError {
+						log.Errorf("Broker returned error: %s", string(m.Payload))
+						return
+					}
+				}
+			}()
+
+			for {
+				if pending == nil {
+					e := <-b.ch
+
+					data, err := json.Marshal(e)
+					if err != nil {
+						log.Errorf("Error marshaling event: %s", err.Error())
+						continue
+					}
+
+					// messages that can't be encoded are dropped, they
+					// would fail again after reconnecting
+					m, err := PublishMessage(b.Ident, b.channel(e), data)
+					if err != nil {
+						log.Errorf("Error creating publish message, dropping event: %s", err.Error())
+						b.CountFailed()
+						continue
+					}
+
+					pending = m
+				}
+
+				conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
+
+				if err := WriteMessage(conn, pending); err == ErrMessageTooLarge {
+					log.Errorf("Could not write, dropping message: %s", err.Error())
+					b.CountFailed()
+					pending = nil
+					continue
+				} else if err != nil {
+					log.Errorf("Could not write: %s", err.Error())
+					b.CountFailed()
+					return
+				}
+
+				pending = nil
+
+				// connection is healthy, reset backoff
+				delay = b.ReconnectDelay.Duration()
+			}
+		}()
+
+		log.Infof("Connection lost. Reconnecting in %s.", delay)
+
+		time.Sleep(delay)
+
+		delay *= 2
+		if max := b.MaxReconnectDelay.Duration(); delay > max {
+			delay = max
+		}
+	}
+}
+
+func (b *Backend) Send(e event.Event) {
+	if b.channel(e) == "" {
+		return
+	}
+
+	select {
+	case b.ch <- e:
+	default:
+		log.Errorf("Could not send more messages, channel full")
+		b.CountDropped()
+	}
+}
+
+// QueueLen returns the number of events waiting in the channel buffer.
+func (b *Backend) QueueLen() int {
+	return len(b.ch)
+}
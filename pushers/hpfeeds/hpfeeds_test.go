@@ -0,0 +1,286 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package hpfeeds
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+// broker is a minimal hpfeeds broker, it authenticates clients
+// and forwards their publish messages.
+type broker struct {
+	net.Listener
+
+	secrets map[string]string
+
+	published chan *Publish
+}
+
+func newBroker(t *testing.T, addr string, secrets map[string]string) *broker {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &broker{
+		Listener:  l,
+		secrets:   secrets,
+		published: make(chan *Publish, 10),
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go b.serve(conn)
+		}
+	}()
+
+	return b
+}
+
+func (b *broker) serve(conn net.Conn) {
+	defer conn.Close()
+
+	nonce := []byte{0x01, 0x02, 0x03, 0x04}
+
+	m, _ := InfoMessage("test-broker", nonce)
+	if err := WriteMessage(conn, m); err != nil {
+		return
+	}
+
+	m, err := ReadMessage(conn)
+	if err != nil || m.Opcode != OpAuth {
+		return
+	}
+
+	ident, hash, err := ParseAuth(m.Payload)
+	if err != nil {
+		return
+	}
+
+	if secret, ok := b.secrets[ident]; !ok || !bytes.Equal(hash, Hash(nonce, secret)) {
+		WriteMessage(conn, &Message{Opcode: OpError, Payload: []byte("authfail")})
+		return
+	}
+
+	for {
+		m, err := ReadMessage(conn)
+		if err != nil {
+			return
+		}
+
+		if m.Opcode != OpPublish {
+			continue
+		}
+
+		p, err := ParsePublish(m.Payload)
+		if err != nil {
+			return
+		}
+
+		b.published <- p
+	}
+}
+
+func newBackend(t *testing.T, config string) pushers.Channel {
+	s := struct {
+		P toml.Primitive
+	}{}
+
+	md, err := toml.Decode(config, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New(
+		pushers.WithConfig(s.P, &md),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestHPFeedsPublish(t *testing.T) {
+	b := newBroker(t, "127.0.0.1:0", map[string]string{
+		"honeytrap": "s3cr3t",
+	})
+	defer b.Close()
+
+	c := newBackend(t, fmt.Sprintf(`
+[P]
+address="%s"
+ident="honeytrap"
+secret="s3cr3t"
+channel="honeytrap.events"
+
+[P.channels]
+ssh="honeytrap.ssh"
+`, b.Addr()))
+
+	c.Send(event.New(
+		event.Category("ssh"),
+		event.Type("password-authentication"),
+	))
+
+	c.Send(event.New(
+		event.Category("http"),
+	))
+
+	for _, expected := range []string{"honeytrap.ssh", "honeytrap.events"} {
+		select {
+		case p := <-b.published:
+			if p.Ident != "honeytrap" {
+				t.Errorf("Expected ident honeytrap, got %s", p.Ident)
+			}
+
+			if p.Channel != expected {
+				t.Errorf("Expected channel %s, got %s", expected, p.Channel)
+			}
+
+			v := map[string]interface{}{}
+			if err := json.Unmarshal(p.Payload, &v); err != nil {
+				t.Errorf("Expected json payload: %s", err.Error())
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("Timeout waiting for published event")
+		}
+	}
+}
+
+func TestHPFeedsReconnect(t *testing.T) {
+	b := newBroker(t, "127.0.0.1:0", map[string]string{
+		"honeytrap": "s3cr3t",
+	})
+
+	addr := b.Addr().String()
+	b.Close()
+
+	c := newBackend(t, fmt.Sprintf(`
+[P]
+address="%s"
+ident="honeytrap"
+secret="s3cr3t"
+reconnect_delay="10ms"
+max_reconnect_delay="50ms"
+`, addr))
+
+	c.Send(event.New(
+		event.Category("ssh"),
+	))
+
+	// give the backend some time to fail connecting
+	time.Sleep(time.Millisecond * 30)
+
+	b = newBroker(t, addr, map[string]string{
+		"honeytrap": "s3cr3t",
+	})
+	defer b.Close()
+
+	select {
+	case p := <-b.published:
+		if p.Channel != "honeytrap.events" {
+			t.Errorf("Expected channel honeytrap.events, got %s", p.Channel)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timeout waiting for published event after reconnect")
+	}
+}
+
+func TestHPFeedsDropTooLarge(t *testing.T) {
+	b := newBroker(t, "127.0.0.1:0", map[string]string{
+		"honeytrap": "s3cr3t",
+	})
+	defer b.Close()
+
+	c := newBackend(t, fmt.Sprintf(`
+[P]
+address="%s"
+ident="honeytrap"
+secret="s3cr3t"
+`, b.Addr()))
+
+	c.Send(event.New(
+		event.Category("http"),
+		event.Custom("http.body", strings.Repeat("A", maxMessageSize)),
+	))
+
+	c.Send(event.New(
+		event.Category("ssh"),
+	))
+
+	select {
+	case p := <-b.published:
+		v := map[string]interface{}{}
+		if err := json.Unmarshal(p.Payload, &v); err != nil {
+			t.Fatal(err)
+		}
+
+		if v["category"] != "ssh" {
+			t.Errorf("Expected oversized event to be dropped, got %v", v["category"])
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timeout waiting for published event")
+	}
+}
+
+func TestHPFeedsMessage(t *testing.T) {
+	m, err := PublishMessage("ident", "channel", []byte("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buff := bytes.Buffer{}
+	if err := WriteMessage(&buff, m); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte("\x00\x00\x00\x1a\x03\x05ident\x07channelpayload")
+	if !bytes.Equal(buff.Bytes(), expected) {
+		t.Errorf("Expected %q, got %q", expected, buff.Bytes())
+	}
+
+	m, err = ReadMessage(&buff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := ParsePublish(m.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Ident != "ident" || p.Channel != "channel" || string(p.Payload) != "payload" {
+		t.Errorf("Unexpected publish message %+v", p)
+	}
+
+	if _, err := PublishMessage("ident", "channel", make([]byte, maxMessageSize)); err != ErrMessageTooLarge {
+		t.Errorf("Expected ErrMessageTooLarge, got %v", err)
+	}
+}
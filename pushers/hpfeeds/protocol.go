@@ -0,0 +1,241 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package hpfeeds
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Opcodes of the hpfeeds protocol.
+const (
+	OpError     uint8 = 0
+	OpInfo      uint8 = 1
+	OpAuth      uint8 = 2
+	OpPublish   uint8 = 3
+	OpSubscribe uint8 = 4
+)
+
+const (
+	// headerSize is the size of the message length and opcode
+	headerSize = 5
+
+	// maxMessageSize is the maximum size of a message accepted by brokers
+	maxMessageSize = 1024 * 1024
+)
+
+var (
+	ErrMessageTooLarge = errors.New("hpfeeds message too large")
+	ErrInvalidMessage  = errors.New("invalid hpfeeds message")
+)
+
+// Message is a single hpfeeds message.
+type Message struct {
+	Opcode  uint8
+	Payload []byte
+}
+
+// ReadMessage reads a message from r.
+func ReadMessage(r io.Reader) (*Message, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size < headerSize {
+		return nil, ErrInvalidMessage
+	} else if size > maxMessageSize {
+		return nil, ErrMessageTooLarge
+	}
+
+	payload := make([]byte, size-headerSize)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		Opcode:  header[4],
+		Payload: payload,
+	}, nil
+}
+
+// WriteMessage writes the message to w.
+func WriteMessage(w io.Writer, m *Message) error {
+	size := headerSize + len(m.Payload)
+	if size > maxMessageSize {
+		return ErrMessageTooLarge
+	}
+
+	buff := make([]byte, size)
+	binary.BigEndian.PutUint32(buff[0:4], uint32(size))
+	buff[4] = m.Opcode
+	copy(buff[headerSize:], m.Payload)
+
+	_, err := w.Write(buff)
+	return err
+}
+
+// writeString writes a string prefixed with its one byte length.
+func writeString(buff *bytes.Buffer, s string) error {
+	if len(s) > 255 {
+		return fmt.Errorf("hpfeeds string too long: %s", s)
+	}
+
+	buff.WriteByte(uint8(len(s)))
+	buff.WriteString(s)
+	return nil
+}
+
+// readString reads a string prefixed with its one byte length.
+func readString(r *bytes.Reader) (string, error) {
+	size, err := r.ReadByte()
+	if err != nil {
+		return "", ErrInvalidMessage
+	}
+
+	s := make([]byte, size)
+	if _, err := io.ReadFull(r, s); err != nil {
+		return "", ErrInvalidMessage
+	}
+
+	return string(s), nil
+}
+
+// Info is the message sent by the broker after connecting.
+type Info struct {
+	Name  string
+	Nonce []byte
+}
+
+// ParseInfo parses the payload of an info message.
+func ParseInfo(payload []byte) (*Info, error) {
+	r := bytes.NewReader(payload)
+
+	name, err := readString(r)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, r.Len())
+	r.Read(nonce)
+
+	return &Info{
+		Name:  name,
+		Nonce: nonce,
+	}, nil
+}
+
+// InfoMessage returns the info message of a broker.
+func InfoMessage(name string, nonce []byte) (*Message, error) {
+	buff := bytes.Buffer{}
+	if err := writeString(&buff, name); err != nil {
+		return nil, err
+	}
+
+	buff.Write(nonce)
+
+	return &Message{Opcode: OpInfo, Payload: buff.Bytes()}, nil
+}
+
+// Hash returns the authentication hash of the secret for the nonce.
+func Hash(nonce []byte, secret string) []byte {
+	h := sha1.New()
+	h.Write(nonce)
+	h.Write([]byte(secret))
+	return h.Sum(nil)
+}
+
+// AuthMessage returns the message authenticating ident with secret.
+func AuthMessage(nonce []byte, ident, secret string) (*Message, error) {
+	buff := bytes.Buffer{}
+	if err := writeString(&buff, ident); err != nil {
+		return nil, err
+	}
+
+	buff.Write(Hash(nonce, secret))
+
+	return &Message{Opcode: OpAuth, Payload: buff.Bytes()}, nil
+}
+
+// ParseAuth parses the payload of an auth message.
+func ParseAuth(payload []byte) (string, []byte, error) {
+	r := bytes.NewReader(payload)
+
+	ident, err := readString(r)
+	if err != nil {
+		return "", nil, err
+	}
+
+	hash := make([]byte, r.Len())
+	r.Read(hash)
+
+	return ident, hash, nil
+}
+
+// Publish is a message published to a channel.
+type Publish struct {
+	Ident   string
+	Channel string
+	Payload []byte
+}
+
+// PublishMessage returns the message publishing payload to channel,
+// ErrMessageTooLarge is returned when brokers won't accept the message.
+func PublishMessage(ident, channel string, payload []byte) (*Message, error) {
+	buff := bytes.Buffer{}
+	if err := writeString(&buff, ident); err != nil {
+		return nil, err
+	}
+
+	if err := writeString(&buff, channel); err != nil {
+		return nil, err
+	}
+
+	if headerSize+buff.Len()+len(payload) > maxMessageSize {
+		return nil, ErrMessageTooLarge
+	}
+
+	buff.Write(payload)
+
+	return &Message{Opcode: OpPublish, Payload: buff.Bytes()}, nil
+}
+
+// ParsePublish parses the payload of a publish message.
+func ParsePublish(payload []byte) (*Publish, error) {
+	r := bytes.NewReader(payload)
+
+	ident, err := readString(r)
+	if err != nil {
+		return nil, err
+	}
+
+	channel, err := readString(r)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, r.Len())
+	r.Read(data)
+
+	return &Publish{
+		Ident:   ident,
+		Channel: channel,
+		Payload: data,
+	}, nil
+}
@@ -37,6 +37,10 @@ type Backend struct {
 
 	producer sarama.AsyncProducer
 
+	// syncProducer is used by Deliver, it shares the client and
+	// configuration of the producer
+	syncProducer sarama.SyncProducer
+
 	ch chan map[string]interface{}
 }
 
@@ -54,12 +58,23 @@ func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
 	config := sarama.NewConfig()
 	config.Producer.Return.Successes = true
 
-	producer, err := sarama.NewAsyncProducer(c.Brokers, config)
+	client, err := sarama.NewClient(c.Brokers, config)
+	if err != nil {
+		return nil, err
+	}
+
+	producer, err := sarama.NewAsyncProducerFromClient(client)
 	if err != nil {
 		return nil, err
 	}
 	c.producer = producer
 
+	syncProducer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		return nil, err
+	}
+	c.syncProducer = syncProducer
+
 	go c.run()
 
 	return &c, nil
@@ -83,6 +98,26 @@ func (hc Backend) run() {
 	}
 }
 
+// Deliver produces the events synchronously, it is used when the channel is spooled.
+func (hc Backend) Deliver(events []event.Event) error {
+	messages := []*sarama.ProducerMessage{}
+
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			log.Errorf("Error marshaling event: %s", err.Error())
+			continue
+		}
+
+		messages = append(messages, &sarama.ProducerMessage{
+			Topic: hc.Topic,
+			Value: sarama.ByteEncoder(data),
+		})
+	}
+
+	return hc.syncProducer.SendMessages(messages)
+}
+
 // Send delivers the giving push messages into the internal elastic search endpoint.
 func (hc Backend) Send(message event.Event) {
 	mp := make(map[string]interface{})
@@ -96,3 +131,8 @@ func (hc Backend) Send(message event.Event) {
 
 	hc.ch <- mp
 }
+
+// QueueLen returns the number of events waiting in the channel buffer.
+func (hc Backend) QueueLen() int {
+	return len(hc.ch)
+}
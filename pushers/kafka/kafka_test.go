@@ -78,3 +78,43 @@ func TestChannelsKafkaSend(t *testing.T) {
 	close(kb.ch)
 
 }
+
+func TestChannelsKafkaDeliver(t *testing.T) {
+	seedBroker := sarama.NewMockBroker(t, 1)
+	defer seedBroker.Close()
+
+	leader := sarama.NewMockBroker(t, 2)
+	defer leader.Close()
+
+	metadataResponse := new(sarama.MetadataResponse)
+	metadataResponse.AddBroker(leader.Addr(), leader.BrokerID())
+	metadataResponse.AddTopicPartition("my_topic", 0, leader.BrokerID(), nil, nil, sarama.ErrNoError)
+
+	seedBroker.Returns(metadataResponse)
+
+	prodSuccess := new(sarama.ProduceResponse)
+	prodSuccess.AddTopicPartition("my_topic", 0, sarama.ErrNoError)
+
+	leader.Returns(prodSuccess)
+
+	s := struct {
+		P toml.Primitive
+	}{}
+
+	md, err := toml.Decode(fmt.Sprintf(config, seedBroker.Addr()), &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New(
+		pushers.WithConfig(s.P, &md),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the sync producer shares the client, no metadata is requested again
+	if err := c.(*Backend).Deliver([]event.Event{event.New()}); err != nil {
+		t.Error(err)
+	}
+}
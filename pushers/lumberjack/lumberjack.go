@@ -19,6 +19,8 @@ var log = logging.MustGetLogger("channels/lumberjack")
 
 type Backend struct {
 	Config
+	pushers.Counting
+
 	ch chan event.Event
 }
 
@@ -120,6 +122,7 @@ func (b Backend) run() {
 		}
 	}, bo, func(err error, duration time.Duration) {
 		log.Errorf("Error %s, retrying in %v", err.Error(), duration)
+		b.CountFailed()
 	})
 }
 
@@ -128,5 +131,11 @@ func (b Backend) Send(message event.Event) {
 	case b.ch <- message:
 	default:
 		log.Errorf("Could not send more messages, channel full")
+		b.CountDropped()
 	}
 }
+
+// QueueLen returns the number of events waiting in the channel buffer.
+func (b Backend) QueueLen() int {
+	return len(b.ch)
+}
@@ -38,6 +38,7 @@ var log = logging.MustGetLogger("channels:marija")
 // push messages to an elasticsearch api.
 type Backend struct {
 	Config
+	pushers.Counting
 
 	ch chan map[string]interface{}
 }
@@ -109,11 +110,13 @@ func (hc Backend) run() {
 		resp, err := client.Do(req)
 		if err != nil {
 			log.Errorf("Could not submit event to Marija: %s", err.Error())
+			hc.CountFailed()
 			return
 		}
 
 		if resp.StatusCode != http.StatusOK {
 			log.Errorf("Could not submit event to Marija: %d", resp.StatusCode)
+			hc.CountFailed()
 			return
 		}
 	}
@@ -174,5 +177,11 @@ func (hc Backend) Send(message event.Event) {
 	case hc.ch <- mp:
 	default:
 		log.Errorf("Could not send more messages, channel full")
+		hc.CountDropped()
 	}
 }
+
+// QueueLen returns the number of events waiting in the channel buffer.
+func (hc Backend) QueueLen() int {
+	return len(hc.ch)
+}
@@ -0,0 +1,425 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package mqtt
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+
+	logging "github.com/op/go-logging"
+)
+
+var (
+	_ = pushers.Register("mqtt", New)
+)
+
+var log = logging.MustGetLogger("channels/mqtt")
+
+var (
+	ErrAddressNotSet = errors.New("Address has not been set")
+	ErrAckTimeout    = errors.New("Timeout waiting for acknowledgement")
+)
+
+const (
+	defaultReconnectDelay    = time.Second
+	defaultMaxReconnectDelay = time.Minute
+)
+
+// topicEscaper replaces the separator and wildcards in field values.
+var topicEscaper = strings.NewReplacer("/", "_", "+", "_", "#", "_", "\x00", "")
+
+type Config struct {
+	Address string `toml:"address"`
+
+	// Version is one of 3.1.1 or 5
+	Version string `toml:"version"`
+
+	ClientID string `toml:"client_id"`
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+
+	// Topic is a template, eg. "honeytrap/{sensor}/{category}/{type}"
+	Topic string `toml:"topic"`
+
+	// QoS is either 0 (at most once) or 1 (at least once)
+	QoS    int  `toml:"qos"`
+	Retain bool `toml:"retain"`
+
+	KeepAlive config.Delay `toml:"keep_alive"`
+
+	TLS        bool   `toml:"tls"`
+	Insecure   bool   `toml:"insecure"`
+	ServerName string `toml:"server_name"`
+	CAFile     string `toml:"ca_file"`
+	CertFile   string `toml:"cert_file"`
+	KeyFile    string `toml:"key_file"`
+
+	ReconnectDelay    config.Delay `toml:"reconnect_delay"`
+	MaxReconnectDelay config.Delay `toml:"max_reconnect_delay"`
+}
+
+// Backend publishes events to a MQTT broker.
+type Backend struct {
+	Config
+	pushers.Counting
+
+	version byte
+	topic   *pushers.TopicTemplate
+	tls     *tls.Config
+
+	packetID uint16
+
+	ch chan event.Event
+}
+
+func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
+	c := Backend{
+		Config: Config{
+			Version:           "3.1.1",
+			ClientID:          "honeytrap",
+			Topic:             "honeytrap/{sensor}/{category}/{type}",
+			KeepAlive:         config.Delay(time.Minute),
+			ReconnectDelay:    config.Delay(defaultReconnectDelay),
+			MaxReconnectDelay: config.Delay(defaultMaxReconnectDelay),
+		},
+		ch: make(chan event.Event, 100),
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(&c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Address == "" {
+		return nil, ErrAddressNotSet
+	}
+
+	switch c.Version {
+	case "3.1.1":
+		c.version = Version311
+	case "5":
+		c.version = Version5
+	default:
+		return nil, fmt.Errorf("Unsupported MQTT version %s", c.Version)
+	}
+
+	if c.QoS != 0 && c.QoS != 1 {
+		return nil, fmt.Errorf("Unsupported QoS %d", c.QoS)
+	}
+
+	topic, err := pushers.ParseTopicTemplate(c.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	c.topic = topic
+
+	if !c.TLS {
+	} else if tc, err := c.tlsConfig(); err != nil {
+		return nil, err
+	} else {
+		c.tls = tc
+	}
+
+	go c.run()
+
+	return &c, nil
+}
+
+func (b *Backend) tlsConfig() (*tls.Config, error) {
+	tc := &tls.Config{
+		InsecureSkipVerify: b.Insecure,
+		ServerName:         b.ServerName,
+	}
+
+	if b.CAFile != "" {
+		data, err := ioutil.ReadFile(b.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("No certificates found in %s", b.CAFile)
+		}
+	}
+
+	if b.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(b.CertFile, b.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tc.Certificates = []tls.Certificate{cert}
+	}
+
+	return tc, nil
+}
+
+func (b *Backend) nextPacketID() uint16 {
+	b.packetID++
+	if b.packetID == 0 {
+		b.packetID++
+	}
+
+	return b.packetID
+}
+
+// connect connects to the broker, and waits for the connection
+// to be acknowledged.
+func (b *Backend) connect() (net.Conn, *bufio.Reader, *Connack, error) {
+	dialer := &net.Dialer{Timeout: time.Second * 10}
+
+	var conn net.Conn
+	var err error
+
+	if b.tls != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", b.Address, b.tls)
+	} else {
+		conn, err = dialer.Dial("tcp", b.Address)
+	}
+
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(time.Second * 10))
+
+	if err := WritePacket(conn, ConnectPacket(Connect{
+		Version:   b.version,
+		ClientID:  b.ClientID,
+		Username:  b.Username,
+		Password:  b.Password,
+		KeepAlive: uint16(b.KeepAlive.Duration().Seconds()),
+	})); err != nil {
+		conn.Close()
+		return nil, nil, nil, err
+	}
+
+	r := bufio.NewReader(conn)
+
+	p, err := ReadPacket(r)
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, err
+	} else if p.Type != TypeConnack {
+		conn.Close()
+		return nil, nil, nil, fmt.Errorf("Expected connack packet, got type %d", p.Type)
+	}
+
+	connack, err := ParseConnack(b.version, p.Body)
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	log.Debugf("Connected to MQTT broker %s", b.Address)
+
+	return conn, r, connack, nil
+}
+
+func (b *Backend) run() {
+	delay := b.ReconnectDelay.Duration()
+
+	// publish that hasn't been acknowledged, will be retried after reconnecting
+	var pending *Publish
+
+	for {
+		func() {
+			conn, r, connack, err := b.connect()
+			if err != nil {
+				log.Errorf("Error connecting to MQTT broker %s: %s", b.Address, err.Error())
+				b.CountFailed()
+				return
+			}
+
+			defer conn.Close()
+
+			acks := make(chan uint16)
+			closed := make(chan struct{})
+
+			done := make(chan struct{})
+			defer close(done)
+
+			go func() {
+				defer close(closed)
+
+				for {
+					p, err := ReadPacket(r)
+					if err != nil {
+						return
+					}
+
+					switch p.Type {
+					case TypePuback:
+						id, code, err := ParsePuback(p.Body)
+						if err != nil {
+							log.Errorf("Error parsing puback: %s", err.Error())
+							return
+						} else if code >= 0x80 {
+							log.Errorf("Broker refused publish %d: reason code 0x%02x", id, code)
+						}
+
+						select {
+						case acks <- id:
+						case <-done:
+							return
+						}
+					case TypeDisconnect:
+						log.Errorf("Broker disconnected")
+						return
+					}
+				}
+			}()
+
+			// a keep alive of zero disables the keep alive mechanism
+			var keepAlive <-chan time.Time
+			if d := b.KeepAlive.Duration(); d > 0 {
+				ticker := time.NewTicker(d / 2)
+				defer ticker.Stop()
+
+				keepAlive = ticker.C
+			}
+
+			for {
+				if pending == nil {
+					select {
+					case e := <-b.ch:
+						data, err := json.Marshal(e)
+						if err != nil {
+							log.Errorf("Error marshaling event: %s", err.Error())
+							continue
+						}
+
+						pending = &Publish{
+							Topic:   b.topic.Execute(e, topicEscaper.Replace),
+							QoS:     byte(b.QoS),
+							Retain:  b.Retain,
+							Payload: data,
+						}
+
+						if pending.QoS > 0 {
+							pending.PacketID = b.nextPacketID()
+						}
+					case <-keepAlive:
+						conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
+
+						if err := WritePacket(conn, &Packet{Type: TypePingreq}); err != nil {
+							log.Errorf("Could not write: %s", err.Error())
+							return
+						}
+
+						continue
+					case <-closed:
+						return
+					}
+				}
+
+				packet := PublishPacket(b.version, *pending)
+
+				// the broker would disconnect, the publish would fail again
+				// after reconnecting
+				if max := connack.MaximumPacketSize; max > 0 && packet.Size() > int(max) {
+					log.Errorf("Dropping publish of %d bytes, the broker accepts %d bytes", packet.Size(), max)
+					b.CountFailed()
+					pending = nil
+					continue
+				}
+
+				conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
+
+				if err := WritePacket(conn, packet); err == ErrPacketTooLarge {
+					log.Errorf("Could not write, dropping publish: %s", err.Error())
+					b.CountFailed()
+					pending = nil
+					continue
+				} else if err != nil {
+					log.Errorf("Could not write: %s", err.Error())
+					b.CountFailed()
+					return
+				}
+
+				if pending.QoS > 0 {
+					if err := b.waitAck(acks, closed, pending.PacketID); err != nil {
+						log.Errorf("Error publishing: %s", err.Error())
+						b.CountFailed()
+
+						pending.Dup = true
+						return
+					}
+				}
+
+				pending = nil
+
+				// connection is healthy, reset backoff
+				delay = b.ReconnectDelay.Duration()
+			}
+		}()
+
+		log.Infof("Connection lost. Reconnecting in %s.", delay)
+
+		time.Sleep(delay)
+
+		delay *= 2
+		if max := b.MaxReconnectDelay.Duration(); delay > max {
+			delay = max
+		}
+	}
+}
+
+func (b *Backend) waitAck(acks <-chan uint16, closed <-chan struct{}, id uint16) error {
+	timeout := time.After(time.Second * 10)
+
+	for {
+		select {
+		case ack := <-acks:
+			if ack == id {
+				return nil
+			}
+		case <-closed:
+			return fmt.Errorf("Connection closed")
+		case <-timeout:
+			return ErrAckTimeout
+		}
+	}
+}
+
+func (b *Backend) Send(e event.Event) {
+	select {
+	case b.ch <- e:
+	default:
+		log.Errorf("Could not send more messages, channel full")
+		b.CountDropped()
+	}
+}
+
+// QueueLen returns the number of events waiting in the channel buffer.
+func (b *Backend) QueueLen() int {
+	return len(b.ch)
+}
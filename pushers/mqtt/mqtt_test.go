@@ -0,0 +1,417 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+// broker is a minimal MQTT broker, it authenticates clients
+// and acknowledges their publish packets.
+type broker struct {
+	net.Listener
+
+	username string
+	password string
+
+	// maxPacketSize is accessed atomically
+	maxPacketSize uint32
+
+	connects  chan *Connect
+	published chan *Publish
+}
+
+func newBroker(t *testing.T, tc *tls.Config, username, password string) *broker {
+	var l net.Listener
+	var err error
+
+	if tc != nil {
+		l, err = tls.Listen("tcp", "127.0.0.1:0", tc)
+	} else {
+		l, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &broker{
+		Listener:  l,
+		username:  username,
+		password:  password,
+		connects:  make(chan *Connect, 10),
+		published: make(chan *Publish, 10),
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go b.serve(conn)
+		}
+	}()
+
+	return b
+}
+
+func (b *broker) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+
+	p, err := ReadPacket(r)
+	if err != nil || p.Type != TypeConnect {
+		return
+	}
+
+	c, err := ParseConnect(p.Body)
+	if err != nil {
+		return
+	}
+
+	b.connects <- c
+
+	if c.Username != b.username || c.Password != b.password {
+		code := byte(4)
+		if c.Version == Version5 {
+			code = 0x86
+		}
+
+		WritePacket(conn, ConnackPacket(c.Version, Connack{Code: code}))
+		return
+	}
+
+	if err := WritePacket(conn, ConnackPacket(c.Version, Connack{MaximumPacketSize: atomic.LoadUint32(&b.maxPacketSize)})); err != nil {
+		return
+	}
+
+	for {
+		p, err := ReadPacket(r)
+		if err != nil {
+			return
+		}
+
+		switch p.Type {
+		case TypePingreq:
+			WritePacket(conn, &Packet{Type: TypePingresp})
+		case TypePublish:
+			pub, err := ParsePublish(c.Version, p)
+			if err != nil {
+				return
+			}
+
+			if pub.QoS > 0 {
+				WritePacket(conn, PubackPacket(pub.PacketID))
+			}
+
+			b.published <- pub
+		}
+	}
+}
+
+func newBackend(t *testing.T, config string) pushers.Channel {
+	s := struct {
+		P toml.Primitive
+	}{}
+
+	md, err := toml.Decode(config, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New(
+		pushers.WithConfig(s.P, &md),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func (b *broker) expectPublish(t *testing.T) *Publish {
+	select {
+	case p := <-b.published:
+		return p
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timeout waiting for publish")
+	}
+
+	return nil
+}
+
+func TestMQTTPublish(t *testing.T) {
+	b := newBroker(t, nil, "", "")
+	defer b.Close()
+
+	c := newBackend(t, fmt.Sprintf(`
+[P]
+address="%s"
+topic="{sensor}/{category}/{type}"
+`, b.Addr().String()))
+
+	c.Send(event.New(
+		event.Sensor("services"),
+		event.Category("ssh"),
+		event.Type("password-authentication"),
+		event.Custom("ssh.username", "root"),
+	))
+
+	p := b.expectPublish(t)
+
+	if p.Topic != "services/ssh/password-authentication" {
+		t.Errorf("Expected topic services/ssh/password-authentication, got %s", p.Topic)
+	}
+
+	if p.QoS != 0 {
+		t.Errorf("Expected QoS 0, got %d", p.QoS)
+	}
+
+	values := map[string]interface{}{}
+	if err := json.Unmarshal(p.Payload, &values); err != nil {
+		t.Fatal(err)
+	} else if values["ssh.username"] != "root" {
+		t.Errorf("Expected ssh.username root, got %v", values["ssh.username"])
+	}
+
+	if c := <-b.connects; c.Version != Version311 {
+		t.Errorf("Expected protocol level 4, got %d", c.Version)
+	}
+}
+
+func TestMQTTPublishV5QoS1(t *testing.T) {
+	b := newBroker(t, nil, "honeytrap", "s3cr3t")
+	defer b.Close()
+
+	c := newBackend(t, fmt.Sprintf(`
+[P]
+address="%s"
+version="5"
+qos=1
+username="honeytrap"
+password="s3cr3t"
+topic="honeytrap/{category}/{destination-port}"
+`, b.Addr().String()))
+
+	for i := 0; i < 2; i++ {
+		c.Send(event.New(
+			event.Category("http/proxy"),
+			event.Custom("destination-port", 8080),
+		))
+	}
+
+	for i := 1; i <= 2; i++ {
+		p := b.expectPublish(t)
+
+		if p.Topic != "honeytrap/http_proxy/8080" {
+			t.Errorf("Expected topic honeytrap/http_proxy/8080, got %s", p.Topic)
+		}
+
+		if p.QoS != 1 {
+			t.Errorf("Expected QoS 1, got %d", p.QoS)
+		}
+
+		if p.PacketID != uint16(i) {
+			t.Errorf("Expected packet id %d, got %d", i, p.PacketID)
+		}
+	}
+
+	if c := <-b.connects; c.Version != Version5 || c.Username != "honeytrap" {
+		t.Errorf("Expected MQTT 5 connect of honeytrap, got %+v", c)
+	}
+}
+
+func TestMQTTConnectRefused(t *testing.T) {
+	b := newBroker(t, nil, "honeytrap", "s3cr3t")
+	defer b.Close()
+
+	for _, version := range []byte{Version311, Version5} {
+		backend := &Backend{
+			Config: Config{
+				Address:  b.Addr().String(),
+				Username: "honeytrap",
+				Password: "wrong",
+			},
+			version: version,
+		}
+
+		if _, _, _, err := backend.connect(); err == nil {
+			t.Errorf("Expected connection with version %d to be refused", version)
+		}
+	}
+}
+
+func TestMQTTMaximumPacketSize(t *testing.T) {
+	b := newBroker(t, nil, "", "")
+	defer b.Close()
+
+	atomic.StoreUint32(&b.maxPacketSize, 1024)
+
+	c := newBackend(t, fmt.Sprintf(`
+[P]
+address="%s"
+version="5"
+`, b.Addr().String()))
+
+	c.Send(event.New(
+		event.Category("http"),
+		event.Custom("http.body", strings.Repeat("A", 2048)),
+	))
+
+	c.Send(event.New(
+		event.Category("ssh"),
+	))
+
+	if p := b.expectPublish(t); p.Topic != "honeytrap/unknown/ssh/unknown" {
+		t.Errorf("Expected oversized publish to be dropped, got topic %s", p.Topic)
+	}
+
+	if n := len(b.connects); n != 1 {
+		t.Errorf("Expected backend to stay connected, got %d connects", n)
+	}
+}
+
+func TestMQTTTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqtt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	cert, certPEM := selfSignedCert(t)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	b := newBroker(t, &tls.Config{Certificates: []tls.Certificate{cert}}, "", "")
+	defer b.Close()
+
+	c := newBackend(t, fmt.Sprintf(`
+[P]
+address="%s"
+tls=true
+ca_file="%s"
+server_name="localhost"
+`, b.Addr().String(), caFile))
+
+	c.Send(event.New(
+		event.Category("telnet"),
+	))
+
+	if p := b.expectPublish(t); p.Topic != "honeytrap/unknown/telnet/unknown" {
+		t.Errorf("Expected topic honeytrap/unknown/telnet/unknown, got %s", p.Topic)
+	}
+}
+
+func TestMQTTPacket(t *testing.T) {
+	buff := bytes.Buffer{}
+
+	if err := WritePacket(&buff, ConnectPacket(Connect{
+		Version:   Version311,
+		ClientID:  "ht",
+		Username:  "u",
+		KeepAlive: 60,
+	})); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte("\x10\x11\x00\x04MQTT\x04\x82\x00\x3c\x00\x02ht\x00\x01u")
+	if !bytes.Equal(buff.Bytes(), expected) {
+		t.Errorf("Expected %q, got %q", expected, buff.Bytes())
+	}
+
+	connack, err := ParseConnack(Version5, ConnackPacket(Version5, Connack{MaximumPacketSize: 1024}).Body)
+	if err != nil {
+		t.Fatal(err)
+	} else if connack.MaximumPacketSize != 1024 {
+		t.Errorf("Expected maximum packet size 1024, got %d", connack.MaximumPacketSize)
+	}
+
+	// reason string and user property preceding the maximum packet size
+	body := []byte("\x00\x00\x11\x1f\x00\x02ok\x26\x00\x01k\x00\x01v\x27\x00\x00\x10\x00")
+	if connack, err := ParseConnack(Version5, body); err != nil {
+		t.Fatal(err)
+	} else if connack.MaximumPacketSize != 4096 {
+		t.Errorf("Expected maximum packet size 4096, got %d", connack.MaximumPacketSize)
+	}
+
+	for _, n := range []int{0, 127, 128, 16383, 16384, maxRemainingLength} {
+		v, err := readVarint(bytes.NewReader(appendVarint(nil, n)))
+		if err != nil {
+			t.Fatal(err)
+		} else if v != n {
+			t.Errorf("Expected %d, got %d", n, v)
+		}
+	}
+}
+
+func selfSignedCert(t *testing.T) (tls.Certificate, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
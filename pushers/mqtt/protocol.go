@@ -0,0 +1,515 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Packet types
+const (
+	TypeConnect    = 1
+	TypeConnack    = 2
+	TypePublish    = 3
+	TypePuback     = 4
+	TypePingreq    = 12
+	TypePingresp   = 13
+	TypeDisconnect = 14
+)
+
+// Protocol levels
+const (
+	Version311 = 4
+	Version5   = 5
+)
+
+const maxRemainingLength = 268435455
+
+// PropertyMaximumPacketSize is the MQTT 5 property of the maximum packet
+// size the server or client accepts.
+const PropertyMaximumPacketSize = 0x27
+
+// Types of MQTT 5 property values
+const (
+	propertyByte = iota + 1
+	propertyUint16
+	propertyUint32
+	propertyVarint
+	propertyString
+	propertyStringPair
+)
+
+// propertyTypes maps the MQTT 5 properties to the type of their value,
+// binary data is encoded like a string.
+var propertyTypes = map[byte]int{
+	0x01: propertyByte,
+	0x02: propertyUint32,
+	0x03: propertyString,
+	0x08: propertyString,
+	0x09: propertyString,
+	0x0B: propertyVarint,
+	0x11: propertyUint32,
+	0x12: propertyString,
+	0x13: propertyUint16,
+	0x15: propertyString,
+	0x16: propertyString,
+	0x17: propertyByte,
+	0x18: propertyUint32,
+	0x19: propertyByte,
+	0x1A: propertyString,
+	0x1C: propertyString,
+	0x1F: propertyString,
+	0x21: propertyUint16,
+	0x22: propertyUint16,
+	0x23: propertyUint16,
+	0x24: propertyByte,
+	0x25: propertyByte,
+	0x26: propertyStringPair,
+	0x27: propertyUint32,
+	0x28: propertyByte,
+	0x29: propertyByte,
+	0x2A: propertyByte,
+}
+
+var (
+	ErrMalformedPacket = errors.New("Malformed packet")
+	ErrPacketTooLarge  = errors.New("Packet too large")
+)
+
+var connackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// Packet is a MQTT control packet.
+type Packet struct {
+	Type  byte
+	Flags byte
+	Body  []byte
+}
+
+// Size returns the size of the packet including its fixed header.
+func (p *Packet) Size() int {
+	return 1 + len(appendVarint(nil, len(p.Body))) + len(p.Body)
+}
+
+func appendVarint(b []byte, n int) []byte {
+	for {
+		v := byte(n % 128)
+		n /= 128
+
+		if n > 0 {
+			v |= 0x80
+		}
+
+		b = append(b, v)
+
+		if n == 0 {
+			return b
+		}
+	}
+}
+
+func readVarint(r io.ByteReader) (int, error) {
+	n, multiplier := 0, 1
+
+	for i := 0; i < 4; i++ {
+		v, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		n += int(v&0x7f) * multiplier
+		if v&0x80 == 0 {
+			return n, nil
+		}
+
+		multiplier *= 128
+	}
+
+	return 0, ErrMalformedPacket
+}
+
+func appendString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
+
+// reader reads the fields of a packet body.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) ReadByte() (byte, error) {
+	if len(r.b) < 1 {
+		r.err = ErrMalformedPacket
+		return 0, r.err
+	}
+
+	v := r.b[0]
+	r.b = r.b[1:]
+	return v, nil
+}
+
+func (r *reader) uint16() uint16 {
+	if len(r.b) < 2 {
+		r.err = ErrMalformedPacket
+		return 0
+	}
+
+	v := binary.BigEndian.Uint16(r.b)
+	r.b = r.b[2:]
+	return v
+}
+
+func (r *reader) uint32() uint32 {
+	if len(r.b) < 4 {
+		r.err = ErrMalformedPacket
+		return 0
+	}
+
+	v := binary.BigEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+func (r *reader) string() string {
+	n := int(r.uint16())
+	if len(r.b) < n {
+		r.err = ErrMalformedPacket
+		return ""
+	}
+
+	v := string(r.b[:n])
+	r.b = r.b[n:]
+	return v
+}
+
+// skipProperties skips the MQTT 5 properties.
+func (r *reader) skipProperties() {
+	n, err := readVarint(r)
+	if err != nil {
+		r.err = ErrMalformedPacket
+	} else if len(r.b) < n {
+		r.err = ErrMalformedPacket
+	} else {
+		r.b = r.b[n:]
+	}
+}
+
+// properties reads the MQTT 5 properties, it returns the values of the
+// integer properties.
+func (r *reader) properties() map[byte]uint32 {
+	n, err := readVarint(r)
+	if err != nil || len(r.b) < n {
+		r.err = ErrMalformedPacket
+		return nil
+	}
+
+	pr := &reader{b: r.b[:n]}
+	r.b = r.b[n:]
+
+	values := map[byte]uint32{}
+
+	for len(pr.b) > 0 && pr.err == nil {
+		id, _ := pr.ReadByte()
+
+		switch propertyTypes[id] {
+		case propertyByte:
+			v, _ := pr.ReadByte()
+			values[id] = uint32(v)
+		case propertyUint16:
+			values[id] = uint32(pr.uint16())
+		case propertyUint32:
+			values[id] = pr.uint32()
+		case propertyVarint:
+			v, _ := readVarint(pr)
+			values[id] = uint32(v)
+		case propertyString:
+			pr.string()
+		case propertyStringPair:
+			pr.string()
+			pr.string()
+		default:
+			pr.err = ErrMalformedPacket
+		}
+	}
+
+	if pr.err != nil {
+		r.err = pr.err
+	}
+
+	return values
+}
+
+// ReadPacket reads a control packet.
+func ReadPacket(r *bufio.Reader) (*Packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := readVarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Packet{
+		Type:  header >> 4,
+		Flags: header & 0x0f,
+		Body:  make([]byte, size),
+	}
+
+	if _, err := io.ReadFull(r, p.Body); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// WritePacket writes the control packet.
+func WritePacket(w io.Writer, p *Packet) error {
+	if len(p.Body) > maxRemainingLength {
+		return ErrPacketTooLarge
+	}
+
+	b := make([]byte, 0, len(p.Body)+5)
+	b = append(b, p.Type<<4|p.Flags&0x0f)
+	b = appendVarint(b, len(p.Body))
+	b = append(b, p.Body...)
+
+	_, err := w.Write(b)
+	return err
+}
+
+// Connect contains the fields of a connect packet.
+type Connect struct {
+	Version   byte
+	ClientID  string
+	Username  string
+	Password  string
+	KeepAlive uint16
+}
+
+// ConnectPacket returns the connect packet, the session will be clean.
+func ConnectPacket(c Connect) *Packet {
+	flags := byte(0x02)
+	if c.Username != "" {
+		flags |= 0x80
+	}
+
+	if c.Password != "" {
+		flags |= 0x40
+	}
+
+	b := appendString(nil, "MQTT")
+	b = append(b, c.Version, flags, byte(c.KeepAlive>>8), byte(c.KeepAlive))
+
+	if c.Version == Version5 {
+		b = appendVarint(b, 0)
+	}
+
+	b = appendString(b, c.ClientID)
+
+	if c.Username != "" {
+		b = appendString(b, c.Username)
+	}
+
+	if c.Password != "" {
+		b = appendString(b, c.Password)
+	}
+
+	return &Packet{Type: TypeConnect, Body: b}
+}
+
+// ParseConnect parses the body of a connect packet.
+func ParseConnect(body []byte) (*Connect, error) {
+	r := &reader{b: body}
+
+	if name := r.string(); r.err == nil && name != "MQTT" {
+		return nil, fmt.Errorf("Unsupported protocol %s", name)
+	}
+
+	c := &Connect{}
+
+	c.Version, _ = r.ReadByte()
+	flags, _ := r.ReadByte()
+	c.KeepAlive = r.uint16()
+
+	if c.Version == Version5 {
+		r.skipProperties()
+	}
+
+	c.ClientID = r.string()
+
+	if flags&0x80 != 0 {
+		c.Username = r.string()
+	}
+
+	if flags&0x40 != 0 {
+		c.Password = r.string()
+	}
+
+	return c, r.err
+}
+
+// Connack contains the fields of a connack packet.
+type Connack struct {
+	// Code is the return (or reason) code
+	Code byte
+
+	// MaximumPacketSize is the size of the largest packet the server
+	// accepts, zero when the server didn't announce a maximum (MQTT 5 only).
+	MaximumPacketSize uint32
+}
+
+// ConnackPacket returns the connack packet.
+func ConnackPacket(version byte, c Connack) *Packet {
+	b := []byte{0x00, c.Code}
+	if version != Version5 {
+	} else if c.MaximumPacketSize == 0 {
+		b = appendVarint(b, 0)
+	} else {
+		b = appendVarint(b, 5)
+		b = append(b, PropertyMaximumPacketSize, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(b[len(b)-4:], c.MaximumPacketSize)
+	}
+
+	return &Packet{Type: TypeConnack, Body: b}
+}
+
+// ParseConnack parses the body of a connack packet, an error will be
+// returned when the connection has been refused.
+func ParseConnack(version byte, body []byte) (*Connack, error) {
+	r := &reader{b: body}
+
+	r.ReadByte()
+
+	c := &Connack{}
+	c.Code, _ = r.ReadByte()
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if c.Code == 0 && version == Version5 {
+		properties := r.properties()
+		if r.err != nil {
+			return nil, r.err
+		}
+
+		c.MaximumPacketSize = properties[PropertyMaximumPacketSize]
+	}
+
+	if c.Code == 0 {
+		return c, nil
+	} else if version == Version5 {
+		return nil, fmt.Errorf("Connection refused: reason code 0x%02x", c.Code)
+	} else if reason, ok := connackErrors[c.Code]; ok {
+		return nil, fmt.Errorf("Connection refused: %s", reason)
+	}
+
+	return nil, fmt.Errorf("Connection refused: return code %d", c.Code)
+}
+
+// Publish contains the fields of a publish packet.
+type Publish struct {
+	Topic    string
+	QoS      byte
+	Retain   bool
+	Dup      bool
+	PacketID uint16
+	Payload  []byte
+}
+
+// PublishPacket returns the publish packet.
+func PublishPacket(version byte, p Publish) *Packet {
+	flags := p.QoS << 1
+	if p.Retain {
+		flags |= 0x01
+	}
+
+	if p.Dup {
+		flags |= 0x08
+	}
+
+	b := appendString(nil, p.Topic)
+
+	if p.QoS > 0 {
+		b = append(b, byte(p.PacketID>>8), byte(p.PacketID))
+	}
+
+	if version == Version5 {
+		b = appendVarint(b, 0)
+	}
+
+	b = append(b, p.Payload...)
+
+	return &Packet{Type: TypePublish, Flags: flags, Body: b}
+}
+
+// ParsePublish parses a publish packet.
+func ParsePublish(version byte, p *Packet) (*Publish, error) {
+	r := &reader{b: p.Body}
+
+	pub := &Publish{
+		QoS:    (p.Flags >> 1) & 0x03,
+		Retain: p.Flags&0x01 != 0,
+		Dup:    p.Flags&0x08 != 0,
+	}
+
+	pub.Topic = r.string()
+
+	if pub.QoS > 0 {
+		pub.PacketID = r.uint16()
+	}
+
+	if version == Version5 {
+		r.skipProperties()
+	}
+
+	pub.Payload = r.b
+
+	return pub, r.err
+}
+
+// PubackPacket returns the puback packet for the packet identifier.
+func PubackPacket(id uint16) *Packet {
+	return &Packet{Type: TypePuback, Body: []byte{byte(id >> 8), byte(id)}}
+}
+
+// ParsePuback parses the body of a puback packet, it returns the packet
+// identifier and the reason code (always 0 for MQTT 3.1.1).
+func ParsePuback(body []byte) (uint16, byte, error) {
+	r := &reader{b: body}
+
+	id := r.uint16()
+
+	// reason code and properties may be omitted when the
+	// publish succeeded without properties
+	code := byte(0)
+	if len(r.b) > 0 {
+		code, _ = r.ReadByte()
+	}
+
+	return id, code, r.err
+}
@@ -0,0 +1,486 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package nats
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/cmd"
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+
+	logging "github.com/op/go-logging"
+)
+
+var (
+	_ = pushers.Register("nats", New)
+)
+
+var log = logging.MustGetLogger("channels/nats")
+
+var (
+	ErrAddressNotSet = errors.New("Address has not been set")
+	ErrAckTimeout    = errors.New("Timeout waiting for acknowledgement")
+)
+
+const (
+	defaultReconnectDelay    = time.Second
+	defaultMaxReconnectDelay = time.Minute
+	defaultAckTimeout        = time.Second * 5
+)
+
+// subjectEscaper replaces the separator, wildcards and whitespace in field values.
+var subjectEscaper = strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_", "\t", "_", "\r", "_", "\n", "_")
+
+type Config struct {
+	Address string `toml:"address"`
+	Name    string `toml:"name"`
+
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+	Token    string `toml:"token"`
+
+	// Subject is a template, eg. "honeytrap.{sensor}.{category}.{type}"
+	Subject string `toml:"subject"`
+
+	// JetStream waits for events to be acknowledged by a stream
+	JetStream  bool         `toml:"jetstream"`
+	AckTimeout config.Delay `toml:"ack_timeout"`
+
+	TLS        bool   `toml:"tls"`
+	Insecure   bool   `toml:"insecure"`
+	ServerName string `toml:"server_name"`
+	CAFile     string `toml:"ca_file"`
+	CertFile   string `toml:"cert_file"`
+	KeyFile    string `toml:"key_file"`
+
+	ReconnectDelay    config.Delay `toml:"reconnect_delay"`
+	MaxReconnectDelay config.Delay `toml:"max_reconnect_delay"`
+}
+
+// Backend publishes events to a NATS server.
+type Backend struct {
+	Config
+	pushers.Counting
+
+	subject *pushers.TopicTemplate
+	tls     *tls.Config
+
+	inbox string
+	seq   uint64
+
+	ch chan event.Event
+}
+
+func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
+	c := Backend{
+		Config: Config{
+			Name:              "honeytrap",
+			Subject:           "honeytrap.{sensor}.{category}.{type}",
+			AckTimeout:        config.Delay(defaultAckTimeout),
+			ReconnectDelay:    config.Delay(defaultReconnectDelay),
+			MaxReconnectDelay: config.Delay(defaultMaxReconnectDelay),
+		},
+		ch: make(chan event.Event, 100),
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(&c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Address == "" {
+		return nil, ErrAddressNotSet
+	}
+
+	subject, err := pushers.ParseTopicTemplate(c.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	c.subject = subject
+
+	if !c.TLS {
+	} else if tc, err := c.tlsConfig(); err != nil {
+		return nil, err
+	} else {
+		c.tls = tc
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	c.inbox = "_INBOX." + hex.EncodeToString(id)
+
+	go c.run()
+
+	return &c, nil
+}
+
+func (b *Backend) tlsConfig() (*tls.Config, error) {
+	tc := &tls.Config{
+		InsecureSkipVerify: b.Insecure,
+		ServerName:         b.ServerName,
+	}
+
+	if b.CAFile != "" {
+		data, err := ioutil.ReadFile(b.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("No certificates found in %s", b.CAFile)
+		}
+	}
+
+	if b.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(b.CertFile, b.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tc.Certificates = []tls.Certificate{cert}
+	}
+
+	if tc.ServerName == "" {
+		if host, _, err := net.SplitHostPort(b.Address); err == nil {
+			tc.ServerName = host
+		}
+	}
+
+	return tc, nil
+}
+
+// conn is a connection to the server, writes are serialized
+// as the reader responds to pings.
+type conn struct {
+	net.Conn
+
+	r *bufio.Reader
+	m sync.Mutex
+
+	// maxPayload is the size of the largest payload the server accepts
+	maxPayload int64
+}
+
+func (c *conn) WriteOp(op *Op) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.SetWriteDeadline(time.Now().Add(time.Second * 10))
+	return WriteOp(c.Conn, op)
+}
+
+// connect connects to the server and waits for the
+// connection to be accepted.
+func (b *Backend) connect() (*conn, error) {
+	nc, err := net.DialTimeout("tcp", b.Address, time.Second*10)
+	if err != nil {
+		return nil, err
+	}
+
+	nc.SetDeadline(time.Now().Add(time.Second * 10))
+
+	r := bufio.NewReaderSize(nc, maxLineLength)
+
+	op, err := ReadOp(r)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	} else if op.Name != "INFO" {
+		nc.Close()
+		return nil, fmt.Errorf("Expected INFO, got %s", op.Name)
+	}
+
+	info := Info{}
+	if err := ParseArg(op, &info); err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	if b.tls != nil {
+		tc := tls.Client(nc, b.tls)
+		if err := tc.Handshake(); err != nil {
+			nc.Close()
+			return nil, err
+		}
+
+		nc = tc
+		r = bufio.NewReaderSize(nc, maxLineLength)
+	} else if info.TLSRequired {
+		nc.Close()
+		return nil, fmt.Errorf("Server requires tls")
+	}
+
+	if b.JetStream && !info.JetStream {
+		log.Warningf("Server %s didn't announce JetStream support", b.Address)
+	}
+
+	c := &conn{
+		Conn:       nc,
+		r:          r,
+		maxPayload: info.MaxPayload,
+	}
+
+	connect, err := ConnectOp(Connect{
+		TLSRequired: b.tls != nil,
+		Name:        b.Name,
+		Lang:        "go",
+		Version:     cmd.Version,
+		Protocol:    1,
+		User:        b.Username,
+		Pass:        b.Password,
+		AuthToken:   b.Token,
+	})
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	ops := []*Op{connect}
+
+	if b.JetStream {
+		ops = append(ops, &Op{Name: "SUB", Args: []string{b.inbox + ".*", "1"}})
+	}
+
+	ops = append(ops, &Op{Name: "PING"})
+
+	for _, op := range ops {
+		if err := c.WriteOp(op); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+
+	// errors (like authorization violations) are returned before the pong
+	for {
+		op, err := ReadOp(r)
+		if err != nil {
+			nc.Close()
+			return nil, err
+		}
+
+		if op.Name == "PONG" {
+			break
+		} else if op.Name == "-ERR" {
+			nc.Close()
+			return nil, fmt.Errorf("Server returned error: %s", strings.Join(op.Args, " "))
+		}
+	}
+
+	nc.SetDeadline(time.Time{})
+
+	log.Debugf("Connected to NATS server %s (%s)", b.Address, info.ServerID)
+
+	return c, nil
+}
+
+func (b *Backend) run() {
+	delay := b.ReconnectDelay.Duration()
+
+	// message that hasn't been published, will be retried after reconnecting
+	var pending *Op
+
+	for {
+		func() {
+			c, err := b.connect()
+			if err != nil {
+				log.Errorf("Error connecting to NATS server %s: %s", b.Address, err.Error())
+				b.CountFailed()
+				return
+			}
+
+			defer c.Close()
+
+			acks := make(chan *Op, 16)
+			closed := make(chan struct{})
+
+			go func() {
+				defer close(closed)
+
+				for {
+					op, err := ReadOp(c.r)
+					if err != nil {
+						return
+					}
+
+					switch op.Name {
+					case "PING":
+						if err := c.WriteOp(&Op{Name: "PONG"}); err != nil {
+							return
+						}
+					case "MSG":
+						// acks arriving late aren't waited for, the
+						// oldest ack is dropped so the reader doesn't
+						// block and keeps answering pings
+						select {
+						case acks <- op:
+						default:
+							select {
+							case <-acks:
+							default:
+							}
+
+							acks <- op
+						}
+					case "-ERR":
+						log.Errorf("Server returned error: %s", strings.Join(op.Args, " "))
+						return
+					}
+				}
+			}()
+
+			for {
+				if pending == nil {
+					select {
+					case e := <-b.ch:
+						data, err := json.Marshal(e)
+						if err != nil {
+							log.Errorf("Error marshaling event: %s", err.Error())
+							continue
+						}
+
+						pending = &Op{
+							Name:    "PUB",
+							Args:    []string{b.subject.Execute(e, subjectEscaper.Replace)},
+							Payload: data,
+						}
+
+						if b.JetStream {
+							b.seq++
+							pending.Args = append(pending.Args, b.inbox+"."+strconv.FormatUint(b.seq, 10))
+						}
+					case <-closed:
+						return
+					}
+				}
+
+				// the server would close the connection, the message would
+				// fail again after reconnecting
+				if c.maxPayload > 0 && int64(len(pending.Payload)) > c.maxPayload {
+					log.Errorf("Dropping message of %d bytes, the server accepts %d bytes", len(pending.Payload), c.maxPayload)
+					b.CountFailed()
+					pending = nil
+					continue
+				}
+
+				if err := c.WriteOp(pending); err != nil {
+					log.Errorf("Could not write: %s", err.Error())
+					b.CountFailed()
+					return
+				}
+
+				if !b.JetStream {
+				} else if err := b.waitAck(acks, closed, pending.Args[1]); err == nil {
+				} else if _, ok := err.(*StreamError); ok {
+					// the stream refused the event, retrying won't help
+					log.Errorf("Error publishing to JetStream: %s", err.Error())
+					b.CountFailed()
+				} else {
+					log.Errorf("Error publishing to JetStream: %s", err.Error())
+					b.CountFailed()
+					return
+				}
+
+				pending = nil
+
+				// connection is healthy, reset backoff
+				delay = b.ReconnectDelay.Duration()
+			}
+		}()
+
+		log.Infof("Connection lost. Reconnecting in %s.", delay)
+
+		time.Sleep(delay)
+
+		delay *= 2
+		if max := b.MaxReconnectDelay.Duration(); delay > max {
+			delay = max
+		}
+	}
+}
+
+// StreamError is returned when the stream refused the message.
+type StreamError struct {
+	Code        int
+	Description string
+}
+
+func (se *StreamError) Error() string {
+	return fmt.Sprintf("%s (%d)", se.Description, se.Code)
+}
+
+// waitAck waits for the stream to acknowledge the message published
+// with the reply subject.
+func (b *Backend) waitAck(acks <-chan *Op, closed <-chan struct{}, reply string) error {
+	timeout := time.After(b.AckTimeout.Duration())
+
+	for {
+		select {
+		case op := <-acks:
+			if len(op.Args) == 0 || op.Args[0] != reply {
+				continue
+			}
+
+			ack := PubAck{}
+			if err := json.Unmarshal(op.Payload, &ack); err != nil {
+				return err
+			} else if ack.Error != nil {
+				return &StreamError{
+					Code:        ack.Error.Code,
+					Description: ack.Error.Description,
+				}
+			}
+
+			return nil
+		case <-closed:
+			return fmt.Errorf("Connection closed")
+		case <-timeout:
+			return ErrAckTimeout
+		}
+	}
+}
+
+func (b *Backend) Send(e event.Event) {
+	select {
+	case b.ch <- e:
+	default:
+		log.Errorf("Could not send more messages, channel full")
+		b.CountDropped()
+	}
+}
+
+// QueueLen returns the number of events waiting in the channel buffer.
+func (b *Backend) QueueLen() int {
+	return len(b.ch)
+}
@@ -0,0 +1,368 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package nats
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+// server is a minimal NATS server, it authenticates clients and
+// acknowledges messages published with a reply subject like a
+// JetStream stream would.
+type server struct {
+	net.Listener
+
+	token string
+
+	// subjects the stream refuses
+	refuse map[string]bool
+
+	// maxPayload is accessed atomically
+	maxPayload int64
+
+	published chan *Op
+}
+
+func newServer(t *testing.T, token string) *server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &server{
+		Listener:   l,
+		token:      token,
+		refuse:     map[string]bool{},
+		maxPayload: 1048576,
+		published:  make(chan *Op, 10),
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	info, _ := InfoOp(Info{
+		ServerID:     "test",
+		AuthRequired: s.token != "",
+		MaxPayload:   atomic.LoadInt64(&s.maxPayload),
+		JetStream:    true,
+	})
+
+	if err := WriteOp(conn, info); err != nil {
+		return
+	}
+
+	r := bufio.NewReader(conn)
+
+	op, err := ReadOp(r)
+	if err != nil || op.Name != "CONNECT" {
+		return
+	}
+
+	c := Connect{}
+	if err := ParseArg(op, &c); err != nil {
+		return
+	}
+
+	if c.AuthToken != s.token {
+		WriteOp(conn, &Op{Name: "-ERR", Args: []string{"'Authorization Violation'"}})
+		return
+	}
+
+	seq := 0
+
+	for {
+		op, err := ReadOp(r)
+		if err != nil {
+			return
+		}
+
+		switch op.Name {
+		case "PING":
+			WriteOp(conn, &Op{Name: "PONG"})
+		case "PUB":
+			if len(op.Args) == 2 {
+				ack := PubAck{Stream: "EVENTS"}
+
+				if s.refuse[op.Args[0]] {
+					ack.Error = &struct {
+						Code        int    `json:"code"`
+						Description string `json:"description"`
+					}{Code: 400, Description: "refused"}
+				} else {
+					seq++
+					ack.Sequence = uint64(seq)
+				}
+
+				data, _ := json.Marshal(ack)
+				WriteOp(conn, &Op{Name: "MSG", Args: []string{op.Args[1], "1"}, Payload: data})
+
+				if ack.Error != nil {
+					continue
+				}
+			}
+
+			s.published <- op
+		}
+	}
+}
+
+func (s *server) expectPublish(t *testing.T) *Op {
+	select {
+	case op := <-s.published:
+		return op
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timeout waiting for publish")
+	}
+
+	return nil
+}
+
+func newBackend(t *testing.T, config string) pushers.Channel {
+	s := struct {
+		P toml.Primitive
+	}{}
+
+	md, err := toml.Decode(config, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New(
+		pushers.WithConfig(s.P, &md),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestNATSPublish(t *testing.T) {
+	s := newServer(t, "s3cr3t")
+	defer s.Close()
+
+	c := newBackend(t, fmt.Sprintf(`
+[P]
+address="%s"
+token="s3cr3t"
+subject="honeytrap.{sensor}.{category}.{type}"
+`, s.Addr().String()))
+
+	c.Send(event.New(
+		event.Sensor("services"),
+		event.Category("dns"),
+		event.Type("query.a"),
+		event.Custom("dns.name", "example.org"),
+	))
+
+	op := s.expectPublish(t)
+
+	if len(op.Args) != 1 {
+		t.Errorf("Expected no reply subject, got %v", op.Args)
+	} else if op.Args[0] != "honeytrap.services.dns.query_a" {
+		t.Errorf("Expected subject honeytrap.services.dns.query_a, got %s", op.Args[0])
+	}
+
+	values := map[string]interface{}{}
+	if err := json.Unmarshal(op.Payload, &values); err != nil {
+		t.Fatal(err)
+	} else if values["dns.name"] != "example.org" {
+		t.Errorf("Expected dns.name example.org, got %v", values["dns.name"])
+	}
+}
+
+func TestNATSJetStream(t *testing.T) {
+	s := newServer(t, "")
+	defer s.Close()
+
+	s.refuse["honeytrap.refused"] = true
+
+	c := newBackend(t, fmt.Sprintf(`
+[P]
+address="%s"
+subject="honeytrap.{category}"
+jetstream=true
+`, s.Addr().String()))
+
+	c.Send(event.New(event.Category("refused")))
+	c.Send(event.New(event.Category("ssh")))
+
+	// the refused event has been dropped
+	op := s.expectPublish(t)
+
+	if op.Args[0] != "honeytrap.ssh" {
+		t.Errorf("Expected subject honeytrap.ssh, got %s", op.Args[0])
+	}
+
+	if len(op.Args) != 2 {
+		t.Fatalf("Expected reply subject, got %v", op.Args)
+	}
+}
+
+func TestNATSLateAcks(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	newBackend(t, fmt.Sprintf(`
+[P]
+address="%s"
+jetstream=true
+`, l.Addr().String()))
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	info, _ := InfoOp(Info{ServerID: "test", JetStream: true})
+	if err := WriteOp(conn, info); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bufio.NewReader(conn)
+
+	for {
+		op, err := ReadOp(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if op.Name == "PING" {
+			break
+		}
+	}
+
+	if err := WriteOp(conn, &Op{Name: "PONG"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// acks nobody waits for don't keep the client from answering pings
+	for i := 0; i < 32; i++ {
+		WriteOp(conn, &Op{Name: "MSG", Args: []string{"_INBOX.stale", "1"}, Payload: []byte("{}")})
+	}
+
+	if err := WriteOp(conn, &Op{Name: "PING"}); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+
+	for {
+		op, err := ReadOp(r)
+		if err != nil {
+			t.Fatalf("Expected PONG, got %v", err)
+		}
+
+		if op.Name == "PONG" {
+			break
+		}
+	}
+}
+
+func TestNATSMaxPayload(t *testing.T) {
+	s := newServer(t, "")
+	defer s.Close()
+
+	atomic.StoreInt64(&s.maxPayload, 1024)
+
+	c := newBackend(t, fmt.Sprintf(`
+[P]
+address="%s"
+subject="honeytrap.{category}"
+`, s.Addr().String()))
+
+	c.Send(event.New(
+		event.Category("http"),
+		event.Custom("http.body", strings.Repeat("A", 2048)),
+	))
+	c.Send(event.New(event.Category("ssh")))
+
+	// the oversized event has been dropped
+	if op := s.expectPublish(t); op.Args[0] != "honeytrap.ssh" {
+		t.Errorf("Expected subject honeytrap.ssh, got %s", op.Args[0])
+	}
+}
+
+func TestNATSConnectRefused(t *testing.T) {
+	s := newServer(t, "s3cr3t")
+	defer s.Close()
+
+	b := &Backend{
+		Config: Config{
+			Address: s.Addr().String(),
+			Token:   "wrong",
+		},
+	}
+
+	if _, err := b.connect(); err == nil {
+		t.Error("Expected connection to be refused")
+	}
+}
+
+func TestNATSOp(t *testing.T) {
+	buff := bytes.Buffer{}
+
+	if err := WriteOp(&buff, &Op{
+		Name:    "PUB",
+		Args:    []string{"honeytrap.events", "_INBOX.1"},
+		Payload: []byte("hello"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "PUB honeytrap.events _INBOX.1 5\r\nhello\r\n"; buff.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buff.String())
+	}
+
+	op, err := ReadOp(bufio.NewReader(&buff))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if op.Name != "PUB" || len(op.Args) != 2 || string(op.Payload) != "hello" {
+		t.Errorf("Expected PUB message, got %+v", op)
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package nats
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const maxLineLength = 4096
+
+var (
+	ErrLineTooLong = errors.New("Protocol line too long")
+	ErrMalformedOp = errors.New("Malformed protocol message")
+)
+
+// Op is a protocol message, the arguments of INFO, CONNECT and -ERR are
+// kept as a single argument. The size of PUB and MSG payloads is not part
+// of the arguments.
+type Op struct {
+	Name    string
+	Args    []string
+	Payload []byte
+}
+
+func hasPayload(name string) bool {
+	return name == "PUB" || name == "MSG"
+}
+
+// ReadOp reads a protocol message.
+func ReadOp(r *bufio.Reader) (*Op, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, ErrLineTooLong
+	} else if err != nil {
+		return nil, err
+	}
+
+	s := strings.TrimRight(string(line), "\r\n")
+
+	op := &Op{}
+
+	if i := strings.IndexAny(s, " \t"); i == -1 {
+		op.Name = strings.ToUpper(s)
+	} else {
+		op.Name = strings.ToUpper(s[:i])
+
+		switch rest := strings.TrimSpace(s[i+1:]); op.Name {
+		case "INFO", "CONNECT", "-ERR":
+			op.Args = []string{rest}
+		default:
+			op.Args = strings.Fields(rest)
+		}
+	}
+
+	if !hasPayload(op.Name) {
+		return op, nil
+	}
+
+	if len(op.Args) < 2 {
+		return nil, ErrMalformedOp
+	}
+
+	size, err := strconv.Atoi(op.Args[len(op.Args)-1])
+	if err != nil || size < 0 {
+		return nil, ErrMalformedOp
+	}
+
+	op.Args = op.Args[:len(op.Args)-1]
+
+	// payload is followed by \r\n
+	op.Payload = make([]byte, size+2)
+	if _, err := io.ReadFull(r, op.Payload); err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasSuffix(op.Payload, []byte("\r\n")) {
+		return nil, ErrMalformedOp
+	}
+
+	op.Payload = op.Payload[:size]
+	return op, nil
+}
+
+// WriteOp writes the protocol message.
+func WriteOp(w io.Writer, op *Op) error {
+	b := bytes.Buffer{}
+	b.WriteString(op.Name)
+
+	for _, arg := range op.Args {
+		b.WriteByte(' ')
+		b.WriteString(arg)
+	}
+
+	if hasPayload(op.Name) {
+		fmt.Fprintf(&b, " %d\r\n", len(op.Payload))
+		b.Write(op.Payload)
+	}
+
+	b.WriteString("\r\n")
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// Info is sent by the server after connecting.
+type Info struct {
+	ServerID     string `json:"server_id"`
+	Version      string `json:"version"`
+	AuthRequired bool   `json:"auth_required,omitempty"`
+	TLSRequired  bool   `json:"tls_required,omitempty"`
+	MaxPayload   int64  `json:"max_payload"`
+	JetStream    bool   `json:"jetstream,omitempty"`
+}
+
+// Connect contains the options of the client.
+type Connect struct {
+	Verbose     bool   `json:"verbose"`
+	Pedantic    bool   `json:"pedantic"`
+	TLSRequired bool   `json:"tls_required"`
+	Name        string `json:"name,omitempty"`
+	Lang        string `json:"lang"`
+	Version     string `json:"version"`
+	Protocol    int    `json:"protocol"`
+	User        string `json:"user,omitempty"`
+	Pass        string `json:"pass,omitempty"`
+	AuthToken   string `json:"auth_token,omitempty"`
+}
+
+// InfoOp returns the INFO message.
+func InfoOp(info Info) (*Op, error) {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Op{Name: "INFO", Args: []string{string(data)}}, nil
+}
+
+// ConnectOp returns the CONNECT message.
+func ConnectOp(c Connect) (*Op, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Op{Name: "CONNECT", Args: []string{string(data)}}, nil
+}
+
+// ParseArg unmarshals the JSON argument of INFO and CONNECT messages.
+func ParseArg(op *Op, v interface{}) error {
+	if len(op.Args) != 1 {
+		return ErrMalformedOp
+	}
+
+	return json.Unmarshal([]byte(op.Args[0]), v)
+}
+
+// PubAck is the response of JetStream to a publish.
+type PubAck struct {
+	Stream    string `json:"stream"`
+	Sequence  uint64 `json:"seq"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+
+	Error *struct {
+		Code        int    `json:"code"`
+		Description string `json:"description"`
+	} `json:"error,omitempty"`
+}
@@ -0,0 +1,40 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// NormalizeFunc defines a function which returns the normalized event.
+type NormalizeFunc func(event.Event) event.Event
+
+type normalizeChannel struct {
+	Channel
+
+	NormalizeFn NormalizeFunc
+}
+
+// Send delivers the normalized event to the channel.
+func (mc normalizeChannel) Send(e event.Event) {
+	mc.Channel.Send(mc.NormalizeFn(e))
+}
+
+// NormalizeChannel returns a Channel which normalizes events before delivering them.
+func NormalizeChannel(channel Channel, fn NormalizeFunc) Channel {
+	return normalizeChannel{
+		Channel:     channel,
+		NormalizeFn: fn,
+	}
+}
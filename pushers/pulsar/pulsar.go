@@ -65,6 +65,7 @@ type producerAckMessage struct {
 
 type producer struct {
 	Config
+	pushers.Counting
 
 	ch        chan Message
 	ws        *websocket.Conn
@@ -125,6 +126,7 @@ func (p *producer) run() {
 			ws, _, err := d.Dial(p.URL, headers)
 			if err != nil {
 				log.Errorf("Error connecting to Pulsar: %s: %s", p.URL, err.Error())
+				p.CountFailed()
 				return
 			}
 
@@ -144,6 +146,7 @@ func (p *producer) run() {
 						break
 					} else if ack.Result != "ok" {
 						log.Errorf("Error ack result: %s", *ack.ErrorMsg)
+						p.CountFailed()
 					}
 				}
 			}(ws)
@@ -159,6 +162,7 @@ func (p *producer) run() {
 						Key:        &m.Key,
 					}); err != nil {
 						log.Errorf("Error writing message: %s", err.Error())
+						p.CountFailed()
 						continue
 					}
 				}
@@ -192,3 +196,8 @@ func (p *producer) Send(e event.Event) {
 		Data: msg,
 	}
 }
+
+// QueueLen returns the number of events waiting in the channel buffer.
+func (p *producer) QueueLen() int {
+	return len(p.ch)
+}
@@ -84,6 +84,7 @@ func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
 
 type AMQPObject struct {
 	AMQPConfig
+	pushers.Counting
 	io.Writer
 	ch          chan map[string]interface{}
 	amqpChannel *amqp.Channel
@@ -116,6 +117,12 @@ func (b *AMQPObject) Send(e event.Event) {
 	)
 	if err != nil {
 		log.Errorf("Failed to send event: %s", err.Error())
+		b.CountFailed()
 		return
 	}
 }
+
+// QueueLen returns the number of events waiting in the channel buffer.
+func (b *AMQPObject) QueueLen() int {
+	return len(b.ch)
+}
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"net/http"
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -39,15 +40,26 @@ var log = logging.MustGetLogger("channels/raven")
 // push messages to an elasticsearch api.
 type Backend struct {
 	Config
+	pushers.Counting
+
+	// conn is the connection used by Deliver
+	conn *syncConn
 
 	ch chan event.Event
 }
 
+// syncConn is the connection used to deliver spooled events.
+type syncConn struct {
+	m sync.Mutex
+	c *websocket.Conn
+}
+
 func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
 	ch := make(chan event.Event, 100)
 
 	c := Backend{
-		ch: ch,
+		conn: &syncConn{},
+		ch:   ch,
 	}
 
 	for _, optionFn := range options {
@@ -64,7 +76,7 @@ func Insecure(config *tls.Config) *tls.Config {
 	return config
 }
 
-func (hc Backend) run() {
+func (hc Backend) dial() (*websocket.Conn, error) {
 	tlsClientConfig := &tls.Config{}
 
 	if hc.Insecure {
@@ -76,15 +88,21 @@ func (hc Backend) run() {
 		TLSClientConfig: tlsClientConfig,
 	}
 
+	headers := http.Header{}
+	headers.Set("User-Agent", fmt.Sprintf("Honeytrap/%s (%s; %s) %s", cmd.Version, runtime.GOOS, runtime.GOARCH, cmd.ShortCommitID))
+	headers.Set("Authorization", fmt.Sprintf("Bearer %s", hc.Token))
+
+	c, _, err := d.Dial(hc.Server, headers)
+	return c, err
+}
+
+func (hc Backend) run() {
 	for {
 		func() {
-			headers := http.Header{}
-			headers.Set("User-Agent", fmt.Sprintf("Honeytrap/%s (%s; %s) %s", cmd.Version, runtime.GOOS, runtime.GOARCH, cmd.ShortCommitID))
-			headers.Set("Authorization", fmt.Sprintf("Bearer %s", hc.Token))
-
-			c, _, err := d.Dial(hc.Server, headers)
+			c, err := hc.dial()
 			if err != nil {
 				log.Errorf("Error connecting to Raven server: %s: %s", hc.Server, err.Error())
+				hc.CountFailed()
 				return
 			}
 
@@ -134,6 +152,7 @@ func (hc Backend) run() {
 						err = c.WriteMessage(websocket.BinaryMessage, data)
 						if err != nil {
 							log.Errorf("Could not write: %s", err.Error())
+							hc.CountFailed()
 							return
 						}
 					}
@@ -147,11 +166,63 @@ func (hc Backend) run() {
 	}
 }
 
+// Deliver writes the events synchronously, it is used when the channel is
+// spooled. The connection is reused until writing fails.
+func (hc Backend) Deliver(events []event.Event) error {
+	hc.conn.m.Lock()
+	defer hc.conn.m.Unlock()
+
+	if hc.conn.c == nil {
+		c, err := hc.dial()
+		if err != nil {
+			return err
+		}
+
+		// control messages are handled by reading, messages of the
+		// server are ignored
+		go func() {
+			for {
+				if _, _, err := c.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		hc.conn.c = c
+	}
+
+	for _, evt := range events {
+		if evt.Get("category") == "heartbeat" {
+			continue
+		}
+
+		data, err := json.Marshal(evt)
+		if err != nil {
+			log.Errorf("Error occurred while marshalling: %s", err.Error())
+			continue
+		}
+
+		if err := hc.conn.c.WriteMessage(websocket.BinaryMessage, data); err != nil {
+			hc.conn.c.Close()
+			hc.conn.c = nil
+			return err
+		}
+	}
+
+	return nil
+}
+
 // Send delivers the giving push messages into the internal elastic search endpoint.
 func (hc Backend) Send(message event.Event) {
 	select {
 	case hc.ch <- message:
 	default:
 		log.Errorf("Could not send more messages, channel full")
+		hc.CountDropped()
 	}
 }
+
+// QueueLen returns the number of events waiting in the channel buffer.
+func (hc Backend) QueueLen() int {
+	return len(hc.ch)
+}
@@ -13,10 +13,79 @@
 // limitations under the License.
 package raven
 
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/honeytrap/honeytrap/event"
+)
+
 const config = `
 [P]
 `
 
+func TestDeliver(t *testing.T) {
+	messages := make(chan map[string]interface{}, 10)
+
+	upgrader := websocket.Upgrader{}
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("Unexpected authorization %s", r.Header.Get("Authorization"))
+		}
+
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		defer c.Close()
+
+		for {
+			_, data, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			values := map[string]interface{}{}
+			json.Unmarshal(data, &values)
+
+			messages <- values
+		}
+	}))
+	defer s.Close()
+
+	b := Backend{
+		Config: Config{
+			Token:  "token",
+			Server: "ws" + strings.TrimPrefix(s.URL, "http"),
+		},
+		conn: &syncConn{},
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := b.Deliver([]event.Event{
+			event.New(event.Category("heartbeat")),
+			event.New(event.Category("ssh")),
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if v := <-messages; v["category"] != "ssh" {
+			t.Errorf("Expected the ssh event, got %v", v)
+		}
+	}
+
+	if len(messages) != 0 {
+		t.Errorf("Expected heartbeats to be ignored, got %d messages", len(messages))
+	}
+}
+
 /*
 func TestChannelsRavenSend(t *testing.T) {
 	seedBroker := sarama.NewMockBroker(t, 1)
@@ -0,0 +1,110 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package s3
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client uploads objects to a S3-compatible endpoint.
+type Client struct {
+	Endpoint *url.URL
+	Bucket   string
+	Region   string
+
+	// PathStyle addresses the bucket in the path instead of the hostname
+	PathStyle bool
+
+	Credentials Credentials
+
+	client *http.Client
+}
+
+// NewClient returns a client for the bucket on the endpoint.
+func NewClient(endpoint, bucket, region string, creds Credentials, pathStyle, insecure bool) (*Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("Unsupported endpoint scheme %s", u.Scheme)
+	}
+
+	return &Client{
+		Endpoint:    u,
+		Bucket:      bucket,
+		Region:      region,
+		PathStyle:   pathStyle,
+		Credentials: creds,
+		client: &http.Client{
+			Timeout: time.Minute * 5,
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: insecure,
+				},
+			},
+		},
+	}, nil
+}
+
+// objectURL returns the url of the object.
+func (c *Client) objectURL(key string) *url.URL {
+	u := *c.Endpoint
+
+	if c.PathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + c.Bucket + "/" + key
+	} else {
+		u.Host = c.Bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	}
+
+	return &u
+}
+
+// PutObject uploads the object.
+func (c *Client) PutObject(key string, data []byte, contentType string) error {
+	req, err := http.NewRequest(http.MethodPut, c.objectURL(key).String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+
+	Sign(req, sha256Hex(data), c.Credentials, c.Region, time.Now())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("Error uploading %s: %s: %s", key, resp.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,453 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package s3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/storage"
+	"github.com/rs/xid"
+
+	logging "github.com/op/go-logging"
+)
+
+var (
+	_ = pushers.Register("s3", New)
+)
+
+var log = logging.MustGetLogger("channels/s3")
+
+var (
+	ErrEndpointNotSet = errors.New("Endpoint has not been set")
+	ErrBucketNotSet   = errors.New("Bucket has not been set")
+)
+
+const (
+	defaultBatchSize     = 1000
+	defaultBatchInterval = time.Minute * 5
+	defaultRetryDelay    = time.Second
+	defaultMaxRetryDelay = time.Minute * 5
+
+	// suffix of staged objects that haven't been completely written
+	tmpExt = ".tmp"
+)
+
+var unsafeChars = regexp.MustCompile(`[^a-zA-Z0-9_.\-]`)
+
+type Config struct {
+	Endpoint string `toml:"endpoint"`
+	Region   string `toml:"region"`
+	Bucket   string `toml:"bucket"`
+
+	AccessKey    string `toml:"access_key"`
+	SecretKey    string `toml:"secret_key"`
+	SessionToken string `toml:"session_token"`
+
+	// PathStyle addresses the bucket in the path, required by most
+	// S3-compatible servers like MinIO
+	PathStyle bool `toml:"path_style"`
+	Insecure  bool `toml:"insecure"`
+
+	// Prefix is prepended to the keys of all objects
+	Prefix string `toml:"prefix"`
+
+	BatchSize     int          `toml:"batch_size"`
+	BatchInterval config.Delay `toml:"batch_interval"`
+
+	// Artifacts uploads captured files (fields ending with .file) and
+	// pcaps (fields ending with .pcap) as separate objects, the events
+	// will reference the objects.
+	Artifacts bool `toml:"artifacts"`
+
+	// StagingDir contains the objects waiting to be uploaded, defaults
+	// to s3-staging in the data directory
+	StagingDir string `toml:"staging_dir"`
+
+	RetryDelay    config.Delay `toml:"retry_delay"`
+	MaxRetryDelay config.Delay `toml:"max_retry_delay"`
+}
+
+// Backend archives events and artifacts to a S3-compatible bucket.
+type Backend struct {
+	Config
+	pushers.Counting
+
+	client *Client
+
+	// hostname identifies the instance when events don't carry a token
+	hostname string
+
+	ch     chan event.Event
+	notify chan struct{}
+}
+
+// batch contains the compressed events of a partition.
+type batch struct {
+	// instance that generated the events
+	instance string
+
+	buff  bytes.Buffer
+	w     *gzip.Writer
+	count int
+}
+
+func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
+	c := Backend{
+		Config: Config{
+			Region:        "us-east-1",
+			BatchSize:     defaultBatchSize,
+			BatchInterval: config.Delay(defaultBatchInterval),
+			RetryDelay:    config.Delay(defaultRetryDelay),
+			MaxRetryDelay: config.Delay(defaultMaxRetryDelay),
+		},
+		ch:     make(chan event.Event, 100),
+		notify: make(chan struct{}, 1),
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(&c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Endpoint == "" {
+		return nil, ErrEndpointNotSet
+	} else if c.Bucket == "" {
+		return nil, ErrBucketNotSet
+	}
+
+	if c.BatchSize <= 0 {
+		return nil, fmt.Errorf("Invalid batch size %d", c.BatchSize)
+	}
+
+	client, err := NewClient(c.Endpoint, c.Bucket, c.Region, Credentials{
+		AccessKey:    c.AccessKey,
+		SecretKey:    c.SecretKey,
+		SessionToken: c.SessionToken,
+	}, c.PathStyle, c.Insecure)
+	if err != nil {
+		return nil, err
+	}
+
+	c.client = client
+
+	if c.StagingDir == "" {
+		c.StagingDir = filepath.Join(storage.DataDir(), "s3-staging")
+	} else if filepath.IsAbs(c.StagingDir) {
+	} else if pwd, err := os.Getwd(); err == nil {
+		c.StagingDir = filepath.Join(pwd, c.StagingDir)
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		c.hostname = hostname
+	} else {
+		c.hostname = "unknown"
+	}
+
+	if err := os.MkdirAll(c.StagingDir, 0700); err != nil {
+		return nil, err
+	}
+
+	go c.run()
+	go c.upload()
+
+	return &c, nil
+}
+
+// partition returns the partition of the event, eg. "dt=2019-01-01/hour=13/sensor=services".
+func partition(values map[string]interface{}) string {
+	t, ok := values["date"].(time.Time)
+	if !ok {
+		t = time.Now()
+	}
+
+	t = t.UTC()
+
+	sensor, _ := values["sensor"].(string)
+	if sensor == "" {
+		sensor = "unknown"
+	}
+
+	return fmt.Sprintf("dt=%s/hour=%02d/sensor=%s", t.Format("2006-01-02"), t.Hour(), unsafeChars.ReplaceAllString(sensor, "_"))
+}
+
+// instance returns the token of the honeytrap instance that generated the
+// event, or the hostname when the event has no token.
+func (b *Backend) instance(values map[string]interface{}) string {
+	token, _ := values["token"].(string)
+	if token == "" {
+		token = b.hostname
+	}
+
+	return unsafeChars.ReplaceAllString(token, "_")
+}
+
+func contentType(key string) string {
+	switch {
+	case strings.HasSuffix(key, ".ndjson.gz"):
+		return "application/gzip"
+	case strings.HasSuffix(key, ".pcap"):
+		return "application/vnd.tcpdump.pcap"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// stage writes the object to the staging directory, it will be uploaded
+// by the uploader.
+func (b *Backend) stage(key string, data []byte) error {
+	p := filepath.Join(b.StagingDir, filepath.FromSlash(key))
+
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(p+tmpExt, data, 0600); err != nil {
+		return err
+	}
+
+	if err := os.Rename(p+tmpExt, p); err != nil {
+		return err
+	}
+
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// artifacts stages the captured files and pcaps of the event, and
+// replaces them by references to the objects.
+func (b *Backend) artifacts(values map[string]interface{}, part string) {
+	for key, value := range values {
+		data, ok := value.([]byte)
+		if !ok {
+			continue
+		}
+
+		hash := sha256Hex(data)
+
+		var objectKey string
+
+		switch {
+		case strings.HasSuffix(key, ".file"):
+			objectKey = fmt.Sprintf("%sartifacts/%s/%s", b.Prefix, hash[:2], hash)
+		case strings.HasSuffix(key, ".pcap"):
+			objectKey = fmt.Sprintf("%spcaps/%s/%s.pcap", b.Prefix, part, hash)
+		default:
+			continue
+		}
+
+		if err := b.stage(objectKey, data); err != nil {
+			log.Errorf("Error staging artifact %s: %s", objectKey, err.Error())
+			continue
+		}
+
+		delete(values, key)
+
+		values[key+".sha256"] = hash
+		values[key+".object"] = fmt.Sprintf("s3://%s/%s", b.Bucket, objectKey)
+	}
+}
+
+func (b *Backend) flush(part string, bt *batch) {
+	if err := bt.w.Close(); err != nil {
+		log.Errorf("Error compressing events: %s", err.Error())
+		return
+	}
+
+	// instances can share a bucket and restart within a second, the
+	// instance and a unique id keep them from overwriting objects
+	key := fmt.Sprintf("%sevents/%s/%s-%s-%s.ndjson.gz", b.Prefix, part, time.Now().UTC().Format("20060102T150405Z"), bt.instance, xid.New().String())
+
+	if err := b.stage(key, bt.buff.Bytes()); err != nil {
+		log.Errorf("Error staging %d events: %s", bt.count, err.Error())
+		b.CountFailed()
+	}
+}
+
+func (b *Backend) run() {
+	batches := map[string]*batch{}
+
+	ticker := time.NewTicker(b.BatchInterval.Duration())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e := <-b.ch:
+			values := event.ToMap(e)
+
+			part := partition(values)
+
+			if b.Artifacts {
+				b.artifacts(values, part)
+			}
+
+			data, err := json.Marshal(values)
+			if err != nil {
+				log.Errorf("Error marshaling event: %s", err.Error())
+				continue
+			}
+
+			bt, ok := batches[part]
+			if !ok {
+				bt = &batch{
+					instance: b.instance(values),
+				}
+				bt.w = gzip.NewWriter(&bt.buff)
+				batches[part] = bt
+			}
+
+			bt.w.Write(data)
+			bt.w.Write([]byte("\n"))
+			bt.count++
+
+			if bt.count < b.BatchSize {
+				continue
+			}
+
+			b.flush(part, bt)
+			delete(batches, part)
+		case <-ticker.C:
+			for part, bt := range batches {
+				b.flush(part, bt)
+			}
+
+			batches = map[string]*batch{}
+		}
+	}
+}
+
+// staged returns the keys of the staged objects, oldest first.
+func (b *Backend) staged() ([]string, error) {
+	keys := []string{}
+
+	err := filepath.Walk(b.StagingDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		} else if info.IsDir() {
+			return nil
+		} else if strings.HasSuffix(p, tmpExt) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(b.StagingDir, p)
+		if err != nil {
+			return err
+		}
+
+		keys = append(keys, filepath.ToSlash(rel))
+		return nil
+	})
+
+	sort.Strings(keys)
+	return keys, err
+}
+
+// uploadStaged uploads and removes the staged objects, it returns
+// the number of objects that couldn't be uploaded.
+func (b *Backend) uploadStaged() (int, error) {
+	keys, err := b.staged()
+	if err != nil {
+		return 0, err
+	}
+
+	failed := 0
+
+	for _, key := range keys {
+		p := filepath.Join(b.StagingDir, filepath.FromSlash(key))
+
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			log.Errorf("Error reading staged object %s: %s", key, err.Error())
+			failed++
+			continue
+		}
+
+		if err := b.client.PutObject(key, data, contentType(key)); err != nil {
+			log.Errorf("Error uploading %s: %s", key, err.Error())
+			b.CountFailed()
+			failed++
+			continue
+		}
+
+		if err := os.Remove(p); err != nil {
+			log.Errorf("Error removing staged object %s: %s", key, err.Error())
+		}
+
+		// remove empty partition directories
+		for dir := filepath.Dir(p); dir != b.StagingDir; dir = filepath.Dir(dir) {
+			if err := os.Remove(dir); err != nil {
+				break
+			}
+		}
+	}
+
+	return failed, nil
+}
+
+// upload uploads the staged objects, objects staged by a previous
+// run will be uploaded as well. Failed uploads are retried with backoff.
+func (b *Backend) upload() {
+	delay := b.RetryDelay.Duration()
+
+	for {
+		failed, err := b.uploadStaged()
+		if err != nil {
+			log.Errorf("Error reading staging directory %s: %s", b.StagingDir, err.Error())
+		} else if failed == 0 {
+			delay = b.RetryDelay.Duration()
+
+			<-b.notify
+			continue
+		}
+
+		time.Sleep(delay)
+
+		delay *= 2
+		if max := b.MaxRetryDelay.Duration(); delay > max {
+			delay = max
+		}
+	}
+}
+
+func (b *Backend) Send(e event.Event) {
+	select {
+	case b.ch <- e:
+	default:
+		log.Errorf("Could not send more messages, channel full")
+		b.CountDropped()
+	}
+}
+
+// QueueLen returns the number of events waiting in the channel buffer.
+func (b *Backend) QueueLen() int {
+	return len(b.ch)
+}
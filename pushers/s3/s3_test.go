@@ -0,0 +1,394 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+// server is a minimal S3-compatible server, it verifies the signature
+// of uploads and stores the objects.
+type server struct {
+	*httptest.Server
+
+	m       sync.Mutex
+	objects map[string][]byte
+
+	// number of requests to fail
+	fail int
+
+	uploaded chan string
+}
+
+func newServer(t *testing.T) *server {
+	s := &server{
+		objects:  map[string][]byte{},
+		uploaded: make(chan string, 100),
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := verify(r, data); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		s.m.Lock()
+		defer s.m.Unlock()
+
+		if s.fail > 0 {
+			s.fail--
+			http.Error(w, "SlowDown", http.StatusServiceUnavailable)
+			return
+		}
+
+		if r.Method != http.MethodPut {
+			http.Error(w, "MethodNotAllowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		s.objects[r.URL.Path] = data
+		s.uploaded <- r.URL.Path
+	}))
+
+	return s
+}
+
+// verify signs the received request again and compares the signatures.
+func verify(r *http.Request, data []byte) error {
+	if sha256Hex(data) != r.Header.Get("X-Amz-Content-Sha256") {
+		return fmt.Errorf("payload hash mismatch")
+	}
+
+	t, err := time.Parse(amzDateFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	if err != nil {
+		return err
+	}
+
+	auth := r.Header.Get("Authorization")
+
+	i := strings.Index(auth, "SignedHeaders=")
+	if i == -1 {
+		return fmt.Errorf("signed headers missing")
+	}
+
+	signed := strings.Split(strings.SplitN(auth[i+len("SignedHeaders="):], ",", 2)[0], ";")
+	for _, h := range signed {
+		if h != "host" {
+			req.Header.Set(h, r.Header.Get(h))
+		}
+	}
+
+	Sign(req, r.Header.Get("X-Amz-Content-Sha256"), Credentials{
+		AccessKey: "minio",
+		SecretKey: "minio123",
+	}, "us-east-1", t)
+
+	if req.Header.Get("Authorization") != auth {
+		return fmt.Errorf("signature mismatch")
+	}
+
+	return nil
+}
+
+func (s *server) expectUpload(t *testing.T) string {
+	select {
+	case p := <-s.uploaded:
+		return p
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timeout waiting for upload")
+	}
+
+	return ""
+}
+
+func (s *server) object(p string) []byte {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.objects[p]
+}
+
+func newBackend(t *testing.T, config string) pushers.Channel {
+	s := struct {
+		P toml.Primitive
+	}{}
+
+	md, err := toml.Decode(config, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New(
+		pushers.WithConfig(s.P, &md),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func testConfig(s *server, dir string, extra string) string {
+	return fmt.Sprintf(`
+[P]
+endpoint="%s"
+bucket="honeytrap"
+access_key="minio"
+secret_key="minio123"
+path_style=true
+staging_dir="%s"
+retry_delay="10ms"
+%s
+`, s.URL, dir, extra)
+}
+
+func readEvents(t *testing.T, data []byte) []map[string]interface{} {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := []map[string]interface{}{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		values := map[string]interface{}{}
+		if err := json.Unmarshal(scanner.Bytes(), &values); err != nil {
+			t.Fatal(err)
+		}
+
+		events = append(events, values)
+	}
+
+	return events
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "s3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func TestS3Events(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	s := newServer(t)
+	defer s.Close()
+
+	c := newBackend(t, testConfig(s, dir, `
+prefix="archive/"
+batch_size=2
+`))
+
+	date := time.Date(2019, 1, 2, 13, 4, 5, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		c.Send(event.New(
+			event.Sensor("services"),
+			event.Category("ssh"),
+			event.Custom("date", date),
+			event.Custom("index", i),
+		))
+	}
+
+	p := s.expectUpload(t)
+
+	if !strings.HasPrefix(p, "/honeytrap/archive/events/dt=2019-01-02/hour=13/sensor=services/") {
+		t.Errorf("Unexpected object path %s", p)
+	}
+
+	if !strings.HasSuffix(p, ".ndjson.gz") {
+		t.Errorf("Expected compressed ndjson object, got %s", p)
+	}
+
+	events := readEvents(t, s.object(p))
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(events))
+	}
+
+	for i, values := range events {
+		if values["index"] != float64(i) {
+			t.Errorf("Expected index %d, got %v", i, values["index"])
+		}
+	}
+}
+
+func TestS3SharedBucket(t *testing.T) {
+	s := newServer(t)
+	defer s.Close()
+
+	// two instances with the same token, eg. a restarted instance,
+	// writing the same partition in the same second
+	for i := 0; i < 2; i++ {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+
+		c := newBackend(t, testConfig(s, dir, `
+batch_size=1
+`))
+
+		c.Send(event.New(
+			event.Sensor("services"),
+			event.Token("honeytrap-1"),
+			event.Custom("date", time.Date(2019, 1, 2, 13, 4, 5, 0, time.UTC)),
+			event.Custom("index", i),
+		))
+	}
+
+	paths := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		p := s.expectUpload(t)
+
+		if !strings.Contains(p, "-honeytrap-1-") {
+			t.Errorf("Expected object path to contain the instance, got %s", p)
+		}
+
+		paths[p] = true
+	}
+
+	if len(paths) != 2 {
+		t.Fatalf("Expected 2 objects, got %v", paths)
+	}
+
+	indexes := map[float64]bool{}
+	for p := range paths {
+		for _, values := range readEvents(t, s.object(p)) {
+			indexes[values["index"].(float64)] = true
+		}
+	}
+
+	if !indexes[0] || !indexes[1] {
+		t.Errorf("Expected the events of both instances, got %v", indexes)
+	}
+}
+
+func TestS3Artifacts(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	s := newServer(t)
+	defer s.Close()
+
+	c := newBackend(t, testConfig(s, dir, `
+artifacts=true
+batch_size=1
+`))
+
+	c.Send(event.New(
+		event.Sensor("services"),
+		event.Category("tftp"),
+		event.Custom("tftp.file", []byte("test")),
+	))
+
+	hash := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+	paths := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		paths[s.expectUpload(t)] = true
+	}
+
+	artifact := "/honeytrap/artifacts/9f/" + hash
+	if !paths[artifact] {
+		t.Fatalf("Expected artifact to be uploaded, got %v", paths)
+	} else if string(s.object(artifact)) != "test" {
+		t.Errorf("Unexpected artifact content %q", s.object(artifact))
+	}
+
+	delete(paths, artifact)
+
+	for p := range paths {
+		values := readEvents(t, s.object(p))[0]
+
+		if _, ok := values["tftp.file"]; ok {
+			t.Error("Expected file to be removed from event")
+		}
+
+		if values["tftp.file.sha256"] != hash {
+			t.Errorf("Expected file hash %s, got %v", hash, values["tftp.file.sha256"])
+		}
+
+		if values["tftp.file.object"] != "s3://honeytrap/artifacts/9f/"+hash {
+			t.Errorf("Unexpected object reference %v", values["tftp.file.object"])
+		}
+	}
+}
+
+func TestS3Retry(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	s := newServer(t)
+	defer s.Close()
+
+	s.fail = 2
+
+	// object staged by a previous run
+	staged := filepath.Join(dir, "events", "dt=2019-01-02", "hour=13", "sensor=services", "previous.ndjson.gz")
+	if err := os.MkdirAll(filepath.Dir(staged), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(staged, []byte("previous"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	newBackend(t, testConfig(s, dir, ""))
+
+	if p := s.expectUpload(t); p != "/honeytrap/events/dt=2019-01-02/hour=13/sensor=services/previous.ndjson.gz" {
+		t.Errorf("Unexpected object path %s", p)
+	}
+
+	// wait for the staged object to be removed
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(filepath.Join(dir, "events")); os.IsNotExist(err) {
+			return
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	t.Error("Expected staging directory to be empty after upload")
+}
@@ -47,6 +47,7 @@ type Config struct {
 // slack notifications are sent into giving slack groups and channels.
 type Backend struct {
 	Config
+	pushers.Counting
 
 	ch chan map[string]interface{}
 }
@@ -176,6 +177,7 @@ func (b Backend) run() {
 		res, err := client.Do(req)
 		if err != nil {
 			log.Errorf("Error while making request to endpoint(%q): %q", b.WebhookURL, err.Error())
+			b.CountFailed()
 			return
 		}
 
@@ -189,6 +191,7 @@ func (b Backend) run() {
 		} else if res.StatusCode == http.StatusCreated {
 		} else {
 			log.Errorf("API Response with unexpected Status Code[%d] to endpoint: %q", res.StatusCode, b.WebhookURL)
+			b.CountFailed()
 			return
 		}
 
@@ -210,6 +213,11 @@ func (b Backend) Send(e event.Event) {
 	b.ch <- mp
 }
 
+// QueueLen returns the number of events waiting in the channel buffer.
+func (b Backend) QueueLen() int {
+	return len(b.ch)
+}
+
 // Message defines the base message to be included sent to a slack endpoint.
 type Message struct {
 	Text        string       `json:"text"`
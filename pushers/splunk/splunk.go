@@ -36,6 +36,9 @@ var log = logging.MustGetLogger("channels:splunk")
 // push messages to an elasticsearch api.
 type Backend struct {
 	Config
+	pushers.Counting
+
+	client hec.HEC
 
 	ch chan map[string]interface{}
 }
@@ -51,6 +54,15 @@ func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
 		optionFn(&c)
 	}
 
+	c.client = hec.NewCluster(
+		c.Config.Endpoints,
+		c.Config.Token,
+	)
+
+	c.client.SetHTTPClient(&http.Client{Transport: &http.Transport{
+		TLSClientConfig: c.tlsConfig,
+	}})
+
 	go c.run()
 
 	return &c, nil
@@ -60,15 +72,6 @@ func (hc Backend) run() {
 	log.Debug("Splunk indexer started...")
 	defer log.Debug("Splunk indexer stopped...")
 
-	client := hec.NewCluster(
-		hc.Config.Endpoints,
-		hc.Config.Token,
-	)
-
-	client.SetHTTPClient(&http.Client{Transport: &http.Transport{
-		TLSClientConfig: hc.tlsConfig,
-	}})
-
 	batch := []*hec.Event{}
 
 	count := 0
@@ -76,7 +79,7 @@ func (hc Backend) run() {
 		select {
 		case doc := <-hc.ch:
 			event := hec.NewEvent(doc)
-			event.SetTime(time.Now())
+			event.SetTime(eventTime(doc))
 
 			batch = append(batch, event)
 			if len(batch) < 10 {
@@ -89,8 +92,9 @@ func (hc Backend) run() {
 			continue
 		}
 
-		if err := client.WriteBatch(batch); err != nil {
+		if err := hc.client.WriteBatch(batch); err != nil {
 			log.Errorf("Error indexing: %s", err.Error())
+			hc.CountFailed()
 		} else {
 			count += len(batch)
 
@@ -101,6 +105,37 @@ func (hc Backend) run() {
 	}
 }
 
+// eventTime returns the date of the event, spooled events contain the
+// date as string.
+func eventTime(values map[string]interface{}) time.Time {
+	switch v := values["date"].(type) {
+	case time.Time:
+		return v
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t
+		}
+	}
+
+	return time.Now()
+}
+
+// Deliver indexes the events synchronously, it is used when the channel is spooled.
+func (hc Backend) Deliver(events []event.Event) error {
+	batch := []*hec.Event{}
+
+	for _, e := range events {
+		values := event.ToMap(e)
+
+		ev := hec.NewEvent(values)
+		ev.SetTime(eventTime(values))
+
+		batch = append(batch, ev)
+	}
+
+	return hc.client.WriteBatch(batch)
+}
+
 // Send delivers the giving push messages into the internal elastic search endpoint.
 func (hc Backend) Send(message event.Event) {
 	mp := make(map[string]interface{})
@@ -114,3 +149,8 @@ func (hc Backend) Send(message event.Event) {
 
 	hc.ch <- mp
 }
+
+// QueueLen returns the number of events waiting in the channel buffer.
+func (hc Backend) QueueLen() int {
+	return len(hc.ch)
+}
@@ -12,3 +12,75 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 package splunk_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/pushers/splunk"
+	"github.com/honeytrap/honeytrap/pushers/spool"
+)
+
+func TestDeliverEventTime(t *testing.T) {
+	times := make(chan string, 1)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+
+		v := struct {
+			Time string `json:"time"`
+		}{}
+
+		if err := json.Unmarshal(data, &v); err != nil {
+			t.Error(err)
+		}
+
+		times <- v.Time
+
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer s.Close()
+
+	c := struct {
+		P toml.Primitive
+	}{}
+
+	md, err := toml.Decode(`
+[P]
+endpoints=["`+s.URL+`"]
+token="token"
+`, &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch, err := splunk.New(pushers.WithConfig(c.P, &md))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// spooled events contain the date as string
+	data, _ := json.Marshal(event.New(
+		event.Custom("date", time.Date(2019, 1, 2, 13, 4, 5, 0, time.UTC)),
+	))
+
+	values := map[string]interface{}{}
+	json.Unmarshal(data, &values)
+
+	if err := ch.(spool.Deliverer).Deliver([]event.Event{
+		event.New(event.CopyFrom(values)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := <-times; v != "1546434245.000" {
+		t.Errorf("Expected the time of the event, got %s", v)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package spool
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+// Deliverer is implemented by channels that can deliver events synchronously,
+// the events will be redelivered when an error has been returned.
+type Deliverer interface {
+	Deliver([]event.Event) error
+}
+
+// Channel spools events to disk before delivering them.
+type Channel struct {
+	name string
+
+	spool     *Spool
+	deliverer Deliverer
+}
+
+// NewChannel returns a channel that writes events to the spool and delivers
+// them using d, events that couldn't be delivered are retried with backoff.
+func NewChannel(name string, s *Spool, d Deliverer) pushers.Channel {
+	c := &Channel{
+		name:      name,
+		spool:     s,
+		deliverer: d,
+	}
+
+	go c.run()
+
+	return c
+}
+
+func (c *Channel) run() {
+	bo := &backoff.ExponentialBackOff{
+		InitialInterval:     backoff.DefaultInitialInterval,
+		RandomizationFactor: backoff.DefaultRandomizationFactor,
+		Multiplier:          backoff.DefaultMultiplier,
+		MaxInterval:         time.Minute,
+		MaxElapsedTime:      0,
+		Stop:                backoff.Stop,
+		Clock:               backoff.SystemClock,
+	}
+
+	bo.Reset()
+
+	for {
+		records, err := c.spool.Peek(c.spool.BatchSize)
+		if err != nil {
+			log.Errorf("Error reading spool %s: %s", c.name, err.Error())
+		} else if len(records) == 0 {
+			<-c.spool.Notify()
+			continue
+		} else if err := c.deliver(records); err != nil {
+			c.spool.failed()
+			log.Errorf("Error delivering %d spooled events of %s: %s", len(records), c.name, err.Error())
+		} else {
+			c.spool.Ack(records)
+			bo.Reset()
+			continue
+		}
+
+		time.Sleep(bo.NextBackOff())
+	}
+}
+
+func (c *Channel) deliver(records []Record) error {
+	events := []event.Event{}
+
+	for _, record := range records {
+		values := map[string]interface{}{}
+		if err := json.Unmarshal(record.Data, &values); err != nil {
+			log.Errorf("Error unmarshaling spooled event: %s", err.Error())
+			continue
+		}
+
+		events = append(events, event.New(event.CopyFrom(values)))
+	}
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	return c.deliverer.Deliver(events)
+}
+
+// Send writes the event to the spool.
+func (c *Channel) Send(e event.Event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Errorf("Error marshaling event: %s", err.Error())
+		return
+	}
+
+	if err := c.spool.Append(data); err != nil {
+		log.Errorf("Error writing event to spool %s: %s", c.name, err.Error())
+	}
+}
@@ -0,0 +1,688 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package spool
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("channels/spool")
+
+var (
+	ErrCorruptRecord  = errors.New("corrupt spool record")
+	ErrRecordTooLarge = errors.New("spool record exceeds the segment size")
+)
+
+const (
+	defaultMaxSize     = 1024 * 1024 * 1024
+	defaultSegmentSize = 16 * 1024 * 1024
+	defaultBatchSize   = 100
+
+	// recordHeaderSize is the size of the length, checksum and timestamp
+	recordHeaderSize = 16
+
+	segmentExt = ".seg"
+	ackFile    = "ack"
+)
+
+// Config configures the spool of a channel.
+type Config struct {
+	Enabled bool `toml:"enabled"`
+
+	// MaxSize is the maximum size in bytes of the spool, the oldest
+	// segments will be dropped when exceeded.
+	MaxSize int64 `toml:"max_size"`
+
+	// MaxAge is the maximum age of spooled events, older events
+	// will be dropped instead of delivered. Zero disables the limit.
+	MaxAge config.Delay `toml:"max_age"`
+
+	SegmentSize int64 `toml:"segment_size"`
+	BatchSize   int   `toml:"batch_size"`
+}
+
+func (c *Config) defaults() {
+	if c.MaxSize <= 0 {
+		c.MaxSize = defaultMaxSize
+	}
+
+	if c.SegmentSize <= 0 {
+		c.SegmentSize = defaultSegmentSize
+	}
+
+	if c.SegmentSize > c.MaxSize {
+		c.SegmentSize = c.MaxSize
+	}
+
+	if c.BatchSize <= 0 {
+		c.BatchSize = defaultBatchSize
+	}
+}
+
+// Stats contains the depth and counters of a spool.
+type Stats struct {
+	// Depth is the number of events waiting to be delivered
+	Depth int64
+	// Size is the size in bytes of the segments
+	Size     int64
+	Segments int
+
+	Appended  uint64
+	Delivered uint64
+	Dropped   uint64
+	Expired   uint64
+	// Failed is the number of failed deliveries
+	Failed uint64
+}
+
+// Record is a spooled event.
+type Record struct {
+	Data []byte
+	Time time.Time
+
+	// position after this record
+	segment uint64
+	offset  int64
+
+	// number of expired records skipped before this record
+	expired int
+}
+
+type segment struct {
+	id   uint64
+	size int64
+}
+
+type position struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// before returns whether the position is before the offset in the segment.
+func (p position) before(segment uint64, offset int64) bool {
+	return p.Segment < segment || (p.Segment == segment && p.Offset < offset)
+}
+
+// Spool is a write-ahead log of events that haven't been delivered yet, it
+// consists of segment files with length prefixed records and a file
+// containing the position up to which records have been acknowledged.
+type Spool struct {
+	Config
+
+	dir string
+
+	m sync.Mutex
+
+	segments []*segment
+	w        *os.File
+
+	ack position
+
+	stats Stats
+
+	notify chan struct{}
+}
+
+var spools sync.Map
+
+// Range calls fn for all open spools.
+func Range(fn func(name string, s *Spool)) {
+	spools.Range(func(key, value interface{}) bool {
+		fn(key.(string), value.(*Spool))
+		return true
+	})
+}
+
+func segmentName(id uint64) string {
+	return fmt.Sprintf("%016d%s", id, segmentExt)
+}
+
+// Open opens or creates the spool in dir, name identifies the spool in Range.
+func Open(name, dir string, c Config) (*Spool, error) {
+	c.defaults()
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	s := &Spool{
+		Config:   c,
+		dir:      dir,
+		segments: []*segment{},
+		notify:   make(chan struct{}, 1),
+	}
+
+	if data, err := ioutil.ReadFile(filepath.Join(dir, ackFile)); os.IsNotExist(err) {
+	} else if err != nil {
+		return nil, err
+	} else if err := json.Unmarshal(data, &s.ack); err != nil {
+		return nil, fmt.Errorf("Error reading spool position: %s", err.Error())
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fi := range files {
+		if !strings.HasSuffix(fi.Name(), segmentExt) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(fi.Name(), segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		if id < s.ack.Segment {
+			// fully acknowledged, but not removed yet
+			os.Remove(filepath.Join(dir, fi.Name()))
+			continue
+		}
+
+		s.segments = append(s.segments, &segment{id: id, size: fi.Size()})
+	}
+
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].id < s.segments[j].id
+	})
+
+	for _, seg := range s.segments {
+		offset := int64(0)
+		if seg.id == s.ack.Segment {
+			offset = s.ack.Offset
+		}
+
+		records, err := s.scan(seg, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		s.stats.Depth += records
+	}
+
+	if len(s.segments) == 0 {
+		s.segments = append(s.segments, &segment{id: s.ack.Segment})
+		s.ack.Offset = 0
+	} else if s.segments[0].id > s.ack.Segment {
+		s.ack = position{Segment: s.segments[0].id}
+	}
+
+	if err := s.openWriter(); err != nil {
+		return nil, err
+	}
+
+	spools.Store(name, s)
+
+	if s.stats.Depth > 0 {
+		log.Infof("Spool %s contains %d undelivered events", name, s.stats.Depth)
+	}
+
+	return s, nil
+}
+
+// scan counts the valid records of the segment from offset, a truncated or
+// corrupt tail (eg. after a crash) will be cut off.
+func (s *Spool) scan(seg *segment, offset int64) (int64, error) {
+	f, err := os.Open(filepath.Join(s.dir, segmentName(seg.id)))
+	if err != nil {
+		return 0, err
+	}
+
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	records := int64(0)
+
+	for {
+		n, _, err := readRecord(f, seg.size)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Errorf("Truncating spool segment %s at %d: %s", segmentName(seg.id), offset, err.Error())
+
+			if err := os.Truncate(filepath.Join(s.dir, segmentName(seg.id)), offset); err != nil {
+				return 0, err
+			}
+
+			break
+		}
+
+		offset += n
+		records++
+	}
+
+	seg.size = offset
+	return records, nil
+}
+
+// pending counts the records of the segment that haven't been acknowledged.
+func (s *Spool) pending(seg *segment) int64 {
+	f, err := os.Open(filepath.Join(s.dir, segmentName(seg.id)))
+	if err != nil {
+		return 0
+	}
+
+	defer f.Close()
+
+	if seg.id == s.ack.Segment {
+		f.Seek(s.ack.Offset, io.SeekStart)
+	}
+
+	records := int64(0)
+	for {
+		if _, _, err := readRecord(f, seg.size); err != nil {
+			return records
+		}
+
+		records++
+	}
+}
+
+func (s *Spool) openWriter() error {
+	seg := s.segments[len(s.segments)-1]
+
+	f, err := os.OpenFile(filepath.Join(s.dir, segmentName(seg.id)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	s.w = f
+	return nil
+}
+
+// readRecord reads a record, returning the number of bytes read. Records
+// can't be larger than the segment, a larger length is corrupt.
+func readRecord(r io.Reader, max int64) (int64, *Record, error) {
+	header := make([]byte, recordHeaderSize)
+	if n, err := io.ReadFull(r, header); err == io.EOF {
+		return 0, nil, io.EOF
+	} else if err != nil {
+		return int64(n), nil, ErrCorruptRecord
+	}
+
+	size := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+	ts := int64(binary.BigEndian.Uint64(header[8:16]))
+
+	if int64(size) > max-recordHeaderSize {
+		return 0, nil, ErrCorruptRecord
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, ErrCorruptRecord
+	}
+
+	if crc32.ChecksumIEEE(data) != checksum {
+		return 0, nil, ErrCorruptRecord
+	}
+
+	return int64(recordHeaderSize + len(data)), &Record{
+		Data: data,
+		Time: time.Unix(0, ts),
+	}, nil
+}
+
+func encodeRecord(data []byte, t time.Time) []byte {
+	buff := make([]byte, recordHeaderSize+len(data))
+	binary.BigEndian.PutUint32(buff[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(buff[4:8], crc32.ChecksumIEEE(data))
+	binary.BigEndian.PutUint64(buff[8:16], uint64(t.UnixNano()))
+	copy(buff[recordHeaderSize:], data)
+	return buff
+}
+
+// Append writes the data to the spool.
+func (s *Spool) Append(data []byte) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if int64(len(data)) > s.SegmentSize-recordHeaderSize {
+		return ErrRecordTooLarge
+	}
+
+	record := encodeRecord(data, time.Now())
+
+	seg := s.segments[len(s.segments)-1]
+	if seg.size > 0 && seg.size+int64(len(record)) > s.SegmentSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+
+		seg = s.segments[len(s.segments)-1]
+	}
+
+	if _, err := s.w.Write(record); err != nil {
+		// cut off the partial record, it would block delivery of the
+		// records appended after it
+		if err := s.w.Truncate(seg.size); err == nil {
+		} else if err := s.rotate(); err != nil {
+			log.Errorf("Error rotating spool segment after failed write: %s", err.Error())
+		}
+
+		return err
+	}
+
+	seg.size += int64(len(record))
+
+	s.stats.Depth++
+	s.stats.Appended++
+
+	s.enforceMaxSize()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func (s *Spool) rotate() error {
+	if err := s.w.Sync(); err != nil {
+		return err
+	}
+
+	if err := s.w.Close(); err != nil {
+		return err
+	}
+
+	s.segments = append(s.segments, &segment{id: s.segments[len(s.segments)-1].id + 1})
+
+	return s.openWriter()
+}
+
+func (s *Spool) size() int64 {
+	size := int64(0)
+	for _, seg := range s.segments {
+		size += seg.size
+	}
+
+	return size
+}
+
+// enforceMaxSize drops the oldest segments while the spool is too large.
+func (s *Spool) enforceMaxSize() {
+	for len(s.segments) > 1 && s.size() > s.MaxSize {
+		records := s.pending(s.segments[0])
+
+		log.Errorf("Spool exceeds max size of %d bytes, dropping %d events", s.MaxSize, records)
+
+		s.stats.Dropped += uint64(records)
+		s.stats.Depth -= records
+
+		s.removeHead()
+	}
+}
+
+// removeHead removes the oldest segment and moves the position to the next.
+func (s *Spool) removeHead() {
+	seg := s.segments[0]
+	s.segments = s.segments[1:]
+
+	if s.ack.Segment < s.segments[0].id {
+		s.ack = position{Segment: s.segments[0].id}
+	}
+
+	s.saveAck()
+
+	if err := os.Remove(filepath.Join(s.dir, segmentName(seg.id))); err != nil {
+		log.Errorf("Error removing spool segment: %s", err.Error())
+	}
+}
+
+func (s *Spool) saveAck() {
+	data, _ := json.Marshal(s.ack)
+
+	tmp := filepath.Join(s.dir, ackFile+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		log.Errorf("Error writing spool position: %s", err.Error())
+		return
+	}
+
+	if err := os.Rename(tmp, filepath.Join(s.dir, ackFile)); err != nil {
+		log.Errorf("Error writing spool position: %s", err.Error())
+	}
+}
+
+// Notify returns a channel that receives when records have been appended.
+func (s *Spool) Notify() <-chan struct{} {
+	return s.notify
+}
+
+// Peek returns up to n records that haven't been acknowledged, expired
+// records are skipped. The rest of a segment with a corrupt record is
+// dropped.
+func (s *Spool) Peek(n int) ([]Record, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.peek(n)
+}
+
+func (s *Spool) peek(n int) ([]Record, error) {
+	records := []Record{}
+
+	pos := s.ack
+
+	// expired records since the last record returned
+	expired := 0
+
+	var corrupt *segment
+
+	for _, seg := range s.segments {
+		if seg.id < pos.Segment {
+			continue
+		} else if seg.id > pos.Segment {
+			pos = position{Segment: seg.id}
+		}
+
+		if pos.Offset >= seg.size {
+			continue
+		}
+
+		if err := func() error {
+			f, err := os.Open(filepath.Join(s.dir, segmentName(seg.id)))
+			if err != nil {
+				return err
+			}
+
+			defer f.Close()
+
+			if _, err := f.Seek(pos.Offset, io.SeekStart); err != nil {
+				return err
+			}
+
+			for len(records) < n && pos.Offset < seg.size {
+				size, record, err := readRecord(f, seg.size)
+				if err != nil {
+					return err
+				}
+
+				pos.Offset += size
+
+				if s.MaxAge > 0 && time.Since(record.Time) > s.MaxAge.Duration() {
+					expired++
+					continue
+				}
+
+				record.segment = pos.Segment
+				record.offset = pos.Offset
+				record.expired = expired
+
+				expired = 0
+
+				records = append(records, *record)
+			}
+
+			return nil
+		}(); err == ErrCorruptRecord {
+			corrupt = seg
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if len(records) >= n {
+			break
+		}
+	}
+
+	if corrupt != nil && len(records) == 0 {
+		s.skip(corrupt, pos, expired)
+		return s.peek(n)
+	} else if len(records) == 0 && expired > 0 {
+		// only expired records, acknowledge right away
+		s.ack = pos
+		s.stats.Expired += uint64(expired)
+		s.stats.Depth -= int64(expired)
+
+		s.cleanup()
+	}
+
+	return records, nil
+}
+
+// skip drops the records of the segment from the corrupt record at pos.
+func (s *Spool) skip(seg *segment, pos position, expired int) {
+	log.Errorf("Corrupt record in spool segment %s at %d, dropping the rest of the segment", segmentName(seg.id), pos.Offset)
+
+	if seg == s.segments[len(s.segments)-1] {
+		// records can't be appended after the corrupt record
+		if err := s.rotate(); err != nil {
+			log.Errorf("Error rotating spool segment: %s", err.Error())
+		}
+	}
+
+	s.ack = position{Segment: seg.id + 1}
+	s.cleanup()
+
+	depth := int64(0)
+	for _, seg := range s.segments {
+		depth += s.pending(seg)
+	}
+
+	if dropped := s.stats.Depth - depth - int64(expired); dropped > 0 {
+		s.stats.Dropped += uint64(dropped)
+	}
+
+	s.stats.Expired += uint64(expired)
+	s.stats.Depth = depth
+}
+
+// Ack acknowledges the records, they should have been returned by Peek.
+func (s *Spool) Ack(records []Record) {
+	if len(records) == 0 {
+		return
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	last := records[len(records)-1]
+
+	if !s.ack.before(last.segment, last.offset) {
+		// records have been dropped in the mean time
+		return
+	}
+
+	for _, record := range records {
+		if !s.ack.before(record.segment, record.offset) {
+			// dropped in the mean time
+			continue
+		}
+
+		s.stats.Expired += uint64(record.expired)
+		s.stats.Delivered++
+		s.stats.Depth -= int64(record.expired + 1)
+	}
+
+	s.ack = position{Segment: last.segment, Offset: last.offset}
+
+	s.cleanup()
+}
+
+// cleanup removes fully acknowledged segments and saves the position.
+func (s *Spool) cleanup() {
+	for len(s.segments) > 1 {
+		seg := s.segments[0]
+
+		if seg.id > s.ack.Segment || (seg.id == s.ack.Segment && s.ack.Offset < seg.size) {
+			break
+		}
+
+		s.removeHead()
+	}
+
+	s.saveAck()
+}
+
+func (s *Spool) failed() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.stats.Failed++
+}
+
+// Stats returns the depth and counters of the spool.
+func (s *Spool) Stats() Stats {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	stats := s.stats
+	stats.Size = s.size()
+	stats.Segments = len(s.segments)
+	return stats
+}
+
+// Close closes the spool.
+func (s *Spool) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	spools.Range(func(key, value interface{}) bool {
+		if value == s {
+			spools.Delete(key)
+		}
+
+		return true
+	})
+
+	if err := s.w.Sync(); err != nil {
+		return err
+	}
+
+	return s.w.Close()
+}
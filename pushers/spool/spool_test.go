@@ -0,0 +1,339 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package spool
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func appendRecords(t *testing.T, s *Spool, n int) {
+	for i := 0; i < n; i++ {
+		if err := s.Append([]byte(fmt.Sprintf("record-%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSpoolReplay(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := Open("test", dir, Config{SegmentSize: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appendRecords(t, s, 10)
+
+	records, err := s.Peek(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 4 || string(records[0].Data) != "record-0" {
+		t.Fatalf("Unexpected records %v", records)
+	}
+
+	s.Ack(records)
+
+	if stats := s.Stats(); stats.Depth != 6 || stats.Delivered != 4 {
+		t.Errorf("Expected depth 6 and 4 delivered, got %+v", stats)
+	}
+
+	// unacknowledged records are returned again
+	records, _ = s.Peek(2)
+	if string(records[0].Data) != "record-4" {
+		t.Errorf("Expected record-4, got %s", records[0].Data)
+	}
+
+	s.Close()
+
+	// reopen, unacknowledged records should be replayed
+	s, err = Open("test", dir, Config{SegmentSize: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+
+	if stats := s.Stats(); stats.Depth != 6 {
+		t.Errorf("Expected depth 6 after reopening, got %d", stats.Depth)
+	}
+
+	records, _ = s.Peek(100)
+	if len(records) != 6 || string(records[0].Data) != "record-4" || string(records[5].Data) != "record-9" {
+		t.Fatalf("Unexpected records after reopening %v", records)
+	}
+
+	s.Ack(records)
+
+	if stats := s.Stats(); stats.Depth != 0 || stats.Segments != 1 {
+		t.Errorf("Expected empty spool with a single segment, got %+v", stats)
+	}
+}
+
+func TestSpoolMaxSize(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// records are 24 bytes, two records per segment
+	s, err := Open("test", dir, Config{SegmentSize: 48, MaxSize: 96})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+
+	appendRecords(t, s, 6)
+
+	stats := s.Stats()
+	if stats.Size > 96 {
+		t.Errorf("Expected spool size to be capped at 96, got %d", stats.Size)
+	}
+
+	if stats.Dropped != 2 || stats.Depth != 4 {
+		t.Errorf("Expected 2 dropped and depth 4, got %+v", stats)
+	}
+
+	records, _ := s.Peek(1)
+	if string(records[0].Data) != "record-2" {
+		t.Errorf("Expected oldest records to be dropped, got %s", records[0].Data)
+	}
+}
+
+func TestSpoolMaxAge(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := Open("test", dir, Config{MaxAge: config.Delay(time.Millisecond * 10)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+
+	appendRecords(t, s, 3)
+
+	time.Sleep(time.Millisecond * 20)
+
+	s.Append([]byte("fresh"))
+
+	records, _ := s.Peek(10)
+	if len(records) != 1 || string(records[0].Data) != "fresh" {
+		t.Fatalf("Expected only the fresh record, got %v", records)
+	}
+
+	s.Ack(records)
+
+	if stats := s.Stats(); stats.Expired != 3 || stats.Depth != 0 {
+		t.Errorf("Expected 3 expired and depth 0, got %+v", stats)
+	}
+}
+
+func TestSpoolCorruptTail(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := Open("test", dir, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appendRecords(t, s, 2)
+	s.Close()
+
+	// simulate a partial write
+	f, err := os.OpenFile(filepath.Join(dir, segmentName(0)), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Write([]byte{0, 0, 0, 100, 1, 2})
+	f.Close()
+
+	s, err = Open("test", dir, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+
+	appendRecords(t, s, 1)
+
+	records, err := s.Peek(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 3 {
+		t.Errorf("Expected 3 records after truncating corrupt tail, got %d", len(records))
+	}
+}
+
+func TestSpoolCorruptRecord(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := Open("test", dir, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+
+	appendRecords(t, s, 1)
+
+	// a partial record, eg. a failed write, with a length that would
+	// allocate gigabytes
+	f, err := os.OpenFile(filepath.Join(dir, segmentName(0)), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Write([]byte{0xff, 0xff, 0xff, 0xf0, 1, 2})
+	f.Close()
+
+	appendRecords(t, s, 2)
+
+	records, err := s.Peek(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 1 || string(records[0].Data) != "record-0" {
+		t.Fatalf("Expected the record before the corrupt record, got %v", records)
+	}
+
+	s.Ack(records)
+
+	// the rest of the segment is dropped, delivery continues
+	records, err = s.Peek(10)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(records) != 0 {
+		t.Fatalf("Expected the rest of the segment to be dropped, got %v", records)
+	}
+
+	if stats := s.Stats(); stats.Depth != 0 || stats.Dropped != 2 {
+		t.Errorf("Expected 2 dropped and depth 0, got %+v", stats)
+	}
+
+	appendRecords(t, s, 1)
+
+	records, err = s.Peek(10)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(records) != 1 || string(records[0].Data) != "record-0" {
+		t.Fatalf("Expected the record appended after dropping, got %v", records)
+	}
+}
+
+func TestSpoolRecordTooLarge(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := Open("test", dir, Config{SegmentSize: 32})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+
+	if err := s.Append(make([]byte, 17)); err != ErrRecordTooLarge {
+		t.Errorf("Expected ErrRecordTooLarge, got %v", err)
+	}
+
+	if err := s.Append(make([]byte, 16)); err != nil {
+		t.Errorf("Expected record to fit the segment, got %v", err)
+	}
+}
+
+type deliverer struct {
+	m sync.Mutex
+
+	fail   int
+	events []event.Event
+
+	delivered chan struct{}
+}
+
+func (d *deliverer) Deliver(events []event.Event) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if d.fail > 0 {
+		d.fail--
+		return errors.New("backend unavailable")
+	}
+
+	d.events = append(d.events, events...)
+	d.delivered <- struct{}{}
+	return nil
+}
+
+func TestChannel(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := Open("test", dir, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+
+	d := &deliverer{
+		fail:      1,
+		delivered: make(chan struct{}, 10),
+	}
+
+	c := NewChannel("test", s, d)
+
+	c.Send(event.New(
+		event.Category("ssh"),
+		event.Custom("ssh.username", "root"),
+	))
+
+	select {
+	case <-d.delivered:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timeout waiting for delivery")
+	}
+
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if len(d.events) != 1 || d.events[0].Get("ssh.username") != "root" {
+		t.Errorf("Unexpected delivered events %v", d.events)
+	}
+}
@@ -0,0 +1,334 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package sqlite
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// filterColumns maps query parameters to the columns they filter on.
+var filterColumns = map[string]string{
+	"sensor":           "sensor",
+	"category":         "category",
+	"type":             "type",
+	"service":          "service",
+	"source_ip":        "source_ip",
+	"destination_ip":   "destination_ip",
+	"destination_port": "destination_port",
+	"session_id":       "session_id",
+}
+
+// groupExpressions maps the group_by values of counts to their expressions.
+var groupExpressions = map[string]string{
+	"sensor":           "sensor",
+	"category":         "category",
+	"type":             "type",
+	"service":          "service",
+	"source_ip":        "source_ip",
+	"destination_port": "destination_port",
+	"session_id":       "session_id",
+	"hour":             fmt.Sprintf("(time / %d) * %d", int64(time.Hour), int64(time.Hour)),
+	"day":              fmt.Sprintf("(time / %d) * %d", int64(time.Hour*24), int64(time.Hour*24)),
+}
+
+// Query filters the stored events.
+type Query struct {
+	From time.Time
+	To   time.Time
+
+	// Filters maps columns to the value they should equal
+	Filters map[string]string
+
+	Limit  int
+	Offset int
+
+	Ascending bool
+}
+
+func parseTime(s string) (time.Time, error) {
+	if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(v, 0), nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
+
+func parseInt(s string, def int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("Invalid number %s", s)
+	}
+
+	return v, nil
+}
+
+// ParseQuery parses the query from url values.
+func ParseQuery(values url.Values) (*Query, error) {
+	q := &Query{
+		Filters:   map[string]string{},
+		Ascending: values.Get("order") == "asc",
+	}
+
+	if s := values.Get("from"); s == "" {
+	} else if t, err := parseTime(s); err != nil {
+		return nil, fmt.Errorf("Invalid from %s", s)
+	} else {
+		q.From = t
+	}
+
+	if s := values.Get("to"); s == "" {
+	} else if t, err := parseTime(s); err != nil {
+		return nil, fmt.Errorf("Invalid to %s", s)
+	} else {
+		q.To = t
+	}
+
+	for param, column := range filterColumns {
+		if v := values.Get(param); v != "" {
+			q.Filters[column] = v
+		}
+	}
+
+	var err error
+
+	if q.Limit, err = parseInt(values.Get("limit"), defaultLimit); err != nil {
+		return nil, err
+	} else if q.Limit > maxLimit {
+		q.Limit = maxLimit
+	}
+
+	if q.Offset, err = parseInt(values.Get("offset"), 0); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// where returns the where clause and arguments of the query.
+func (q *Query) where() (string, []interface{}) {
+	clauses := []string{"1 = 1"}
+	args := []interface{}{}
+
+	if !q.From.IsZero() {
+		clauses = append(clauses, "time >= ?")
+		args = append(args, q.From.UnixNano())
+	}
+
+	if !q.To.IsZero() {
+		clauses = append(clauses, "time < ?")
+		args = append(args, q.To.UnixNano())
+	}
+
+	// filter columns in a stable order
+	for _, column := range []string{"sensor", "category", "type", "service", "source_ip", "destination_ip", "destination_port", "session_id"} {
+		if v, ok := q.Filters[column]; ok {
+			clauses = append(clauses, column+" = ?")
+			args = append(args, v)
+		}
+	}
+
+	return strings.Join(clauses, " AND "), args
+}
+
+// Result is a page of events.
+type Result struct {
+	Total  int64             `json:"total"`
+	Limit  int               `json:"limit"`
+	Offset int               `json:"offset"`
+	Events []json.RawMessage `json:"events"`
+}
+
+// Events returns the events matching the query.
+func (b *Backend) Events(q *Query) (*Result, error) {
+	where, args := q.where()
+
+	result := &Result{
+		Limit:  q.Limit,
+		Offset: q.Offset,
+		Events: []json.RawMessage{},
+	}
+
+	if err := b.db.QueryRow("SELECT COUNT(*) FROM events WHERE "+where, args...).Scan(&result.Total); err != nil {
+		return nil, err
+	}
+
+	order := "DESC"
+	if q.Ascending {
+		order = "ASC"
+	}
+
+	rows, err := b.db.Query(
+		fmt.Sprintf("SELECT data FROM events WHERE %s ORDER BY time %s, id %s LIMIT ? OFFSET ?", where, order, order),
+		append(args, q.Limit, q.Offset)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		result.Events = append(result.Events, json.RawMessage(data))
+	}
+
+	return result, rows.Err()
+}
+
+// Count is the number of events of a group.
+type Count struct {
+	Key   interface{} `json:"key"`
+	Count int64       `json:"count"`
+}
+
+// Counts returns the number of events matching the query per group.
+func (b *Backend) Counts(q *Query, groupBy string) ([]Count, error) {
+	expr, ok := groupExpressions[groupBy]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported group_by %s", groupBy)
+	}
+
+	where, args := q.where()
+
+	rows, err := b.db.Query(
+		fmt.Sprintf("SELECT %s AS key, COUNT(*) AS count FROM events WHERE %s GROUP BY key ORDER BY count DESC, key ASC LIMIT ? OFFSET ?", expr, where),
+		append(args, q.Limit, q.Offset)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	counts := []Count{}
+
+	for rows.Next() {
+		c := Count{}
+
+		var key interface{}
+		if err := rows.Scan(&key, &c.Count); err != nil {
+			return nil, err
+		}
+
+		switch groupBy {
+		case "hour", "day":
+			v, _ := key.(int64)
+			c.Key = time.Unix(0, v).UTC()
+		default:
+			c.Key = key
+		}
+
+		counts = append(counts, c)
+	}
+
+	return counts, rows.Err()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("Error encoding response: %s", err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{
+		"error": err.Error(),
+	})
+}
+
+// Handler returns the http handler of the query api, the handler is
+// mounted on the authenticated management api as /api/v1/channels/{name}/.
+//
+//	GET /events?from=&to=&category=&limit=&offset=
+//	GET /events/count?group_by=category&from=&to=
+func (b *Backend) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %s not allowed", r.Method))
+			return
+		}
+
+		q, err := ParseQuery(r.URL.Query())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		result, err := b.Events(q)
+		if err != nil {
+			log.Errorf("Error querying events: %s", err.Error())
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, result)
+	})
+
+	mux.HandleFunc("/events/count", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %s not allowed", r.Method))
+			return
+		}
+
+		q, err := ParseQuery(r.URL.Query())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		groupBy := r.URL.Query().Get("group_by")
+		if _, ok := groupExpressions[groupBy]; !ok {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Unsupported group_by %s", groupBy))
+			return
+		}
+
+		counts, err := b.Counts(q, groupBy)
+		if err != nil {
+			log.Errorf("Error counting events: %s", err.Error())
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"group_by": groupBy,
+			"counts":   counts,
+		})
+	})
+
+	return mux
+}
@@ -0,0 +1,310 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+
+	logging "github.com/op/go-logging"
+
+	// pure go sqlite driver
+	_ "modernc.org/sqlite"
+)
+
+var (
+	_ = pushers.Register("sqlite", New)
+)
+
+var log = logging.MustGetLogger("channels/sqlite")
+
+const (
+	defaultRetentionInterval = time.Minute * 10
+)
+
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		time INTEGER NOT NULL,
+		sensor TEXT NOT NULL DEFAULT '',
+		category TEXT NOT NULL DEFAULT '',
+		type TEXT NOT NULL DEFAULT '',
+		service TEXT NOT NULL DEFAULT '',
+		source_ip TEXT NOT NULL DEFAULT '',
+		source_port INTEGER NOT NULL DEFAULT 0,
+		destination_ip TEXT NOT NULL DEFAULT '',
+		destination_port INTEGER NOT NULL DEFAULT 0,
+		session_id TEXT NOT NULL DEFAULT '',
+		data TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS events_time ON events (time)`,
+	`CREATE INDEX IF NOT EXISTS events_source_ip ON events (source_ip, time)`,
+	`CREATE INDEX IF NOT EXISTS events_service ON events (service, time)`,
+	`CREATE INDEX IF NOT EXISTS events_category ON events (category, time)`,
+	`CREATE INDEX IF NOT EXISTS events_session_id ON events (session_id)`,
+}
+
+type Config struct {
+	Filename string `toml:"filename"`
+
+	// Retention is the maximum age of stored events, zero keeps events forever
+	Retention config.Delay `toml:"retention"`
+
+	// MaxEvents is the maximum number of stored events, zero is unlimited
+	MaxEvents int64 `toml:"max_events"`
+}
+
+// Backend stores events in a sqlite database.
+type Backend struct {
+	Config
+	pushers.Counting
+
+	db *sql.DB
+
+	ch chan event.Event
+}
+
+func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
+	c := Backend{
+		Config: Config{
+			Filename: "events.db",
+		},
+		ch: make(chan event.Event, 100),
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(&c); err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := Open(c.Filename)
+	if err != nil {
+		return nil, err
+	}
+
+	c.db = db
+
+	go c.run()
+
+	return &c, nil
+}
+
+// Open opens the database and creates the schema.
+func Open(filename string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// sqlite allows a single writer
+	db.SetMaxOpenConns(1)
+
+	for _, stmt := range append([]string{"PRAGMA journal_mode=WAL"}, schema...) {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("Error creating schema: %s", err.Error())
+		}
+	}
+
+	return db, nil
+}
+
+func (b *Backend) run() {
+	batch := []event.Event{}
+
+	flush := time.NewTicker(time.Second)
+	defer flush.Stop()
+
+	retention := time.NewTicker(defaultRetentionInterval)
+	defer retention.Stop()
+
+	for {
+		select {
+		case e := <-b.ch:
+			batch = append(batch, e)
+
+			if len(batch) < 100 {
+				continue
+			}
+		case <-flush.C:
+		case <-retention.C:
+			if err := b.expire(time.Now()); err != nil {
+				log.Errorf("Error applying retention: %s", err.Error())
+			}
+		}
+
+		if len(batch) == 0 {
+			continue
+		}
+
+		if err := b.insert(batch); err != nil {
+			log.Errorf("Error storing %d events: %s", len(batch), err.Error())
+			b.CountFailed()
+		}
+
+		batch = []event.Event{}
+	}
+}
+
+// row contains the indexed columns of an event.
+type row struct {
+	Time            time.Time
+	Sensor          string
+	Category        string
+	Type            string
+	Service         string
+	SourceIP        string
+	SourcePort      int
+	DestinationIP   string
+	DestinationPort int
+	SessionID       string
+}
+
+func toInt(v interface{}) int {
+	switch v := v.(type) {
+	case int:
+		return v
+	case uint16:
+		return int(v)
+	case float64:
+		return int(v)
+	case string:
+		i, _ := strconv.Atoi(v)
+		return i
+	default:
+		return 0
+	}
+}
+
+func newRow(values map[string]interface{}) row {
+	r := row{
+		Time: time.Now(),
+	}
+
+	switch v := values["date"].(type) {
+	case time.Time:
+		r.Time = v
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			r.Time = t
+		}
+	}
+
+	r.Sensor, _ = values["sensor"].(string)
+	r.Category, _ = values["category"].(string)
+	r.Type, _ = values["type"].(string)
+	r.Service, _ = values["service"].(string)
+	r.SourceIP, _ = values["source-ip"].(string)
+	r.SourcePort = toInt(values["source-port"])
+	r.DestinationIP, _ = values["destination-ip"].(string)
+	r.DestinationPort = toInt(values["destination-port"])
+
+	for k, v := range values {
+		if !strings.HasSuffix(k, "sessionid") && !strings.HasSuffix(k, "session-id") {
+			continue
+		}
+
+		r.SessionID = fmt.Sprintf("%v", v)
+		break
+	}
+
+	return r
+}
+
+func (b *Backend) insert(events []event.Event) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO events (time, sensor, category, type, service, source_ip, source_port, destination_ip, destination_port, session_id, data) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	defer stmt.Close()
+
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			log.Errorf("Error marshaling event: %s", err.Error())
+			continue
+		}
+
+		r := newRow(event.ToMap(e))
+
+		if _, err := stmt.Exec(r.Time.UnixNano(), r.Sensor, r.Category, r.Type, r.Service, r.SourceIP, r.SourcePort, r.DestinationIP, r.DestinationPort, r.SessionID, string(data)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// expire removes the events exceeding the retention policies.
+func (b *Backend) expire(now time.Time) error {
+	if b.Retention > 0 {
+		res, err := b.db.Exec(`DELETE FROM events WHERE time < ?`, now.Add(-b.Retention.Duration()).UnixNano())
+		if err != nil {
+			return err
+		}
+
+		if n, _ := res.RowsAffected(); n > 0 {
+			log.Debugf("Removed %d events older than %s", n, b.Retention.Duration())
+		}
+	}
+
+	if b.MaxEvents > 0 {
+		res, err := b.db.Exec(`DELETE FROM events WHERE id <= (SELECT id FROM events ORDER BY id DESC LIMIT 1 OFFSET ?)`, b.MaxEvents)
+		if err != nil {
+			return err
+		}
+
+		if n, _ := res.RowsAffected(); n > 0 {
+			log.Debugf("Removed %d events exceeding max events %d", n, b.MaxEvents)
+		}
+	}
+
+	return nil
+}
+
+// Deliver stores the events synchronously, it is used when the channel is spooled.
+func (b *Backend) Deliver(events []event.Event) error {
+	return b.insert(events)
+}
+
+func (b *Backend) Send(e event.Event) {
+	select {
+	case b.ch <- e:
+	default:
+		log.Errorf("Could not send more messages, channel full")
+		b.CountDropped()
+	}
+}
+
+// QueueLen returns the number of events waiting in the channel buffer.
+func (b *Backend) QueueLen() int {
+	return len(b.ch)
+}
@@ -0,0 +1,230 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package sqlite
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+)
+
+func newBackend(t *testing.T) (*Backend, func()) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open(filepath.Join(dir, "events.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Backend{db: db}, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func testEvent(date time.Time, category string, ip string, port int) event.Event {
+	e := event.New(
+		event.Sensor("services"),
+		event.Category(category),
+		event.Service(category),
+		event.SourceAddr(&net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}),
+		event.DestinationAddr(&net.TCPAddr{IP: net.ParseIP("10.0.0.100"), Port: port}),
+	)
+
+	e.Store("date", date)
+	return e
+}
+
+func TestEvents(t *testing.T) {
+	b, cleanup := newBackend(t)
+	defer cleanup()
+
+	now := time.Now()
+
+	events := []event.Event{
+		testEvent(now.Add(-time.Hour*3), "ssh", "10.0.0.1", 22),
+		testEvent(now.Add(-time.Hour*2), "ssh", "10.0.0.2", 22),
+		testEvent(now.Add(-time.Hour), "http", "10.0.0.1", 80),
+		testEvent(now, "ssh", "10.0.0.1", 22),
+	}
+
+	events[3].Store("ssh.sessionid", "abc")
+
+	if err := b.insert(events); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := b.Events(&Query{Filters: map[string]string{"category": "ssh"}, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Total != 3 || len(result.Events) != 2 {
+		t.Fatalf("Expected 3 total and a page of 2, got %d and %d", result.Total, len(result.Events))
+	}
+
+	v := map[string]interface{}{}
+	json.Unmarshal(result.Events[0], &v)
+
+	if v["ssh.sessionid"] != "abc" {
+		t.Errorf("Expected most recent event first, got %v", v)
+	}
+
+	result, err = b.Events(&Query{Filters: map[string]string{"session_id": "abc"}, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	} else if result.Total != 1 {
+		t.Errorf("Expected 1 event for session, got %d", result.Total)
+	}
+
+	result, err = b.Events(&Query{
+		From:    now.Add(-time.Hour*2 - time.Minute),
+		To:      now.Add(-time.Minute),
+		Filters: map[string]string{},
+		Limit:   10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if result.Total != 2 {
+		t.Errorf("Expected 2 events within time range, got %d", result.Total)
+	}
+
+	result, err = b.Events(&Query{Filters: map[string]string{"destination_port": "80"}, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	} else if result.Total != 1 {
+		t.Errorf("Expected 1 event on port 80, got %d", result.Total)
+	}
+
+	counts, err := b.Counts(&Query{Filters: map[string]string{}, Limit: 10}, "source_ip")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(counts) != 2 || counts[0].Key != "10.0.0.1" || counts[0].Count != 3 {
+		t.Errorf("Unexpected counts %+v", counts)
+	}
+
+	counts, err = b.Counts(&Query{Filters: map[string]string{}, Limit: 10}, "hour")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(counts) != 4 {
+		t.Errorf("Expected 4 hourly buckets, got %+v", counts)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	b, cleanup := newBackend(t)
+	defer cleanup()
+
+	now := time.Now()
+
+	events := []event.Event{}
+	for i := 0; i < 10; i++ {
+		events = append(events, testEvent(now.Add(-time.Hour*time.Duration(i)), "ssh", "10.0.0.1", 22))
+	}
+
+	if err := b.insert(events); err != nil {
+		t.Fatal(err)
+	}
+
+	b.Retention = config.Delay(time.Hour*5 + time.Minute)
+
+	if err := b.expire(now); err != nil {
+		t.Fatal(err)
+	}
+
+	if result, _ := b.Events(&Query{Filters: map[string]string{}, Limit: 100}); result.Total != 6 {
+		t.Errorf("Expected 6 events after retention, got %d", result.Total)
+	}
+
+	b.MaxEvents = 2
+
+	if err := b.expire(now); err != nil {
+		t.Fatal(err)
+	}
+
+	if result, _ := b.Events(&Query{Filters: map[string]string{}, Limit: 100}); result.Total != 2 {
+		t.Errorf("Expected 2 events after max events, got %d", result.Total)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	b, cleanup := newBackend(t)
+	defer cleanup()
+
+	now := time.Now()
+
+	if err := b.insert([]event.Event{
+		testEvent(now, "ssh", "10.0.0.1", 22),
+		testEvent(now, "http", "10.0.0.1", 80),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(b.Handler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/events?" + url.Values{"category": {"http"}, "from": {now.Add(-time.Minute).Format(time.RFC3339)}}.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := Result{}
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+
+	if result.Total != 1 || len(result.Events) != 1 {
+		t.Errorf("Expected 1 http event, got %+v", result)
+	}
+
+	resp, err = http.Get(ts.URL + "/events/count?group_by=category")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := struct {
+		Counts []Count `json:"counts"`
+	}{}
+	json.NewDecoder(resp.Body).Decode(&counts)
+	resp.Body.Close()
+
+	if len(counts.Counts) != 2 {
+		t.Errorf("Expected counts for 2 categories, got %+v", counts)
+	}
+
+	resp, err = http.Get(ts.URL + "/events/count?group_by=data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected bad request for unsupported group_by, got %d", resp.StatusCode)
+	}
+}
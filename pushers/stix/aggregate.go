@@ -0,0 +1,308 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package stix
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// Kinds of indicators extracted from events.
+const (
+	KindIPv4      = "ipv4-addr"
+	KindIPv6      = "ipv6-addr"
+	KindURL       = "url"
+	KindJA3       = "ja3"
+	KindUserAgent = "user-agent"
+	KindFile      = "file"
+)
+
+// Indicator is a single indicator of compromise.
+type Indicator struct {
+	Kind  string
+	Value string
+
+	// Name is the filename for file indicators
+	Name string
+}
+
+func (i Indicator) key() string {
+	return i.Kind + "|" + i.Value
+}
+
+// Credential is a username and password tried by the attacker.
+type Credential struct {
+	Username string
+	Password string
+}
+
+// Activity is the aggregated activity of a single attacker or session.
+type Activity struct {
+	Key      string
+	SourceIP string
+
+	First time.Time
+	Last  time.Time
+	Count int
+
+	Categories map[string]struct{}
+
+	Indicators  map[string]Indicator
+	Credentials map[Credential]struct{}
+	Commands    map[string]struct{}
+}
+
+func newActivity(key string) *Activity {
+	return &Activity{
+		Key:         key,
+		Categories:  map[string]struct{}{},
+		Indicators:  map[string]Indicator{},
+		Credentials: map[Credential]struct{}{},
+		Commands:    map[string]struct{}{},
+	}
+}
+
+// SortedIndicators returns the indicators in a stable order.
+func (a *Activity) SortedIndicators() []Indicator {
+	keys := []string{}
+	for k := range a.Indicators {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	indicators := []Indicator{}
+	for _, k := range keys {
+		indicators = append(indicators, a.Indicators[k])
+	}
+
+	return indicators
+}
+
+// SortedCredentials returns the credentials in a stable order.
+func (a *Activity) SortedCredentials() []Credential {
+	credentials := []Credential{}
+	for c := range a.Credentials {
+		credentials = append(credentials, c)
+	}
+
+	sort.Slice(credentials, func(i, j int) bool {
+		if credentials[i].Username == credentials[j].Username {
+			return credentials[i].Password < credentials[j].Password
+		}
+
+		return credentials[i].Username < credentials[j].Username
+	})
+
+	return credentials
+}
+
+func (a *Activity) addIndicator(i Indicator) {
+	if i.Value == "" {
+		return
+	}
+
+	a.Indicators[i.key()] = i
+}
+
+// add aggregates the values of the event into the activity.
+func (a *Activity) add(values map[string]interface{}, now time.Time) {
+	if a.Count == 0 {
+		a.First = now
+	}
+
+	a.Last = now
+	a.Count++
+
+	if v, ok := values["category"].(string); ok && v != "" {
+		a.Categories[v] = struct{}{}
+	}
+
+	if ip := net.ParseIP(a.SourceIP); ip == nil {
+	} else if ip.To4() != nil {
+		a.addIndicator(Indicator{Kind: KindIPv4, Value: a.SourceIP})
+	} else {
+		a.addIndicator(Indicator{Kind: KindIPv6, Value: a.SourceIP})
+	}
+
+	if u, ok := values["http.url"].(string); !ok {
+	} else if host, ok := values["http.host"].(string); !ok || host == "" {
+	} else if strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://") {
+		a.addIndicator(Indicator{Kind: KindURL, Value: u})
+	} else {
+		scheme := "http"
+		if values["category"] == "https" {
+			scheme = "https"
+		}
+
+		a.addIndicator(Indicator{Kind: KindURL, Value: fmt.Sprintf("%s://%s%s", scheme, host, u)})
+	}
+
+	if v, ok := values["https.ja3-digest"].(string); ok {
+		a.addIndicator(Indicator{Kind: KindJA3, Value: v})
+	}
+
+	for _, key := range []string{"http.user-agent", "user-agent"} {
+		if v, ok := values[key].(string); ok {
+			a.addIndicator(Indicator{Kind: KindUserAgent, Value: v})
+		}
+	}
+
+	for key, value := range values {
+		switch {
+		case strings.HasSuffix(key, ".username"):
+			prefix := strings.TrimSuffix(key, ".username")
+
+			username, _ := value.(string)
+			if username == "" {
+				continue
+			}
+
+			password, _ := values[prefix+".password"].(string)
+
+			a.Credentials[Credential{Username: username, Password: password}] = struct{}{}
+		case strings.HasSuffix(key, ".command"), strings.HasSuffix(key, ".exec"):
+			if v, ok := value.(string); ok && v != "" {
+				a.Commands[v] = struct{}{}
+			}
+		case strings.HasSuffix(key, ".file"):
+			data, ok := value.([]byte)
+			if !ok || len(data) == 0 {
+				continue
+			}
+
+			name, _ := values[strings.TrimSuffix(key, ".file")+".filename"].(string)
+
+			hash := sha256.Sum256(data)
+
+			a.addIndicator(Indicator{Kind: KindFile, Value: hex.EncodeToString(hash[:]), Name: name})
+		}
+	}
+}
+
+// aggregator aggregates events per attacker or session.
+type aggregator struct {
+	groupBy string
+
+	activities map[string]*Activity
+}
+
+func newAggregator(groupBy string) *aggregator {
+	return &aggregator{
+		groupBy:    groupBy,
+		activities: map[string]*Activity{},
+	}
+}
+
+// key returns the key the event will be aggregated on.
+func (ag *aggregator) key(values map[string]interface{}) string {
+	sourceIP := fmt.Sprintf("%v", values["source-ip"])
+
+	if ag.groupBy != "session" {
+		return sourceIP
+	}
+
+	for k, v := range values {
+		if strings.HasSuffix(k, ".sessionid") {
+			return fmt.Sprintf("%s|%v", sourceIP, v)
+		}
+	}
+
+	return fmt.Sprintf("%s|%v", sourceIP, values["source-port"])
+}
+
+// Add adds the event to the activity it belongs to.
+func (ag *aggregator) Add(e event.Event, now time.Time) {
+	values := event.ToMap(e)
+
+	sourceIP, ok := values["source-ip"].(string)
+	if !ok || sourceIP == "" {
+		return
+	}
+
+	key := ag.key(values)
+
+	a, ok := ag.activities[key]
+	if !ok {
+		a = newActivity(key)
+		a.SourceIP = sourceIP
+		ag.activities[key] = a
+	}
+
+	a.add(values, now)
+}
+
+// Flush removes and returns the activities that started before the window.
+func (ag *aggregator) Flush(now time.Time, window time.Duration) []*Activity {
+	activities := []*Activity{}
+
+	for key, a := range ag.activities {
+		if now.Sub(a.First) < window {
+			continue
+		}
+
+		activities = append(activities, a)
+		delete(ag.activities, key)
+	}
+
+	sort.Slice(activities, func(i, j int) bool {
+		return activities[i].Key < activities[j].Key
+	})
+
+	return activities
+}
+
+// deduplicator keeps track of the indicators that have been exported recently.
+type deduplicator struct {
+	window time.Duration
+
+	seen map[string]time.Time
+}
+
+func newDeduplicator(window time.Duration) *deduplicator {
+	return &deduplicator{
+		window: window,
+		seen:   map[string]time.Time{},
+	}
+}
+
+// Filter returns the indicators that haven't been exported within the window,
+// and marks them as exported.
+func (d *deduplicator) Filter(indicators []Indicator, now time.Time) []Indicator {
+	for k, t := range d.seen {
+		if now.Sub(t) >= d.window {
+			delete(d.seen, k)
+		}
+	}
+
+	filtered := []Indicator{}
+
+	for _, i := range indicators {
+		if _, ok := d.seen[i.key()]; ok {
+			continue
+		}
+
+		d.seen[i.key()] = now
+		filtered = append(filtered, i)
+	}
+
+	return filtered
+}
@@ -0,0 +1,331 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package stix
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+const specVersion = "2.1"
+
+var (
+	// namespaceSTIX is the namespace used for deterministic identifiers
+	// of STIX cyber-observable objects.
+	namespaceSTIX = uuid.Must(uuid.FromString("00abedb4-aa42-466c-9c01-fed23315a9b7"))
+
+	// namespaceHoneytrap is the namespace used for deterministic identifiers
+	// of domain objects created by honeytrap.
+	namespaceHoneytrap = uuid.NewV5(namespaceSTIX, "honeytrap")
+)
+
+// Object is a STIX object.
+type Object map[string]interface{}
+
+// ID returns the identifier of the object.
+func (o Object) ID() string {
+	id, _ := o["id"].(string)
+	return id
+}
+
+// Bundle is a collection of STIX objects.
+type Bundle struct {
+	Type    string   `json:"type"`
+	ID      string   `json:"id"`
+	Objects []Object `json:"objects"`
+}
+
+// technique is a MITRE ATT&CK technique.
+type technique struct {
+	ID   string
+	Name string
+}
+
+var (
+	techniqueActiveScanning      = technique{"T1595", "Active Scanning"}
+	techniqueBruteForce          = technique{"T1110", "Brute Force"}
+	techniqueCommandInterpreter  = technique{"T1059", "Command and Scripting Interpreter"}
+	techniqueIngressToolTransfer = technique{"T1105", "Ingress Tool Transfer"}
+)
+
+func timestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+func randomID(typ string) string {
+	return fmt.Sprintf("%s--%s", typ, uuid.NewV4().String())
+}
+
+// deterministicID returns an identifier derived from the properties of the object,
+// so the same object will have the same identifier across bundles.
+func deterministicID(namespace uuid.UUID, typ string, properties interface{}) string {
+	data, _ := json.Marshal(properties)
+	return fmt.Sprintf("%s--%s", typ, uuid.NewV5(namespace, string(data)).String())
+}
+
+var patternEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// pattern returns the STIX pattern matching the indicator.
+func pattern(i Indicator) string {
+	value := patternEscaper.Replace(i.Value)
+
+	switch i.Kind {
+	case KindIPv4, KindIPv6, KindURL:
+		return fmt.Sprintf("[%s:value = '%s']", i.Kind, value)
+	case KindFile:
+		return fmt.Sprintf("[file:hashes.'SHA-256' = '%s']", value)
+	case KindUserAgent:
+		return fmt.Sprintf("[network-traffic:extensions.'http-request-ext'.request_header.'User-Agent' = '%s']", value)
+	case KindJA3:
+		// there is no standard object for ja3 fingerprints, use a custom one
+		return fmt.Sprintf("[x-ja3-fingerprint:value = '%s']", value)
+	default:
+		return ""
+	}
+}
+
+// observable returns the STIX cyber-observable object of the indicator.
+func observable(i Indicator) Object {
+	switch i.Kind {
+	case KindIPv4, KindIPv6, KindURL:
+		return Object{
+			"type":         i.Kind,
+			"spec_version": specVersion,
+			"id":           deterministicID(namespaceSTIX, i.Kind, map[string]string{"value": i.Value}),
+			"value":        i.Value,
+		}
+	case KindFile:
+		hashes := map[string]string{"SHA-256": i.Value}
+
+		o := Object{
+			"type":         "file",
+			"spec_version": specVersion,
+			"id":           deterministicID(namespaceSTIX, "file", map[string]interface{}{"hashes": hashes}),
+			"hashes":       hashes,
+		}
+
+		if i.Name != "" {
+			o["name"] = i.Name
+		}
+
+		return o
+	default:
+		return nil
+	}
+}
+
+func credentialObservable(c Credential) Object {
+	// the credential is part of the identifier, otherwise different
+	// passwords tried for the same login would share the identifier.
+	return Object{
+		"type":          "user-account",
+		"spec_version":  specVersion,
+		"id":            deterministicID(namespaceSTIX, "user-account", map[string]string{"account_login": c.Username, "credential": c.Password}),
+		"account_login": c.Username,
+		"credential":    c.Password,
+	}
+}
+
+// bundler creates STIX bundles of attacker activity.
+type bundler struct {
+	identity Object
+}
+
+func newBundler(name string, now time.Time) *bundler {
+	return &bundler{
+		identity: Object{
+			"type":           "identity",
+			"spec_version":   specVersion,
+			"id":             deterministicID(namespaceHoneytrap, "identity", map[string]string{"name": name}),
+			"created":        timestamp(now),
+			"modified":       timestamp(now),
+			"name":           name,
+			"identity_class": "system",
+		},
+	}
+}
+
+func (b *bundler) attackPattern(t technique, now time.Time) Object {
+	return Object{
+		"type":           "attack-pattern",
+		"spec_version":   specVersion,
+		"id":             deterministicID(namespaceHoneytrap, "attack-pattern", map[string]string{"external_id": t.ID}),
+		"created":        timestamp(now),
+		"modified":       timestamp(now),
+		"created_by_ref": b.identity.ID(),
+		"name":           t.Name,
+		"external_references": []map[string]string{
+			{
+				"source_name": "mitre-attack",
+				"external_id": t.ID,
+				"url":         fmt.Sprintf("https://attack.mitre.org/techniques/%s/", t.ID),
+			},
+		},
+	}
+}
+
+func (b *bundler) relationship(typ string, source, target Object, now time.Time) Object {
+	return Object{
+		"type":              "relationship",
+		"spec_version":      specVersion,
+		"id":                randomID("relationship"),
+		"created":           timestamp(now),
+		"modified":          timestamp(now),
+		"created_by_ref":    b.identity.ID(),
+		"relationship_type": typ,
+		"source_ref":        source.ID(),
+		"target_ref":        target.ID(),
+	}
+}
+
+// techniques returns the techniques used during the activity.
+func techniques(a *Activity) []technique {
+	techniques := []technique{techniqueActiveScanning}
+
+	if len(a.Credentials) > 0 {
+		techniques = append(techniques, techniqueBruteForce)
+	}
+
+	if len(a.Commands) > 0 {
+		techniques = append(techniques, techniqueCommandInterpreter)
+	}
+
+	for _, i := range a.Indicators {
+		if i.Kind == KindFile {
+			techniques = append(techniques, techniqueIngressToolTransfer)
+			break
+		}
+	}
+
+	return techniques
+}
+
+// Bundle returns the bundle describing the activity, only the
+// indicators passed will be included as indicator objects.
+func (b *bundler) Bundle(a *Activity, indicators []Indicator, now time.Time) *Bundle {
+	objects := []Object{b.identity}
+
+	// observed data
+	observables := []Object{}
+	for _, i := range a.SortedIndicators() {
+		if o := observable(i); o != nil {
+			observables = append(observables, o)
+		}
+	}
+
+	for _, c := range a.SortedCredentials() {
+		observables = append(observables, credentialObservable(c))
+	}
+
+	refs := []string{}
+	for _, o := range observables {
+		refs = append(refs, o.ID())
+	}
+
+	observedData := Object{
+		"type":            "observed-data",
+		"spec_version":    specVersion,
+		"id":              randomID("observed-data"),
+		"created":         timestamp(now),
+		"modified":        timestamp(now),
+		"created_by_ref":  b.identity.ID(),
+		"first_observed":  timestamp(a.First),
+		"last_observed":   timestamp(a.Last),
+		"number_observed": a.Count,
+		"object_refs":     refs,
+	}
+
+	objects = append(objects, observables...)
+	objects = append(objects, observedData)
+
+	// attack patterns
+	attackPatterns := []Object{}
+	for _, t := range techniques(a) {
+		attackPatterns = append(attackPatterns, b.attackPattern(t, now))
+	}
+
+	objects = append(objects, attackPatterns...)
+
+	// malware samples
+	malware := map[string]Object{}
+	for _, i := range a.SortedIndicators() {
+		if i.Kind != KindFile {
+			continue
+		}
+
+		name := i.Name
+		if name == "" {
+			name = i.Value
+		}
+
+		m := Object{
+			"type":           "malware",
+			"spec_version":   specVersion,
+			"id":             deterministicID(namespaceHoneytrap, "malware", map[string]string{"sha256": i.Value}),
+			"created":        timestamp(now),
+			"modified":       timestamp(now),
+			"created_by_ref": b.identity.ID(),
+			"name":           name,
+			"is_family":      false,
+			"sample_refs":    []string{observable(i).ID()},
+		}
+
+		malware[i.Value] = m
+		objects = append(objects, m)
+	}
+
+	// indicators
+	for _, i := range indicators {
+		p := pattern(i)
+		if p == "" {
+			continue
+		}
+
+		indicator := Object{
+			"type":            "indicator",
+			"spec_version":    specVersion,
+			"id":              deterministicID(namespaceHoneytrap, "indicator", map[string]string{"pattern": p}),
+			"created":         timestamp(now),
+			"modified":        timestamp(now),
+			"created_by_ref":  b.identity.ID(),
+			"name":            fmt.Sprintf("%s %s", i.Kind, i.Value),
+			"indicator_types": []string{"malicious-activity"},
+			"pattern":         p,
+			"pattern_type":    "stix",
+			"valid_from":      timestamp(a.First),
+		}
+
+		objects = append(objects, indicator)
+		objects = append(objects, b.relationship("based-on", indicator, observedData, now))
+
+		switch i.Kind {
+		case KindIPv4, KindIPv6:
+			for _, ap := range attackPatterns {
+				objects = append(objects, b.relationship("indicates", indicator, ap, now))
+			}
+		case KindFile:
+			objects = append(objects, b.relationship("indicates", indicator, malware[i.Value], now))
+		}
+	}
+
+	return &Bundle{
+		Type:    "bundle",
+		ID:      randomID("bundle"),
+		Objects: objects,
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package stix
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MISPConfig configures the MISP instance events will be posted to.
+type MISPConfig struct {
+	URL      string `toml:"url"`
+	Key      string `toml:"key"`
+	Insecure bool   `toml:"insecure"`
+
+	// Distribution, ThreatLevel and Analysis are the MISP event
+	// distribution, threat_level_id and analysis levels.
+	Distribution int `toml:"distribution"`
+	ThreatLevel  int `toml:"threat_level"`
+	Analysis     int `toml:"analysis"`
+
+	Tags []string `toml:"tags"`
+}
+
+type mispAttribute struct {
+	Type     string `json:"type"`
+	Category string `json:"category"`
+	Value    string `json:"value"`
+	ToIDS    bool   `json:"to_ids"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type mispTag struct {
+	Name string `json:"name"`
+}
+
+type mispEvent struct {
+	Info          string          `json:"info"`
+	Date          string          `json:"date"`
+	Distribution  int             `json:"distribution,string"`
+	ThreatLevelID int             `json:"threat_level_id,string"`
+	Analysis      int             `json:"analysis,string"`
+	Attribute     []mispAttribute `json:"Attribute"`
+	Tag           []mispTag       `json:"Tag,omitempty"`
+}
+
+func mispIndicatorAttribute(i Indicator) (mispAttribute, bool) {
+	switch i.Kind {
+	case KindIPv4, KindIPv6:
+		return mispAttribute{Type: "ip-src", Category: "Network activity", Value: i.Value, ToIDS: true}, true
+	case KindURL:
+		return mispAttribute{Type: "url", Category: "Network activity", Value: i.Value, ToIDS: true}, true
+	case KindJA3:
+		return mispAttribute{Type: "ja3-fingerprint-md5", Category: "Network activity", Value: i.Value, ToIDS: true}, true
+	case KindUserAgent:
+		return mispAttribute{Type: "user-agent", Category: "Network activity", Value: i.Value}, true
+	case KindFile:
+		if i.Name == "" {
+			return mispAttribute{Type: "sha256", Category: "Payload delivery", Value: i.Value, ToIDS: true}, true
+		}
+
+		return mispAttribute{Type: "filename|sha256", Category: "Payload delivery", Value: i.Name + "|" + i.Value, ToIDS: true}, true
+	default:
+		return mispAttribute{}, false
+	}
+}
+
+// mispClient posts events to the MISP REST api.
+type mispClient struct {
+	MISPConfig
+
+	client *http.Client
+}
+
+func newMISPClient(c MISPConfig) *mispClient {
+	return &mispClient{
+		MISPConfig: c,
+		client: &http.Client{
+			Timeout: time.Second * 30,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: c.Insecure,
+				},
+			},
+		},
+	}
+}
+
+// Event returns the MISP event of the activity, only the indicators
+// passed will be added as attributes.
+func (mc *mispClient) Event(a *Activity, indicators []Indicator) *mispEvent {
+	e := &mispEvent{
+		Info:          fmt.Sprintf("Honeytrap: activity from %s", a.SourceIP),
+		Date:          a.First.UTC().Format("2006-01-02"),
+		Distribution:  mc.Distribution,
+		ThreatLevelID: mc.ThreatLevel,
+		Analysis:      mc.Analysis,
+		Attribute:     []mispAttribute{},
+		Tag:           []mispTag{},
+	}
+
+	for _, i := range indicators {
+		if attr, ok := mispIndicatorAttribute(i); ok {
+			e.Attribute = append(e.Attribute, attr)
+		}
+	}
+
+	if len(e.Attribute) == 0 {
+		return e
+	}
+
+	for _, c := range a.SortedCredentials() {
+		e.Attribute = append(e.Attribute, mispAttribute{
+			Type:     "text",
+			Category: "Other",
+			Value:    c.Username + ":" + c.Password,
+			Comment:  "credential tried by attacker",
+		})
+	}
+
+	for _, t := range mc.Tags {
+		e.Tag = append(e.Tag, mispTag{Name: t})
+	}
+
+	return e
+}
+
+// Post adds the event to MISP.
+func (mc *mispClient) Post(e *mispEvent) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"Event": e,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(mc.URL, "/")+"/events/add", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", mc.Key)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := mc.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("MISP returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
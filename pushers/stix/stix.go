@@ -0,0 +1,187 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package stix
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+
+	logging "github.com/op/go-logging"
+)
+
+var (
+	_ = pushers.Register("stix", New)
+)
+
+var log = logging.MustGetLogger("channels/stix")
+
+var (
+	ErrNoOutput = errors.New("Filename or misp url needs to be set")
+)
+
+const (
+	defaultWindow      = time.Minute * 5
+	defaultDedupWindow = time.Hour * 24
+)
+
+type Config struct {
+	// Filename bundles will be appended to, one bundle per line
+	Filename string `toml:"filename"`
+
+	// Window events of the same attacker will be aggregated in
+	Window config.Delay `toml:"window"`
+
+	// DedupWindow indicators won't be exported again within
+	DedupWindow config.Delay `toml:"dedup_window"`
+
+	// GroupBy is either attacker (source ip) or session
+	GroupBy string `toml:"group_by"`
+
+	// Identity is the name of the identity creating the objects
+	Identity string `toml:"identity"`
+
+	MISP MISPConfig `toml:"misp"`
+}
+
+// Backend exports attacker activity as STIX bundles and MISP events.
+type Backend struct {
+	Config
+	pushers.Counting
+
+	aggregator   *aggregator
+	deduplicator *deduplicator
+	bundler      *bundler
+	misp         *mispClient
+
+	f *os.File
+
+	ch chan event.Event
+}
+
+func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
+	c := Backend{
+		Config: Config{
+			Window:      config.Delay(defaultWindow),
+			DedupWindow: config.Delay(defaultDedupWindow),
+			GroupBy:     "attacker",
+			Identity:    "Honeytrap",
+			MISP: MISPConfig{
+				ThreatLevel: 3,
+			},
+		},
+		ch: make(chan event.Event, 100),
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(&c); err != nil {
+			return nil, err
+		}
+	}
+
+	switch c.GroupBy {
+	case "attacker", "session":
+	default:
+		return nil, fmt.Errorf("Unsupported group_by %s, expected attacker or session", c.GroupBy)
+	}
+
+	if c.Filename == "" && c.MISP.URL == "" {
+		return nil, ErrNoOutput
+	}
+
+	if c.Filename != "" {
+		f, err := os.OpenFile(c.Filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+
+		c.f = f
+	}
+
+	if c.MISP.URL != "" {
+		c.misp = newMISPClient(c.MISP)
+	}
+
+	c.aggregator = newAggregator(c.GroupBy)
+	c.deduplicator = newDeduplicator(c.DedupWindow.Duration())
+	c.bundler = newBundler(c.Identity, time.Now())
+
+	go c.run()
+
+	return &c, nil
+}
+
+func (b *Backend) run() {
+	interval := time.Second
+	if window := b.Window.Duration(); window < interval {
+		interval = window
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e := <-b.ch:
+			b.aggregator.Add(e, time.Now())
+		case now := <-ticker.C:
+			b.flush(now)
+		}
+	}
+}
+
+// flush exports the activities of which the window has passed.
+func (b *Backend) flush(now time.Time) {
+	for _, a := range b.aggregator.Flush(now, b.Window.Duration()) {
+		indicators := b.deduplicator.Filter(a.SortedIndicators(), now)
+
+		if b.f != nil {
+			bundle := b.bundler.Bundle(a, indicators, now)
+
+			if err := json.NewEncoder(b.f).Encode(bundle); err != nil {
+				log.Errorf("Error writing bundle: %s", err.Error())
+				b.CountFailed()
+			}
+		}
+
+		if b.misp == nil || len(indicators) == 0 {
+			continue
+		}
+
+		if err := b.misp.Post(b.misp.Event(a, indicators)); err != nil {
+			log.Errorf("Error posting MISP event: %s", err.Error())
+			b.CountFailed()
+		}
+	}
+}
+
+func (b *Backend) Send(e event.Event) {
+	select {
+	case b.ch <- e:
+	default:
+		log.Errorf("Could not send more messages, channel full")
+		b.CountDropped()
+	}
+}
+
+// QueueLen returns the number of events waiting in the channel buffer.
+func (b *Backend) QueueLen() int {
+	return len(b.ch)
+}
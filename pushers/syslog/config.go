@@ -0,0 +1,107 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package syslog
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrAddressNotSet = errors.New("Address has not been set")
+)
+
+var facilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+type Config struct {
+	// Network is one of udp, tcp or tls
+	Network string `toml:"network"`
+	Address string `toml:"address"`
+
+	// Format is one of rfc5424, cef or leef
+	Format string `toml:"format"`
+
+	// Framing of tcp and tls messages, octet-counting or non-transparent
+	Framing string `toml:"framing"`
+
+	Facility string `toml:"facility"`
+	Hostname string `toml:"hostname"`
+	AppName  string `toml:"app_name"`
+
+	// MaxSize is the maximum size of a message, larger messages will be
+	// truncated. It defaults to the largest udp datagram.
+	MaxSize int `toml:"max_size"`
+
+	Insecure   bool   `toml:"insecure"`
+	ServerName string `toml:"server_name"`
+
+	// Mapping maps event keys to CEF or LEEF extension keys, it will
+	// be merged with the default mapping.
+	Mapping map[string]string `toml:"mapping"`
+}
+
+func (c *Config) validate() error {
+	if c.Address == "" {
+		return ErrAddressNotSet
+	}
+
+	switch c.Network {
+	case "udp", "tcp", "tls":
+	default:
+		return fmt.Errorf("Unsupported network %s", c.Network)
+	}
+
+	switch c.Format {
+	case "rfc5424", "cef", "leef":
+	default:
+		return fmt.Errorf("Unsupported format %s", c.Format)
+	}
+
+	switch c.Framing {
+	case "octet-counting", "non-transparent":
+	default:
+		return fmt.Errorf("Unsupported framing %s", c.Framing)
+	}
+
+	if c.MaxSize <= 0 {
+		return fmt.Errorf("Invalid max_size %d", c.MaxSize)
+	}
+
+	if _, ok := facilities[c.Facility]; !ok {
+		return fmt.Errorf("Unsupported facility %s", c.Facility)
+	}
+
+	return nil
+}
@@ -0,0 +1,335 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package syslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/honeytrap/honeytrap/cmd"
+	"github.com/honeytrap/honeytrap/event"
+)
+
+const (
+	// enterprise number used for the structured data id
+	enterpriseNumber = "32473"
+
+	vendor  = "DutchSec"
+	product = "Honeytrap"
+)
+
+var (
+	defaultCEFMapping = map[string]string{
+		"source-ip":        "src",
+		"source-port":      "spt",
+		"source-mac":       "smac",
+		"destination-ip":   "dst",
+		"destination-port": "dpt",
+		"destination-mac":  "dmac",
+		"protocol":         "proto",
+		"category":         "cat",
+		"service":          "app",
+		"message":          "msg",
+		"date":             "rt",
+	}
+
+	defaultLEEFMapping = map[string]string{
+		"source-ip":        "src",
+		"source-port":      "srcPort",
+		"source-mac":       "srcMAC",
+		"destination-ip":   "dst",
+		"destination-port": "dstPort",
+		"destination-mac":  "dstMAC",
+		"protocol":         "proto",
+		"category":         "cat",
+		"date":             "devTime",
+	}
+)
+
+// formatValue returns the string representation of an event value.
+func formatValue(v interface{}) string {
+	switch vv := v.(type) {
+	case string:
+		return vv
+	case []byte:
+		return string(vv)
+	case time.Time:
+		return vv.UTC().Format(time.RFC3339Nano)
+	case error:
+		return vv.Error()
+	case fmt.Stringer:
+		return vv.String()
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, bool:
+		return fmt.Sprint(vv)
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+
+	return string(data)
+}
+
+// severity returns the syslog severity for the event.
+func severity(e event.Event) int {
+	switch e.Get("type") {
+	case "fatal":
+		return 2
+	case "error":
+		return 3
+	default:
+		return 6
+	}
+}
+
+// cefSeverity returns the CEF severity (0-10) for the event.
+func cefSeverity(e event.Event) int {
+	switch e.Get("type") {
+	case "fatal":
+		return 10
+	case "error":
+		return 7
+	default:
+		return 3
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+func eventID(e event.Event) string {
+	category := e.Get("category")
+	if t := e.Get("type"); t != "" {
+		return category + ":" + t
+	}
+
+	return category
+}
+
+// formatter formats the body of a syslog message.
+type formatter interface {
+	StructuredData(event.Event) string
+	Message(event.Event) string
+}
+
+// rfc5424Formatter puts all event fields in structured data.
+type rfc5424Formatter struct {
+}
+
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// sdName returns a valid SD-NAME, printable US-ASCII except '=', ' ', ']'
+// and '"', with a maximum length of 32 characters.
+func sdName(s string) string {
+	name := []byte{}
+	for i := 0; i < len(s) && len(name) < 32; i++ {
+		c := s[i]
+		if c <= 32 || c >= 127 || c == '=' || c == ']' || c == '"' {
+			c = '_'
+		}
+
+		name = append(name, c)
+	}
+
+	return string(name)
+}
+
+func (f *rfc5424Formatter) StructuredData(e event.Event) string {
+	m := event.ToMap(e)
+
+	sd := strings.Builder{}
+	sd.WriteString("[honeytrap@" + enterpriseNumber)
+
+	for _, k := range sortedKeys(m) {
+		sd.WriteString(" ")
+		sd.WriteString(sdName(k))
+		sd.WriteString(`="`)
+		sd.WriteString(sdEscaper.Replace(formatValue(m[k])))
+		sd.WriteString(`"`)
+	}
+
+	sd.WriteString("]")
+	return sd.String()
+}
+
+func (f *rfc5424Formatter) Message(e event.Event) string {
+	if message := e.Get("message"); message != "" {
+		return message
+	}
+
+	return fmt.Sprintf("Event with category %q of type %q for sensor %q occurred", e.Get("category"), e.Get("type"), e.Get("sensor"))
+}
+
+// cefFormatter formats events as ArcSight Common Event Format.
+type cefFormatter struct {
+	mapping map[string]string
+}
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+)
+
+func (f *cefFormatter) StructuredData(e event.Event) string {
+	return "-"
+}
+
+func (f *cefFormatter) Message(e event.Event) string {
+	m := event.ToMap(e)
+
+	extensions := []string{}
+	for _, k := range sortedKeys(m) {
+		name, ok := f.mapping[k]
+		if !ok {
+			continue
+		}
+
+		value := formatValue(m[k])
+		if t, ok := m[k].(time.Time); ok {
+			// CEF timestamps are milliseconds since epoch
+			value = fmt.Sprintf("%d", t.UnixNano()/int64(time.Millisecond))
+		}
+
+		extensions = append(extensions, fmt.Sprintf("%s=%s", name, cefExtensionEscaper.Replace(value)))
+	}
+
+	return fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|%s",
+		cefHeaderEscaper.Replace(vendor),
+		cefHeaderEscaper.Replace(product),
+		cefHeaderEscaper.Replace(cmd.Version),
+		cefHeaderEscaper.Replace(eventID(e)),
+		cefHeaderEscaper.Replace(e.Get("category")),
+		cefSeverity(e),
+		strings.Join(extensions, " "),
+	)
+}
+
+// leefFormatter formats events as IBM QRadar Log Event Extended Format.
+type leefFormatter struct {
+	mapping map[string]string
+}
+
+var (
+	leefHeaderEscaper    = strings.NewReplacer(`|`, `\|`, "\r", " ", "\n", " ")
+	leefAttributeEscaper = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+)
+
+func (f *leefFormatter) StructuredData(e event.Event) string {
+	return "-"
+}
+
+func (f *leefFormatter) Message(e event.Event) string {
+	m := event.ToMap(e)
+
+	attributes := []string{
+		fmt.Sprintf("sev=%d", cefSeverity(e)),
+	}
+
+	for _, k := range sortedKeys(m) {
+		name, ok := f.mapping[k]
+		if !ok {
+			continue
+		}
+
+		value := formatValue(m[k])
+		if t, ok := m[k].(time.Time); ok {
+			value = fmt.Sprintf("%d", t.UnixNano()/int64(time.Millisecond))
+		}
+
+		attributes = append(attributes, fmt.Sprintf("%s=%s", name, leefAttributeEscaper.Replace(value)))
+	}
+
+	return fmt.Sprintf("LEEF:1.0|%s|%s|%s|%s|%s",
+		leefHeaderEscaper.Replace(vendor),
+		leefHeaderEscaper.Replace(product),
+		leefHeaderEscaper.Replace(cmd.Version),
+		leefHeaderEscaper.Replace(eventID(e)),
+		strings.Join(attributes, "\t"),
+	)
+}
+
+func mergeMapping(defaults map[string]string, mapping map[string]string) map[string]string {
+	m := map[string]string{}
+	for k, v := range defaults {
+		m[k] = v
+	}
+
+	for k, v := range mapping {
+		if v == "" {
+			// allow removing default mappings
+			delete(m, k)
+			continue
+		}
+
+		m[k] = v
+	}
+
+	return m
+}
+
+func newFormatter(c Config) formatter {
+	switch c.Format {
+	case "cef":
+		return &cefFormatter{
+			mapping: mergeMapping(defaultCEFMapping, c.Mapping),
+		}
+	case "leef":
+		return &leefFormatter{
+			mapping: mergeMapping(defaultLEEFMapping, c.Mapping),
+		}
+	default:
+		return &rfc5424Formatter{}
+	}
+}
+
+// nilValue returns the RFC 5424 NILVALUE for empty header fields.
+func nilValue(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+// format returns the RFC 5424 syslog message for the event.
+func format(c Config, f formatter, e event.Event) []byte {
+	priority := facilities[c.Facility]*8 + severity(e)
+
+	date := time.Now()
+	if v, ok := event.ToMap(e)["date"].(time.Time); ok {
+		date = v
+	}
+
+	return []byte(fmt.Sprintf("<%d>1 %s %s %s %s %s %s %s",
+		priority,
+		date.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		nilValue(c.Hostname),
+		nilValue(c.AppName),
+		"-",
+		nilValue(sdName(e.Get("category"))),
+		f.StructuredData(e),
+		f.Message(e),
+	))
+}
@@ -0,0 +1,190 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package syslog
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"time"
+	"unicode/utf8"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+
+	logging "github.com/op/go-logging"
+)
+
+var (
+	_ = pushers.Register("syslog", New)
+)
+
+var log = logging.MustGetLogger("channels/syslog")
+
+const (
+	// maxDatagramSize is the largest payload of an udp datagram.
+	maxDatagramSize = 65507
+
+	// maxAttempts is the number of times writing a message is tried,
+	// before the message is dropped.
+	maxAttempts = 3
+)
+
+// Backend sends events to a syslog collector.
+type Backend struct {
+	Config
+	pushers.Counting
+
+	formatter formatter
+
+	ch chan event.Event
+}
+
+func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
+	hostname, _ := os.Hostname()
+
+	c := Backend{
+		Config: Config{
+			Network:  "udp",
+			Format:   "rfc5424",
+			Framing:  "octet-counting",
+			Facility: "local0",
+			Hostname: hostname,
+			AppName:  "honeytrap",
+			MaxSize:  maxDatagramSize,
+		},
+		ch: make(chan event.Event, 100),
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(&c); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	c.formatter = newFormatter(c.Config)
+
+	go c.run()
+
+	return &c, nil
+}
+
+func (b *Backend) dial() (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout: time.Second * 10,
+	}
+
+	switch b.Network {
+	case "tls":
+		return tls.DialWithDialer(dialer, "tcp", b.Address, &tls.Config{
+			InsecureSkipVerify: b.Insecure,
+			ServerName:         b.ServerName,
+		})
+	default:
+		return dialer.Dial(b.Network, b.Address)
+	}
+}
+
+// frame returns the message framed for the transport, datagrams are not framed.
+// Messages larger than the maximum size are truncated.
+func (b *Backend) frame(msg []byte) []byte {
+	if len(msg) > b.MaxSize {
+		n := b.MaxSize
+		for n > 0 && !utf8.RuneStart(msg[n]) {
+			n--
+		}
+
+		log.Warningf("Truncating message of %d bytes to %d bytes", len(msg), n)
+		msg = msg[:n]
+	}
+
+	if b.Network == "udp" {
+		return msg
+	}
+
+	if b.Framing == "non-transparent" {
+		return append(msg, '\n')
+	}
+
+	return append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+}
+
+func (b *Backend) run() {
+	// message that couldn't be written, will be retried after reconnecting
+	var pending []byte
+	attempts := 0
+
+	for {
+		func() {
+			conn, err := b.dial()
+			if err != nil {
+				log.Errorf("Error connecting to syslog server: %s: %s", b.Address, err.Error())
+				b.CountFailed()
+				return
+			}
+
+			log.Debugf("Connected to syslog server %s/%s", b.Network, b.Address)
+			defer log.Debugf("Connection to syslog server lost")
+
+			defer conn.Close()
+
+			for {
+				if pending == nil {
+					e := <-b.ch
+					pending = b.frame(format(b.Config, b.formatter, e))
+					attempts = 0
+				}
+
+				conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
+
+				if _, err := conn.Write(pending); err != nil {
+					log.Errorf("Could not write: %s", err.Error())
+					b.CountFailed()
+
+					if attempts++; attempts >= maxAttempts {
+						log.Errorf("Dropping message after %d attempts", attempts)
+						pending = nil
+					}
+
+					return
+				}
+
+				pending = nil
+			}
+		}()
+
+		time.Sleep(time.Second * 5)
+
+		log.Info("Connection lost. Reconnecting in 5 seconds.")
+	}
+}
+
+func (b *Backend) Send(e event.Event) {
+	select {
+	case b.ch <- e:
+	default:
+		log.Errorf("Could not send more messages, channel full")
+		b.CountDropped()
+	}
+}
+
+// QueueLen returns the number of events waiting in the channel buffer.
+func (b *Backend) QueueLen() int {
+	return len(b.ch)
+}
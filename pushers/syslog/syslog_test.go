@@ -0,0 +1,232 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package syslog
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+func newBackend(t *testing.T, config string) pushers.Channel {
+	s := struct {
+		P toml.Primitive
+	}{}
+
+	md, err := toml.Decode(config, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New(
+		pushers.WithConfig(s.P, &md),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func testEvent() event.Event {
+	return event.New(
+		event.Category("ssh"),
+		event.Type("password-authentication"),
+		event.SourceAddr(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}),
+		event.DestinationAddr(&net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 22}),
+		event.Custom("ssh.password", `pass=w|ord"]`),
+	)
+}
+
+func TestSyslogUDP(t *testing.T) {
+	l, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	c := newBackend(t, fmt.Sprintf(`
+[P]
+network="udp"
+address="%s"
+`, l.LocalAddr()))
+
+	c.Send(testEvent())
+
+	l.SetReadDeadline(time.Now().Add(time.Second * 5))
+
+	buff := make([]byte, 65535)
+	n, _, err := l.ReadFromUDP(buff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := string(buff[:n])
+
+	if !strings.HasPrefix(msg, "<134>1 ") {
+		t.Errorf("Expected priority 134 (local0.info), got %s", msg)
+	}
+
+	if !strings.Contains(msg, ` ssh [honeytrap@32473 `) {
+		t.Errorf("Expected structured data, got %s", msg)
+	}
+
+	if !strings.Contains(msg, `ssh.password="pass=w|ord\"\]"`) {
+		t.Errorf("Expected escaped structured data param, got %s", msg)
+	}
+}
+
+func TestSyslogTCPOctetCounting(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	c := newBackend(t, fmt.Sprintf(`
+[P]
+network="tcp"
+address="%s"
+format="cef"
+
+[P.mapping]
+"ssh.password" = "cs1"
+`, l.Addr()))
+
+	c.Send(testEvent())
+	c.Send(testEvent())
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+
+	r := bufio.NewReader(conn)
+
+	for i := 0; i < 2; i++ {
+		length, err := r.ReadString(' ')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(length))
+		if err != nil {
+			t.Fatalf("Expected octet count, got %q", length)
+		}
+
+		msg := make([]byte, n)
+		if _, err := r.Read(msg); err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(string(msg), "CEF:0|DutchSec|Honeytrap|") {
+			t.Errorf("Expected CEF message, got %s", msg)
+		}
+
+		if !strings.Contains(string(msg), `cs1=pass\=w|ord"]`) {
+			t.Errorf("Expected mapped and escaped extension, got %s", msg)
+		}
+
+		if !strings.Contains(string(msg), `src=10.0.0.1`) || !strings.Contains(string(msg), `dpt=22`) {
+			t.Errorf("Expected default mapping, got %s", msg)
+		}
+	}
+}
+
+func TestSyslogTruncate(t *testing.T) {
+	l, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	c := newBackend(t, fmt.Sprintf(`
+[P]
+network="udp"
+address="%s"
+max_size=256
+`, l.LocalAddr()))
+
+	c.Send(event.New(
+		event.Category("http"),
+		event.Custom("http.body", strings.Repeat("ä", 100000)),
+	))
+
+	l.SetReadDeadline(time.Now().Add(time.Second * 5))
+
+	buff := make([]byte, 65535)
+	n, _, err := l.ReadFromUDP(buff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n > 256 || n < 250 {
+		t.Errorf("Expected message to be truncated to 256 bytes, got %d bytes", n)
+	}
+
+	if !utf8.Valid(buff[:n]) {
+		t.Errorf("Expected message to be truncated on a character boundary")
+	}
+}
+
+func TestSyslogLEEF(t *testing.T) {
+	f := newFormatter(Config{
+		Format: "leef",
+	})
+
+	msg := f.Message(testEvent())
+
+	if !strings.HasPrefix(msg, "LEEF:1.0|DutchSec|Honeytrap|") {
+		t.Errorf("Expected LEEF message, got %s", msg)
+	}
+
+	if !strings.Contains(msg, "\tsrc=10.0.0.1") || !strings.Contains(msg, "\tdstPort=22") {
+		t.Errorf("Expected tab delimited attributes, got %q", msg)
+	}
+}
+
+func TestSyslogInvalidConfig(t *testing.T) {
+	s := struct {
+		P toml.Primitive
+	}{}
+
+	md, err := toml.Decode(`
+[P]
+address="127.0.0.1:514"
+format="xml"
+`, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(pushers.WithConfig(s.P, &md)); err == nil {
+		t.Error("Expected error for unsupported format")
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// TopicTemplate formats topics from event fields, fields are referenced
+// by name between braces, eg. "{sensor}/{category}/{type}".
+type TopicTemplate struct {
+	// parts alternates between literals (even) and field names (odd)
+	parts []string
+}
+
+// ParseTopicTemplate parses the topic template.
+func ParseTopicTemplate(s string) (*TopicTemplate, error) {
+	t := &TopicTemplate{}
+
+	for {
+		i := strings.IndexByte(s, '{')
+		if i == -1 {
+			if strings.IndexByte(s, '}') != -1 {
+				return nil, fmt.Errorf("Unexpected } in topic template")
+			}
+
+			t.parts = append(t.parts, s)
+			return t, nil
+		}
+
+		if strings.IndexByte(s[:i], '}') != -1 {
+			return nil, fmt.Errorf("Unexpected } in topic template")
+		}
+
+		j := strings.IndexByte(s[i:], '}')
+		if j == -1 {
+			return nil, fmt.Errorf("Unterminated field in topic template")
+		}
+
+		field := s[i+1 : i+j]
+		if field == "" {
+			return nil, fmt.Errorf("Empty field in topic template")
+		}
+
+		t.parts = append(t.parts, s[:i], field)
+		s = s[i+j+1:]
+	}
+}
+
+// Execute returns the topic for the event, the field values are passed
+// through escape to remove characters having a special meaning in topics.
+// Fields without a value are formatted as "unknown".
+func (t *TopicTemplate) Execute(e event.Event, escape func(string) string) string {
+	values := event.ToMap(e)
+
+	sb := strings.Builder{}
+
+	for i, part := range t.parts {
+		if i%2 == 0 {
+			sb.WriteString(part)
+			continue
+		}
+
+		v := "unknown"
+		if value, ok := values[part]; !ok {
+		} else if s := fmt.Sprintf("%v", value); s != "" {
+			v = s
+		}
+
+		sb.WriteString(escape(v))
+	}
+
+	return sb.String()
+}
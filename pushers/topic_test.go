@@ -0,0 +1,43 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package pushers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+func TestTopicTemplate(t *testing.T) {
+	tt, err := ParseTopicTemplate("honeytrap/{category}/{destination-port}/{type}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topic := tt.Execute(event.New(
+		event.Category("http/proxy"),
+		event.Custom("destination-port", 8080),
+	), strings.NewReplacer("/", "_").Replace)
+
+	if expected := "honeytrap/http_proxy/8080/unknown"; topic != expected {
+		t.Errorf("Expected topic %s, got %s", expected, topic)
+	}
+
+	for _, s := range []string{"{category", "category}", "a/{}/b", "}{category}"} {
+		if _, err := ParseTopicTemplate(s); err == nil {
+			t.Errorf("Expected error parsing %q", s)
+		}
+	}
+}
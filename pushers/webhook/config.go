@@ -0,0 +1,129 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+)
+
+var (
+	ErrURLNotSet = errors.New("URL has not been set")
+)
+
+const (
+	defaultBatchInterval = time.Second * 5
+	defaultRetryDelay    = time.Second
+	defaultMaxRetries    = 3
+	defaultTimeout       = time.Second * 10
+
+	// maxRetryAfter limits the delay requested by targets with the
+	// Retry-After header.
+	maxRetryAfter = time.Minute * 5
+
+	// limiterExpiry is the time limiters of targets are kept after
+	// their last request.
+	limiterExpiry = time.Minute * 10
+
+	defaultSignatureHeader = "X-Honeytrap-Signature"
+)
+
+type Config struct {
+	Method string `toml:"method"`
+
+	// URL is a template rendered with the same data as the body
+	URL string `toml:"url"`
+
+	Headers     map[string]string `toml:"headers"`
+	ContentType string            `toml:"content_type"`
+
+	// Body is a text/template rendered over the batch of events, it
+	// defaults to the json encoded event (or events when batching).
+	Body string `toml:"body"`
+
+	BatchSize     int          `toml:"batch_size"`
+	BatchInterval config.Delay `toml:"batch_interval"`
+
+	// Secret used to sign the body with HMAC-SHA256, the signature will
+	// be sent in SignatureHeader as sha256=<hex>.
+	Secret          string `toml:"hmac_secret"`
+	SignatureHeader string `toml:"hmac_header"`
+
+	MaxRetries int          `toml:"max_retries"`
+	RetryDelay config.Delay `toml:"retry_delay"`
+
+	// RateLimit is the maximum number of requests per second per target
+	RateLimit float64 `toml:"rate_limit"`
+	RateBurst int     `toml:"rate_burst"`
+
+	Timeout  config.Delay `toml:"timeout"`
+	Insecure bool         `toml:"insecure"`
+}
+
+var funcMap = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// templates parses the url and body templates.
+func (c *Config) templates() (*template.Template, *template.Template, error) {
+	if c.URL == "" {
+		return nil, nil, ErrURLNotSet
+	}
+
+	switch c.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodGet:
+	default:
+		return nil, nil, fmt.Errorf("Unsupported method %s", c.Method)
+	}
+
+	if c.BatchSize < 1 {
+		return nil, nil, fmt.Errorf("Batch size should be at least 1, got %d", c.BatchSize)
+	}
+
+	if c.MaxRetries < 0 {
+		return nil, nil, fmt.Errorf("Max retries should be at least 0, got %d", c.MaxRetries)
+	}
+
+	u, err := template.New("url").Funcs(funcMap).Parse(c.URL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error parsing url template: %s", err.Error())
+	}
+
+	body := c.Body
+	if body != "" {
+	} else if c.BatchSize > 1 {
+		body = "{{json .Events}}"
+	} else {
+		body = "{{json .Event}}"
+	}
+
+	b, err := template.New("body").Funcs(funcMap).Parse(body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error parsing body template: %s", err.Error())
+	}
+
+	return u, b, nil
+}
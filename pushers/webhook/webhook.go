@@ -0,0 +1,359 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+	"golang.org/x/time/rate"
+
+	logging "github.com/op/go-logging"
+)
+
+var (
+	_ = pushers.Register("webhook", New)
+)
+
+var log = logging.MustGetLogger("channels/webhook")
+
+// Batch is the data the url and body templates are rendered with.
+type Batch struct {
+	// Event is the first event of the batch
+	Event  map[string]interface{}
+	Events []map[string]interface{}
+	Count  int
+}
+
+// Backend sends events to a http endpoint.
+type Backend struct {
+	Config
+	pushers.Counting
+
+	url  *template.Template
+	body *template.Template
+
+	client *http.Client
+
+	// limiters per target host, deliver is called by both the run loop
+	// and Deliver
+	m        sync.Mutex
+	limiters map[string]*limiter
+	expired  time.Time
+
+	ch chan map[string]interface{}
+}
+
+func New(options ...func(pushers.Channel) error) (pushers.Channel, error) {
+	c := Backend{
+		Config: Config{
+			Method:          http.MethodPost,
+			ContentType:     "application/json",
+			BatchSize:       1,
+			BatchInterval:   config.Delay(defaultBatchInterval),
+			SignatureHeader: defaultSignatureHeader,
+			MaxRetries:      defaultMaxRetries,
+			RetryDelay:      config.Delay(defaultRetryDelay),
+			RateBurst:       1,
+			Timeout:         config.Delay(defaultTimeout),
+		},
+		limiters: map[string]*limiter{},
+		ch:       make(chan map[string]interface{}, 100),
+	}
+
+	for _, optionFn := range options {
+		if err := optionFn(&c); err != nil {
+			return nil, err
+		}
+	}
+
+	u, body, err := c.templates()
+	if err != nil {
+		return nil, err
+	}
+
+	c.url = u
+	c.body = body
+
+	c.client = &http.Client{
+		Timeout: c.Timeout.Duration(),
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: c.Insecure,
+			},
+		},
+	}
+
+	go c.run()
+
+	return &c, nil
+}
+
+func (b *Backend) run() {
+	batch := []map[string]interface{}{}
+
+	ticker := time.NewTicker(b.BatchInterval.Duration())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e := <-b.ch:
+			batch = append(batch, e)
+
+			if len(batch) < b.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+
+		if err := b.deliver(batch); err != nil {
+			log.Errorf("Error delivering %d events: %s", len(batch), err.Error())
+			b.CountFailed()
+		}
+
+		batch = []map[string]interface{}{}
+	}
+}
+
+type limiter struct {
+	*rate.Limiter
+
+	used time.Time
+}
+
+// limiter returns the rate limiter of the target, nil when rate limiting
+// has been disabled. Limiters of targets without requests for
+// limiterExpiry are removed, as targets are rendered from the events.
+func (b *Backend) limiter(target string) *rate.Limiter {
+	if b.RateLimit <= 0 {
+		return nil
+	}
+
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	now := time.Now()
+
+	if now.Sub(b.expired) >= limiterExpiry {
+		for k, l := range b.limiters {
+			if now.Sub(l.used) >= limiterExpiry {
+				delete(b.limiters, k)
+			}
+		}
+
+		b.expired = now
+	}
+
+	l, ok := b.limiters[target]
+	if !ok {
+		l = &limiter{
+			Limiter: rate.NewLimiter(rate.Limit(b.RateLimit), b.RateBurst),
+		}
+
+		b.limiters[target] = l
+	}
+
+	l.used = now
+	return l.Limiter
+}
+
+// retryAfter returns the delay requested with the Retry-After header, in
+// seconds or as http date.
+func retryAfter(resp *http.Response) time.Duration {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+
+	var d time.Duration
+
+	if seconds, err := strconv.Atoi(v); err == nil {
+		d = time.Duration(seconds) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = time.Until(t)
+	}
+
+	if d > maxRetryAfter {
+		d = maxRetryAfter
+	}
+
+	return d
+}
+
+// retryAfterBackOff waits the delay requested by the target, instead of
+// the next interval of the backoff.
+type retryAfterBackOff struct {
+	backoff.BackOff
+
+	after time.Duration
+}
+
+func (r *retryAfterBackOff) NextBackOff() time.Duration {
+	next := r.BackOff.NextBackOff()
+	if next == backoff.Stop || r.after <= 0 {
+		return next
+	}
+
+	next, r.after = r.after, 0
+	return next
+}
+
+// sign returns the HMAC-SHA256 signature of the body.
+func (b *Backend) sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(b.Secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver renders and sends the batch, retrying on network errors,
+// server errors and when being rate limited by the target.
+func (b *Backend) deliver(events []map[string]interface{}) error {
+	data := Batch{
+		Event:  events[0],
+		Events: events,
+		Count:  len(events),
+	}
+
+	target := bytes.Buffer{}
+	if err := b.url.Execute(&target, data); err != nil {
+		return err
+	}
+
+	u, err := url.Parse(target.String())
+	if err != nil {
+		return err
+	}
+
+	body := bytes.Buffer{}
+	if err := b.body.Execute(&body, data); err != nil {
+		return err
+	}
+
+	limiter := b.limiter(u.Host)
+
+	bo := &retryAfterBackOff{}
+
+	bo.BackOff = &backoff.ExponentialBackOff{
+		InitialInterval:     b.RetryDelay.Duration(),
+		RandomizationFactor: backoff.DefaultRandomizationFactor,
+		Multiplier:          backoff.DefaultMultiplier,
+		MaxInterval:         time.Minute,
+		MaxElapsedTime:      0,
+		Stop:                backoff.Stop,
+		Clock:               backoff.SystemClock,
+	}
+
+	return backoff.RetryNotify(func() error {
+		if limiter != nil {
+			limiter.Wait(context.Background())
+		}
+
+		req, err := http.NewRequest(b.Method, u.String(), bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+
+		req.Header.Set("Content-Type", b.ContentType)
+		req.Header.Set("User-Agent", "Honeytrap")
+
+		for k, v := range b.Headers {
+			req.Header.Set(k, v)
+		}
+
+		if b.Secret != "" {
+			req.Header.Set(b.SignatureHeader, b.sign(body.Bytes()))
+		}
+
+		resp, err := b.client.Do(req)
+		if err != nil {
+			return err
+		}
+
+		defer resp.Body.Close()
+
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1024*1024))
+
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusServiceUnavailable:
+			bo.after = retryAfter(resp)
+			return fmt.Errorf("%s returned status %d", u.Host, resp.StatusCode)
+		case resp.StatusCode >= 500:
+			return fmt.Errorf("%s returned status %d", u.Host, resp.StatusCode)
+		default:
+			return backoff.Permanent(fmt.Errorf("%s returned status %d", u.Host, resp.StatusCode))
+		}
+	}, backoff.WithMaxRetries(bo, uint64(b.MaxRetries)), func(err error, duration time.Duration) {
+		log.Errorf("Error %s, retrying in %v", err.Error(), duration)
+	})
+}
+
+// Deliver sends the events synchronously in batches, it is used when the
+// channel is spooled.
+func (b *Backend) Deliver(events []event.Event) error {
+	for len(events) > 0 {
+		n := b.BatchSize
+		if n > len(events) {
+			n = len(events)
+		}
+
+		batch := []map[string]interface{}{}
+		for _, e := range events[:n] {
+			batch = append(batch, event.ToMap(e))
+		}
+
+		if err := b.deliver(batch); err != nil {
+			return err
+		}
+
+		events = events[n:]
+	}
+
+	return nil
+}
+
+func (b *Backend) Send(e event.Event) {
+	select {
+	case b.ch <- event.ToMap(e):
+	default:
+		log.Errorf("Could not send more messages, channel full")
+		b.CountDropped()
+	}
+}
+
+// QueueLen returns the number of events waiting in the channel buffer.
+func (b *Backend) QueueLen() int {
+	return len(b.ch)
+}
@@ -0,0 +1,355 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+type request struct {
+	Path    string
+	Headers http.Header
+	Body    string
+}
+
+func newServer(statuses ...int) (*httptest.Server, chan request) {
+	requests := make(chan request, 10)
+
+	var count int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		requests <- request{
+			Path:    r.URL.Path,
+			Headers: r.Header,
+			Body:    string(body),
+		}
+
+		if n := int(atomic.AddInt32(&count, 1)) - 1; n < len(statuses) {
+			w.WriteHeader(statuses[n])
+		}
+	}))
+
+	return ts, requests
+}
+
+func newBackend(t *testing.T, config string) pushers.Channel {
+	s := struct {
+		P toml.Primitive
+	}{}
+
+	md, err := toml.Decode(config, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New(
+		pushers.WithConfig(s.P, &md),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func receive(t *testing.T, requests chan request) request {
+	select {
+	case r := <-requests:
+		return r
+	case <-time.After(time.Second * 5):
+		t.Fatal("Timeout waiting for request")
+	}
+
+	return request{}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	ts, requests := newServer()
+	defer ts.Close()
+
+	c := newBackend(t, fmt.Sprintf(`
+[P]
+url="%s/{{.Event.category}}"
+content_type="text/plain"
+body='{{.Event.category | upper}} from {{index .Event "source-ip"}}'
+hmac_secret="secret"
+
+[P.headers]
+Authorization="Bearer token"
+`, ts.URL))
+
+	c.Send(event.New(
+		event.Category("ssh"),
+		event.SourceIP([]byte{10, 0, 0, 1}),
+	))
+
+	r := receive(t, requests)
+
+	if r.Path != "/ssh" {
+		t.Errorf("Expected path /ssh, got %s", r.Path)
+	}
+
+	if r.Body != "SSH from 10.0.0.1" {
+		t.Errorf("Unexpected body %q", r.Body)
+	}
+
+	if r.Headers.Get("Authorization") != "Bearer token" {
+		t.Errorf("Expected authorization header, got %s", r.Headers.Get("Authorization"))
+	}
+
+	if r.Headers.Get("Content-Type") != "text/plain" {
+		t.Errorf("Expected content type text/plain, got %s", r.Headers.Get("Content-Type"))
+	}
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(r.Body))
+
+	if expected := "sha256=" + hex.EncodeToString(mac.Sum(nil)); r.Headers.Get("X-Honeytrap-Signature") != expected {
+		t.Errorf("Expected signature %s, got %s", expected, r.Headers.Get("X-Honeytrap-Signature"))
+	}
+}
+
+func TestWebhookBatch(t *testing.T) {
+	ts, requests := newServer()
+	defer ts.Close()
+
+	c := newBackend(t, fmt.Sprintf(`
+[P]
+url="%s"
+batch_size=2
+`, ts.URL))
+
+	c.Send(event.New(event.Category("ssh")))
+	c.Send(event.New(event.Category("http")))
+
+	r := receive(t, requests)
+
+	events := []map[string]interface{}{}
+	if err := json.Unmarshal([]byte(r.Body), &events); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 || events[0]["category"] != "ssh" || events[1]["category"] != "http" {
+		t.Errorf("Unexpected batch %s", r.Body)
+	}
+}
+
+func TestWebhookBatchInterval(t *testing.T) {
+	ts, requests := newServer()
+	defer ts.Close()
+
+	c := newBackend(t, fmt.Sprintf(`
+[P]
+url="%s"
+batch_size=10
+batch_interval="10ms"
+`, ts.URL))
+
+	c.Send(event.New(event.Category("ssh")))
+
+	r := receive(t, requests)
+
+	events := []map[string]interface{}{}
+	if err := json.Unmarshal([]byte(r.Body), &events); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 {
+		t.Errorf("Expected partial batch to be flushed, got %s", r.Body)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	ts, requests := newServer(http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK)
+	defer ts.Close()
+
+	c := newBackend(t, fmt.Sprintf(`
+[P]
+url="%s"
+retry_delay="1ms"
+`, ts.URL))
+
+	c.Send(event.New(event.Category("ssh")))
+
+	for i := 0; i < 3; i++ {
+		receive(t, requests)
+	}
+}
+
+func TestWebhookPermanentError(t *testing.T) {
+	ts, requests := newServer(http.StatusBadRequest)
+	defer ts.Close()
+
+	c := newBackend(t, fmt.Sprintf(`
+[P]
+url="%s"
+retry_delay="1ms"
+`, ts.URL)).(*Backend)
+
+	err := c.deliver([]map[string]interface{}{{"category": "ssh"}})
+	if err == nil {
+		t.Error("Expected error for bad request")
+	}
+
+	receive(t, requests)
+
+	select {
+	case <-requests:
+		t.Error("Expected bad request not to be retried")
+	default:
+	}
+}
+
+func TestWebhookRateLimit(t *testing.T) {
+	ts, requests := newServer()
+	defer ts.Close()
+
+	c := newBackend(t, fmt.Sprintf(`
+[P]
+url="%s"
+rate_limit=20.0
+`, ts.URL))
+
+	start := time.Now()
+
+	for i := 0; i < 3; i++ {
+		c.Send(event.New(event.Category("ssh")))
+	}
+
+	for i := 0; i < 3; i++ {
+		receive(t, requests)
+	}
+
+	// first request is allowed immediately, the others wait 50ms each
+	if d := time.Since(start); d < time.Millisecond*90 {
+		t.Errorf("Expected requests to be rate limited, took %s", d)
+	}
+}
+
+func TestWebhookInvalidTemplate(t *testing.T) {
+	s := struct {
+		P toml.Primitive
+	}{}
+
+	md, err := toml.Decode(`
+[P]
+url="http://127.0.0.1/"
+body="{{.Event"
+`, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(pushers.WithConfig(s.P, &md)); err == nil {
+		t.Error("Expected error for invalid body template")
+	}
+}
+
+func TestWebhookRetryAfter(t *testing.T) {
+	requests := make(chan time.Time, 10)
+
+	var count int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- time.Now()
+
+		if atomic.AddInt32(&count, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer ts.Close()
+
+	c := newBackend(t, fmt.Sprintf(`
+[P]
+url="%s"
+retry_delay="1ms"
+`, ts.URL)).(*Backend)
+
+	if err := c.deliver([]map[string]interface{}{{"category": "ssh"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	first, second := <-requests, <-requests
+
+	if d := second.Sub(first); d < time.Millisecond*900 {
+		t.Errorf("Expected retry after 1s, retried after %s", d)
+	}
+}
+
+func TestWebhookLimiterExpiry(t *testing.T) {
+	ts, _ := newServer()
+	defer ts.Close()
+
+	c := newBackend(t, fmt.Sprintf(`
+[P]
+url="%s"
+rate_limit=20.0
+`, ts.URL)).(*Backend)
+
+	c.limiter("first.example.org")
+
+	c.m.Lock()
+	c.limiters["first.example.org"].used = time.Now().Add(-limiterExpiry)
+	c.expired = time.Now().Add(-limiterExpiry)
+	c.m.Unlock()
+
+	c.limiter("second.example.org")
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if _, ok := c.limiters["first.example.org"]; ok {
+		t.Error("Expected limiter of idle target to be removed")
+	}
+
+	if _, ok := c.limiters["second.example.org"]; !ok {
+		t.Error("Expected limiter of target to be kept")
+	}
+}
+
+func TestWebhookNegativeMaxRetries(t *testing.T) {
+	s := struct {
+		P toml.Primitive
+	}{}
+
+	md, err := toml.Decode(`
+[P]
+url="http://127.0.0.1/"
+max_retries=-1
+`, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(pushers.WithConfig(s.P, &md)); err == nil {
+		t.Error("Expected error for negative max retries")
+	}
+}
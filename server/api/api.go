@@ -0,0 +1,335 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/honeytrap/honeytrap/cmd"
+	logging "github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("honeytrap/api")
+
+// Prefix is the path the api is mounted on.
+const Prefix = "/api/v1/"
+
+var (
+	ErrSessionNotFound  = errors.New("Session not found")
+	ErrAgentsDisabled   = errors.New("No agent listener configured")
+	ErrInvalidPublicKey = errors.New("Invalid agent public key")
+)
+
+// Port is a configured port and the services handling it.
+type Port struct {
+	Port      string   `json:"port"`
+	Services  []string `json:"services"`
+	Listeners []string `json:"listeners"`
+}
+
+// Service is a configured service.
+type Service struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Director string `json:"director,omitempty"`
+}
+
+// Channel is a configured channel.
+type Channel struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Normalize string `json:"normalize,omitempty"`
+	Spool     bool   `json:"spool"`
+}
+
+// Session is a connection being handled by a service.
+type Session struct {
+	ID          string    `json:"id"`
+	Service     string    `json:"service"`
+	Listener    string    `json:"listener,omitempty"`
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	Start       time.Time `json:"start"`
+}
+
+// Agent is an agent known by the agent listener.
+type Agent struct {
+	Name       string    `json:"name"`
+	PublicKey  string    `json:"public_key"`
+	Status     string    `json:"status"`
+	Version    string    `json:"version,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	FirstSeen  time.Time `json:"first_seen,omitempty"`
+	LastSeen   time.Time `json:"last_seen,omitempty"`
+}
+
+// Backend exposes the state of the running sensor.
+type Backend interface {
+	Ports() []Port
+	Services() []Service
+	Channels() []Channel
+
+	Sessions() []Session
+	KillSession(id string) error
+
+	Agents() ([]Agent, error)
+	RevokeAgent(publicKey string) error
+	DenyAgent(publicKey string) error
+
+	// Ready returns an error when the sensor isn't ready to handle connections
+	Ready() error
+}
+
+// Config contains the authentication of the api. Requests are authorized
+// by one of the bearer tokens, or by a client certificate verified by
+// the web server.
+type Config struct {
+	Enabled bool `toml:"enabled"`
+
+	Tokens []string `toml:"tokens"`
+
+	// ClientNames restricts the common names of the client certificates,
+	// all verified certificates are allowed when empty.
+	ClientNames []string `toml:"client_names"`
+}
+
+// API serves the management api.
+type API struct {
+	Config
+
+	backend Backend
+	events  *Recent
+
+	mux *http.ServeMux
+}
+
+// New returns the api for the backend, events are served from the recent events.
+func New(backend Backend, events *Recent, c Config) *API {
+	api := &API{
+		Config:  c,
+		backend: backend,
+		events:  events,
+		mux:     http.NewServeMux(),
+	}
+
+	api.mux.HandleFunc(Prefix+"health", api.health)
+	api.mux.HandleFunc(Prefix+"ready", api.ready)
+
+	api.mux.Handle(Prefix+"ports", api.authorized(api.ports))
+	api.mux.Handle(Prefix+"services", api.authorized(api.services))
+	api.mux.Handle(Prefix+"channels", api.authorized(api.channels))
+	api.mux.Handle(Prefix+"sessions", api.authorized(api.sessions))
+	api.mux.Handle(Prefix+"sessions/", api.authorized(api.session))
+	api.mux.Handle(Prefix+"events", api.authorized(api.recentEvents))
+	api.mux.Handle(Prefix+"agents", api.authorized(api.agents))
+	api.mux.Handle(Prefix+"agents/", api.authorized(api.agent))
+
+	return api
+}
+
+// HandleChannel mounts the api of the channel, eg. to query stored events.
+func (api *API) HandleChannel(name string, h http.Handler) {
+	prefix := Prefix + "channels/" + name
+
+	api.mux.Handle(prefix+"/", api.authorized(http.StripPrefix(prefix, h).ServeHTTP))
+}
+
+func (api *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mux.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("Error encoding response: %s", err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{
+		"error": err.Error(),
+	})
+}
+
+// method returns false and writes an error when the request method isn't allowed.
+func method(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %s not allowed", r.Method))
+	return false
+}
+
+func (api *API) health(w http.ResponseWriter, r *http.Request) {
+	if !method(w, r, http.MethodGet) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"status":  "ok",
+		"version": cmd.Version,
+	})
+}
+
+func (api *API) ready(w http.ResponseWriter, r *http.Request) {
+	if !method(w, r, http.MethodGet) {
+		return
+	}
+
+	if err := api.backend.Ready(); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{
+			"status": "not ready",
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"status": "ready",
+	})
+}
+
+func (api *API) ports(w http.ResponseWriter, r *http.Request) {
+	if !method(w, r, http.MethodGet) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, api.backend.Ports())
+}
+
+func (api *API) services(w http.ResponseWriter, r *http.Request) {
+	if !method(w, r, http.MethodGet) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, api.backend.Services())
+}
+
+func (api *API) channels(w http.ResponseWriter, r *http.Request) {
+	if !method(w, r, http.MethodGet) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, api.backend.Channels())
+}
+
+func (api *API) sessions(w http.ResponseWriter, r *http.Request) {
+	if !method(w, r, http.MethodGet) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, api.backend.Sessions())
+}
+
+// session kills the session.
+func (api *API) session(w http.ResponseWriter, r *http.Request) {
+	if !method(w, r, http.MethodDelete) {
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, Prefix+"sessions/")
+
+	if err := api.backend.KillSession(id); err == ErrSessionNotFound {
+		writeError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) recentEvents(w http.ResponseWriter, r *http.Request) {
+	if !method(w, r, http.MethodGet) {
+		return
+	}
+
+	q, err := ParseQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, api.events.Find(q))
+}
+
+func agentError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrAgentsDisabled:
+		writeError(w, http.StatusNotFound, err)
+	case ErrInvalidPublicKey:
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func (api *API) agents(w http.ResponseWriter, r *http.Request) {
+	if !method(w, r, http.MethodGet) {
+		return
+	}
+
+	agents, err := api.backend.Agents()
+	if err != nil {
+		agentError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, agents)
+}
+
+// agent revokes or denies the agent, by posting to
+// agents/{public key}/revoke or agents/{public key}/deny.
+func (api *API) agent(w http.ResponseWriter, r *http.Request) {
+	if !method(w, r, http.MethodPost) {
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, Prefix+"agents/"), "/")
+	if len(parts) != 2 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("Path %s not found", r.URL.Path))
+		return
+	}
+
+	var err error
+
+	switch parts[1] {
+	case "revoke":
+		err = api.backend.RevokeAgent(parts[0])
+	case "deny":
+		err = api.backend.DenyAgent(parts[0])
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("Path %s not found", r.URL.Path))
+		return
+	}
+
+	if err != nil {
+		agentError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
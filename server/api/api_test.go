@@ -0,0 +1,372 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+type backend struct {
+	sessions map[string]Session
+	agents   map[string]Agent
+	ready    bool
+}
+
+func (b *backend) Ports() []Port {
+	return []Port{
+		{Port: "tcp/:22", Services: []string{"ssh"}, Listeners: []string{"default"}},
+	}
+}
+
+func (b *backend) Services() []Service {
+	return []Service{{Name: "ssh", Type: "ssh-simulator"}}
+}
+
+func (b *backend) Channels() []Channel {
+	return []Channel{{Name: "console", Type: "console"}}
+}
+
+func (b *backend) Sessions() []Session {
+	sessions := []Session{}
+	for _, s := range b.sessions {
+		sessions = append(sessions, s)
+	}
+
+	return sessions
+}
+
+func (b *backend) KillSession(id string) error {
+	if _, ok := b.sessions[id]; !ok {
+		return ErrSessionNotFound
+	}
+
+	delete(b.sessions, id)
+	return nil
+}
+
+func (b *backend) Agents() ([]Agent, error) {
+	agents := []Agent{}
+	for _, a := range b.agents {
+		agents = append(agents, a)
+	}
+
+	return agents, nil
+}
+
+func (b *backend) setAgentStatus(publicKey, status string) error {
+	a, ok := b.agents[publicKey]
+	if !ok {
+		return ErrInvalidPublicKey
+	}
+
+	a.Status = status
+	b.agents[publicKey] = a
+	return nil
+}
+
+func (b *backend) RevokeAgent(publicKey string) error {
+	return b.setAgentStatus(publicKey, "revoked")
+}
+
+func (b *backend) DenyAgent(publicKey string) error {
+	return b.setAgentStatus(publicKey, "denied")
+}
+
+func (b *backend) Ready() error {
+	if !b.ready {
+		return ErrUnauthorized
+	}
+
+	return nil
+}
+
+func newAPI() (*API, *backend, *Recent) {
+	b := &backend{
+		sessions: map[string]Session{
+			"abc": {ID: "abc", Service: "ssh"},
+		},
+		agents: map[string]Agent{
+			"ab01": {Name: "sensor-1", PublicKey: "ab01", Status: "allowed"},
+		},
+	}
+
+	recent := NewRecent(3)
+
+	return New(b, recent, Config{
+		Enabled: true,
+		Tokens:  []string{"s3cr3t"},
+	}), b, recent
+}
+
+func request(h http.Handler, method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAPIAuthorization(t *testing.T) {
+	api, _, _ := newAPI()
+
+	for _, path := range []string{"ports", "services", "channels", "sessions", "events", "agents"} {
+		if rec := request(api, "GET", Prefix+path, ""); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected %s without token to be unauthorized, got %d", path, rec.Code)
+		}
+
+		if rec := request(api, "GET", Prefix+path, "wrong"); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected %s with wrong token to be unauthorized, got %d", path, rec.Code)
+		}
+
+		if rec := request(api, "GET", Prefix+path, "s3cr3t"); rec.Code != http.StatusOK {
+			t.Errorf("Expected %s with token to be served, got %d", path, rec.Code)
+		}
+	}
+
+	if rec := request(api, "GET", Prefix+"health", ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected health to be served without token, got %d", rec.Code)
+	}
+}
+
+func TestAPIReady(t *testing.T) {
+	api, b, _ := newAPI()
+
+	if rec := request(api, "GET", Prefix+"ready", ""); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected not ready, got %d", rec.Code)
+	}
+
+	b.ready = true
+
+	if rec := request(api, "GET", Prefix+"ready", ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected ready, got %d", rec.Code)
+	}
+}
+
+func TestAPIKillSession(t *testing.T) {
+	api, b, _ := newAPI()
+
+	if rec := request(api, "GET", Prefix+"sessions/abc", "s3cr3t"); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected method not allowed, got %d", rec.Code)
+	}
+
+	if rec := request(api, "DELETE", Prefix+"sessions/abc", "s3cr3t"); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected session to be killed, got %d", rec.Code)
+	}
+
+	if len(b.sessions) != 0 {
+		t.Errorf("Expected session to be removed")
+	}
+
+	if rec := request(api, "DELETE", Prefix+"sessions/abc", "s3cr3t"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected unknown session, got %d", rec.Code)
+	}
+}
+
+func TestAPIEvents(t *testing.T) {
+	api, _, recent := newAPI()
+
+	for i := 0; i < 4; i++ {
+		category := "ssh"
+		if i%2 == 1 {
+			category = "telnet"
+		}
+
+		recent.Send(event.New(
+			event.Category(category),
+			event.Custom("index", i),
+		))
+	}
+
+	rec := request(api, "GET", Prefix+"events?category=ssh", "s3cr3t")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected events, got %d", rec.Code)
+	}
+
+	result := struct {
+		Total  int                      `json:"total"`
+		Events []map[string]interface{} `json:"events"`
+	}{}
+
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first event has been dropped from the buffer
+	if result.Total != 1 || result.Events[0]["index"] != float64(2) {
+		t.Errorf("Expected ssh event 2, got %+v", result)
+	}
+
+	if r := recent.Find(Query{Limit: 2}); r.Total != 3 || len(r.Events) != 2 {
+		t.Errorf("Expected 2 of 3 events, got %d of %d", len(r.Events), r.Total)
+	} else if r.Events[0]["index"] != 3 {
+		t.Errorf("Expected newest event first, got %v", r.Events[0]["index"])
+	}
+
+	if rec := request(api, "GET", Prefix+"events?limit=-1", "s3cr3t"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected invalid limit to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestAPIClientCertificate(t *testing.T) {
+	api, _, _ := newAPI()
+	api.ClientNames = []string{"sensor-admin"}
+
+	ca, caKey := certificate(t, "ca", nil, nil)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	server := httptest.NewUnstartedServer(api)
+	server.TLS = &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: tls.VerifyClientCertIfGiven,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	for name, expected := range map[string]int{
+		"sensor-admin": http.StatusOK,
+		"other":        http.StatusUnauthorized,
+	} {
+		cert, key := certificate(t, name, ca, caKey)
+
+		// new transport for every client, connections shouldn't be reused
+		transport := server.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = []tls.Certificate{{
+			Certificate: [][]byte{cert.Raw},
+			PrivateKey:  key,
+		}}
+
+		client := &http.Client{Transport: transport}
+
+		resp, err := client.Get(server.URL + Prefix + "ports")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+
+		if resp.StatusCode != expected {
+			t.Errorf("Expected %d for client %s, got %d", expected, name, resp.StatusCode)
+		}
+	}
+}
+
+// certificate returns a certificate signed by the parent, or a self
+// signed ca certificate when parent is nil.
+func certificate(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+func TestAPIAgents(t *testing.T) {
+	api, b, _ := newAPI()
+
+	if rec := request(api, "POST", Prefix+"agents/ab01/revoke", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected revoke without token to be unauthorized, got %d", rec.Code)
+	}
+
+	if rec := request(api, "GET", Prefix+"agents/ab01/revoke", "s3cr3t"); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET to be not allowed, got %d", rec.Code)
+	}
+
+	if rec := request(api, "POST", Prefix+"agents/ab01/unknown", "s3cr3t"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected unknown action to be not found, got %d", rec.Code)
+	}
+
+	if rec := request(api, "POST", Prefix+"agents/cd02/deny", "s3cr3t"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected invalid public key to be a bad request, got %d", rec.Code)
+	}
+
+	if rec := request(api, "POST", Prefix+"agents/ab01/revoke", "s3cr3t"); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected agent to be revoked, got %d", rec.Code)
+	}
+
+	if b.agents["ab01"].Status != "revoked" {
+		t.Errorf("Expected status revoked, got %s", b.agents["ab01"].Status)
+	}
+}
+
+func TestAPIHandleChannel(t *testing.T) {
+	api, _, _ := newAPI()
+
+	api.HandleChannel("sqlite", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, r.URL.Path)
+	}))
+
+	if rec := request(api, "GET", Prefix+"channels/sqlite/events", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected channel api without token to be unauthorized, got %d", rec.Code)
+	}
+
+	rec := request(api, "GET", Prefix+"channels/sqlite/events", "s3cr3t")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected channel api with token to be served, got %d", rec.Code)
+	}
+
+	path := ""
+	if err := json.NewDecoder(rec.Body).Decode(&path); err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/events" {
+		t.Errorf("Expected path /events, got %s", path)
+	}
+}
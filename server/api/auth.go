@@ -0,0 +1,74 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrUnauthorized = errors.New("Unauthorized")
+)
+
+// authorize returns whether the request has a valid bearer token
+// or verified client certificate.
+func (api *API) authorize(r *http.Request) bool {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		if len(api.ClientNames) == 0 {
+			return true
+		}
+
+		cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+
+		for _, name := range api.ClientNames {
+			if name == cn {
+				return true
+			}
+		}
+	}
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+
+	token := []byte(strings.TrimPrefix(auth, "Bearer "))
+
+	for _, t := range api.Tokens {
+		if t == "" {
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(t), token) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (api *API) authorized(fn http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !api.authorize(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="honeytrap"`)
+			writeError(w, http.StatusUnauthorized, ErrUnauthorized)
+			return
+		}
+
+		fn(w, r)
+	})
+}
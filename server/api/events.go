@@ -0,0 +1,162 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+const (
+	defaultLimit = 100
+)
+
+// Recent keeps the most recent events, it should be subscribed
+// to the event bus.
+type Recent struct {
+	m sync.Mutex
+
+	events []map[string]interface{}
+	next   int
+	count  int
+}
+
+// NewRecent returns a buffer keeping the last size events.
+func NewRecent(size int) *Recent {
+	return &Recent{
+		events: make([]map[string]interface{}, size),
+	}
+}
+
+// Send stores the event.
+func (r *Recent) Send(e event.Event) {
+	values := event.ToMap(e)
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if len(r.events) == 0 {
+		return
+	}
+
+	r.events[r.next] = values
+	r.next = (r.next + 1) % len(r.events)
+
+	if r.count < len(r.events) {
+		r.count++
+	}
+}
+
+// Query filters the recent events.
+type Query struct {
+	// Fields contains the values events need to match
+	Fields map[string]string
+
+	Since time.Time
+	Limit int
+}
+
+// ParseQuery parses the query parameters, all parameters except
+// since and limit filter on event fields.
+//
+//	GET /events?category=ssh&source-ip=10.0.0.1&since=2019-01-01T00:00:00Z&limit=10
+func ParseQuery(v url.Values) (Query, error) {
+	q := Query{
+		Fields: map[string]string{},
+		Limit:  defaultLimit,
+	}
+
+	for key := range v {
+		value := v.Get(key)
+
+		switch key {
+		case "since":
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return q, fmt.Errorf("Invalid since %s: %s", value, err.Error())
+			}
+
+			q.Since = t
+		case "limit":
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit <= 0 {
+				return q, fmt.Errorf("Invalid limit %s", value)
+			}
+
+			q.Limit = limit
+		default:
+			q.Fields[key] = value
+		}
+	}
+
+	return q, nil
+}
+
+func (q Query) match(values map[string]interface{}) bool {
+	if !q.Since.IsZero() {
+		if t, ok := values["date"].(time.Time); !ok || t.Before(q.Since) {
+			return false
+		}
+	}
+
+	for key, expected := range q.Fields {
+		v, ok := values[key]
+		if !ok {
+			return false
+		}
+
+		if fmt.Sprintf("%v", v) != expected {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Result contains the matched events, newest first.
+type Result struct {
+	Total  int                      `json:"total"`
+	Events []map[string]interface{} `json:"events"`
+}
+
+// Find returns the recent events matching the query.
+func (r *Recent) Find(q Query) Result {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	result := Result{
+		Events: []map[string]interface{}{},
+	}
+
+	for i := 1; i <= r.count; i++ {
+		values := r.events[(r.next-i+len(r.events))%len(r.events)]
+
+		if !q.match(values) {
+			continue
+		}
+
+		result.Total++
+
+		if len(result.Events) < q.Limit {
+			result.Events = append(result.Events, values)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,99 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/server/api"
+)
+
+// Problem is a problem found while configuring honeytrap.
+type Problem struct {
+	Position config.Position
+	Warning  bool
+	Message  string
+}
+
+func (p Problem) String() string {
+	level := "error"
+	if p.Warning {
+		level = "warning"
+	}
+
+	return fmt.Sprintf("%s: %s: %s", p.Position, level, p.Message)
+}
+
+// CheckResult contains the problems of the configuration and
+// the resolved ports.
+type CheckResult struct {
+	Problems []Problem
+	Ports    []api.Port
+}
+
+// errorf logs and records a configuration error of the key.
+func (hc *Honeytrap) errorf(key []string, format string, a ...interface{}) {
+	p := Problem{
+		Position: hc.config.Position(key...),
+		Message:  fmt.Sprintf(format, a...),
+	}
+
+	hc.problems = append(hc.problems, p)
+
+	log.Errorf("%s: %s", p.Position, p.Message)
+}
+
+// warningf logs and records a configuration warning of the key.
+func (hc *Honeytrap) warningf(key []string, format string, a ...interface{}) {
+	p := Problem{
+		Position: hc.config.Position(key...),
+		Warning:  true,
+		Message:  fmt.Sprintf(format, a...),
+	}
+
+	hc.problems = append(hc.problems, p)
+
+	log.Warningf("%s: %s", p.Position, p.Message)
+}
+
+// fatalf records the error when checking, honeytrap will exit otherwise.
+func (hc *Honeytrap) fatalf(key []string, format string, a ...interface{}) {
+	if !hc.check {
+		log.Fatalf("%s: %s", hc.config.Position(key...), fmt.Sprintf(format, a...))
+	}
+
+	hc.errorf(key, format, a...)
+}
+
+// Check configures the directors, services and listeners without starting
+// them, so no sockets will be bound and no containers will be touched.
+// Channels aren't initialized, as they connect or create files.
+func (hc *Honeytrap) Check() *CheckResult {
+	hc.check = true
+
+	hc.configure()
+
+	problems := append([]Problem{}, hc.problems...)
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Position.Line < problems[j].Position.Line
+	})
+
+	return &CheckResult{
+		Problems: problems,
+		Ports:    hc.Ports(),
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/pushers"
+)
+
+// ConnOptions keeps the event options of the connections being handled. Events
+// sent by services for one of these connections will be enriched with the
+// options of the connection, eg. the proxy or agent the connection came through.
+type ConnOptions struct {
+	m sync.Map
+}
+
+func connOptionsKey(sourceIP, sourcePort, destinationPort interface{}) string {
+	return fmt.Sprintf("%v|%v|%v", sourceIP, sourcePort, destinationPort)
+}
+
+func addrIPPort(addr net.Addr) (string, int) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP.String(), a.Port
+	case *net.UDPAddr:
+		return a.IP.String(), a.Port
+	default:
+		return "", 0
+	}
+}
+
+// Add registers the options of the connection, the returned function
+// unregisters them again.
+func (co *ConnOptions) Add(conn net.Conn) func() {
+	ec, ok := conn.(*event.Conn)
+	if !ok {
+		return func() {}
+	}
+
+	sourceIP, sourcePort := addrIPPort(conn.RemoteAddr())
+	_, destinationPort := addrIPPort(conn.LocalAddr())
+
+	key := connOptionsKey(sourceIP, sourcePort, destinationPort)
+
+	co.m.Store(key, ec.Options())
+
+	return func() {
+		co.m.Delete(key)
+	}
+}
+
+// Get returns the options of the connection the event belongs to.
+func (co *ConnOptions) Get(e event.Event) (event.Option, bool) {
+	values := event.ToMap(e)
+
+	key := connOptionsKey(values["source-ip"], values["source-port"], values["destination-port"])
+
+	v, ok := co.m.Load(key)
+	if !ok {
+		return nil, false
+	}
+
+	return v.(event.Option), true
+}
+
+// Channel returns a channel that enriches events before sending them to ch.
+func (co *ConnOptions) Channel(ch pushers.Channel) pushers.Channel {
+	return &connOptionsChannel{
+		Channel: ch,
+		co:      co,
+	}
+}
+
+type connOptionsChannel struct {
+	pushers.Channel
+
+	co *ConnOptions
+}
+
+func (c *connOptionsChannel) Send(e event.Event) {
+	if option, ok := c.co.Get(e); ok {
+		e = event.Apply(e, option)
+	}
+
+	c.Channel.Send(e)
+}
@@ -0,0 +1,228 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/director"
+	"github.com/honeytrap/honeytrap/event"
+)
+
+var (
+	ErrDirectorTimeout = errors.New("director timed out")
+)
+
+// maxHandover is the amount of data read by a service, the connection can't
+// be handed over when the service has read more.
+const maxHandover = 64 * 1024
+
+// fallback is the chain of services that will handle connections of a port,
+// when the director of the service failed. Connections are only handed over
+// when the service didn't write to them before dialing its director, so
+// services like ssh-proxy, which dial after the ssh handshake, never fall
+// back.
+type fallback struct {
+	Services []*ServiceMap
+	Timeout  time.Duration
+}
+
+func (hc *Honeytrap) findFallback(conn net.Conn) *fallback {
+	for k, fb := range hc.fallbacks {
+		if compareAddr(k, conn.LocalAddr()) {
+			return fb
+		}
+	}
+
+	return nil
+}
+
+// handoverConn is the connection handed to services having a fallback. The
+// data read is being kept, so the connection can be handed over to the
+// fallback service when the director of the service failed, as long as the
+// service didn't write to the connection.
+type handoverConn struct {
+	net.Conn
+
+	timeout time.Duration
+
+	m        sync.Mutex
+	buffer   []byte
+	overflow bool
+	written  bool
+	err      error
+}
+
+func newHandoverConn(conn net.Conn, timeout time.Duration) *handoverConn {
+	return &handoverConn{
+		Conn:    conn,
+		timeout: timeout,
+		buffer:  []byte{},
+	}
+}
+
+func (c *handoverConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	switch {
+	case c.overflow:
+	case len(c.buffer)+n > maxHandover:
+		c.overflow = true
+		c.buffer = nil
+	default:
+		c.buffer = append(c.buffer, b[:n]...)
+	}
+
+	return n, err
+}
+
+func (c *handoverConn) Write(b []byte) (int, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	// the connection will be handed over
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.written = true
+	}
+
+	return n, err
+}
+
+// Close keeps the connection open when it will be handed over.
+func (c *handoverConn) Close() error {
+	if c.handoverable() {
+		return nil
+	}
+
+	return c.Conn.Close()
+}
+
+func (c *handoverConn) handoverable() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.err != nil && !c.written && !c.overflow
+}
+
+// fail records the failure of the director.
+func (c *handoverConn) fail(err error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.err = err
+}
+
+// handover returns the connection for the fallback service, replaying the
+// data read, and the error of the director. The connection is nil when it
+// can't be handed over.
+func (c *handoverConn) handover() (net.Conn, error) {
+	if !c.handoverable() {
+		return nil, nil
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return &peekConnection{
+		Conn:   c.Conn,
+		buffer: c.buffer,
+	}, c.err
+}
+
+// fallbackDirector records failures of the director, for connections that can
+// be handed over to a fallback service.
+type fallbackDirector struct {
+	director.Director
+}
+
+func (d *fallbackDirector) Dial(conn net.Conn) (net.Conn, error) {
+	hc, ok := conn.(*handoverConn)
+	if !ok {
+		return d.Director.Dial(conn)
+	}
+
+	c, err := d.dial(conn, hc.timeout)
+	if err != nil {
+		hc.fail(err)
+	}
+
+	return c, err
+}
+
+func (d *fallbackDirector) dial(conn net.Conn, timeout time.Duration) (net.Conn, error) {
+	if timeout == 0 {
+		return d.Director.Dial(conn)
+	}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+
+	ch := make(chan result, 1)
+
+	go func() {
+		c, err := d.Director.Dial(conn)
+		ch <- result{c, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.conn, r.err
+	case <-time.After(timeout):
+		go func() {
+			// connection has been established too late
+			if r := <-ch; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+
+		return nil, ErrDirectorTimeout
+	}
+}
+
+// FallbackEvent returns the event of a connection being handed over to the
+// fallback service.
+func FallbackEvent(conn net.Conn, from, to string, err error) event.Event {
+	reason := "failed"
+
+	switch err {
+	case director.ErrPoolExhausted, director.ErrSessionLimit:
+		reason = "saturated"
+	case ErrDirectorTimeout:
+		reason = "timeout"
+	}
+
+	return event.New(
+		event.ServiceSensor,
+		event.ServiceFallback,
+		event.SourceAddr(conn.RemoteAddr()),
+		event.DestinationAddr(conn.LocalAddr()),
+		event.Custom("service", from),
+		event.Custom("fallback", to),
+		event.Custom("reason", reason),
+		event.Error(err),
+	)
+}
@@ -17,12 +17,16 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/mattn/go-isatty"
 
 	"github.com/fatih/color"
@@ -31,14 +35,16 @@ import (
 	"github.com/honeytrap/honeytrap/config"
 
 	"github.com/honeytrap/honeytrap/director"
+	_ "github.com/honeytrap/honeytrap/director/docker"
 	_ "github.com/honeytrap/honeytrap/director/forward"
 	_ "github.com/honeytrap/honeytrap/director/lxc"
+	_ "github.com/honeytrap/honeytrap/director/qemu"
 
-	// _ "github.com/honeytrap/honeytrap/director/qemu"
 	// Import your directors here.
 
 	"github.com/honeytrap/honeytrap/pushers"
 	"github.com/honeytrap/honeytrap/pushers/eventbus"
+	"github.com/honeytrap/honeytrap/pushers/spool"
 
 	"github.com/honeytrap/honeytrap/services"
 	_ "github.com/honeytrap/honeytrap/services/bannerfmt"
@@ -58,6 +64,9 @@ import (
 	"github.com/honeytrap/honeytrap/listener"
 	_ "github.com/honeytrap/honeytrap/listener/agent"
 	_ "github.com/honeytrap/honeytrap/listener/canary"
+	"github.com/honeytrap/honeytrap/metrics"
+	"github.com/honeytrap/honeytrap/server/api"
+	"github.com/honeytrap/honeytrap/web"
 
 	//_ "github.com/honeytrap/honeytrap/listener/netstack"
 	//_ "github.com/honeytrap/honeytrap/listener/netstack-experimental"
@@ -68,20 +77,29 @@ import (
 	// proxies
 
 	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/event/ecs"
 	"github.com/honeytrap/honeytrap/server/profiler"
 
 	_ "github.com/honeytrap/honeytrap/pushers/console"
 	_ "github.com/honeytrap/honeytrap/pushers/dshield"
 	_ "github.com/honeytrap/honeytrap/pushers/elasticsearch"
 	_ "github.com/honeytrap/honeytrap/pushers/file"
+	_ "github.com/honeytrap/honeytrap/pushers/hpfeeds"
 	_ "github.com/honeytrap/honeytrap/pushers/kafka"
 	_ "github.com/honeytrap/honeytrap/pushers/lumberjack"
 	_ "github.com/honeytrap/honeytrap/pushers/marija"
+	_ "github.com/honeytrap/honeytrap/pushers/mqtt"
+	_ "github.com/honeytrap/honeytrap/pushers/nats"
 	_ "github.com/honeytrap/honeytrap/pushers/pulsar"
 	_ "github.com/honeytrap/honeytrap/pushers/rabbitmq"
 	_ "github.com/honeytrap/honeytrap/pushers/raven"
+	_ "github.com/honeytrap/honeytrap/pushers/s3"
 	_ "github.com/honeytrap/honeytrap/pushers/slack"
 	_ "github.com/honeytrap/honeytrap/pushers/splunk"
+	_ "github.com/honeytrap/honeytrap/pushers/sqlite"
+	_ "github.com/honeytrap/honeytrap/pushers/stix"
+	_ "github.com/honeytrap/honeytrap/pushers/syslog"
+	_ "github.com/honeytrap/honeytrap/pushers/webhook"
 
 	"github.com/op/go-logging"
 )
@@ -100,12 +118,41 @@ type Honeytrap struct {
 
 	director director.Director
 
+	// event options of the connections being handled
+	conns *ConnOptions
+
 	token string
 
 	dataDir string
 
 	// Maps a port and a protocol to an array of pointers to services
 	ports map[net.Addr][]*ServiceMap
+
+	// services handling connections of a port when directors fail
+	fallbacks map[net.Addr]*fallback
+
+	// connections being handled by services
+	sessions *Sessions
+
+	// listeners accepting connections of agents
+	agents []agentManager
+
+	// apis of channels, by channel
+	channelHandlers map[string]http.Handler
+
+	// configuration exposed through the api
+	apiPorts    []api.Port
+	apiServices []api.Service
+	apiChannels []api.Channel
+
+	// set when the listeners have been started
+	ready int32
+
+	// set when only checking the configuration
+	check bool
+
+	// problems found while configuring
+	problems []Problem
 }
 
 // New returns a new instance of a Honeytrap struct.
@@ -120,6 +167,8 @@ func New(options ...OptionFn) (*Honeytrap, error) {
 		config:   conf,
 		director: director.MustDummy(),
 		bus:      bus,
+		conns:    &ConnOptions{},
+		sessions: &Sessions{},
 		profiler: profiler.Dummy(),
 	}
 
@@ -137,6 +186,12 @@ func (hc *Honeytrap) startAgentServer() {
 	// go as.ListenAndServe()
 }
 
+// normalizers contains the normalizations channels can select.
+var normalizers = map[string]pushers.NormalizeFunc{
+	"":    nil,
+	"ecs": ecs.Normalize,
+}
+
 // EventServiceStarted will return a service started Event struct
 func EventServiceStarted(service string) event.Event {
 	return event.New(
@@ -324,66 +379,202 @@ func compareAddr(addr1 net.Addr, addr2 net.Addr) bool {
 	return false
 }
 
-// Run will start honeytrap
-func (hc *Honeytrap) Run(ctx context.Context) {
-	if IsTerminal(os.Stdout) {
-		fmt.Println(color.YellowString(`
- _   _                       _____                %c
-| | | | ___  _ __   ___ _   |_   _| __ __ _ _ __
-| |_| |/ _ \| '_ \ / _ \ | | || || '__/ _' | '_ \
-|  _  | (_) | | | |  __/ |_| || || | | (_| | |_) |
-|_| |_|\___/|_| |_|\___|\__, ||_||_|  \__,_| .__/
-                        |___/              |_|
-`, 127855))
+// namedListener wraps a Listener, adding some metadata
+type namedListener struct {
+	listener.Listener
+
+	Name string
+	Type string
+}
+
+// listenerConfigs returns the configuration of all listeners by name. A single
+// [listener] table with a type is supported and will be named "default".
+func (hc *Honeytrap) listenerConfigs() (map[string]toml.Primitive, error) {
+	x := struct {
+		Type string `toml:"type"`
+	}{}
+
+	if err := hc.config.PrimitiveDecode(hc.config.Listener, &x); err != nil {
+		return nil, err
 	}
 
-	fmt.Println(color.YellowString("Honeytrap starting (%s)...", hc.token))
-	fmt.Println(color.YellowString("Version: %s (%s)", cmd.Version, cmd.ShortCommitID))
+	if x.Type != "" {
+		return map[string]toml.Primitive{
+			"default": hc.config.Listener,
+		}, nil
+	}
 
-	log.Debugf("Using datadir: %s", hc.dataDir)
+	configs := map[string]toml.Primitive{}
+	if err := hc.config.PrimitiveDecode(hc.config.Listener, &configs); err != nil {
+		return nil, err
+	}
 
-	go hc.heartbeat()
+	return configs, nil
+}
 
-	hc.profiler.Start()
+// configureWeb configures the web interface, with the management api
+// mounted when enabled. The web interface will be started unless checking.
+func (hc *Honeytrap) configureWeb() {
+	x := struct {
+		API api.Config `toml:"api"`
+	}{}
+
+	if err := hc.config.PrimitiveDecode(hc.config.Web, &x); err != nil {
+		hc.errorf([]string{"web"}, "Error parsing configuration of web: %s", err.Error())
+		return
+	}
+
+	w, err := web.New(
+		web.WithEventBus(hc.bus),
+		web.WithDataDir(hc.dataDir),
+		web.WithConfig(hc.config.Web, hc.config),
+	)
+	if err != nil {
+		hc.errorf([]string{"web"}, "Error initializing web: %s", err.Error())
+		return
+	}
+
+	if err := w.Check(); err == web.ErrNoAuthentication {
+		hc.fatalf([]string{"web", "auth"}, "Error configuring web: %s", err.Error())
+		return
+	} else if err != nil {
+		hc.errorf([]string{"web"}, "Error configuring web: %s", err.Error())
+		return
+	}
+
+	if x.API.Enabled && len(x.API.Tokens) == 0 {
+		hc.warningf([]string{"web", "api"}, "Management api enabled without tokens, only client certificates will be accepted")
+	}
+
+	if !x.API.Enabled {
+		for key := range hc.channelHandlers {
+			hc.warningf([]string{"channel", key}, "Api of channel %s won't be served, the management api hasn't been enabled", key)
+		}
+	}
+
+	if hc.check {
+		return
+	}
+
+	if x.API.Enabled {
+		recent := api.NewRecent(1000)
+		hc.bus.Subscribe(recent)
 
+		a := api.New(hc, recent, x.API)
+		for key, h := range hc.channelHandlers {
+			a.HandleChannel(key, h)
+		}
+
+		w.Handle(api.Prefix, a)
+	}
+
+	w.Start()
+}
+
+// configure initializes the channels, directors, services, listeners and ports
+// of the configuration. When checking, nothing will be started and problems
+// will be recorded instead of being fatal.
+func (hc *Honeytrap) configure() map[string]*namedListener {
 	channels := map[string]pushers.Channel{}
 	isChannelUsed := make(map[string]bool)
+
+	hc.channelHandlers = map[string]http.Handler{}
 	// sane defaults!
 
 	for key, s := range hc.config.Channels {
 		x := struct {
-			Type string `toml:"type"`
+			Type      string       `toml:"type"`
+			Normalize string       `toml:"normalize"`
+			Spool     spool.Config `toml:"spool"`
 		}{}
 
 		err := hc.config.PrimitiveDecode(s, &x)
 		if err != nil {
-			log.Error("Error parsing configuration of channel: %s", err.Error())
+			hc.errorf([]string{"channel", key}, "Error parsing configuration of channel: %s", err.Error())
 			continue
 		}
 
 		if x.Type == "" {
-			log.Error("Error parsing configuration of channel %s: type not set", key)
+			hc.errorf([]string{"channel", key}, "Error parsing configuration of channel %s: type not set", key)
+			continue
+		}
+
+		normalizeFn, ok := normalizers[x.Normalize]
+		if !ok {
+			hc.fatalf([]string{"channel", key, "normalize"}, "Error initializing channel %s(%s): unsupported normalization %s", key, x.Type, x.Normalize)
+			continue
+		}
+
+		channelFunc, ok := pushers.Get(x.Type)
+		if !ok {
+			hc.errorf([]string{"channel", key, "type"}, "Channel %s not supported on platform (%s)", x.Type, key)
 			continue
 		}
 
-		if channelFunc, ok := pushers.Get(x.Type); !ok {
-			log.Error("Channel %s not supported on platform (%s)", x.Type, key)
-		} else if d, err := channelFunc(
+		var d pushers.Channel
+
+		if hc.check {
+			// channels connect or create files when initialized, only
+			// their type is checked
+			if err := hc.config.PrimitiveDecode(s, &map[string]interface{}{}); err != nil {
+				hc.errorf([]string{"channel", key}, "Error parsing configuration of channel: %s", err.Error())
+				continue
+			}
+
+			d = pushers.MustDummy()
+		} else if d, err = channelFunc(
 			pushers.WithConfig(s, hc.config),
+			pushers.WithCounters(key),
 		); err != nil {
-			log.Fatalf("Error initializing channel %s(%s): %s", key, x.Type, err)
+			hc.fatalf([]string{"channel", key}, "Error initializing channel %s(%s): %s", key, x.Type, err)
+			continue
+		}
+
+		if ch, ok := d.(channelHandler); ok {
+			hc.channelHandlers[key] = ch.Handler()
+		}
+
+		var channel pushers.Channel
+
+		if !x.Spool.Enabled || hc.check {
+			channel = d
+		} else if deliverer, ok := d.(spool.Deliverer); !ok {
+			hc.fatalf([]string{"channel", key, "spool"}, "Error initializing channel %s(%s): spooling not supported", key, x.Type)
+			continue
+		} else if sp, err := spool.Open(key, filepath.Join(hc.dataDir, "spool", key), x.Spool); err != nil {
+			hc.fatalf([]string{"channel", key, "spool"}, "Error opening spool of channel %s(%s): %s", key, x.Type, err)
+			continue
 		} else {
-			channels[key] = d
-			isChannelUsed[key] = false
+			channel = spool.NewChannel(key, sp, deliverer)
 		}
+
+		if normalizeFn != nil {
+			channel = pushers.NormalizeChannel(channel, normalizeFn)
+		}
+
+		channels[key] = metrics.PusherChannel(key, channel)
+		isChannelUsed[key] = false
+
+		hc.apiChannels = append(hc.apiChannels, api.Channel{
+			Name:      key,
+			Type:      x.Type,
+			Normalize: x.Normalize,
+			Spool:     x.Spool.Enabled,
+		})
+	}
+
+	if !hc.check {
+		// subscribe default to global bus
+		// maybe we can rewrite pushers / channels to use global bus instead
+		bc := pushers.NewBusChannel()
+		hc.bus.Subscribe(bc)
+
+		hc.bus.Subscribe(metrics.EventChannel())
 	}
 
-	// subscribe default to global bus
-	// maybe we can rewrite pushers / channels to use global bus instead
-	bc := pushers.NewBusChannel()
-	hc.bus.Subscribe(bc)
+	for i, s := range hc.config.Filters {
+		key := []string{"filter", strconv.Itoa(i)}
 
-	for _, s := range hc.config.Filters {
 		x := struct {
 			Channels   []string `toml:"channel"`
 			Services   []string `toml:"services"`
@@ -392,18 +583,23 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 
 		err := hc.config.PrimitiveDecode(s, &x)
 		if err != nil {
-			log.Error("Error parsing configuration of filter: %s", err.Error())
+			hc.errorf(key, "Error parsing configuration of filter: %s", err.Error())
 			continue
 		}
 
 		for _, name := range x.Channels {
 			channel, ok := channels[name]
 			if !ok {
-				log.Error("Could not find channel %s for filter", name)
+				hc.errorf(append(key, "channel"), "Could not find channel %s for filter", name)
 				continue
 			}
 
 			isChannelUsed[name] = true
+
+			if hc.check {
+				continue
+			}
+
 			channel = pushers.TokenChannel(channel, hc.token)
 
 			if len(x.Categories) != 0 {
@@ -422,7 +618,7 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 
 	for name, isUsed := range isChannelUsed {
 		if !isUsed {
-			log.Warningf("Channel %s is unused. Did you forget to add a filter?", name)
+			hc.warningf([]string{"channel", name}, "Channel %s is unused. Did you forget to add a filter?", name)
 		}
 	}
 
@@ -437,39 +633,33 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 
 		err := hc.config.PrimitiveDecode(s, &x)
 		if err != nil {
-			log.Error("Error parsing configuration of director: %s", err.Error())
+			hc.errorf([]string{"director", key}, "Error parsing configuration of director: %s", err.Error())
 			continue
 		}
 
 		if x.Type == "" {
-			log.Error("Error parsing configuration of service %s: type not set", key)
+			hc.errorf([]string{"director", key}, "Error parsing configuration of service %s: type not set", key)
 			continue
 		}
 
 		if directorFunc, ok := director.Get(x.Type); !ok {
-			log.Error("Director type=%s not supported on platform (director=%s). Available directors: %s", x.Type, key, strings.Join(availableDirectorNames, ", "))
+			hc.errorf([]string{"director", key, "type"}, "Director type=%s not supported on platform (director=%s). Available directors: %s", x.Type, key, strings.Join(availableDirectorNames, ", "))
 		} else if d, err := directorFunc(
 			director.WithChannel(hc.bus),
 			director.WithConfig(s, hc.config),
 		); err != nil {
-			log.Fatalf("Error initializing director %s(%s): %s", key, x.Type, err)
+			hc.fatalf([]string{"director", key}, "Error initializing director %s(%s): %s", key, x.Type, err)
 		} else {
-			directors[key] = d
-		}
-	}
-
-	// initialize listener
-	x := struct {
-		Type string `toml:"type"`
-	}{}
-
-	if err := hc.config.PrimitiveDecode(hc.config.Listener, &x); err != nil {
-		log.Error("Error parsing configuration of listener: %s", err.Error())
-		return
-	}
+			// directors won't clean up or fill pools when checking
+			if st, ok := d.(director.Starter); ok && !hc.check {
+				if err := st.Start(); err != nil {
+					hc.fatalf([]string{"director", key}, "Error starting director %s(%s): %s", key, x.Type, err)
+					continue
+				}
+			}
 
-	if x.Type == "" {
-		fmt.Println(color.RedString("Listener not set"))
+			directors[key] = &fallbackDirector{d}
+		}
 	}
 
 	var enabledDirectorNames []string
@@ -488,18 +678,18 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 		}{}
 
 		if err := hc.config.PrimitiveDecode(s, &x); err != nil {
-			log.Error("Error parsing configuration of service %s: %s", key, err.Error())
+			hc.errorf([]string{"service", key}, "Error parsing configuration of service %s: %s", key, err.Error())
 			continue
 		}
 
 		if x.Port != "" {
-			log.Error("Ports in services are deprecated, add services to ports instead")
+			hc.errorf([]string{"service", key, "port"}, "Ports in services are deprecated, add services to ports instead")
 			continue
 		}
 
 		// individual configuration per service
 		options := []services.ServicerFunc{
-			services.WithChannel(hc.bus),
+			services.WithChannel(hc.conns.Channel(hc.bus)),
 			services.WithConfig(s, hc.config),
 		}
 
@@ -507,13 +697,13 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 		} else if d, ok := directors[x.Director]; ok {
 			options = append(options, services.WithDirector(d))
 		} else {
-			log.Error(color.RedString("Could not find director=%s for service=%s. Enabled directors: %s", x.Director, key, strings.Join(enabledDirectorNames, ", ")))
+			hc.errorf([]string{"service", key, "director"}, "Could not find director=%s for service=%s. Enabled directors: %s", x.Director, key, strings.Join(enabledDirectorNames, ", "))
 			continue
 		}
 
 		fn, ok := services.Get(x.Type)
 		if !ok {
-			log.Error(color.RedString("Could not find type %s for service %s", x.Type, key))
+			hc.errorf([]string{"service", key, "type"}, "Could not find type %s for service %s", x.Type, key)
 			continue
 		}
 
@@ -524,33 +714,92 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 			Type:    x.Type,
 		}
 		isServiceUsed[key] = false
+
+		hc.apiServices = append(hc.apiServices, api.Service{
+			Name:     key,
+			Type:     x.Type,
+			Director: x.Director,
+		})
+
 		log.Infof("Configured service %s (%s)", x.Type, key)
 	}
 
-	listenerFunc, ok := listener.Get(x.Type)
-	if !ok {
-		fmt.Println(color.RedString("Listener %s not support on platform", x.Type))
-		return
+	// initialize listeners
+	listenerConfigs, err := hc.listenerConfigs()
+	if err != nil {
+		hc.errorf([]string{"listener"}, "Error parsing configuration of listener: %s", err.Error())
+		return nil
 	}
 
-	l, err := listenerFunc(
-		listener.WithChannel(hc.bus),
-		listener.WithConfig(hc.config.Listener, hc.config),
-	)
-	if err != nil {
-		log.Fatalf("Error initializing listener %s: %s", x.Type, err)
+	if len(listenerConfigs) == 0 {
+		hc.errorf([]string{"listener"}, "Listener not set")
+	}
+
+	listeners := map[string]*namedListener{}
+
+	for name, s := range listenerConfigs {
+		key := []string{"listener", name}
+		if name == "default" {
+			key = []string{"listener"}
+		}
+
+		x := struct {
+			Type string `toml:"type"`
+		}{}
+
+		if err := hc.config.PrimitiveDecode(s, &x); err != nil {
+			hc.errorf(key, "Error parsing configuration of listener %s: %s", name, err.Error())
+			continue
+		}
+
+		if x.Type == "" {
+			hc.errorf(key, "Error parsing configuration of listener %s: type not set", name)
+			continue
+		}
+
+		listenerFunc, ok := listener.Get(x.Type)
+		if !ok {
+			hc.errorf(append(key, "type"), "Listener %s not support on platform (%s)", x.Type, name)
+			continue
+		}
+
+		l, err := listenerFunc(
+			listener.WithChannel(hc.bus),
+			listener.WithConfig(s, hc.config),
+		)
+		if err != nil {
+			hc.fatalf(key, "Error initializing listener %s(%s): %s", name, x.Type, err)
+			continue
+		}
+
+		listeners[name] = &namedListener{
+			Listener: l,
+			Name:     name,
+			Type:     x.Type,
+		}
+
+		if am, ok := l.(agentManager); ok {
+			hc.agents = append(hc.agents, am)
+		}
 	}
 
 	hc.ports = make(map[net.Addr][]*ServiceMap)
-	for _, s := range hc.config.Ports {
+	hc.fallbacks = make(map[net.Addr]*fallback)
+	for i, s := range hc.config.Ports {
+		key := []string{"port", strconv.Itoa(i)}
+
 		x := struct {
-			Port     string   `toml:"port"`
-			Ports    []string `toml:"ports"`
-			Services []string `toml:"services"`
+			Port            string       `toml:"port"`
+			Ports           []string     `toml:"ports"`
+			Services        []string     `toml:"services"`
+			Fallback        []string     `toml:"fallback"`
+			FallbackTimeout config.Delay `toml:"fallback_timeout"`
+			Listener        string       `toml:"listener"`
+			Listeners       []string     `toml:"listeners"`
 		}{}
 
 		if err := hc.config.PrimitiveDecode(s, &x); err != nil {
-			log.Error("Error parsing configuration of generic ports: %s", err.Error())
+			hc.errorf(key, "Error parsing configuration of generic ports: %s", err.Error())
 			continue
 		}
 
@@ -562,24 +811,38 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 			ports = append(ports, x.Port)
 		}
 		if x.Port != "" && x.Ports != nil {
-			log.Warning("Both \"port\" and \"ports\" were defined, this can be confusing")
+			hc.warningf(key, "Both \"port\" and \"ports\" were defined, this can be confusing")
 		} else if x.Port == "" && x.Ports == nil {
-			log.Error("Neither \"port\" nor \"ports\" were defined")
+			hc.errorf(key, "Neither \"port\" nor \"ports\" were defined")
 			continue
 		}
 
 		if len(x.Services) == 0 {
-			log.Warning("No services defined for port(s) " + strings.Join(ports, ", "))
+			hc.warningf(key, "No services defined for port(s) "+strings.Join(ports, ", "))
+		}
+
+		listenerNames := x.Listeners
+		if x.Listener != "" {
+			listenerNames = append(listenerNames, x.Listener)
+		}
+
+		if len(listenerNames) == 0 && len(listeners) == 1 {
+			for name := range listeners {
+				listenerNames = append(listenerNames, name)
+			}
+		} else if len(listenerNames) == 0 {
+			hc.errorf(key, "No listener defined for port(s) %s, while multiple listeners are configured", strings.Join(ports, ", "))
+			continue
 		}
 
 		for _, portStr := range ports {
 			addr, _, _, err := ToAddr(portStr)
 			if err != nil {
-				log.Error("Error parsing port string: %s", err.Error())
+				hc.errorf(append(key, "port"), "Error parsing port string: %s", err.Error())
 				continue
 			}
 			if addr == nil {
-				log.Error("Failed to bind: addr is nil")
+				hc.errorf(append(key, "port"), "Failed to bind: addr is nil")
 				continue
 			}
 
@@ -588,14 +851,14 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 			for _, serviceName := range x.Services {
 				ptr, ok := serviceList[serviceName]
 				if !ok {
-					log.Error("Unknown service '%s' for port %s", serviceName, portStr)
+					hc.errorf(append(key, "services"), "Unknown service '%s' for port %s", serviceName, portStr)
 					continue
 				}
 				servicePtrs = append(servicePtrs, ptr)
 				isServiceUsed[serviceName] = true
 			}
 			if len(servicePtrs) == 0 {
-				log.Errorf("Port %s has no valid services, it won't be listened on", portStr)
+				hc.errorf(key, "Port %s has no valid services, it won't be listened on", portStr)
 				continue
 			}
 
@@ -609,55 +872,155 @@ func (hc *Honeytrap) Run(ctx context.Context) {
 			}
 
 			if found {
-				log.Error("Port %s was already defined, ignoring the newer definition", portStr)
+				hc.errorf(key, "Port %s was already defined, ignoring the newer definition", portStr)
 				continue
 			}
 
 			hc.ports[addr] = servicePtrs
 
-			a, ok := l.(listener.AddAddresser)
-			if !ok {
-				log.Error("Listener error")
-				continue
+			if len(x.Fallback) > 0 {
+				fb := &fallback{
+					Timeout: x.FallbackTimeout.Duration(),
+				}
+
+				for _, serviceName := range x.Fallback {
+					ptr, ok := serviceList[serviceName]
+					if !ok {
+						hc.errorf(append(key, "fallback"), "Unknown fallback service '%s' for port %s", serviceName, portStr)
+						continue
+					}
+
+					fb.Services = append(fb.Services, ptr)
+					isServiceUsed[serviceName] = true
+				}
+
+				hc.fallbacks[addr] = fb
+
+				for _, ptr := range servicePtrs {
+					if ew, ok := ptr.Service.(services.EarlyWriter); ok && ew.WritesBeforeDial() {
+						hc.warningf(append(key, "fallback"), "Service %s writes before dialing its director, connections on port %s won't be handed over to the fallback", ptr.Name, portStr)
+					}
+				}
+			} else if x.FallbackTimeout != 0 {
+				hc.warningf(append(key, "fallback_timeout"), "Fallback timeout defined for port %s without fallback services", portStr)
+			}
+
+			port := api.Port{
+				Port:      fmt.Sprintf("%s/%s", addr.Network(), addr.String()),
+				Services:  []string{},
+				Listeners: []string{},
 			}
-			a.AddAddress(addr)
 
-			log.Infof("Configured port %s/%s", addr.Network(), addr.String())
+			for _, ptr := range servicePtrs {
+				port.Services = append(port.Services, ptr.Name)
+			}
+
+			for _, name := range listenerNames {
+				l, ok := listeners[name]
+				if !ok {
+					hc.errorf(key, "Unknown listener '%s' for port %s", name, portStr)
+					continue
+				}
+
+				a, ok := l.Listener.(listener.AddAddresser)
+				if !ok {
+					hc.errorf(key, "Listener %s(%s) doesn't support ports", name, l.Type)
+					continue
+				}
+
+				a.AddAddress(addr)
+
+				port.Listeners = append(port.Listeners, name)
+
+				log.Infof("Configured port %s/%s on listener %s", addr.Network(), addr.String(), name)
+			}
+
+			hc.apiPorts = append(hc.apiPorts, port)
 		}
 	}
 
 	for name, isUsed := range isServiceUsed {
 		if !isUsed {
-			log.Warningf("Service %s is defined but not used", name)
+			hc.warningf([]string{"service", name}, "Service %s is defined but not used", name)
 		}
 	}
 
-	if len(hc.config.Undecoded()) != 0 {
-		log.Warningf("Unrecognized keys in configuration: %v", hc.config.Undecoded())
+	hc.configureWeb()
+
+	for _, key := range hc.config.Undecoded() {
+		hc.warningf(key, "Unrecognized key in configuration: %s", key)
 	}
 
-	if err := l.Start(ctx); err != nil {
-		fmt.Println(color.RedString("Error starting listener: %s", err.Error()))
-		return
+	return listeners
+}
+
+// Run will start honeytrap
+func (hc *Honeytrap) Run(ctx context.Context) {
+	if IsTerminal(os.Stdout) {
+		fmt.Println(color.YellowString(`
+ _   _                       _____                %c
+| | | | ___  _ __   ___ _   |_   _| __ __ _ _ __
+| |_| |/ _ \| '_ \ / _ \ | | || || '__/ _' | '_ \
+|  _  | (_) | | | |  __/ |_| || || | | (_| | |_) |
+|_| |_|\___/|_| |_|\___|\__, ||_||_|  \__,_| .__/
+                        |___/              |_|
+`, 127855))
 	}
 
-	incoming := make(chan net.Conn)
+	fmt.Println(color.YellowString("Honeytrap starting (%s)...", hc.token))
+	fmt.Println(color.YellowString("Version: %s (%s)", cmd.Version, cmd.ShortCommitID))
 
-	go func() {
-		for {
-			conn, err := l.Accept()
-			if err != nil {
-				panic(err)
-			}
+	log.Debugf("Using datadir: %s", hc.dataDir)
+
+	go hc.heartbeat()
 
-			incoming <- conn
+	mc := metrics.Config{}
+	if err := hc.config.PrimitiveDecode(hc.config.Metrics, &mc); err != nil {
+		log.Error("Error parsing configuration of metrics: %s", err.Error())
+	}
 
-			// in case of goroutine starvation
-			// with many connection and single procs
-			runtime.Gosched()
+	go func() {
+		if err := metrics.ListenAndServe(mc); err != nil {
+			log.Errorf("Error starting metrics endpoint: %s", err.Error())
 		}
 	}()
 
+	hc.profiler.Start()
+
+	listeners := hc.configure()
+	if listeners == nil {
+		return
+	}
+
+	incoming := make(chan net.Conn)
+
+	for _, l := range listeners {
+		if err := l.Start(ctx); err != nil {
+			fmt.Println(color.RedString("Error starting listener %s: %s", l.Name, err.Error()))
+			return
+		}
+
+		go func(l *namedListener) {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					panic(err)
+				}
+
+				incoming <- event.WithConn(conn,
+					event.Custom("listener", l.Name),
+					event.Custom("listener.type", l.Type),
+				)
+
+				// in case of goroutine starvation
+				// with many connection and single procs
+				runtime.Gosched()
+			}
+		}(l)
+	}
+
+	atomic.StoreInt32(&hc.ready, 1)
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -701,22 +1064,73 @@ func (hc *Honeytrap) handle(conn net.Conn) {
 	log.Debug("Accepted connection for %s => %s", conn.RemoteAddr(), conn.LocalAddr())
 	defer log.Debug("Disconnected connection for %s => %s", conn.RemoteAddr(), conn.LocalAddr())
 
+	remove := hc.conns.Add(conn)
+	defer remove()
+
+	_, localPort := addrIPPort(conn.LocalAddr())
+	port := fmt.Sprintf("%s/%d", conn.LocalAddr().Network(), localPort)
+
 	/* conn is the original connection. newConn can be either the same
 	 * connection, or a wrapper in the form of a PeekConnection.
 	 */
 	sm, newConn, err := hc.findService(conn)
 	if sm == nil {
+		metrics.ServiceMisses.WithLabelValues(port).Inc()
+
 		log.Debug("No suitable handler for %s => %s: %s", conn.RemoteAddr(), conn.LocalAddr(), err.Error())
 		return
 	}
 
+	metrics.ConnectionsAccepted.WithLabelValues(port, sm.Name).Inc()
+
+	removeSession := hc.sessions.Add(conn, sm)
+	defer removeSession()
+
+	defer func(start time.Time) {
+		metrics.SessionDuration.WithLabelValues(sm.Name).Observe(time.Since(start).Seconds())
+	}(time.Now())
+
 	log.Debug("Handling connection for %s => %s %s(%s)", conn.RemoteAddr(), conn.LocalAddr(), sm.Name, sm.Type)
 
 	newConn = TimeoutConn(newConn, time.Second*30)
 
+	var chain []*ServiceMap
+	var timeout time.Duration
+
+	if fb := hc.findFallback(conn); fb != nil {
+		chain, timeout = fb.Services, fb.Timeout
+	}
+
 	ctx := context.Background()
-	if err := sm.Service.Handle(ctx, newConn); err != nil {
-		log.Errorf(color.RedString("Error handling service: %s: %s", sm.Name, err.Error()))
+
+	for {
+		c := newConn
+
+		// the connection can be handed over to the next service of the chain
+		var hoc *handoverConn
+		if len(chain) > 0 {
+			hoc = newHandoverConn(newConn, timeout)
+			c = hoc
+		}
+
+		if err := sm.Service.Handle(ctx, c); err != nil {
+			log.Errorf(color.RedString("Error handling service: %s: %s", sm.Name, err.Error()))
+		}
+
+		if hoc == nil {
+			return
+		}
+
+		next, err := hoc.handover()
+		if next == nil {
+			return
+		}
+
+		log.Debugf("Handing over connection for %s => %s from %s to %s: %s", conn.RemoteAddr(), conn.LocalAddr(), sm.Name, chain[0].Name, err.Error())
+
+		hc.bus.Send(FallbackEvent(conn, sm.Name, chain[0].Name, err))
+
+		sm, chain, newConn = chain[0], chain[1:], next
 	}
 }
 
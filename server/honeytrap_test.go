@@ -14,9 +14,39 @@
 package server
 
 import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/honeytrap/honeytrap/config"
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/listener"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/storage"
 )
 
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "honeytrap-server")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	storage.SetDataDir(dir)
+
+	code := m.Run()
+
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 func TestBigPortToAddr(t *testing.T) {
 	addr, proto, port, err := ToAddr("tcp/60000")
 	if err != nil {
@@ -48,3 +78,386 @@ func TestUnknownProtoToAddr(t *testing.T) {
 		t.Errorf("No error thrown with incorrect protocol")
 	}
 }
+
+func listenerConfigs(t *testing.T, s string) map[string]toml.Primitive {
+	c := &config.Config{}
+	if err := c.Load(strings.NewReader(s)); err != nil {
+		t.Fatal(err)
+	}
+
+	hc := &Honeytrap{
+		config: c,
+	}
+
+	configs, err := hc.listenerConfigs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return configs
+}
+
+func TestListenerConfigsSingle(t *testing.T) {
+	configs := listenerConfigs(t, `
+[listener]
+type="socket"
+`)
+
+	if _, ok := configs["default"]; !ok || len(configs) != 1 {
+		t.Errorf("Expected a single default listener, got %v", configs)
+	}
+}
+
+func TestListenerConfigsNamed(t *testing.T) {
+	configs := listenerConfigs(t, `
+[listener.public]
+type="socket"
+
+[listener.agents]
+type="agent"
+listen=":1339"
+`)
+
+	if len(configs) != 2 {
+		t.Errorf("Expected 2 listeners, got %d", len(configs))
+	}
+
+	for _, name := range []string{"public", "agents"} {
+		if _, ok := configs[name]; !ok {
+			t.Errorf("Expected listener %s", name)
+		}
+	}
+}
+
+func TestListenerConfigsNone(t *testing.T) {
+	configs := listenerConfigs(t, ``)
+
+	if len(configs) != 0 {
+		t.Errorf("Expected no listeners, got %d", len(configs))
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "honeytrap-check")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "events.json")
+
+	c := &config.Config{}
+	if err := c.Load(strings.NewReader(`
+[listener]
+type="socket"
+
+[service.telnet]
+type="telnet"
+
+[service.unknown]
+type="does-not-exist"
+
+[[port]]
+port="tcp/8023"
+services=["telnet"]
+
+[[port]]
+port="tcp/8023"
+services=["telnet"]
+
+[channel.console]
+type="console"
+
+[channel.file]
+type="file"
+filename="` + filename + `"
+`)); err != nil {
+		t.Fatal(err)
+	}
+
+	hc, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hc.config = c
+
+	result := hc.Check()
+
+	if len(result.Ports) != 1 || result.Ports[0].Port != "tcp/:8023" || result.Ports[0].Services[0] != "telnet" {
+		t.Errorf("Expected tcp/:8023 to be resolved to telnet, got %+v", result.Ports)
+	}
+
+	expected := []struct {
+		line    int
+		warning bool
+		message string
+	}{
+		{9, false, "Could not find type does-not-exist for service unknown"},
+		{15, false, "Port tcp/8023 was already defined, ignoring the newer definition"},
+		{19, true, "Channel console is unused. Did you forget to add a filter?"},
+		{22, true, "Channel file is unused. Did you forget to add a filter?"},
+	}
+
+	if len(result.Problems) != len(expected) {
+		t.Fatalf("Expected %d problems, got %v", len(expected), result.Problems)
+	}
+
+	for i, e := range expected {
+		p := result.Problems[i]
+		if p.Position.Line != e.line || p.Warning != e.warning || p.Message != e.message {
+			t.Errorf("Expected problem %d at line %d: %s, got %s", i, e.line, e.message, p)
+		}
+	}
+
+	time.Sleep(time.Millisecond * 100)
+
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("Expected channel not to create %s when checking", filename)
+	}
+}
+
+func TestService(t *testing.T) {
+	c := &config.Config{}
+	if err := c.Load(strings.NewReader(`
+[service.echo]
+type="echo"
+
+[service.proxy]
+type="echo"
+director="missing"
+`)); err != nil {
+		t.Fatal(err)
+	}
+
+	hc := &Honeytrap{
+		config: c,
+	}
+
+	sm, err := hc.Service("echo", pushers.MustDummy())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sm.Name != "echo" || sm.Type != "echo" {
+		t.Errorf("Unexpected service %s(%s)", sm.Name, sm.Type)
+	}
+
+	if _, err := hc.Service("proxy", pushers.MustDummy()); err == nil {
+		t.Error("Expected error for missing director")
+	}
+
+	if _, err := hc.Service("unknown", pushers.MustDummy()); err == nil {
+		t.Error("Expected error for unknown service")
+	}
+}
+
+type fallbackConn struct {
+	net.Conn
+}
+
+func (fallbackConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{Port: 8023}
+}
+
+func (fallbackConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4321}
+}
+
+type eventChannel chan event.Event
+
+func (ec eventChannel) Send(e event.Event) {
+	ec <- e
+}
+
+func TestFallback(t *testing.T) {
+	c := &config.Config{}
+	if err := c.Load(strings.NewReader(`
+[listener]
+type="socket"
+
+[director.denied]
+type="forward"
+host="127.0.0.1"
+allow=["10.0.0.0/8"]
+
+[service.proxy]
+type="copy"
+director="denied"
+
+[service.echo]
+type="echo"
+
+[[port]]
+port="tcp/8023"
+services=["proxy"]
+fallback=["echo"]
+`)); err != nil {
+		t.Fatal(err)
+	}
+
+	hc, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hc.config = c
+
+	if result := hc.Check(); len(result.Problems) != 0 {
+		t.Fatalf("Unexpected problems %+v", result.Problems)
+	}
+
+	ch := make(eventChannel, 100)
+	hc.bus.Subscribe(ch)
+
+	client, server := net.Pipe()
+
+	done := make(chan struct{})
+
+	go func() {
+		hc.handle(fallbackConn{server})
+		close(done)
+	}()
+
+	client.Write([]byte("hello"))
+
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatal(err)
+	} else if string(buf) != "hello" {
+		t.Errorf("Expected echo service to handle connection, got %q", buf)
+	}
+
+	client.Close()
+	<-done
+
+	for {
+		select {
+		case e := <-ch:
+			if e.Get("type") != "SERVICE:FALLBACK" {
+				continue
+			}
+
+			if e.Get("service") != "proxy" || e.Get("fallback") != "echo" || e.Get("reason") != "failed" {
+				t.Errorf("Unexpected fallback event %v", event.ToMap(e))
+			}
+
+			return
+		default:
+			t.Fatal("Expected fallback event")
+		}
+	}
+}
+
+func TestFallbackWritesBeforeDial(t *testing.T) {
+	c := &config.Config{}
+	if err := c.Load(strings.NewReader(`
+[listener]
+type="socket"
+
+[director.forward]
+type="forward"
+host="127.0.0.1"
+
+[service.proxy]
+type="ssh-proxy"
+director="forward"
+
+[service.echo]
+type="echo"
+
+[[port]]
+port="tcp/8022"
+services=["proxy"]
+fallback=["echo"]
+`)); err != nil {
+		t.Fatal(err)
+	}
+
+	hc, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hc.config = c
+
+	result := hc.Check()
+
+	if len(result.Problems) != 1 {
+		t.Fatalf("Expected 1 problem, got %v", result.Problems)
+	}
+
+	p := result.Problems[0]
+
+	expected := "Service proxy writes before dialing its director, connections on port tcp/8022 won't be handed over to the fallback"
+	if p.Position.Line != 19 || !p.Warning || p.Message != expected {
+		t.Errorf("Expected warning at line 19: %s, got %s", expected, p)
+	}
+}
+
+func TestHandleUDP(t *testing.T) {
+	c := &config.Config{}
+	if err := c.Load(strings.NewReader(`
+[listener]
+type="socket"
+
+[service.echo]
+type="echo"
+
+[[port]]
+port="udp/7"
+services=["echo"]
+`)); err != nil {
+		t.Fatal(err)
+	}
+
+	hc, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hc.config = c
+
+	if result := hc.Check(); len(result.Problems) != 0 {
+		t.Fatalf("Unexpected problems %+v", result.Problems)
+	}
+
+	ch := make(eventChannel, 100)
+	hc.bus.Subscribe(ch)
+
+	written := make(chan []byte, 1)
+
+	// services receive the connection wrapped by handle
+	conn := &listener.DummyUDPConn{
+		Buffer: []byte("hello"),
+		Laddr:  &net.UDPAddr{Port: 7},
+		Raddr:  &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4321},
+		Fn: func(b []byte, addr *net.UDPAddr) (int, error) {
+			written <- append([]byte{}, b...)
+			return len(b), nil
+		},
+	}
+
+	go hc.handle(conn)
+
+	select {
+	case b := <-written:
+		if string(b) != "hello" {
+			t.Errorf("Expected datagram to be echoed, got %q", b)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Expected echo service to handle the datagram")
+	}
+
+	for {
+		select {
+		case e := <-ch:
+			if e.Get("category") == "echo" {
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected echo event of the udp path")
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"sync/atomic"
+
+	"github.com/honeytrap/honeytrap/listener/agent"
+	"github.com/honeytrap/honeytrap/server/api"
+)
+
+var (
+	ErrNotReady = errors.New("listeners haven't been started")
+)
+
+// Ports returns the configured ports.
+func (hc *Honeytrap) Ports() []api.Port {
+	ports := append([]api.Port{}, hc.apiPorts...)
+
+	sort.Slice(ports, func(i, j int) bool {
+		return ports[i].Port < ports[j].Port
+	})
+
+	return ports
+}
+
+// Services returns the configured services.
+func (hc *Honeytrap) Services() []api.Service {
+	services := append([]api.Service{}, hc.apiServices...)
+
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Name < services[j].Name
+	})
+
+	return services
+}
+
+// Channels returns the configured channels.
+func (hc *Honeytrap) Channels() []api.Channel {
+	channels := append([]api.Channel{}, hc.apiChannels...)
+
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].Name < channels[j].Name
+	})
+
+	return channels
+}
+
+// Sessions returns the connections being handled.
+func (hc *Honeytrap) Sessions() []api.Session {
+	return hc.sessions.List()
+}
+
+// KillSession closes the connection of the session.
+func (hc *Honeytrap) KillSession(id string) error {
+	return hc.sessions.Kill(id)
+}
+
+// Ready returns an error until the listeners have been started.
+func (hc *Honeytrap) Ready() error {
+	if atomic.LoadInt32(&hc.ready) == 0 {
+		return ErrNotReady
+	}
+
+	return nil
+}
+
+// channelHandler is implemented by channels serving an api, eg. to query
+// stored events. The api is only served by the management api.
+type channelHandler interface {
+	Handler() http.Handler
+}
+
+// agentManager is implemented by listeners accepting connections of agents.
+type agentManager interface {
+	Registrations() []agent.AgentRecord
+	Revoke(publicKey string) error
+	Deny(publicKey string) error
+}
+
+// Agents returns the agents known by the agent listeners.
+func (hc *Honeytrap) Agents() ([]api.Agent, error) {
+	if len(hc.agents) == 0 {
+		return nil, api.ErrAgentsDisabled
+	}
+
+	agents := []api.Agent{}
+
+	for _, am := range hc.agents {
+		for _, record := range am.Registrations() {
+			agents = append(agents, api.Agent{
+				Name:       record.Name,
+				PublicKey:  record.PublicKey,
+				Status:     string(record.Status),
+				Version:    record.Version,
+				RemoteAddr: record.RemoteAddr,
+				FirstSeen:  record.FirstSeen,
+				LastSeen:   record.LastSeen,
+			})
+		}
+	}
+
+	return agents, nil
+}
+
+func (hc *Honeytrap) manageAgent(fn func(am agentManager) error) error {
+	if len(hc.agents) == 0 {
+		return api.ErrAgentsDisabled
+	}
+
+	for _, am := range hc.agents {
+		if err := fn(am); err == agent.ErrInvalidPublicKey {
+			return api.ErrInvalidPublicKey
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RevokeAgent revokes the agent permanently, and disconnects it.
+func (hc *Honeytrap) RevokeAgent(publicKey string) error {
+	return hc.manageAgent(func(am agentManager) error {
+		return am.Revoke(publicKey)
+	})
+}
+
+// DenyAgent denies the agent, and disconnects it.
+func (hc *Honeytrap) DenyAgent(publicKey string) error {
+	return hc.manageAgent(func(am agentManager) error {
+		return am.Deny(publicKey)
+	})
+}
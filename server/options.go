@@ -54,6 +54,7 @@ func WithConfig(s string) (OptionFn, error) {
 	}
 
 	return func(b *Honeytrap) error {
+		b.config.File = s
 		return b.config.Load(bytes.NewBuffer(data))
 	}, nil
 }
@@ -70,6 +71,7 @@ func WithRemoteConfig(s string) (OptionFn, error) {
 		return nil, err
 	}
 	return func(b *Honeytrap) error {
+		b.config.File = s
 		return b.config.Load(bytes.NewBuffer(body))
 	}, nil
 }
@@ -0,0 +1,104 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"github.com/honeytrap/honeytrap/server/api"
+	"github.com/rs/xid"
+)
+
+// Sessions keeps the connections being handled by services, so they
+// can be listed and killed through the api.
+type Sessions struct {
+	m sync.Mutex
+
+	sessions map[string]*session
+}
+
+type session struct {
+	api.Session
+
+	conn net.Conn
+}
+
+// Add registers the connection handled by the service, the returned
+// function unregisters it again.
+func (s *Sessions) Add(conn net.Conn, sm *ServiceMap) func() {
+	id := xid.New().String()
+
+	sess := &session{
+		Session: api.Session{
+			ID:          id,
+			Service:     sm.Name,
+			Source:      conn.RemoteAddr().String(),
+			Destination: conn.LocalAddr().String(),
+			Start:       time.Now(),
+		},
+		conn: conn,
+	}
+
+	if ec, ok := conn.(*event.Conn); ok {
+		sess.Listener = event.New(ec.Options()).Get("listener")
+	}
+
+	s.m.Lock()
+	if s.sessions == nil {
+		s.sessions = map[string]*session{}
+	}
+
+	s.sessions[id] = sess
+	s.m.Unlock()
+
+	return func() {
+		s.m.Lock()
+		delete(s.sessions, id)
+		s.m.Unlock()
+	}
+}
+
+// List returns the sessions, oldest first.
+func (s *Sessions) List() []api.Session {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	sessions := make([]api.Session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess.Session)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Start.Before(sessions[j].Start)
+	})
+
+	return sessions
+}
+
+// Kill closes the connection of the session.
+func (s *Sessions) Kill(id string) error {
+	s.m.Lock()
+	sess, ok := s.sessions[id]
+	s.m.Unlock()
+
+	if !ok {
+		return api.ErrSessionNotFound
+	}
+
+	return sess.conn.Close()
+}
@@ -0,0 +1,86 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package server
+
+import (
+	"fmt"
+
+	"github.com/honeytrap/honeytrap/director"
+	"github.com/honeytrap/honeytrap/pushers"
+	"github.com/honeytrap/honeytrap/services"
+)
+
+// Service creates the configured service, with its director, on its own. Events
+// of the service will be sent to the channel.
+func (hc *Honeytrap) Service(name string, ch pushers.Channel) (*ServiceMap, error) {
+	s, ok := hc.config.Services[name]
+	if !ok {
+		return nil, fmt.Errorf("Could not find service %s", name)
+	}
+
+	x := struct {
+		Type     string `toml:"type"`
+		Director string `toml:"director"`
+	}{}
+
+	if err := hc.config.PrimitiveDecode(s, &x); err != nil {
+		return nil, fmt.Errorf("Error parsing configuration of service %s: %s", name, err.Error())
+	}
+
+	options := []services.ServicerFunc{
+		services.WithChannel(ch),
+		services.WithConfig(s, hc.config),
+	}
+
+	if x.Director != "" {
+		ds, ok := hc.config.Directors[x.Director]
+		if !ok {
+			return nil, fmt.Errorf("Could not find director=%s for service=%s", x.Director, name)
+		}
+
+		y := struct {
+			Type string `toml:"type"`
+		}{}
+
+		if err := hc.config.PrimitiveDecode(ds, &y); err != nil {
+			return nil, fmt.Errorf("Error parsing configuration of director: %s", err.Error())
+		}
+
+		directorFunc, ok := director.Get(y.Type)
+		if !ok {
+			return nil, fmt.Errorf("Director type=%s not supported on platform (director=%s)", y.Type, x.Director)
+		}
+
+		d, err := directorFunc(
+			director.WithChannel(ch),
+			director.WithConfig(ds, hc.config),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("Error initializing director %s(%s): %s", x.Director, y.Type, err)
+		}
+
+		options = append(options, services.WithDirector(d))
+	}
+
+	fn, ok := services.Get(x.Type)
+	if !ok {
+		return nil, fmt.Errorf("Could not find type %s for service %s", x.Type, name)
+	}
+
+	return &ServiceMap{
+		Service: fn(options...),
+		Name:    name,
+		Type:    x.Type,
+	}, nil
+}
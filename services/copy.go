@@ -19,8 +19,8 @@ import (
 	"net"
 
 	"github.com/honeytrap/honeytrap/director"
+	"github.com/honeytrap/honeytrap/director/record"
 	"github.com/honeytrap/honeytrap/event"
-	"github.com/honeytrap/honeytrap/listener"
 	"github.com/honeytrap/honeytrap/pushers"
 )
 
@@ -30,10 +30,18 @@ var (
 
 // Copy is a placeholder
 func Copy(options ...ServicerFunc) Servicer {
-	s := &copyService{}
+	s := &copyService{
+		Record: record.DefaultConfig(),
+	}
+
 	for _, o := range options {
 		o(s)
 	}
+
+	if err := s.Record.Validate(); err != nil {
+		log.Errorf("Error in copy service recording: %s", err.Error())
+	}
+
 	return s
 }
 
@@ -41,6 +49,10 @@ type copyService struct {
 	c pushers.Channel
 
 	d director.Director
+
+	// Record records the proxied sessions, unless recorded by the
+	// director already.
+	Record record.Config `toml:"record"`
 }
 
 func (s *copyService) SetDirector(d director.Director) {
@@ -53,47 +65,26 @@ func (s *copyService) SetChannel(c pushers.Channel) {
 
 func (s *copyService) Handle(ctx context.Context, conn net.Conn) error {
 	defer conn.Close()
-	switch conn.(type) {
-	case *listener.DummyUDPConn:
-		defer s.c.Send(event.New(
-			EventOptions,
-			event.Category("copy"),
-			event.Type("tcp"),
-			event.SourceAddr(conn.RemoteAddr()),
-			event.DestinationAddr(conn.LocalAddr()),
-		))
-
-		conn2, err := s.d.Dial(conn)
-		if err != nil {
-			return err
-		}
-
-		defer conn2.Close()
-
-		go io.Copy(conn2, conn)
-		_, err = io.Copy(conn, conn2)
 
+	// connections are being wrapped, use the network of the address
+	defer s.c.Send(event.New(
+		EventOptions,
+		event.Category("copy"),
+		event.Type(conn.LocalAddr().Network()),
+		event.SourceAddr(conn.RemoteAddr()),
+		event.DestinationAddr(conn.LocalAddr()),
+	))
+
+	conn2, err := s.d.Dial(conn)
+	if err != nil {
 		return err
-	case *net.TCPConn:
-		defer s.c.Send(event.New(
-			EventOptions,
-			event.Category("copy"),
-			event.Type("udp"),
-			event.SourceAddr(conn.RemoteAddr()),
-			event.DestinationAddr(conn.LocalAddr()),
-		))
-
-		conn2, err := s.d.Dial(conn)
-		if err != nil {
-			return err
-		}
-
-		defer conn2.Close()
-
-		go io.Copy(conn2, conn)
-		_, err = io.Copy(conn, conn2)
-		return err
-	default:
-		return nil
 	}
+
+	conn2 = record.Wrap(conn2, conn, s.c, "copy", s.Record)
+
+	defer conn2.Close()
+
+	go io.Copy(conn2, conn)
+	_, err = io.Copy(conn, conn2)
+	return err
 }
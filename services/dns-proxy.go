@@ -57,7 +57,7 @@ func (s *dnsProxy) Handle(ctx context.Context, conn net.Conn) error {
 
 	buff := [65535]byte{}
 
-	if _, ok := conn.(*listener.DummyUDPConn); ok {
+	if listener.IsUDPConn(conn) {
 		n, err := conn.Read(buff[:])
 		if err != nil {
 			return err
@@ -101,7 +101,7 @@ func (s *dnsProxy) Handle(ctx context.Context, conn net.Conn) error {
 		}
 
 		return err
-	} else if _, ok := conn.(*net.TCPConn); ok {
+	} else if _, ok := conn.LocalAddr().(*net.TCPAddr); ok {
 		n, err := conn.Read(buff[:])
 		if err != nil {
 			return err
@@ -45,7 +45,7 @@ func (s *echoService) SetChannel(c pushers.Channel) {
 }
 
 func (s *echoService) Handle(ctx context.Context, conn net.Conn) error {
-	if _, ok := conn.(*listener.DummyUDPConn); !ok {
+	if !listener.IsUDPConn(conn) {
 		_, err := io.Copy(conn, conn)
 		return err
 	}
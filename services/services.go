@@ -75,6 +75,13 @@ type Proxier interface {
 	SetDirector(director.Director)
 }
 
+// EarlyWriter is implemented by proxies that write to the connection before
+// dialing their director, like the ssh handshake of ssh-proxy. Their
+// connections can't be handed over to a fallback service.
+type EarlyWriter interface {
+	WritesBeforeDial() bool
+}
+
 func WithDirector(d director.Director) ServicerFunc {
 	return func(s Servicer) error {
 		if p, ok := s.(Proxier); ok {
@@ -15,6 +15,7 @@ package smtp
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/smtp"
 	"os"
@@ -32,8 +33,18 @@ const (
 )
 
 func TestMain(m *testing.M) {
-	storage.SetDataDir("")
-	os.Exit(m.Run())
+	dir, err := ioutil.TempDir("", "honeytrap-smtp")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	storage.SetDataDir(dir)
+
+	code := m.Run()
+
+	os.RemoveAll(dir)
+	os.Exit(code)
 }
 
 func TestSMTP(t *testing.T) {
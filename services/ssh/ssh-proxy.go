@@ -73,6 +73,12 @@ func (s *sshProxyService) SetDirector(d director.Director) {
 	s.d = d
 }
 
+// WritesBeforeDial returns true, the director is dialed after the ssh
+// handshake and password authentication.
+func (s *sshProxyService) WritesBeforeDial() bool {
+	return true
+}
+
 func (s *sshProxyService) Handle(ctx context.Context, conn net.Conn) error {
 	id := xid.New()
 
@@ -94,6 +100,8 @@ func (s *sshProxyService) Handle(ctx context.Context, conn net.Conn) error {
 				event.Custom("ssh.publickey", hex.EncodeToString(key.Marshal())),
 			))
 
+			director.Observe(cm.RemoteAddr(), director.IdentitySSHKey, ssh.FingerprintSHA256(key))
+
 			return nil, errors.New("Unknown key")
 		},
 		PasswordCallback: func(cm ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
@@ -108,6 +116,10 @@ func (s *sshProxyService) Handle(ctx context.Context, conn net.Conn) error {
 				event.Custom("ssh.password", string(password)),
 			))
 
+			// observed before dialing, so the source is assigned to the
+			// container of the credentials
+			director.Observe(cm.RemoteAddr(), director.IdentityCredentials, cm.User()+":"+string(password))
+
 			clientConfig := &ssh.ClientConfig{}
 
 			clientConfig.User = cm.User()
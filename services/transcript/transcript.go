@@ -0,0 +1,188 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package transcript
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnexpectedEOF = errors.New("connection closed before expected data was received")
+	ErrTimeout       = errors.New("timeout waiting for expected data")
+)
+
+// Step is a single step of a transcript, the client either sends
+// data to the service or expects data from it.
+type Step struct {
+	Line   int
+	Send   bool
+	Data   []byte
+	Expect []byte
+}
+
+// Transcript is a recorded client session. Transcripts are text files with a
+// step on every line, lines starting with "> " contain data sent by the client,
+// lines starting with "< " data the service should have sent. Data will be
+// unquoted as Go strings, so escapes as \r\n and \x00 can be used. Lines starting
+// with # are comments.
+//
+//	# telnet login
+//	< Username:
+//	> root\r\n
+//	< Password:
+type Transcript []Step
+
+// unescape interprets the Go escapes of the string.
+func unescape(s string) ([]byte, error) {
+	buff := bytes.Buffer{}
+
+	for len(s) > 0 {
+		r, multibyte, tail, err := strconv.UnquoteChar(s, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		if multibyte {
+			buff.WriteRune(r)
+		} else {
+			buff.WriteByte(byte(r))
+		}
+
+		s = tail
+	}
+
+	return buff.Bytes(), nil
+}
+
+// Parse parses the transcript.
+func Parse(r io.Reader) (Transcript, error) {
+	t := Transcript{}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if len(line) < 2 || line[1] != ' ' || (line[0] != '>' && line[0] != '<') {
+			return nil, fmt.Errorf("line %d: expected \"> \" or \"< \"", n)
+		}
+
+		data, err := unescape(line[2:])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err.Error())
+		}
+
+		step := Step{
+			Line: n,
+			Send: line[0] == '>',
+		}
+
+		if step.Send {
+			step.Data = data
+		} else {
+			step.Expect = data
+		}
+
+		t = append(t, step)
+	}
+
+	return t, scanner.Err()
+}
+
+// Replay replays the transcript against the connection, every expected step
+// should be received within the timeout. Data received from the service will
+// be written to w.
+func (t Transcript) Replay(conn net.Conn, timeout time.Duration, w io.Writer) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	ch := make(chan []byte)
+	errCh := make(chan error, 1)
+
+	// the connection is read continuously, services may
+	// not read while they are writing.
+	go func() {
+		for {
+			buffer := make([]byte, 4096)
+
+			n, err := conn.Read(buffer)
+			if n > 0 {
+				select {
+				case ch <- buffer[:n]:
+				case <-done:
+					return
+				}
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+		}
+	}()
+
+	received := []byte{}
+
+	for _, step := range t {
+		if step.Send {
+			conn.SetWriteDeadline(time.Now().Add(timeout))
+
+			if _, err := conn.Write(step.Data); err != nil {
+				return fmt.Errorf("line %d: %s", step.Line, err.Error())
+			}
+
+			continue
+		}
+
+		timer := time.NewTimer(timeout)
+
+		for !bytes.Contains(received, step.Expect) {
+			select {
+			case data := <-ch:
+				w.Write(data)
+
+				received = append(received, data...)
+				continue
+			case err := <-errCh:
+				timer.Stop()
+
+				if err == io.EOF {
+					return fmt.Errorf("line %d: %s, expected %q", step.Line, ErrUnexpectedEOF.Error(), step.Expect)
+				}
+
+				return fmt.Errorf("line %d: %s, expected %q", step.Line, err.Error(), step.Expect)
+			case <-timer.C:
+				return fmt.Errorf("line %d: %s, expected %q, received %q", step.Line, ErrTimeout.Error(), step.Expect, received)
+			}
+		}
+
+		timer.Stop()
+
+		i := bytes.Index(received, step.Expect)
+		received = received[i+len(step.Expect):]
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package transcript
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/services"
+)
+
+func TestParse(t *testing.T) {
+	tr, err := Parse(strings.NewReader(`# comment
+> USER "root"\r\n
+
+< 331 \x00é
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tr) != 2 {
+		t.Fatalf("Expected 2 steps, got %d", len(tr))
+	}
+
+	if !tr[0].Send || string(tr[0].Data) != "USER \"root\"\r\n" || tr[0].Line != 2 {
+		t.Errorf("Unexpected first step %+v", tr[0])
+	}
+
+	if tr[1].Send || string(tr[1].Expect) != "331 \x00é" || tr[1].Line != 4 {
+		t.Errorf("Unexpected second step %+v", tr[1])
+	}
+
+	if _, err := Parse(strings.NewReader("USER root\n")); err == nil {
+		t.Error("Expected error for line without direction")
+	}
+
+	if _, err := Parse(strings.NewReader("> \\q\n")); err == nil {
+		t.Error("Expected error for invalid escape")
+	}
+}
+
+func echo(t *testing.T, s string, timeout time.Duration) (string, error) {
+	tr, err := Parse(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	go func() {
+		defer server.Close()
+
+		services.Echo().Handle(context.Background(), server)
+	}()
+
+	buff := bytes.Buffer{}
+	err = tr.Replay(client, timeout, &buff)
+	return buff.String(), err
+}
+
+func TestReplay(t *testing.T) {
+	received, err := echo(t, "> hello\\n\n< hello\n> world\\n\n< world\\n\n", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if received != "hello\nworld\n" {
+		t.Errorf("Expected received data to be written, got %q", received)
+	}
+}
+
+func TestReplayTimeout(t *testing.T) {
+	_, err := echo(t, "> hello\n< hello\n< world\n", time.Millisecond*50)
+	if err == nil || !strings.Contains(err.Error(), ErrTimeout.Error()) {
+		t.Errorf("Expected timeout, got %v", err)
+	}
+
+	if !strings.HasPrefix(err.Error(), "line 3:") {
+		t.Errorf("Expected error on line 3, got %v", err)
+	}
+}
@@ -14,6 +14,7 @@
 package storage
 
 import (
+	"errors"
 	"log"
 	"path/filepath"
 
@@ -63,10 +64,22 @@ func MustDB() *badger.DB {
 type Storage interface {
 	Get(key string) ([]byte, error)
 	Set(key string, data []byte) error
+	Delete(key string) error
+}
+
+// Ranger is implemented by storages that can iterate over their keys.
+type Ranger interface {
+	// Range calls fn for the keys starting with prefix, until fn
+	// returns false.
+	Range(prefix string, fn func(key string, data []byte) bool) error
 }
 
 // Namespace sets the namespace prefix
 func Namespace(namespace string) (*badgeStorage, error) {
+	if db == nil {
+		return nil, errors.New("storage hasn't been initialized")
+	}
+
 	prefix := make([]byte, len(namespace)+1)
 
 	_ = copy(prefix, namespace)
@@ -116,3 +129,40 @@ func (s *badgeStorage) Set(key string, data []byte) error {
 		return err
 	})
 }
+
+func (s *badgeStorage) Delete(key string) error {
+	k := append(append([]byte{}, s.ns...), key...)
+
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(k)
+	})
+}
+
+func (s *badgeStorage) Range(prefix string, fn func(key string, data []byte) bool) error {
+	p := append(append([]byte{}, s.ns...), prefix...)
+
+	return s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(p); it.ValidForPrefix(p); it.Next() {
+			item := it.Item()
+
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			if !fn(string(item.Key()[len(s.ns):]), v) {
+				return nil
+			}
+		}
+
+		return nil
+	})
+}
+
+// DataDir returns the data directory.
+func DataDir() string {
+	return dataDir
+}
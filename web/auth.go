@@ -0,0 +1,617 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/honeytrap/honeytrap/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("Invalid username or password")
+	ErrNoRole             = errors.New("User has no role assigned")
+	ErrNoAuthentication   = errors.New("No users or oidc provider configured, set insecure_no_auth to serve the web interface without authentication")
+	ErrTooManyFailures    = errors.New("Too many failed logins, please try again later")
+)
+
+const (
+	sessionCookie = "honeytrap-session"
+	csrfCookie    = "honeytrap-csrf"
+	csrfHeader    = "X-CSRF-Token"
+	csrfField     = "csrf_token"
+
+	defaultSessionTimeout = time.Hour * 12
+
+	// maxLoginFailures is the number of failed logins of a user or
+	// address before further logins are delayed
+	maxLoginFailures = 5
+	maxLoginDelay    = time.Minute * 15
+)
+
+type userConfig struct {
+	Username string `toml:"username"`
+	// Password is the bcrypt hash of the password
+	Password string `toml:"password"`
+	Role     string `toml:"role"`
+}
+
+type authConfig struct {
+	Users []userConfig `toml:"users"`
+
+	// Htpasswd is the path of a htpasswd file with bcrypt hashes,
+	// roles of these users are looked up in Roles.
+	Htpasswd    string            `toml:"htpasswd"`
+	Roles       map[string]string `toml:"roles"`
+	DefaultRole string            `toml:"default_role"`
+
+	SessionTimeout config.Delay `toml:"session_timeout"`
+
+	OIDC oidcConfig `toml:"oidc"`
+
+	// InsecureNoAuth serves the web interface, including captured
+	// credentials and payloads, without authentication.
+	InsecureNoAuth bool `toml:"insecure_no_auth"`
+}
+
+type user struct {
+	hash []byte
+	role Role
+}
+
+type session struct {
+	Username  string `json:"username"`
+	Role      Role   `json:"role"`
+	CSRFToken string `json:"csrf_token"`
+
+	expires time.Time
+}
+
+// failures are the failed logins of a user or address.
+type failures struct {
+	count int
+
+	last time.Time
+	// until logins are refused
+	until time.Time
+}
+
+// auth authenticates users of the web interface and keeps their sessions.
+type auth struct {
+	users       map[string]user
+	roles       map[string]Role
+	defaultRole Role
+
+	// dummy is compared against for unknown users, to not disclose
+	// which users exist through timing.
+	dummy []byte
+
+	timeout time.Duration
+	origins []string
+
+	oidc *oidcProvider
+
+	m        sync.Mutex
+	sessions map[string]*session
+	failures map[string]*failures
+}
+
+func newAuth(c authConfig, origins []string) (*auth, error) {
+	a := &auth{
+		users:       map[string]user{},
+		roles:       map[string]Role{},
+		defaultRole: RoleReadOnly,
+		timeout:     defaultSessionTimeout,
+		origins:     origins,
+		sessions:    map[string]*session{},
+		failures:    map[string]*failures{},
+	}
+
+	if c.SessionTimeout != 0 {
+		a.timeout = c.SessionTimeout.Duration()
+	}
+
+	if c.DefaultRole != "" {
+		role, ok := parseRole(c.DefaultRole)
+		if !ok {
+			return nil, fmt.Errorf("Unknown default role %s", c.DefaultRole)
+		}
+
+		a.defaultRole = role
+	}
+
+	for username, s := range c.Roles {
+		role, ok := parseRole(s)
+		if !ok {
+			return nil, fmt.Errorf("Unknown role %s for user %s", s, username)
+		}
+
+		a.roles[username] = role
+	}
+
+	if c.Htpasswd != "" {
+		if err := a.readHtpasswd(c.Htpasswd); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, u := range c.Users {
+		role := RoleReadOnly
+		if u.Role != "" {
+			var ok bool
+			if role, ok = parseRole(u.Role); !ok {
+				return nil, fmt.Errorf("Unknown role %s for user %s", u.Role, u.Username)
+			}
+		}
+
+		if _, err := bcrypt.Cost([]byte(u.Password)); err != nil {
+			return nil, fmt.Errorf("Password of user %s is not a bcrypt hash: %s", u.Username, err.Error())
+		}
+
+		a.users[u.Username] = user{
+			hash: []byte(u.Password),
+			role: role,
+		}
+	}
+
+	if c.OIDC.Issuer != "" {
+		p, err := newOIDCProvider(c.OIDC)
+		if err != nil {
+			return nil, err
+		}
+
+		a.oidc = p
+	}
+
+	if !a.Enabled() && !c.InsecureNoAuth {
+		return nil, ErrNoAuthentication
+	}
+
+	if len(a.users) > 0 {
+		hash, err := bcrypt.GenerateFromPassword([]byte("honeytrap"), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+
+		a.dummy = hash
+	}
+
+	return a, nil
+}
+
+// readHtpasswd reads the users of the htpasswd file, only bcrypt
+// hashes (htpasswd -B) are supported.
+func (a *auth) readHtpasswd(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("Invalid line in htpasswd file %s", path)
+		}
+
+		if _, err := bcrypt.Cost([]byte(parts[1])); err != nil {
+			return fmt.Errorf("Unsupported hash for user %s in htpasswd file %s, only bcrypt is supported", parts[0], path)
+		}
+
+		role, ok := a.roles[parts[0]]
+		if !ok {
+			role = a.defaultRole
+		}
+
+		a.users[parts[0]] = user{
+			hash: []byte(parts[1]),
+			role: role,
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Enabled returns whether users have been configured.
+func (a *auth) Enabled() bool {
+	return len(a.users) > 0 || a.oidc != nil
+}
+
+// Authenticate returns the role of the user when the password matches.
+func (a *auth) Authenticate(username, password string) (Role, error) {
+	u, ok := a.users[username]
+	if !ok {
+		if a.dummy != nil {
+			bcrypt.CompareHashAndPassword(a.dummy, []byte(password))
+		}
+
+		return "", ErrInvalidCredentials
+	}
+
+	if err := bcrypt.CompareHashAndPassword(u.hash, []byte(password)); err != nil {
+		return "", ErrInvalidCredentials
+	}
+
+	return u.role, nil
+}
+
+// delayed returns whether logins of any of the keys are delayed.
+func (a *auth) delayed(keys ...string) bool {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	now := time.Now()
+
+	for _, key := range keys {
+		if f, ok := a.failures[key]; ok && now.Before(f.until) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// failed records a failed login of the keys, after maxLoginFailures
+// failures logins are delayed exponentially.
+func (a *auth) failed(keys ...string) {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	now := time.Now()
+
+	for key, f := range a.failures {
+		if now.Sub(f.last) > maxLoginDelay && now.After(f.until) {
+			delete(a.failures, key)
+		}
+	}
+
+	for _, key := range keys {
+		f, ok := a.failures[key]
+		if !ok {
+			f = &failures{}
+			a.failures[key] = f
+		}
+
+		f.count++
+		f.last = now
+
+		if f.count < maxLoginFailures {
+			continue
+		}
+
+		delay := maxLoginDelay
+		if n := uint(f.count - maxLoginFailures); n < 10 && time.Second<<n < delay {
+			delay = time.Second << n
+		}
+
+		f.until = now.Add(delay)
+	}
+}
+
+// succeeded resets the failed logins of the keys.
+func (a *auth) succeeded(keys ...string) {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	for _, key := range keys {
+		delete(a.failures, key)
+	}
+}
+
+func randomToken() string {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(data)
+}
+
+func setCookie(w http.ResponseWriter, r *http.Request, name, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// login starts a new session for the user.
+func (a *auth) login(w http.ResponseWriter, r *http.Request, username string, role Role) {
+	id := randomToken()
+
+	now := time.Now()
+
+	a.m.Lock()
+	for id, s := range a.sessions {
+		if now.After(s.expires) {
+			delete(a.sessions, id)
+		}
+	}
+
+	a.sessions[id] = &session{
+		Username:  username,
+		Role:      role,
+		CSRFToken: randomToken(),
+		expires:   now.Add(a.timeout),
+	}
+	a.m.Unlock()
+
+	setCookie(w, r, sessionCookie, id, int(a.timeout.Seconds()))
+
+	log.Infof("User %s (%s) logged in from %s", username, role, r.RemoteAddr)
+}
+
+// Session returns the session of the request.
+func (a *auth) Session(r *http.Request) (*session, bool) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil, false
+	}
+
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	s, ok := a.sessions[c.Value]
+	if !ok {
+		return nil, false
+	}
+
+	if time.Now().After(s.expires) {
+		delete(a.sessions, c.Value)
+		return nil, false
+	}
+
+	return s, true
+}
+
+// checkOrigin returns whether the origin of the request is allowed. Requests
+// without origin are not sent by browsers and are allowed, without allowlist
+// only the origin of the web interface itself is allowed.
+func checkOrigin(origins []string, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	if len(origins) == 0 {
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+
+		return strings.EqualFold(u.Host, r.Host)
+	}
+
+	for _, o := range origins {
+		if strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func tokenEqual(a, b string) bool {
+	return a != "" && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// Handler returns a handler that requires a session, unauthenticated
+// users will be redirected to the login page.
+func (a *auth) Handler(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := a.Session(r); ok {
+			h(w, r)
+			return
+		}
+
+		if r.URL.Path == "/ws" {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		http.Redirect(w, r, "/login", http.StatusFound)
+	})
+}
+
+// Mount adds the authentication handlers.
+func (a *auth) Mount(mux *http.ServeMux) {
+	mux.HandleFunc("/login", a.ServeLogin)
+	mux.HandleFunc("/logout", a.ServeLogout)
+	mux.HandleFunc("/auth/session", a.ServeSession)
+
+	if a.oidc != nil {
+		mux.HandleFunc("/auth/oidc", a.ServeOIDC)
+		mux.HandleFunc("/auth/oidc/callback", a.ServeOIDCCallback)
+	}
+}
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Honeytrap</title>
+</head>
+<body>
+<h1>Honeytrap</h1>
+{{ if .Error }}<p>{{ .Error }}</p>{{ end }}
+{{ if .Password }}
+<form method="post" action="/login">
+<input type="hidden" name="csrf_token" value="{{ .CSRFToken }}">
+<p><label>Username <input type="text" name="username" autofocus></label></p>
+<p><label>Password <input type="password" name="password"></label></p>
+<p><button type="submit">Log in</button></p>
+</form>
+{{ end }}
+{{ if .OIDC }}<p><a href="/auth/oidc">Log in with single sign-on</a></p>{{ end }}
+</body>
+</html>
+`))
+
+func (a *auth) renderLogin(w http.ResponseWriter, r *http.Request, status int, message string) {
+	token := randomToken()
+
+	// double submit cookie, the login form has no session yet
+	setCookie(w, r, csrfCookie, token, 0)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+
+	loginTemplate.Execute(w, struct {
+		Error     string
+		CSRFToken string
+		Password  bool
+		OIDC      bool
+	}{
+		Error:     message,
+		CSRFToken: token,
+		Password:  len(a.users) > 0,
+		OIDC:      a.oidc != nil,
+	})
+}
+
+func (a *auth) ServeLogin(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.renderLogin(w, r, http.StatusOK, "")
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkOrigin(a.origins, r) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	c, err := r.Cookie(csrfCookie)
+	if err != nil || !tokenEqual(c.Value, r.PostFormValue(csrfField)) {
+		a.renderLogin(w, r, http.StatusForbidden, "Invalid CSRF token, please try again")
+		return
+	}
+
+	username := r.PostFormValue("username")
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	keys := []string{"user:" + username, "addr:" + host}
+
+	if a.delayed(keys...) {
+		log.Warningf("Delayed login of user %s from %s after failed logins", username, r.RemoteAddr)
+
+		a.renderLogin(w, r, http.StatusTooManyRequests, ErrTooManyFailures.Error())
+		return
+	}
+
+	role, err := a.Authenticate(username, r.PostFormValue("password"))
+	if err != nil {
+		log.Warningf("Failed login of user %s from %s", username, r.RemoteAddr)
+
+		a.failed(keys...)
+
+		a.renderLogin(w, r, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	a.succeeded(keys...)
+
+	setCookie(w, r, csrfCookie, "", -1)
+
+	a.login(w, r, username, role)
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (a *auth) ServeLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkOrigin(a.origins, r) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	s, ok := a.Session(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	token := r.Header.Get(csrfHeader)
+	if token == "" {
+		token = r.PostFormValue(csrfField)
+	}
+
+	if !tokenEqual(s.CSRFToken, token) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	c, _ := r.Cookie(sessionCookie)
+
+	a.m.Lock()
+	delete(a.sessions, c.Value)
+	a.m.Unlock()
+
+	setCookie(w, r, sessionCookie, "", -1)
+
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// ServeSession returns the user, role and csrf token of the session.
+func (a *auth) ServeSession(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.Session(r)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	json.NewEncoder(w).Encode(s)
+}
@@ -0,0 +1,562 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/honeytrap/honeytrap/event"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func hash(t *testing.T, password string) string {
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(h)
+}
+
+func TestAuthHtpasswd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "htpasswd")
+
+	data := fmt.Sprintf("# users\nalice:%s\nbob:%s\n", hash(t, "alice"), hash(t, "bob"))
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := newAuth(authConfig{
+		Htpasswd: path,
+		Roles: map[string]string{
+			"alice": "admin",
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if role, err := a.Authenticate("alice", "alice"); err != nil || role != RoleAdmin {
+		t.Errorf("Expected alice to be admin, got %s %v", role, err)
+	}
+
+	if role, err := a.Authenticate("bob", "bob"); err != nil || role != RoleReadOnly {
+		t.Errorf("Expected bob to be read-only, got %s %v", role, err)
+	}
+
+	if _, err := a.Authenticate("bob", "alice"); err != ErrInvalidCredentials {
+		t.Errorf("Expected invalid credentials, got %v", err)
+	}
+
+	if _, err := a.Authenticate("carol", "carol"); err != ErrInvalidCredentials {
+		t.Errorf("Expected invalid credentials, got %v", err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("alice:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newAuth(authConfig{Htpasswd: path}, nil); err == nil {
+		t.Error("Expected sha hashes to be rejected")
+	}
+}
+
+func TestAuthConfig(t *testing.T) {
+	if _, err := newAuth(authConfig{Users: []userConfig{{Username: "alice", Password: "plain"}}}, nil); err == nil {
+		t.Error("Expected plain text password to be rejected")
+	}
+
+	if _, err := newAuth(authConfig{Users: []userConfig{{Username: "alice", Password: hash(t, "alice"), Role: "root"}}}, nil); err == nil {
+		t.Error("Expected unknown role to be rejected")
+	}
+
+	if _, err := newAuth(authConfig{OIDC: oidcConfig{Issuer: "http://issuer", ClientID: "id", RedirectURL: "http://localhost"}}, nil); err == nil {
+		t.Error("Expected issuer without https to be rejected")
+	}
+
+	if _, err := newAuth(authConfig{}, nil); err != ErrNoAuthentication {
+		t.Errorf("Expected ErrNoAuthentication without users, got %v", err)
+	}
+
+	a, err := newAuth(authConfig{InsecureNoAuth: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Enabled() {
+		t.Error("Expected authentication to be disabled with insecure_no_auth")
+	}
+}
+
+var csrfRegexp = regexp.MustCompile(`name="csrf_token" value="([0-9a-f]+)"`)
+
+func newAuthServer(t *testing.T, a *auth) (*httptest.Server, *http.Client) {
+	mux := http.NewServeMux()
+	a.Mount(mux)
+
+	mux.Handle("/", a.Handler(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("dashboard"))
+	}))
+
+	server := httptest.NewServer(mux)
+
+	jar, _ := cookiejar.New(nil)
+
+	return server, &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func loginForm(t *testing.T, client *http.Client, server *httptest.Server) string {
+	resp, err := client.Get(server.URL + "/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	m := csrfRegexp.FindSubmatch(body)
+	if m == nil {
+		t.Fatalf("Expected csrf token in login form: %s", body)
+	}
+
+	return string(m[1])
+}
+
+func TestAuthLogin(t *testing.T) {
+	a, err := newAuth(authConfig{
+		Users: []userConfig{
+			{Username: "alice", Password: hash(t, "secret"), Role: "admin"},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, client := newAuthServer(t, a)
+	defer server.Close()
+
+	resp, err := client.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/login" {
+		t.Fatalf("Expected redirect to login, got %d", resp.StatusCode)
+	}
+
+	token := loginForm(t, client, server)
+
+	// without csrf token
+	resp, err = client.PostForm(server.URL+"/login", url.Values{"username": {"alice"}, "password": {"secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected login without csrf token to be forbidden, got %d", resp.StatusCode)
+	}
+
+	token = loginForm(t, client, server)
+
+	resp, err = client.PostForm(server.URL+"/login", url.Values{"username": {"alice"}, "password": {"wrong"}, "csrf_token": {token}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected wrong password to be unauthorized, got %d", resp.StatusCode)
+	}
+
+	token = loginForm(t, client, server)
+
+	resp, err = client.PostForm(server.URL+"/login", url.Values{"username": {"alice"}, "password": {"secret"}, "csrf_token": {token}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("Expected login to succeed, got %d", resp.StatusCode)
+	}
+
+	resp, err = client.Get(server.URL + "/auth/session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := session{}
+	json.NewDecoder(resp.Body).Decode(&s)
+	resp.Body.Close()
+
+	if s.Username != "alice" || s.Role != RoleAdmin || s.CSRFToken == "" {
+		t.Fatalf("Unexpected session %+v", s)
+	}
+
+	// logout without csrf token
+	resp, err = client.Post(server.URL+"/logout", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected logout without csrf token to be forbidden, got %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/logout", nil)
+	req.Header.Set(csrfHeader, s.CSRFToken)
+
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Errorf("Expected logout to succeed, got %d", resp.StatusCode)
+	}
+
+	if len(a.sessions) != 0 {
+		t.Errorf("Expected session to be removed")
+	}
+}
+
+func TestAuthLoginDelay(t *testing.T) {
+	a, err := newAuth(authConfig{
+		Users: []userConfig{
+			{Username: "alice", Password: hash(t, "secret"), Role: "admin"},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, client := newAuthServer(t, a)
+	defer server.Close()
+
+	login := func(password string) int {
+		token := loginForm(t, client, server)
+
+		resp, err := client.PostForm(server.URL+"/login", url.Values{"username": {"alice"}, "password": {password}, "csrf_token": {token}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for i := 0; i < maxLoginFailures; i++ {
+		if status := login("wrong"); status != http.StatusUnauthorized {
+			t.Fatalf("Expected wrong password to be unauthorized, got %d", status)
+		}
+	}
+
+	// the correct password is refused while logins are delayed
+	if status := login("secret"); status != http.StatusTooManyRequests {
+		t.Fatalf("Expected login to be delayed, got %d", status)
+	}
+
+	a.m.Lock()
+	for _, f := range a.failures {
+		f.until = time.Time{}
+	}
+	a.m.Unlock()
+
+	if status := login("secret"); status != http.StatusSeeOther {
+		t.Fatalf("Expected login to succeed after the delay, got %d", status)
+	}
+
+	if len(a.failures) != 0 {
+		t.Errorf("Expected failures to be reset after login, got %d", len(a.failures))
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	for _, test := range []struct {
+		origins  []string
+		origin   string
+		expected bool
+	}{
+		{nil, "", true},
+		{nil, "http://honeytrap:8089", true},
+		{nil, "http://evil.example", false},
+		{[]string{"https://dashboard.example/"}, "https://dashboard.example", true},
+		{[]string{"https://dashboard.example"}, "http://honeytrap:8089", false},
+	} {
+		r := httptest.NewRequest("GET", "http://honeytrap:8089/ws", nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+
+		if v := checkOrigin(test.origins, r); v != test.expected {
+			t.Errorf("Expected %t for origin %q with %v, got %t", test.expected, test.origin, test.origins, v)
+		}
+	}
+}
+
+func TestMask(t *testing.T) {
+	e := event.New(
+		event.Category("ssh"),
+		event.Custom("ssh.username", "root"),
+		event.Custom("ssh.password", "toor"),
+		event.Payload([]byte("uname -a")),
+	)
+
+	events := NewSafeArray()
+	events.Append(e)
+
+	for _, msg := range []json.Marshaler{
+		Data("event", e),
+		Data("events", events),
+	} {
+		data, err := mask(RoleReadOnly, msg).MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, s := range []string{"toor", "uname", "756e616d65"} {
+			if strings.Contains(string(data), s) {
+				t.Errorf("Expected %s to be masked: %s", s, data)
+			}
+		}
+
+		if !strings.Contains(string(data), "root") {
+			t.Errorf("Expected username not to be masked: %s", data)
+		}
+
+		if data, _ := mask(RoleAdmin, msg).MarshalJSON(); !strings.Contains(string(data), "toor") {
+			t.Errorf("Expected admin to see password: %s", data)
+		}
+	}
+}
+
+func TestMaskServiceFields(t *testing.T) {
+	sensitive := []string{
+		"ftp.command",
+		"future.field",
+		"http.body",
+		"http.cookie.session",
+		"http.header.authorization",
+		"http.header.cookie",
+		"http.header.proxy-authorization",
+		"http.headers",
+		"http.url",
+		"ipp.user",
+		"memcached.command",
+		"memcached.command-hex",
+		"redis.command",
+		"sip.headers",
+		"smtp.argument",
+		"smtp.body",
+		"smtp.line",
+		"smtp.password",
+		"snmp.community",
+		"ssh.command",
+		"ssh.exec",
+		"ssh.password",
+		"ssh.payload",
+		"ssh.publickey",
+		"ssh.recording",
+		"telnet.command",
+		"telnet.password",
+		"tftp.file",
+		"tftp.file-hex",
+	}
+
+	for _, key := range sensitive {
+		if isVisible(key) {
+			t.Errorf("Expected %s to be masked", key)
+		}
+	}
+
+	for _, key := range []string{
+		"category",
+		"ftp.sessionid",
+		"http.header.user-agent",
+		"http.method",
+		"smtp.username",
+		"source-ip",
+		"source.country.isocode",
+		"ssh.username",
+		"telnet.username",
+	} {
+		if !isVisible(key) {
+			t.Errorf("Expected %s not to be masked", key)
+		}
+	}
+}
+
+func idToken(claims map[string]interface{}) string {
+	data, _ := json.Marshal(claims)
+
+	return strings.Join([]string{
+		base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256"}`)),
+		base64.RawURLEncoding.EncodeToString(data),
+		"signature",
+	}, ".")
+}
+
+func TestAuthOIDC(t *testing.T) {
+	nonce := ""
+
+	var issuer *httptest.Server
+	issuer = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(oidcMetadata{
+				Issuer:                issuer.URL,
+				AuthorizationEndpoint: issuer.URL + "/authorize",
+				TokenEndpoint:         issuer.URL + "/token",
+			})
+		case "/token":
+			if id, secret, _ := r.BasicAuth(); id != "honeytrap" || secret != "s3cr3t" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			if r.PostFormValue("code") != "code" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			json.NewEncoder(w).Encode(map[string]string{
+				"id_token": idToken(map[string]interface{}{
+					"iss":   issuer.URL,
+					"aud":   []string{"honeytrap"},
+					"exp":   time.Now().Add(time.Minute).Unix(),
+					"nonce": nonce,
+					"email": "alice@example.com",
+				}),
+			})
+		}
+	}))
+	defer issuer.Close()
+
+	a, err := newAuth(authConfig{
+		OIDC: oidcConfig{
+			Issuer:       issuer.URL,
+			ClientID:     "honeytrap",
+			ClientSecret: "s3cr3t",
+			RedirectURL:  "http://honeytrap/auth/oidc/callback",
+			DefaultRole:  "read-only",
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.oidc.client = issuer.Client()
+
+	server, client := newAuthServer(t, a)
+	defer server.Close()
+
+	resp, err := client.Get(server.URL + "/auth/oidc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	u, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(u.String(), issuer.URL+"/authorize") {
+		t.Fatalf("Expected redirect to issuer, got %s", u)
+	}
+
+	state := u.Query().Get("state")
+	nonce = u.Query().Get("nonce")
+
+	// callback with another state
+	resp, err = client.Get(server.URL + "/auth/oidc/callback?code=code&state=other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected unknown state to be forbidden, got %d", resp.StatusCode)
+	}
+
+	// the state cookie has been removed, login again
+	resp, err = client.Get(server.URL + "/auth/oidc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	u, _ = url.Parse(resp.Header.Get("Location"))
+	state = u.Query().Get("state")
+	nonce = u.Query().Get("nonce")
+
+	resp, err = client.Get(server.URL + "/auth/oidc/callback?code=code&state=" + state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("Expected login to succeed, got %d", resp.StatusCode)
+	}
+
+	resp, err = client.Get(server.URL + "/auth/session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := session{}
+	json.NewDecoder(resp.Body).Decode(&s)
+	resp.Body.Close()
+
+	if s.Username != "alice@example.com" || s.Role != RoleReadOnly {
+		t.Errorf("Unexpected session %+v", s)
+	}
+}
@@ -40,6 +40,9 @@ type connection struct {
 
 	web *web
 
+	// role of the user, events are masked for read-only users
+	role Role
+
 	send chan json.Marshaler
 }
 
@@ -0,0 +1,372 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidIDToken = errors.New("Invalid id token")
+	ErrInvalidState   = errors.New("Invalid state")
+)
+
+const (
+	oidcCookie = "honeytrap-oidc"
+
+	oidcStateTimeout = time.Minute * 10
+)
+
+type oidcConfig struct {
+	Issuer       string   `toml:"issuer"`
+	ClientID     string   `toml:"client_id"`
+	ClientSecret string   `toml:"client_secret"`
+	RedirectURL  string   `toml:"redirect_url"`
+	Scopes       []string `toml:"scopes"`
+
+	// UsernameClaim is the claim of the id token used as username.
+	UsernameClaim string `toml:"username_claim"`
+
+	// DefaultRole is the role of users without role, these
+	// users will be denied when empty.
+	DefaultRole string `toml:"default_role"`
+}
+
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+type oidcState struct {
+	nonce   string
+	expires time.Time
+}
+
+// oidcProvider implements the OpenID Connect authorization code flow.
+type oidcProvider struct {
+	oidcConfig
+
+	defaultRole Role
+
+	client *http.Client
+
+	m        sync.Mutex
+	metadata *oidcMetadata
+	states   map[string]oidcState
+}
+
+func newOIDCProvider(c oidcConfig) (*oidcProvider, error) {
+	p := &oidcProvider{
+		oidcConfig: c,
+		client:     &http.Client{Timeout: time.Second * 10},
+		states:     map[string]oidcState{},
+	}
+
+	if !strings.HasPrefix(p.Issuer, "https://") {
+		return nil, fmt.Errorf("OIDC issuer %s should use https", p.Issuer)
+	}
+
+	if p.ClientID == "" || p.RedirectURL == "" {
+		return nil, fmt.Errorf("OIDC needs client_id and redirect_url")
+	}
+
+	if p.DefaultRole != "" {
+		role, ok := parseRole(p.DefaultRole)
+		if !ok {
+			return nil, fmt.Errorf("Unknown OIDC default role %s", p.DefaultRole)
+		}
+
+		p.defaultRole = role
+	}
+
+	if len(p.Scopes) == 0 {
+		p.Scopes = []string{"openid", "profile", "email"}
+	}
+
+	if p.UsernameClaim == "" {
+		p.UsernameClaim = "email"
+	}
+
+	return p, nil
+}
+
+// discover retrieves the provider metadata, the metadata is retrieved on first
+// use so honeytrap will start while the provider is unavailable.
+func (p *oidcProvider) discover() (*oidcMetadata, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	resp, err := p.client.Get(strings.TrimSuffix(p.Issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status retrieving OIDC configuration: %s", resp.Status)
+	}
+
+	metadata := oidcMetadata{}
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(metadata.Issuer, "/") != strings.TrimSuffix(p.Issuer, "/") {
+		return nil, fmt.Errorf("OIDC issuer mismatch, got %s", metadata.Issuer)
+	}
+
+	p.metadata = &metadata
+	return p.metadata, nil
+}
+
+// AuthCodeURL returns the url of the provider to redirect the user to.
+func (p *oidcProvider) AuthCodeURL(state string) (string, error) {
+	metadata, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := randomToken()
+
+	now := time.Now()
+
+	p.m.Lock()
+	for state, s := range p.states {
+		if now.After(s.expires) {
+			delete(p.states, state)
+		}
+	}
+
+	p.states[state] = oidcState{
+		nonce:   nonce,
+		expires: now.Add(oidcStateTimeout),
+	}
+	p.m.Unlock()
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.ClientID)
+	v.Set("redirect_uri", p.RedirectURL)
+	v.Set("scope", strings.Join(p.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+
+	sep := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return metadata.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+// Exchange exchanges the code for an id token, and returns its claims.
+func (p *oidcProvider) Exchange(state, code string) (map[string]interface{}, error) {
+	p.m.Lock()
+	s, ok := p.states[state]
+	delete(p.states, state)
+	p.m.Unlock()
+
+	if !ok || time.Now().After(s.expires) {
+		return nil, ErrInvalidState
+	}
+
+	metadata, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	v.Set("redirect_uri", p.RedirectURL)
+
+	req, err := http.NewRequest(http.MethodPost, metadata.TokenEndpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status exchanging OIDC code: %s", resp.Status)
+	}
+
+	token := struct {
+		IDToken string `json:"id_token"`
+	}{}
+
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, err
+	}
+
+	claims, err := parseIDToken(token.IDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// the id token has been received directly from the token endpoint
+	// over tls, so the claims are validated instead of the signature
+	// (OpenID Connect Core 1.0, section 3.1.3.7).
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != strings.TrimSuffix(metadata.Issuer, "/") {
+		return nil, fmt.Errorf("%s: unexpected issuer %s", ErrInvalidIDToken, iss)
+	}
+
+	if !audience(claims["aud"], p.ClientID) {
+		return nil, fmt.Errorf("%s: unexpected audience", ErrInvalidIDToken)
+	}
+
+	if exp, _ := claims["exp"].(float64); time.Now().After(time.Unix(int64(exp), 0)) {
+		return nil, fmt.Errorf("%s: expired", ErrInvalidIDToken)
+	}
+
+	if nonce, _ := claims["nonce"].(string); !tokenEqual(s.nonce, nonce) {
+		return nil, fmt.Errorf("%s: unexpected nonce", ErrInvalidIDToken)
+	}
+
+	return claims, nil
+}
+
+func audience(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		for _, a := range v {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func parseIDToken(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidIDToken
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	claims := map[string]interface{}{}
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	return claims, nil
+}
+
+// Username returns the username of the claims.
+func (p *oidcProvider) Username(claims map[string]interface{}) (string, bool) {
+	username, _ := claims[p.UsernameClaim].(string)
+	if username == "" {
+		return "", false
+	}
+
+	if verified, ok := claims["email_verified"].(bool); ok && !verified && p.UsernameClaim == "email" {
+		return "", false
+	}
+
+	return username, true
+}
+
+func (a *auth) ServeOIDC(w http.ResponseWriter, r *http.Request) {
+	state := randomToken()
+
+	u, err := a.oidc.AuthCodeURL(state)
+	if err != nil {
+		log.Errorf("Error starting OIDC login: %s", err.Error())
+
+		a.renderLogin(w, r, http.StatusBadGateway, "Single sign-on is not available")
+		return
+	}
+
+	// binds the state to the browser starting the login
+	setCookie(w, r, oidcCookie, state, int(oidcStateTimeout.Seconds()))
+
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+func (a *auth) ServeOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+
+	c, err := r.Cookie(oidcCookie)
+	if err != nil || !tokenEqual(c.Value, state) {
+		a.renderLogin(w, r, http.StatusForbidden, ErrInvalidState.Error())
+		return
+	}
+
+	setCookie(w, r, oidcCookie, "", -1)
+
+	if msg := r.URL.Query().Get("error"); msg != "" {
+		a.renderLogin(w, r, http.StatusUnauthorized, fmt.Sprintf("Single sign-on failed: %s", msg))
+		return
+	}
+
+	claims, err := a.oidc.Exchange(state, r.URL.Query().Get("code"))
+	if err != nil {
+		log.Errorf("Error completing OIDC login: %s", err.Error())
+
+		a.renderLogin(w, r, http.StatusUnauthorized, "Single sign-on failed")
+		return
+	}
+
+	username, ok := a.oidc.Username(claims)
+	if !ok {
+		a.renderLogin(w, r, http.StatusUnauthorized, "Single sign-on failed")
+		return
+	}
+
+	role, ok := a.roles[username]
+	if !ok {
+		role = a.oidc.defaultRole
+	}
+
+	if role == "" {
+		log.Warningf("Denied OIDC login of user %s from %s: %s", username, r.RemoteAddr, ErrNoRole.Error())
+
+		a.renderLogin(w, r, http.StatusForbidden, ErrNoRole.Error())
+		return
+	}
+
+	a.login(w, r, username, role)
+
+	http.Redirect(w, r, "/", http.StatusFound)
+}
@@ -0,0 +1,169 @@
+// Copyright 2016-2019 DutchSec (https://dutchsec.com/)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package web
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/honeytrap/honeytrap/event"
+)
+
+// Role defines what a user of the web interface is allowed to see.
+type Role string
+
+const (
+	// RoleAdmin users see events as captured.
+	RoleAdmin Role = "admin"
+
+	// RoleReadOnly users see events with all but the visible fields,
+	// like addresses and usernames, masked.
+	RoleReadOnly Role = "read-only"
+)
+
+func parseRole(s string) (Role, bool) {
+	switch Role(s) {
+	case RoleAdmin, RoleReadOnly:
+		return Role(s), true
+	default:
+		return "", false
+	}
+}
+
+const masked = "********"
+
+// visibleFields are the fields read-only users see, all other fields are
+// masked as they can contain credentials or data sent by attackers, eg. the
+// query string of urls.
+var visibleFields = []string{
+	"agent",
+	"agent.name",
+	"category",
+	"date",
+	"destination-ip",
+	"destination-mac",
+	"destination-port",
+	"director",
+	"duration",
+	"fallback",
+	"host-addr",
+	"listener",
+	"listener.type",
+	"payload-length",
+	"protocol",
+	"reason",
+	"remote-addr",
+	"sensor",
+	"service",
+	"source-ip",
+	"source-mac",
+	"source-port",
+	"type",
+}
+
+// visibleSuffixes are the last parts of the field names of services read-only
+// users see, eg. ssh.username.
+var visibleSuffixes = []string{
+	"method",
+	"session-id",
+	"sessionid",
+	"status",
+	"user-agent",
+	"username",
+	"version",
+}
+
+// visiblePrefixes are the prefixes of fields added by the web interface.
+var visiblePrefixes = []string{
+	"source.country.",
+}
+
+func isVisible(key string) bool {
+	for _, name := range visibleFields {
+		if key == name {
+			return true
+		}
+	}
+
+	for _, prefix := range visiblePrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	i := strings.LastIndex(key, ".")
+	if i == -1 {
+		return false
+	}
+
+	key = key[i+1:]
+
+	for _, name := range visibleSuffixes {
+		if key == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maskEvent returns the fields of the event, with all but the visible
+// fields masked.
+func maskEvent(e event.Event) map[string]interface{} {
+	m := event.ToMap(e)
+
+	for key := range m {
+		if !isVisible(key) {
+			m[key] = masked
+		}
+	}
+
+	return m
+}
+
+// mask returns the message as it will be sent to users of the role.
+func mask(role Role, msg json.Marshaler) json.Marshaler {
+	if role == RoleAdmin {
+		return msg
+	}
+
+	m, ok := msg.(*Message)
+	if !ok {
+		return msg
+	}
+
+	switch v := m.Data.(type) {
+	case event.Event:
+		return Data(m.Type, maskEvent(v))
+	case *SafeArray:
+		if m.Type != "events" {
+			return msg
+		}
+
+		events := []interface{}{}
+
+		v.Range(func(item interface{}) bool {
+			if e, ok := item.(event.Event); ok {
+				item = maskEvent(e)
+			}
+
+			events = append(events, item)
+			return true
+		})
+
+		return Data(m.Type, events)
+	default:
+		return msg
+	}
+}
@@ -15,8 +15,12 @@ package web
 
 import (
 	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
@@ -37,8 +41,6 @@ import (
 
 var log = logging.MustGetLogger("web")
 
-func AcceptAllOrigins(r *http.Request) bool { return true }
-
 func download(url string, dest string) error {
 	client := &http.Client{}
 
@@ -81,6 +83,22 @@ type web struct {
 	ListenAddress string `toml:"listen"`
 	Enabled       bool   `toml:"enabled"`
 
+	// TLSClientCA enables client certificate authentication
+	TLSCert     string `toml:"tls_cert"`
+	TLSKey      string `toml:"tls_key"`
+	TLSClientCA string `toml:"tls_client_ca"`
+
+	// Origins are the origins allowed to connect, defaults to the
+	// origin of the web interface itself.
+	Origins []string `toml:"origins"`
+
+	Auth authConfig `toml:"auth"`
+
+	auth *auth
+
+	// handlers mounted next to the web interface
+	handlers map[string]http.Handler
+
 	eb *eventbus.EventBus
 
 	start time.Time
@@ -110,6 +128,8 @@ func New(options ...func(*web) error) (*web, error) {
 		ListenAddress: "127.0.0.1:8089",
 		Enabled:       false,
 
+		handlers: map[string]http.Handler{},
+
 		register:    make(chan *connection),
 		unregister:  make(chan *connection),
 		connections: make(map[*connection]bool),
@@ -130,16 +150,62 @@ func New(options ...func(*web) error) (*web, error) {
 	return &hc, nil
 }
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+// SetEventBus sets the event bus, the web interface will subscribe to
+// the bus when started.
+func (web *web) SetEventBus(eb *eventbus.EventBus) {
+	web.eb = eb
 }
 
-func (web *web) SetEventBus(eb *eventbus.EventBus) {
-	eb.Subscribe(web)
+// Handle mounts the handler on the pattern.
+func (web *web) Handle(pattern string, h http.Handler) {
+	web.handlers[pattern] = h
+}
+
+func (web *web) tlsConfig() (*tls.Config, error) {
+	tc := &tls.Config{}
+
+	if web.TLSClientCA == "" {
+		return tc, nil
+	}
+
+	data, err := ioutil.ReadFile(web.TLSClientCA)
+	if err != nil {
+		return nil, err
+	}
+
+	tc.ClientCAs = x509.NewCertPool()
+	if !tc.ClientCAs.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("No certificates found in %s", web.TLSClientCA)
+	}
+
+	// clients without certificate can still authenticate otherwise
+	tc.ClientAuth = tls.VerifyClientCertIfGiven
+
+	return tc, nil
+}
+
+// Check validates the tls and authentication configuration.
+func (web *web) Check() error {
+	if !web.Enabled {
+		return nil
+	}
+
+	if _, err := web.tlsConfig(); err != nil {
+		return err
+	}
+
+	if (web.TLSCert == "") != (web.TLSKey == "") {
+		return fmt.Errorf("Both tls_cert and tls_key should be set")
+	}
+
+	if web.TLSCert != "" {
+		if _, err := tls.LoadX509KeyPair(web.TLSCert, web.TLSKey); err != nil {
+			return err
+		}
+	}
+
+	_, err := newAuth(web.Auth, web.Origins)
+	return err
 }
 
 func (web *web) Start() {
@@ -149,11 +215,30 @@ func (web *web) Start() {
 
 	handler := http.NewServeMux()
 
+	tc, err := web.tlsConfig()
+	if err != nil {
+		log.Errorf("Error configuring tls of web interface: %s", err.Error())
+		return
+	}
+
 	server := &http.Server{
-		Addr:    web.ListenAddress,
-		Handler: handler,
+		Addr:      web.ListenAddress,
+		Handler:   handler,
+		TLSConfig: tc,
 	}
 
+	for pattern, h := range web.handlers {
+		handler.Handle(pattern, h)
+	}
+
+	auth, err := newAuth(web.Auth, web.Origins)
+	if err != nil {
+		log.Errorf("Error configuring authentication of web interface: %s", err.Error())
+		return
+	}
+
+	web.auth = auth
+
 	sh := http.FileServer(&assetfs.AssetFS{
 		Asset:     assets.Asset,
 		AssetDir:  assets.AssetDir,
@@ -161,8 +246,17 @@ func (web *web) Start() {
 		Prefix:    assets.Prefix,
 	})
 
-	handler.HandleFunc("/ws", web.ServeWS)
-	handler.Handle("/", sh)
+	if auth.Enabled() {
+		auth.Mount(handler)
+
+		handler.Handle("/ws", auth.Handler(web.ServeWS))
+		handler.Handle("/", auth.Handler(sh.ServeHTTP))
+	} else {
+		log.Warning("Web interface is served without authentication, insecure_no_auth has been set")
+
+		handler.HandleFunc("/ws", web.ServeWS)
+		handler.Handle("/", sh)
+	}
 
 	eventCh := make(chan event.Event)
 
@@ -210,12 +304,23 @@ func (web *web) Start() {
 
 	web.eventCh = eventCh
 
+	if web.eb != nil {
+		web.eb.Subscribe(web)
+	}
+
 	go web.run()
 
 	go func() {
 		log.Infof("Web interface started: %s", web.ListenAddress)
 
-		server.ListenAndServe()
+		var err error
+		if web.TLSCert != "" {
+			err = server.ListenAndServeTLS(web.TLSCert, web.TLSKey)
+		} else {
+			err = server.ListenAndServe()
+		}
+
+		log.Errorf("Error serving web interface: %s", err.Error())
 	}()
 }
 
@@ -232,7 +337,7 @@ func (web *web) run() {
 			}
 		case msg := <-web.messageCh:
 			for c := range web.connections {
-				c.send <- msg
+				c.send <- mask(c.role, msg)
 			}
 		}
 	}
@@ -281,7 +386,7 @@ func resolver(dataDir string, outCh chan event.Event) chan event.Event {
 	if os.IsNotExist(err) {
 		err = download(geoLiteURL, dbPath)
 		if err != nil {
-			log.Fatal(err)
+			log.Errorf("Error downloading GeoLite database, countries won't be resolved: %s", err.Error())
 			return outCh
 		}
 	}
@@ -332,6 +437,25 @@ func (web *web) Send(evt event.Event) {
 }
 
 func (web *web) ServeWS(w http.ResponseWriter, r *http.Request) {
+	role := RoleAdmin
+	if web.auth != nil && web.auth.Enabled() {
+		s, ok := web.auth.Session(r)
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		role = s.Role
+	}
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin: func(r *http.Request) bool {
+			return checkOrigin(web.Origins, r)
+		},
+	}
+
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Errorf("Could not upgrade connection: %s", err.Error())
@@ -341,6 +465,7 @@ func (web *web) ServeWS(w http.ResponseWriter, r *http.Request) {
 	c := &connection{
 		ws:   ws,
 		web:  web,
+		role: role,
 		send: make(chan json.Marshaler, 100),
 	}
 
@@ -362,7 +487,7 @@ func (web *web) ServeWS(w http.ResponseWriter, r *http.Request) {
 		ShortCommitID: cmd.ShortCommitID,
 	})
 
-	c.send <- Data("events", web.events)
+	c.send <- mask(role, Data("events", web.events))
 	c.send <- Data("hot_countries", web.hotCountries)
 
 	go c.writePump()